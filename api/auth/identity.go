@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/akrylysov/algnhsa"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
+	"go.uber.org/zap"
+)
+
+// the claim that carries the id of the dental practice the caller belongs to.
+const DentalPracticeIDClaim string = "custom:dental_practice_id"
+
+// the claim that carries the id of the user making the request.
+const UserIDClaim string = "sub"
+
+// the claim that carries the role of the user making the request.
+const RoleClaim string = "custom:role"
+
+// Role is the job a user does at the dental practice, which decides which
+// patient fields they can see.
+type Role string
+
+const (
+	RoleReceptionist Role = "receptionist"
+	RoleDentist      Role = "dentist"
+	RoleHygienist    Role = "hygienist"
+	RoleAdmin        Role = "admin"
+	RoleFinance      Role = "finance"
+)
+
+// Roles lists every role a user can have.
+var Roles = []Role{RoleReceptionist, RoleDentist, RoleHygienist, RoleAdmin, RoleFinance}
+
+type contextKey int
+
+const identityContextKey contextKey = iota
+
+// Identity describes who is making a request.
+type Identity struct {
+	DentalPracticeID string
+	// the user acting on behalf of the dental practice, which is what changes
+	// to patients are attributed to.
+	UserID string
+	// the role of the user, empty when the claims did not carry one.
+	Role Role
+}
+
+// NewContext returns a copy of ctx that carries the identity.
+func NewContext(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey, identity)
+}
+
+// FromContext returns the identity stored in ctx, if there is one.
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey).(Identity)
+	if !ok || identity.DentalPracticeID == "" {
+		return Identity{}, false
+	}
+
+	return identity, true
+}
+
+// APIGatewayClaims reads the identity from the jwt claims that the api gateway
+// authorizer attached to the request and stores it in the request context.
+// requests without claims are passed through untouched, it is up to the
+// handlers to reject them.
+func APIGatewayClaims(logger *zap.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context(), logger)
+
+		event, ok := algnhsa.APIGatewayV2HTTPRequestFromContext(r.Context())
+		if !ok || event.RequestContext.Authorizer == nil || event.RequestContext.Authorizer.JWT == nil {
+			logger.Warn("no jwt claims were found on the request")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims := event.RequestContext.Authorizer.JWT.Claims
+		identity := Identity{DentalPracticeID: claims[DentalPracticeIDClaim], UserID: claims[UserIDClaim], Role: Role(claims[RoleClaim])}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), identity)))
+	})
+}
+
+// Authenticate verifies the bearer token of every request and stores the
+// identity it carries in the request context. requests without a valid token
+// are rejected with a 401 before they reach next.
+func Authenticate(logger *zap.Logger, verifier *Verifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context(), logger)
+
+		scheme, token, ok := strings.Cut(r.Header.Get("authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "bearer") || token == "" {
+			logger.Warn("no bearer token was found on the request")
+			w.Header().Set("www-authenticate", `Bearer realm="patients"`)
+			problem.Error(w, r, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		identity, err := verifier.Verify(r.Context(), token)
+
+		// the keys could not be fetched, which says nothing about the token
+		if err != nil && !errors.Is(err, ErrInvalidToken) {
+			logger.Error("the bearer token could not be verified", zap.Error(err))
+			problem.Error(w, r, "the token could not be verified, try again later", http.StatusServiceUnavailable)
+			return
+		}
+
+		if err != nil {
+			logger.Warn("the bearer token is invalid", zap.Error(err))
+			w.Header().Set("www-authenticate", `Bearer realm="patients", error="invalid_token"`)
+			problem.Error(w, r, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), identity)))
+	})
+}
+
+// StaticIdentity stores the same identity in the context of every request. it
+// is meant for running the api outside of api gateway, where there is no
+// authorizer to supply the claims.
+func StaticIdentity(identity Identity, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), identity)))
+	})
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// the shortest time between two fetches of a remote key set, so tokens with
+// made up key ids can not be used to flood the issuer with requests.
+const minRefreshInterval = time.Minute
+
+// a json web key set, only the fields of rsa keys are read.
+type jsonWebKeySet struct {
+	Keys []struct {
+		KeyType   string `json:"kty"`
+		KeyID     string `json:"kid"`
+		Use       string `json:"use"`
+		Algorithm string `json:"alg"`
+		Modulus   string `json:"n"`
+		Exponent  string `json:"e"`
+	} `json:"keys"`
+}
+
+// returns the rsa signing keys of a json web key set by their key ids.
+func parseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set jsonWebKeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("could not read the key set: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range set.Keys {
+		if key.KeyType != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+
+		modulus, err := base64.RawURLEncoding.DecodeString(key.Modulus)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the modulus of key %q: %w", key.KeyID, err)
+		}
+
+		exponent, err := base64.RawURLEncoding.DecodeString(key.Exponent)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the exponent of key %q: %w", key.KeyID, err)
+		}
+
+		keys[key.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(modulus),
+			E: int(new(big.Int).SetBytes(exponent).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+// returns the rsa key with the id, for tokens signed with RS256.
+func rsaKey(keys map[string]*rsa.PublicKey, algorithm string, keyID string) (*rsa.PublicKey, error) {
+	if algorithm != AlgorithmRS256 {
+		return nil, fmt.Errorf("%w: the algorithm %q is not accepted", ErrInvalidToken, algorithm)
+	}
+
+	key, ok := keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: the key %q is not known", ErrInvalidToken, keyID)
+	}
+
+	return key, nil
+}
+
+// StaticJWKS is a key set that is read once, from a file.
+type StaticJWKS struct {
+	keys map[string]*rsa.PublicKey
+}
+
+// LoadJWKS reads the key set in the file at path.
+func LoadJWKS(path string) (*StaticJWKS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the key set file: %w", err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StaticJWKS{keys: keys}, nil
+}
+
+func (s *StaticJWKS) Key(_ context.Context, algorithm string, keyID string) (interface{}, error) {
+	return rsaKey(s.keys, algorithm, keyID)
+}
+
+// RemoteJWKS is a key set fetched from the issuer of the tokens. it is fetched
+// again when a token is signed by a key it does not know yet, which is how
+// rotated keys are picked up.
+type RemoteJWKS struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewRemoteJWKS returns a key set fetched from the url, e.g. the
+// .well-known/jwks.json of a cognito user pool.
+func NewRemoteJWKS(url string) *RemoteJWKS {
+	return &RemoteJWKS{url: url, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (s *RemoteJWKS) Key(ctx context.Context, algorithm string, keyID string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[keyID]; !ok && time.Since(s.fetchedAt) >= minRefreshInterval {
+		if err := s.fetch(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return rsaKey(s.keys, algorithm, keyID)
+}
+
+func (s *RemoteJWKS) fetch(ctx context.Context) error {
+	// a failed fetch counts too, so an issuer that is down is not asked again
+	// on every request
+	s.fetchedAt = time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return fmt.Errorf("could not create the key set request: %w", err)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not fetch the key set: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not fetch the key set, got status %v", res.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("could not read the key set: %w", err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	s.keys = keys
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// the algorithms tokens can be signed with.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+)
+
+// how far the clocks of the token issuer and the service can drift apart.
+const clockSkew = time.Minute
+
+// ErrInvalidToken is returned for tokens that can not be trusted, whether
+// they are malformed, badly signed, expired or meant for another audience.
+var ErrInvalidToken = errors.New("the token is invalid")
+
+// KeySource returns the keys that token signatures are verified with.
+type KeySource interface {
+	// returns the key that verifies a token signed with the algorithm by the
+	// key with the id. the key source decides which algorithms it accepts, so
+	// a token can not pick a weaker one.
+	Key(ctx context.Context, algorithm string, keyID string) (interface{}, error)
+}
+
+// HMACKey is a shared secret that verifies tokens signed with HS256. it is
+// meant for tests and running the api locally.
+type HMACKey []byte
+
+func (k HMACKey) Key(_ context.Context, algorithm string, _ string) (interface{}, error) {
+	if algorithm != AlgorithmHS256 {
+		return nil, fmt.Errorf("%w: the algorithm %q is not accepted", ErrInvalidToken, algorithm)
+	}
+
+	return []byte(k), nil
+}
+
+// Verifier checks bearer tokens and reads the identity from their claims.
+type Verifier struct {
+	keys     KeySource
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// NewVerifier returns a verifier for tokens signed by the keys. the issuer and
+// audience are only checked when they are set.
+func NewVerifier(keys KeySource, issuer string, audience string) *Verifier {
+	return &Verifier{keys: keys, issuer: issuer, audience: audience, now: time.Now}
+}
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// Verify checks the signature and claims of the token and returns the
+// identity it carries.
+func (v *Verifier) Verify(ctx context.Context, token string) (Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, fmt.Errorf("%w: the token is not a jwt", ErrInvalidToken)
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Identity{}, fmt.Errorf("%w: the header can not be read", ErrInvalidToken)
+	}
+
+	key, err := v.keys.Key(ctx, header.Algorithm, header.KeyID)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: the signature can not be read", ErrInvalidToken)
+	}
+
+	if err := verifySignature(header.Algorithm, key, parts[0]+"."+parts[1], signature); err != nil {
+		return Identity{}, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Identity{}, fmt.Errorf("%w: the claims can not be read", ErrInvalidToken)
+	}
+
+	if err := v.checkClaims(claims); err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{
+		DentalPracticeID: stringClaim(claims, DentalPracticeIDClaim),
+		UserID:           stringClaim(claims, UserIDClaim),
+		Role:             Role(stringClaim(claims, RoleClaim)),
+	}
+
+	if identity.DentalPracticeID == "" {
+		return Identity{}, fmt.Errorf("%w: the token has no dental practice", ErrInvalidToken)
+	}
+
+	return identity, nil
+}
+
+// checks that the token is in date and was issued for this api.
+func (v *Verifier) checkClaims(claims map[string]interface{}) error {
+	now := v.now()
+
+	expiresAt, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: the token does not expire", ErrInvalidToken)
+	}
+
+	if now.After(time.Unix(int64(expiresAt), 0).Add(clockSkew)) {
+		return fmt.Errorf("%w: the token has expired", ErrInvalidToken)
+	}
+
+	if notBefore, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(notBefore), 0).Add(-clockSkew)) {
+		return fmt.Errorf("%w: the token is not valid yet", ErrInvalidToken)
+	}
+
+	if v.issuer != "" && stringClaim(claims, "iss") != v.issuer {
+		return fmt.Errorf("%w: the token was issued by someone else", ErrInvalidToken)
+	}
+
+	if v.audience != "" && !hasAudience(claims, v.audience) {
+		return fmt.Errorf("%w: the token was issued for another audience", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+// reports whether the token is meant for the audience. id tokens name it in
+// aud, which can be a string or a list, and cognito access tokens name it in
+// client_id.
+func hasAudience(claims map[string]interface{}, audience string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		if aud == audience {
+			return true
+		}
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+
+	return stringClaim(claims, "client_id") == audience
+}
+
+func verifySignature(algorithm string, key interface{}, signingInput string, signature []byte) error {
+	hash := sha256.Sum256([]byte(signingInput))
+
+	switch algorithm {
+	case AlgorithmHS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("%w: the key does not match the algorithm", ErrInvalidToken)
+		}
+
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("%w: the signature does not match", ErrInvalidToken)
+		}
+	case AlgorithmRS256:
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: the key does not match the algorithm", ErrInvalidToken)
+		}
+
+		if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signature); err != nil {
+			return fmt.Errorf("%w: the signature does not match", ErrInvalidToken)
+		}
+	default:
+		return fmt.Errorf("%w: the algorithm %q is not accepted", ErrInvalidToken, algorithm)
+	}
+
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var testSecret = HMACKey("test_secret")
+
+func TestVerifier(t *testing.T) {
+	verifier := NewVerifier(testSecret, "test_issuer", "test_audience")
+
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":                 "test_issuer",
+			"aud":                 "test_audience",
+			"exp":                 time.Now().Add(time.Hour).Unix(),
+			"sub":                 "test_user_id",
+			DentalPracticeIDClaim: "test_dental_practice_id",
+			RoleClaim:             "dentist",
+		}
+	}
+
+	t.Run("returns the identity in a valid token", func(t *testing.T) {
+		identity, err := verifier.Verify(context.Background(), signHS256(t, testSecret, validClaims()))
+		if err != nil {
+			t.Fatalf("could not verify the token: %v", err)
+		}
+
+		want := Identity{DentalPracticeID: "test_dental_practice_id", UserID: "test_user_id", Role: RoleDentist}
+		if identity != want {
+			t.Errorf("got %+v want %+v", identity, want)
+		}
+	})
+
+	t.Run("accepts the audience as a list or a cognito client id", func(t *testing.T) {
+		listed := validClaims()
+		listed["aud"] = []string{"another_audience", "test_audience"}
+
+		clientID := validClaims()
+		delete(clientID, "aud")
+		clientID["client_id"] = "test_audience"
+
+		for _, claims := range []map[string]interface{}{listed, clientID} {
+			if _, err := verifier.Verify(context.Background(), signHS256(t, testSecret, claims)); err != nil {
+				t.Errorf("could not verify the token with claims %v: %v", claims, err)
+			}
+		}
+	})
+
+	cases := map[string]string{
+		"a token signed with another secret": signHS256(t, HMACKey("another_secret"), validClaims()),
+		"a token that is not a jwt":          "not.a-jwt",
+		"an unsigned token":                  encodeSegment(t, map[string]string{"alg": "none"}) + "." + encodeSegment(t, validClaims()) + ".",
+	}
+
+	for name, change := range map[string]func(map[string]interface{}){
+		"an expired token":                  func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"a token that does not expire":      func(c map[string]interface{}) { delete(c, "exp") },
+		"a token that is not valid yet":     func(c map[string]interface{}) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"a token from another issuer":       func(c map[string]interface{}) { c["iss"] = "another_issuer" },
+		"a token for another audience":      func(c map[string]interface{}) { c["aud"] = "another_audience" },
+		"a token without a dental practice": func(c map[string]interface{}) { delete(c, DentalPracticeIDClaim) },
+	} {
+		claims := validClaims()
+		change(claims)
+		cases[name] = signHS256(t, testSecret, claims)
+	}
+
+	for name, token := range cases {
+		t.Run("rejects "+name, func(t *testing.T) {
+			if _, err := verifier.Verify(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("got error %v want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+
+	t.Run("rejects a token signed with an algorithm the key set does not accept", func(t *testing.T) {
+		key := newRSAKey(t)
+		jwks := &StaticJWKS{keys: map[string]*rsa.PublicKey{"test_key": &key.PublicKey}}
+
+		// the public key is no secret, so it must not be usable as an hmac key
+		token := signHS256(t, HMACKey(key.PublicKey.N.Bytes()), validClaims())
+		if _, err := NewVerifier(jwks, "", "").Verify(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("got error %v want %v", err, ErrInvalidToken)
+		}
+	})
+}
+
+func TestJWKS(t *testing.T) {
+	key := newRSAKey(t)
+	claims := map[string]interface{}{
+		"exp":                 time.Now().Add(time.Hour).Unix(),
+		DentalPracticeIDClaim: "test_dental_practice_id",
+	}
+
+	t.Run("verifies tokens with a key set read from a file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		os.WriteFile(path, jwksDocument(t, "test_key", &key.PublicKey), 0600)
+
+		jwks, err := LoadJWKS(path)
+		if err != nil {
+			t.Fatalf("could not load the key set: %v", err)
+		}
+
+		if _, err := NewVerifier(jwks, "", "").Verify(context.Background(), signRS256(t, key, "test_key", claims)); err != nil {
+			t.Errorf("could not verify the token: %v", err)
+		}
+
+		if _, err := NewVerifier(jwks, "", "").Verify(context.Background(), signRS256(t, newRSAKey(t), "test_key", claims)); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("got error %v want %v", err, ErrInvalidToken)
+		}
+	})
+
+	t.Run("fetches a remote key set once and again for a new key", func(t *testing.T) {
+		fetches := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetches++
+			w.Write(jwksDocument(t, "test_key", &key.PublicKey))
+		}))
+		defer server.Close()
+
+		verifier := NewVerifier(NewRemoteJWKS(server.URL), "", "")
+
+		for i := 0; i < 3; i++ {
+			if _, err := verifier.Verify(context.Background(), signRS256(t, key, "test_key", claims)); err != nil {
+				t.Fatalf("could not verify the token: %v", err)
+			}
+		}
+
+		// an unknown key id could be a rotated key, but the key set was only
+		// just fetched so it is not fetched again
+		if _, err := verifier.Verify(context.Background(), signRS256(t, key, "unknown_key", claims)); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("got error %v want %v", err, ErrInvalidToken)
+		}
+
+		if fetches != 1 {
+			t.Errorf("got %v fetches of the key set want 1", fetches)
+		}
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	// create the logger
+	logger, _ := zap.NewProduction()
+
+	var got Identity
+	handler := Authenticate(logger, NewVerifier(testSecret, "", ""), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = FromContext(r.Context())
+	}))
+
+	t.Run("returns 401 (unauthorized) when there is no bearer token", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/patients", nil)
+		res := httptest.NewRecorder()
+
+		handler.ServeHTTP(res, req)
+
+		if res.Code != http.StatusUnauthorized || res.Header().Get("www-authenticate") != `Bearer realm="patients"` {
+			t.Errorf("got %v %q want 401 with a bearer challenge", res.Code, res.Header().Get("www-authenticate"))
+		}
+	})
+
+	t.Run("returns 401 (unauthorized) when the bearer token is invalid", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/patients", nil)
+		req.Header.Set("authorization", "Bearer "+signHS256(t, HMACKey("another_secret"), map[string]interface{}{}))
+		res := httptest.NewRecorder()
+
+		handler.ServeHTTP(res, req)
+
+		if res.Code != http.StatusUnauthorized || res.Header().Get("www-authenticate") != `Bearer realm="patients", error="invalid_token"` {
+			t.Errorf("got %v %q want 401 with an invalid token challenge", res.Code, res.Header().Get("www-authenticate"))
+		}
+	})
+
+	t.Run("passes the identity in a valid bearer token on", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/patients", nil)
+		req.Header.Set("authorization", "Bearer "+signHS256(t, testSecret, map[string]interface{}{
+			"exp":                 time.Now().Add(time.Hour).Unix(),
+			"sub":                 "test_user_id",
+			DentalPracticeIDClaim: "test_dental_practice_id",
+			RoleClaim:             "admin",
+		}))
+		res := httptest.NewRecorder()
+
+		handler.ServeHTTP(res, req)
+
+		want := Identity{DentalPracticeID: "test_dental_practice_id", UserID: "test_user_id", Role: RoleAdmin}
+		if res.Code != http.StatusOK || got != want {
+			t.Errorf("got %v %+v want 200 and %+v", res.Code, got, want)
+		}
+	})
+}
+
+func encodeSegment(t testing.TB, v interface{}) string {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("could not marshal the token segment: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHS256(t testing.TB, secret HMACKey, claims map[string]interface{}) string {
+	t.Helper()
+
+	signingInput := encodeSegment(t, map[string]string{"alg": AlgorithmHS256, "typ": "JWT"}) + "." + encodeSegment(t, claims)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t testing.TB, key *rsa.PrivateKey, keyID string, claims map[string]interface{}) string {
+	t.Helper()
+
+	signingInput := encodeSegment(t, map[string]string{"alg": AlgorithmRS256, "typ": "JWT", "kid": keyID}) + "." + encodeSegment(t, claims)
+
+	hash := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatalf("could not sign the token: %v", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newRSAKey(t testing.TB) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate the rsa key: %v", err)
+	}
+
+	return key
+}
+
+func jwksDocument(t testing.TB, keyID string, key *rsa.PublicKey) []byte {
+	t.Helper()
+
+	return []byte(fmt.Sprintf(`{"keys": [{"kty": "RSA", "kid": %q, "use": "sig", "alg": "RS256", "n": %q, "e": %q}]}`,
+		keyID,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	))
+}
@@ -0,0 +1,83 @@
+package encryption
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// the shortest key a blind index accepts, the same as the key of the hash.
+const blindIndexKeySize = sha256.Size
+
+// the message whose mac is the key of a kms blind index. every hash changes
+// with it, so it is only ever changed along with reindexing the patients.
+const blindIndexKeyMessage = "patients-service blind index v1"
+
+// BlindIndex hashes values with a secret key, so they can be looked up by
+// equality in an index that never holds the values themselves. unlike a plain
+// hash, the values can not be found by hashing guesses without the key.
+type BlindIndex struct {
+	key []byte
+}
+
+// NewBlindIndex returns a blind index that hashes with key, which has to be at
+// least 32 bytes long.
+func NewBlindIndex(key []byte) (*BlindIndex, error) {
+	if len(key) < blindIndexKeySize {
+		return nil, fmt.Errorf("the blind index key is %v bytes, it has to be at least %v", len(key), blindIndexKeySize)
+	}
+
+	return &BlindIndex{key: key}, nil
+}
+
+// NewRandomBlindIndex returns a blind index with a random key. its hashes are
+// lost with it, so it is meant for indexes that are only held in memory.
+func NewRandomBlindIndex() (*BlindIndex, error) {
+	key := make([]byte, blindIndexKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("could not generate a blind index key: %w", err)
+	}
+
+	return NewBlindIndex(key)
+}
+
+// KMSMacClient is the subset of the kms client used to derive the key of a
+// blind index.
+type KMSMacClient interface {
+	GenerateMac(ctx context.Context, params *kms.GenerateMacInput, optFns ...func(*kms.Options)) (*kms.GenerateMacOutput, error)
+}
+
+// NewKMSBlindIndex returns a blind index whose key is derived from the kms hmac
+// key with the id, alias or arn in keyID. the key is derived once, so hashing
+// a value does not call kms.
+func NewKMSBlindIndex(ctx context.Context, client KMSMacClient, keyID string) (*BlindIndex, error) {
+	response, err := client.GenerateMac(ctx, &kms.GenerateMacInput{
+		KeyId:        aws.String(keyID),
+		MacAlgorithm: types.MacAlgorithmSpecHmacSha256,
+		Message:      []byte(blindIndexKeyMessage),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not derive the blind index key with kms key %q: %w", keyID, err)
+	}
+
+	return NewBlindIndex(response.Mac)
+}
+
+// Hash returns the keyed hash of the value, hex encoded. the purpose is hashed
+// along with the value, so the same value hashes differently in each place it
+// is indexed.
+func (b *BlindIndex) Hash(purpose string, value string) string {
+	mac := hmac.New(sha256.New, b.key)
+	mac.Write([]byte(purpose))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,255 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DataKeyAttribute is the attribute an item's encrypted data key is stored in.
+const DataKeyAttribute string = "dk"
+
+// the first byte of every encrypted value, so the format can be changed
+// without losing the ability to read values written before.
+const formatVersion byte = 1
+
+// the most decrypted data keys that are kept, listing patients decrypts a data
+// key per patient so they are cached rather than sent to the key provider on
+// every read.
+const maxCachedDataKeys = 1000
+
+// ErrNoKeyProvider is returned when an encrypted item is read but encryption
+// has not been configured.
+var ErrNoKeyProvider = errors.New("the item is encrypted but no key provider is configured")
+
+// FieldEncrypter encrypts the configured attributes of dynamodb items before
+// they are written and decrypts them after they are read. each item gets its
+// own data key, which is stored in the item encrypted under the master key of
+// the key provider. a nil field encrypter encrypts nothing.
+type FieldEncrypter struct {
+	provider   KeyProvider
+	attributes map[string]bool
+
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+// NewFieldEncrypter returns a field encrypter that encrypts the attributes
+// with data keys from the provider.
+func NewFieldEncrypter(provider KeyProvider, attributes []string) *FieldEncrypter {
+	encrypted := map[string]bool{}
+	for _, attribute := range attributes {
+		encrypted[attribute] = true
+	}
+
+	return &FieldEncrypter{provider: provider, attributes: encrypted, keys: map[string][]byte{}}
+}
+
+// Encrypts reports whether the attribute is encrypted.
+func (f *FieldEncrypter) Encrypts(attribute string) bool {
+	return f != nil && f.attributes[attribute]
+}
+
+// EncryptItem replaces the configured attributes of the item with their
+// encrypted values and stores the encrypted data key in the item. only string
+// attributes are encrypted, the encrypted values are binary attributes.
+func (f *FieldEncrypter) EncryptItem(ctx context.Context, item map[string]types.AttributeValue) error {
+	if f == nil {
+		return nil
+	}
+
+	envelope, err := f.NewEnvelope(ctx)
+	if err != nil {
+		return err
+	}
+
+	for attribute := range f.attributes {
+		value, ok := item[attribute].(*types.AttributeValueMemberS)
+		if !ok {
+			continue
+		}
+
+		// the attribute name is authenticated along with the value, so values
+		// can not be swapped between attributes
+		item[attribute], err = envelope.Seal(value.Value, attribute)
+		if err != nil {
+			return fmt.Errorf("could not encrypt attribute %q: %w", attribute, err)
+		}
+	}
+
+	envelope.Store(item)
+
+	return nil
+}
+
+// DecryptItem replaces the encrypted attributes of the item with their
+// plaintext values and removes the data key. every binary attribute is
+// decrypted, not just the configured ones, so items written before an
+// attribute stopped being encrypted can still be read. items without a data
+// key were written before encryption was turned on and are left as they are.
+func (f *FieldEncrypter) DecryptItem(ctx context.Context, item map[string]types.AttributeValue) error {
+	envelope, err := f.OpenEnvelope(ctx, item)
+	if err != nil || envelope == nil {
+		return err
+	}
+
+	for attribute, value := range item {
+		encrypted, ok := value.(*types.AttributeValueMemberB)
+		if !ok || attribute == DataKeyAttribute {
+			continue
+		}
+
+		plaintext, err := envelope.Open(encrypted, attribute)
+		if err != nil {
+			return fmt.Errorf("could not decrypt attribute %q: %w", attribute, err)
+		}
+
+		item[attribute] = &types.AttributeValueMemberS{Value: plaintext}
+	}
+
+	delete(item, DataKeyAttribute)
+
+	return nil
+}
+
+// NewEnvelope returns an envelope with a new data key, for items whose values
+// are encrypted one at a time rather than by attribute.
+func (f *FieldEncrypter) NewEnvelope(ctx context.Context) (*Envelope, error) {
+	dataKey, err := f.provider.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{key: dataKey.Plaintext, encryptedKey: dataKey.Encrypted}, nil
+}
+
+// OpenEnvelope returns an envelope with the data key stored in the item, or
+// nil if the item has no data key.
+func (f *FieldEncrypter) OpenEnvelope(ctx context.Context, item map[string]types.AttributeValue) (*Envelope, error) {
+	encryptedKey, ok := item[DataKeyAttribute].(*types.AttributeValueMemberB)
+	if !ok {
+		return nil, nil
+	}
+
+	if f == nil {
+		return nil, ErrNoKeyProvider
+	}
+
+	key, err := f.decryptDataKey(ctx, encryptedKey.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{key: key, encryptedKey: encryptedKey.Value}, nil
+}
+
+// returns the plaintext of a data key, from the cache if it has been
+// decrypted before.
+func (f *FieldEncrypter) decryptDataKey(ctx context.Context, encrypted []byte) ([]byte, error) {
+	f.mu.Lock()
+	key, ok := f.keys[string(encrypted)]
+	f.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	key, err := f.provider.DecryptDataKey(ctx, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// the cache is emptied rather than evicting the oldest key, the keys are
+	// cheap to decrypt again
+	if len(f.keys) >= maxCachedDataKeys {
+		f.keys = map[string][]byte{}
+	}
+	f.keys[string(encrypted)] = key
+
+	return key, nil
+}
+
+// Envelope encrypts and decrypts the values of a single item with the item's
+// data key.
+type Envelope struct {
+	key          []byte
+	encryptedKey []byte
+}
+
+// Seal returns the value encrypted as a binary attribute. the associated data
+// has to be given again to decrypt the value.
+func (e *Envelope) Seal(value string, associatedData string) (*types.AttributeValueMemberB, error) {
+	encrypted, err := seal(e.key, []byte(value), []byte(associatedData))
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.AttributeValueMemberB{Value: encrypted}, nil
+}
+
+// Open returns the plaintext of a value encrypted by Seal.
+func (e *Envelope) Open(value *types.AttributeValueMemberB, associatedData string) (string, error) {
+	plaintext, err := open(e.key, value.Value, []byte(associatedData))
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// Store stores the encrypted data key of the envelope in the item.
+func (e *Envelope) Store(item map[string]types.AttributeValue) {
+	item[DataKeyAttribute] = &types.AttributeValueMemberB{Value: e.encryptedKey}
+}
+
+// encrypts the plaintext with aes-gcm. the result is the format version, then
+// the nonce, then the ciphertext.
+func seal(key, plaintext, associatedData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("could not generate a nonce: %w", err)
+	}
+
+	sealed := append([]byte{formatVersion}, nonce...)
+
+	return aead.Seal(sealed, nonce, plaintext, associatedData), nil
+}
+
+// decrypts a value encrypted by seal.
+func open(key, sealed, associatedData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < 1+aead.NonceSize() || sealed[0] != formatVersion {
+		return nil, errors.New("the encrypted value is not in a known format")
+	}
+
+	nonce := sealed[1 : 1+aead.NonceSize()]
+
+	return aead.Open(nil, nonce, sealed[1+aead.NonceSize():], associatedData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
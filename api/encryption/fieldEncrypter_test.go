@@ -0,0 +1,304 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFieldEncrypter(t *testing.T) {
+	provider := newTestKeyProvider(t)
+	encrypter := NewFieldEncrypter(provider, []string{"ni", "dob"})
+
+	newItem := func() map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"pid": &types.AttributeValueMemberS{Value: "test_patient_id"},
+			"ni":  &types.AttributeValueMemberS{Value: "AB123456C"},
+			"dob": &types.AttributeValueMemberS{Value: "1985-03-14"},
+			"a":   &types.AttributeValueMemberBOOL{Value: true},
+		}
+	}
+
+	t.Run("encrypts the configured attributes and decrypts them again", func(t *testing.T) {
+		item := newItem()
+
+		if err := encrypter.EncryptItem(context.Background(), item); err != nil {
+			t.Fatalf("could not encrypt the item: %v", err)
+		}
+
+		for _, attribute := range []string{"ni", "dob", DataKeyAttribute} {
+			if _, ok := item[attribute].(*types.AttributeValueMemberB); !ok {
+				t.Errorf("got %#v want %v to be a binary attribute", item[attribute], attribute)
+			}
+		}
+
+		if _, ok := item["pid"].(*types.AttributeValueMemberS); !ok {
+			t.Errorf("got %#v want pid to be left as it is", item["pid"])
+		}
+
+		if bytes.Contains(item["ni"].(*types.AttributeValueMemberB).Value, []byte("AB123456C")) {
+			t.Error("the national insurance number was found in its encrypted value")
+		}
+
+		if err := encrypter.DecryptItem(context.Background(), item); err != nil {
+			t.Fatalf("could not decrypt the item: %v", err)
+		}
+
+		if diff := cmp.Diff(item, newItem(), cmp.AllowUnexported(types.AttributeValueMemberS{}, types.AttributeValueMemberBOOL{})); diff != "" {
+			t.Error("the decrypted item is not the item that was encrypted", diff)
+		}
+	})
+
+	t.Run("every item gets its own data key", func(t *testing.T) {
+		first, second := newItem(), newItem()
+		encrypter.EncryptItem(context.Background(), first)
+		encrypter.EncryptItem(context.Background(), second)
+
+		if bytes.Equal(first[DataKeyAttribute].(*types.AttributeValueMemberB).Value, second[DataKeyAttribute].(*types.AttributeValueMemberB).Value) {
+			t.Error("the items were encrypted with the same data key")
+		}
+	})
+
+	t.Run("values swapped between attributes can not be decrypted", func(t *testing.T) {
+		item := newItem()
+		encrypter.EncryptItem(context.Background(), item)
+
+		item["ni"], item["dob"] = item["dob"], item["ni"]
+
+		if err := encrypter.DecryptItem(context.Background(), item); err == nil {
+			t.Error("expected the swapped values to fail to decrypt")
+		}
+	})
+
+	t.Run("items written before encryption was turned on are read as they are", func(t *testing.T) {
+		item := newItem()
+
+		if err := encrypter.DecryptItem(context.Background(), item); err != nil {
+			t.Fatalf("could not decrypt the item: %v", err)
+		}
+
+		if attribute := item["ni"].(*types.AttributeValueMemberS).Value; attribute != "AB123456C" {
+			t.Errorf("got %q want the plaintext national insurance number", attribute)
+		}
+	})
+
+	t.Run("attributes that are no longer configured are still decrypted", func(t *testing.T) {
+		item := newItem()
+		encrypter.EncryptItem(context.Background(), item)
+
+		if err := NewFieldEncrypter(provider, []string{"ni"}).DecryptItem(context.Background(), item); err != nil {
+			t.Fatalf("could not decrypt the item: %v", err)
+		}
+
+		if attribute, ok := item["dob"].(*types.AttributeValueMemberS); !ok || attribute.Value != "1985-03-14" {
+			t.Errorf("got %#v want the plaintext date of birth", item["dob"])
+		}
+	})
+
+	t.Run("a nil field encrypter encrypts nothing but refuses encrypted items", func(t *testing.T) {
+		var disabled *FieldEncrypter
+
+		item := newItem()
+		if err := disabled.EncryptItem(context.Background(), item); err != nil {
+			t.Fatalf("could not encrypt the item: %v", err)
+		}
+
+		if _, ok := item[DataKeyAttribute]; ok {
+			t.Error("a nil field encrypter should not add a data key")
+		}
+
+		encrypter.EncryptItem(context.Background(), item)
+		if err := disabled.DecryptItem(context.Background(), item); !errors.Is(err, ErrNoKeyProvider) {
+			t.Errorf("got error %v want %v", err, ErrNoKeyProvider)
+		}
+	})
+}
+
+func TestLocalKeyProvider(t *testing.T) {
+	t.Run("rejects a master key that is not 32 bytes", func(t *testing.T) {
+		if _, err := NewLocalKeyProvider([]byte("too short")); err == nil {
+			t.Error("expected a short master key to be rejected")
+		}
+	})
+
+	t.Run("loads the master key from a file", func(t *testing.T) {
+		masterKey := bytes.Repeat([]byte{7}, 32)
+		path := filepath.Join(t.TempDir(), "master.key")
+		os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(masterKey)+"\n"), 0600)
+
+		provider, err := LoadLocalKeyProvider(path)
+		if err != nil {
+			t.Fatalf("could not load the master key: %v", err)
+		}
+
+		dataKey, _ := provider.GenerateDataKey(context.Background())
+
+		// a provider with the same master key can decrypt the data key
+		other, _ := NewLocalKeyProvider(masterKey)
+		plaintext, err := other.DecryptDataKey(context.Background(), dataKey.Encrypted)
+		if err != nil || !bytes.Equal(plaintext, dataKey.Plaintext) {
+			t.Errorf("got %v, %v want the plaintext data key", plaintext, err)
+		}
+	})
+
+	t.Run("data keys can not be decrypted with another master key", func(t *testing.T) {
+		dataKey, _ := newTestKeyProvider(t).GenerateDataKey(context.Background())
+
+		if _, err := newTestKeyProvider(t).DecryptDataKey(context.Background(), dataKey.Encrypted); err == nil {
+			t.Error("expected the data key to fail to decrypt")
+		}
+	})
+}
+
+type stubKMSClient struct {
+	generateDataKey func(params *kms.GenerateDataKeyInput) (*kms.GenerateDataKeyOutput, error)
+	decrypt         func(params *kms.DecryptInput) (*kms.DecryptOutput, error)
+}
+
+func (s *stubKMSClient) GenerateDataKey(_ context.Context, params *kms.GenerateDataKeyInput, _ ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	return s.generateDataKey(params)
+}
+
+func (s *stubKMSClient) Decrypt(_ context.Context, params *kms.DecryptInput, _ ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	return s.decrypt(params)
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	keyID := "alias/test_key"
+	plaintext := bytes.Repeat([]byte{1}, 32)
+	encrypted := []byte("test_encrypted_data_key")
+	decrypts := 0
+
+	client := &stubKMSClient{
+		generateDataKey: func(params *kms.GenerateDataKeyInput) (*kms.GenerateDataKeyOutput, error) {
+			if aws.ToString(params.KeyId) != keyID {
+				t.Errorf("got key id %q want %q", aws.ToString(params.KeyId), keyID)
+			}
+
+			return &kms.GenerateDataKeyOutput{Plaintext: plaintext, CiphertextBlob: encrypted}, nil
+		},
+		decrypt: func(params *kms.DecryptInput) (*kms.DecryptOutput, error) {
+			decrypts++
+
+			if aws.ToString(params.KeyId) != keyID || !bytes.Equal(params.CiphertextBlob, encrypted) {
+				return nil, errors.New("unknown data key")
+			}
+
+			return &kms.DecryptOutput{Plaintext: plaintext}, nil
+		},
+	}
+
+	encrypter := NewFieldEncrypter(NewKMSKeyProvider(client, keyID), []string{"ni"})
+
+	for i := 0; i < 3; i++ {
+		item := map[string]types.AttributeValue{"ni": &types.AttributeValueMemberS{Value: "AB123456C"}}
+
+		if err := encrypter.EncryptItem(context.Background(), item); err != nil {
+			t.Fatalf("could not encrypt the item: %v", err)
+		}
+
+		if err := encrypter.DecryptItem(context.Background(), item); err != nil {
+			t.Fatalf("could not decrypt the item: %v", err)
+		}
+
+		if got := item["ni"].(*types.AttributeValueMemberS).Value; got != "AB123456C" {
+			t.Errorf("got %q want the plaintext national insurance number", got)
+		}
+	}
+
+	// the decrypted data key is cached, so kms is only asked once
+	if decrypts != 1 {
+		t.Errorf("got %v calls to kms decrypt want 1", decrypts)
+	}
+}
+
+func newTestKeyProvider(t testing.TB) *LocalKeyProvider {
+	t.Helper()
+
+	masterKey := make([]byte, 32)
+	if _, err := rand.Read(masterKey); err != nil {
+		t.Fatalf("could not generate a master key: %v", err)
+	}
+
+	provider, err := NewLocalKeyProvider(masterKey)
+	if err != nil {
+		t.Fatalf("could not create the key provider: %v", err)
+	}
+
+	return provider
+}
+
+type stubKMSMacClient struct {
+	generateMac func(params *kms.GenerateMacInput) (*kms.GenerateMacOutput, error)
+}
+
+func (s *stubKMSMacClient) GenerateMac(_ context.Context, params *kms.GenerateMacInput, _ ...func(*kms.Options)) (*kms.GenerateMacOutput, error) {
+	return s.generateMac(params)
+}
+
+func TestBlindIndex(t *testing.T) {
+	index, err := NewBlindIndex(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("could not create the blind index: %v", err)
+	}
+
+	t.Run("the same value always has the same hash", func(t *testing.T) {
+		if index.Hash("ni", "AB123456C") != index.Hash("ni", "AB123456C") {
+			t.Error("got different hashes for the same value")
+		}
+	})
+
+	t.Run("the hash depends on the key, the purpose and the value", func(t *testing.T) {
+		other, _ := NewBlindIndex(bytes.Repeat([]byte{2}, 32))
+
+		hashes := map[string]bool{
+			index.Hash("ni", "AB123456C"):  true,
+			index.Hash("ni", "AB123456D"):  true,
+			index.Hash("dob", "AB123456C"): true,
+			other.Hash("ni", "AB123456C"):  true,
+		}
+
+		if len(hashes) != 4 {
+			t.Errorf("got %v different hashes want 4", len(hashes))
+		}
+	})
+
+	t.Run("short keys are rejected", func(t *testing.T) {
+		if _, err := NewBlindIndex(make([]byte, 16)); err == nil {
+			t.Error("got no error for a 16 byte key")
+		}
+	})
+
+	t.Run("the key can be derived from a kms hmac key", func(t *testing.T) {
+		keyID := "alias/test_blind_index_key"
+
+		client := &stubKMSMacClient{
+			generateMac: func(params *kms.GenerateMacInput) (*kms.GenerateMacOutput, error) {
+				if aws.ToString(params.KeyId) != keyID {
+					t.Errorf("got key id %q want %q", aws.ToString(params.KeyId), keyID)
+				}
+
+				return &kms.GenerateMacOutput{Mac: bytes.Repeat([]byte{1}, 32)}, nil
+			},
+		}
+
+		derived, err := NewKMSBlindIndex(context.Background(), client, keyID)
+		if err != nil {
+			t.Fatalf("could not create the blind index: %v", err)
+		}
+
+		if derived.Hash("ni", "AB123456C") != index.Hash("ni", "AB123456C") {
+			t.Error("got a different hash from the same key")
+		}
+	})
+}
@@ -0,0 +1,26 @@
+package encryption
+
+import (
+	"context"
+)
+
+// the size in bytes of the data keys, which are aes-256 keys.
+const dataKeySize = 32
+
+// DataKey is a key that encrypts the fields of a single item. it is stored
+// alongside the fields it encrypted, but only in its encrypted form.
+type DataKey struct {
+	Plaintext []byte
+	Encrypted []byte
+}
+
+// KeyProvider generates data keys and decrypts them again. the data keys are
+// encrypted under a master key that never leaves the provider.
+type KeyProvider interface {
+	// returns a new data key, both in plaintext and encrypted under the
+	// master key.
+	GenerateDataKey(ctx context.Context) (DataKey, error)
+	// returns the plaintext of a data key that was encrypted under the master
+	// key.
+	DecryptDataKey(ctx context.Context, encrypted []byte) ([]byte, error)
+}
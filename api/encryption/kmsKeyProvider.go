@@ -0,0 +1,55 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// KMSClient is the subset of the kms client used by the kms key provider.
+type KMSClient interface {
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// KMSKeyProvider generates data keys under a kms key, so the master key is
+// only ever held by kms.
+type KMSKeyProvider struct {
+	client KMSClient
+	keyID  string
+}
+
+// NewKMSKeyProvider returns a key provider that uses the kms key with the id,
+// alias or arn in keyID as the master key.
+func NewKMSKeyProvider(client KMSClient, keyID string) *KMSKeyProvider {
+	return &KMSKeyProvider{client: client, keyID: keyID}
+}
+
+func (p *KMSKeyProvider) GenerateDataKey(ctx context.Context) (DataKey, error) {
+	response, err := p.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(p.keyID),
+		KeySpec: types.DataKeySpecAes256,
+	})
+	if err != nil {
+		return DataKey{}, fmt.Errorf("could not generate a data key with kms key %q: %w", p.keyID, err)
+	}
+
+	return DataKey{Plaintext: response.Plaintext, Encrypted: response.CiphertextBlob}, nil
+}
+
+func (p *KMSKeyProvider) DecryptDataKey(ctx context.Context, encrypted []byte) ([]byte, error) {
+	// the key id is passed so kms refuses data keys encrypted under any other
+	// key
+	response, err := p.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:          aws.String(p.keyID),
+		CiphertextBlob: encrypted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt the data key with kms key %q: %w", p.keyID, err)
+	}
+
+	return response.Plaintext, nil
+}
@@ -0,0 +1,65 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LocalKeyProvider encrypts data keys under a master key held in memory. it is
+// meant for tests and running the service locally, where there is no kms.
+type LocalKeyProvider struct {
+	masterKey []byte
+}
+
+// NewLocalKeyProvider returns a key provider that uses masterKey, which has to
+// be a 32 byte aes-256 key, as the master key.
+func NewLocalKeyProvider(masterKey []byte) (*LocalKeyProvider, error) {
+	if len(masterKey) != dataKeySize {
+		return nil, fmt.Errorf("the master key is %v bytes, it has to be %v", len(masterKey), dataKeySize)
+	}
+
+	return &LocalKeyProvider{masterKey: masterKey}, nil
+}
+
+// LoadLocalKeyProvider returns a key provider whose master key is read from
+// the file at path, which holds the key base64 encoded.
+func LoadLocalKeyProvider(path string) (*LocalKeyProvider, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the master key file: %w", err)
+	}
+
+	masterKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return nil, fmt.Errorf("the master key file is not base64 encoded: %w", err)
+	}
+
+	return NewLocalKeyProvider(masterKey)
+}
+
+func (p *LocalKeyProvider) GenerateDataKey(ctx context.Context) (DataKey, error) {
+	plaintext := make([]byte, dataKeySize)
+	if _, err := rand.Read(plaintext); err != nil {
+		return DataKey{}, fmt.Errorf("could not generate a data key: %w", err)
+	}
+
+	encrypted, err := seal(p.masterKey, plaintext, nil)
+	if err != nil {
+		return DataKey{}, fmt.Errorf("could not encrypt the data key: %w", err)
+	}
+
+	return DataKey{Plaintext: plaintext, Encrypted: encrypted}, nil
+}
+
+func (p *LocalKeyProvider) DecryptDataKey(ctx context.Context, encrypted []byte) ([]byte, error) {
+	plaintext, err := open(p.masterKey, encrypted, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt the data key: %w", err)
+	}
+
+	return plaintext, nil
+}
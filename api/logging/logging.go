@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/akrylysov/algnhsa"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// the header the id of a request is read from and echoed back in.
+const RequestIDHeader string = "x-request-id"
+
+// request ids supplied by callers that are longer than this are replaced,
+// rather than copied into every log line.
+const maxRequestIDLength int = 128
+
+type contextKey int
+
+const (
+	loggerContextKey contextKey = iota
+	requestIDContextKey
+)
+
+// NewContext returns a copy of ctx that carries the logger.
+func NewContext(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, logger)
+}
+
+// FromContext returns the logger stored in ctx, or fallback when there is
+// none. a nil fallback logs nothing.
+func FromContext(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	logger, ok := ctx.Value(loggerContextKey).(*zap.Logger)
+	if ok && logger != nil {
+		return logger
+	}
+
+	if fallback == nil {
+		return zap.NewNop()
+	}
+
+	return fallback
+}
+
+// RequestIDFromContext returns the id of the request ctx belongs to, or an
+// empty string when there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// RequestIDs gives every request an id, which is taken from the x-request-id
+// header when the caller sent one, then from the id api gateway gave the
+// request, and is generated otherwise. the id is echoed back in the response
+// and stored in the request context along with a logger that adds it to every
+// log line.
+func RequestIDs(logger *zap.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID != "" && !validRequestID(requestID) {
+			logger.Warn("the request id sent with the request is invalid and will be replaced", zap.Int("length", len(requestID)))
+			requestID = ""
+		}
+
+		if requestID == "" {
+			if event, ok := algnhsa.APIGatewayV2HTTPRequestFromContext(r.Context()); ok {
+				requestID = event.RequestContext.RequestID
+			}
+		}
+
+		if requestID == "" {
+			requestID = uuid.New().String()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+		ctx = NewContext(ctx, logger.With(zap.String("requestID", requestID)))
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// request ids end up in log lines and response headers, so only printable
+// ascii without spaces is accepted.
+func validRequestID(requestID string) bool {
+	if len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] <= ' ' || requestID[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
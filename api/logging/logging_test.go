@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestIDs(t *testing.T) {
+	// create a logger that records what is logged
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core)
+
+	var gotRequestID string
+	handler := RequestIDs(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRequestID = RequestIDFromContext(r.Context())
+		FromContext(r.Context(), zap.NewNop()).Info("handling the request")
+	}))
+
+	t.Run("uses the request id sent with the request", func(t *testing.T) {
+		logs.TakeAll()
+
+		req, _ := http.NewRequest("GET", "/patients", nil)
+		req.Header.Set(RequestIDHeader, "test_request_id")
+		res := httptest.NewRecorder()
+
+		handler.ServeHTTP(res, req)
+
+		if got := res.Header().Get(RequestIDHeader); got != "test_request_id" {
+			t.Errorf("got request id header %q want %q", got, "test_request_id")
+		}
+
+		if gotRequestID != "test_request_id" {
+			t.Errorf("got request id %q in the context want %q", gotRequestID, "test_request_id")
+		}
+
+		// the logger in the context adds the request id to every line
+		entries := logs.FilterField(zap.String("requestID", "test_request_id")).All()
+		if len(entries) != 1 || entries[0].Message != "handling the request" {
+			t.Errorf("got %v log lines with the request id want the line logged by the handler", entries)
+		}
+	})
+
+	t.Run("generates a request id when none was sent", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/patients", nil)
+		res := httptest.NewRecorder()
+
+		handler.ServeHTTP(res, req)
+
+		got := res.Header().Get(RequestIDHeader)
+		if _, err := uuid.Parse(got); err != nil {
+			t.Errorf("the generated request id %q is not a uuid", got)
+		}
+
+		if gotRequestID != got {
+			t.Errorf("got request id %q in the context want %q", gotRequestID, got)
+		}
+	})
+
+	t.Run("replaces a request id that is invalid", func(t *testing.T) {
+		for _, requestID := range []string{"has spaces", "has\nnew lines", strings.Repeat("a", 129)} {
+			req, _ := http.NewRequest("GET", "/patients", nil)
+			req.Header.Set(RequestIDHeader, requestID)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, req)
+
+			got := res.Header().Get(RequestIDHeader)
+			if _, err := uuid.Parse(got); err != nil {
+				t.Errorf("%q was not replaced with a generated request id, got %q", requestID, got)
+			}
+		}
+	})
+}
+
+func TestFromContext(t *testing.T) {
+	fallback := zap.NewExample()
+
+	t.Run("returns the logger in the context", func(t *testing.T) {
+		logger := zap.NewNop()
+
+		if got := FromContext(NewContext(context.Background(), logger), fallback); got != logger {
+			t.Errorf("got %v want the logger in the context", got)
+		}
+	})
+
+	t.Run("returns the fallback when there is no logger in the context", func(t *testing.T) {
+		if got := FromContext(context.Background(), fallback); got != fallback {
+			t.Errorf("got %v want the fallback", got)
+		}
+	})
+
+	t.Run("returns a logger when there is no fallback either", func(t *testing.T) {
+		if got := FromContext(context.Background(), nil); got == nil {
+			t.Error("got a nil logger")
+		}
+	})
+}
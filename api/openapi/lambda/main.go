@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/akrylysov/algnhsa"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/openapi"
+	"go.uber.org/zap"
+)
+
+func main() {
+	// initialise a new zap logger
+	logger, _ := zap.NewProduction()
+
+	logger.Info("running the openapi document lamdba...")
+
+	mux := http.NewServeMux()
+
+	// the document is public, so there are no claims to read or limits to enforce
+	mux.Handle("/", logging.RequestIDs(logger, openapi.Handler()))
+	algnhsa.ListenAndServe(mux, nil)
+}
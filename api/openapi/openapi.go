@@ -0,0 +1,232 @@
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
+)
+
+// Path is the path the document is served at.
+const Path string = "/openapi.json"
+
+// the openapi 3 document that describes every route of the patients api. it
+// is kept in sync with the json the handlers read and write by the tests.
+//
+//go:embed openapi.json
+var spec []byte
+
+// Document is the part of an openapi 3 document that requests and responses
+// are validated against.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Components struct {
+	Schemas    map[string]*Schema    `json:"schemas"`
+	Parameters map[string]*Parameter `json:"parameters"`
+	Responses  map[string]*Response  `json:"responses"`
+}
+
+// PathItem holds the operations of a path, and the parameters they share.
+type PathItem struct {
+	Parameters []*Parameter `json:"parameters"`
+	Get        *Operation   `json:"get"`
+	Put        *Operation   `json:"put"`
+	Post       *Operation   `json:"post"`
+	Patch      *Operation   `json:"patch"`
+	Delete     *Operation   `json:"delete"`
+}
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Parameters  []*Parameter         `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Ref     string                `json:"$ref"`
+	Headers map[string]*Parameter `json:"headers"`
+	Content map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Load parses the document embedded in the package and resolves the
+// parameters and responses it refers to.
+func Load() (*Document, error) {
+	return Parse(spec)
+}
+
+// Parse parses an openapi 3 document and resolves the parameters and
+// responses it refers to. schemas are resolved when they are validated
+// against.
+func Parse(data []byte) (*Document, error) {
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("unable to parse the openapi document: %w", err)
+	}
+
+	for path, item := range doc.Paths {
+		if err := doc.resolveParameters(item.Parameters); err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+
+		for method, operation := range item.operations() {
+			if err := doc.resolveParameters(operation.Parameters); err != nil {
+				return nil, fmt.Errorf("%v %v: %w", method, path, err)
+			}
+
+			for status, response := range operation.Responses {
+				if response.Ref == "" {
+					continue
+				}
+
+				resolved, ok := doc.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+				if !ok {
+					return nil, fmt.Errorf("%v %v: the %v response refers to %q which does not exist", method, path, status, response.Ref)
+				}
+
+				operation.Responses[status] = resolved
+			}
+		}
+	}
+
+	return &doc, nil
+}
+
+func (doc *Document) resolveParameters(parameters []*Parameter) error {
+	for i, parameter := range parameters {
+		if parameter.Ref == "" {
+			continue
+		}
+
+		resolved, ok := doc.Components.Parameters[strings.TrimPrefix(parameter.Ref, "#/components/parameters/")]
+		if !ok {
+			return fmt.Errorf("the parameter %q does not exist", parameter.Ref)
+		}
+
+		parameters[i] = resolved
+	}
+
+	return nil
+}
+
+// returns the operations of the path by their http method.
+func (item *PathItem) operations() map[string]*Operation {
+	operations := map[string]*Operation{}
+
+	for method, operation := range map[string]*Operation{
+		http.MethodGet:    item.Get,
+		http.MethodPut:    item.Put,
+		http.MethodPost:   item.Post,
+		http.MethodPatch:  item.Patch,
+		http.MethodDelete: item.Delete,
+	} {
+		if operation != nil {
+			operations[method] = operation
+		}
+	}
+
+	return operations
+}
+
+// Operations returns every operation in the document keyed by its method and
+// path, for example "GET /patients/{patient-id}".
+func (doc *Document) Operations() map[string]*Operation {
+	operations := map[string]*Operation{}
+
+	for path, item := range doc.Paths {
+		for method, operation := range item.operations() {
+			operations[method+" "+path] = operation
+		}
+	}
+
+	return operations
+}
+
+// finds the operation that handles the method and path, along with the values
+// of its path parameters. the operation is nil when the document does not
+// describe the request.
+func (doc *Document) findOperation(method string, path string) (*PathItem, *Operation, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	for template, item := range doc.Paths {
+		pathParams, ok := matchPath(strings.Split(strings.Trim(template, "/"), "/"), segments)
+		if !ok {
+			continue
+		}
+
+		return item, item.operations()[method], pathParams
+	}
+
+	return nil, nil, nil
+}
+
+// matches the segments of a request path against those of a path template,
+// returning the values of the template's parameters.
+func matchPath(template []string, segments []string) (map[string]string, bool) {
+	if len(template) != len(segments) {
+		return nil, false
+	}
+
+	pathParams := map[string]string{}
+
+	for i, segment := range template {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+
+			pathParams[strings.Trim(segment, "{}")] = segments[i]
+			continue
+		}
+
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+
+	return pathParams, true
+}
+
+// Handler serves the openapi document. it is public, so that clients can be
+// generated without first signing in.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("allow", "GET, HEAD")
+			problem.Error(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("content-type", "application/json")
+		w.Header().Set("cache-control", "public, max-age=300")
+		w.WriteHeader(http.StatusOK)
+
+		if r.Method == http.MethodGet {
+			w.Write(spec)
+		}
+	})
+}
@@ -0,0 +1,289 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/create"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/validation"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
+)
+
+// the go types the schemas in the document describe.
+var schemaTypes = map[string]reflect.Type{
+	"Patient":                   reflect.TypeOf(patients.Patient{}),
+	"CreatePatientRequest":      reflect.TypeOf(patients.CreatePatientRequest{}),
+	"UpdatePatientRequest":      reflect.TypeOf(patients.UpdatePatientRequest{}),
+	"PatientPatch":              reflect.TypeOf(patients.UpdatePatientRequest{}),
+	"CreatePatientResponse":     reflect.TypeOf(patients.CreatePatientResponse{}),
+	"MergePatientsRequest":      reflect.TypeOf(patients.MergePatientsRequest{}),
+	"PatientSearchResponseItem": reflect.TypeOf(patients.PatientSearchResponseItem{}),
+	"PatientSearchResponse":     reflect.TypeOf(patients.PatientSearchResponse{}),
+	"PatientHistoryItem":        reflect.TypeOf(patients.PatientHistoryItem{}),
+	"FieldChange":               reflect.TypeOf(patients.FieldChange{}),
+	"PatientHistoryResponse":    reflect.TypeOf(patients.PatientHistoryResponse{}),
+	"FieldError":                reflect.TypeOf(problem.FieldError{}),
+	"Problem":                   reflect.TypeOf(problem.Problem{}),
+	"DuplicatePatientsResponse": reflect.TypeOf(create.DuplicatePatientsResponse{}),
+}
+
+func TestLoad(t *testing.T) {
+	doc, err := Load()
+	if err != nil {
+		t.Fatalf("could not load the document: %v", err)
+	}
+
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("got openapi version %q want 3.x", doc.OpenAPI)
+	}
+
+	t.Run("describes every route of the api", func(t *testing.T) {
+		want := []string{
+			"POST /patients",
+			"GET /patients",
+			"GET /patients/{patient-id}",
+			"PUT /patients/{patient-id}",
+			"PATCH /patients/{patient-id}",
+			"POST /patients/{patient-id}/merge",
+			"GET /patients/{patient-id}/history",
+			"GET /openapi.json",
+		}
+
+		operations := doc.Operations()
+		for _, route := range want {
+			if _, ok := operations[route]; !ok {
+				t.Errorf("the document does not describe %v", route)
+			}
+		}
+
+		if len(operations) != len(want) {
+			t.Errorf("got %v operations want %v", len(operations), len(want))
+		}
+	})
+
+	t.Run("every schema it refers to exists", func(t *testing.T) {
+		for route, operation := range doc.Operations() {
+			if operation.RequestBody != nil {
+				for mediaType, content := range operation.RequestBody.Content {
+					checkRefs(t, doc, route+" "+mediaType, content.Schema)
+				}
+			}
+
+			for status, response := range operation.Responses {
+				for mediaType, content := range response.Content {
+					checkRefs(t, doc, route+" "+status+" "+mediaType, content.Schema)
+				}
+			}
+		}
+
+		for name, schema := range doc.Components.Schemas {
+			checkRefs(t, doc, name, schema)
+		}
+	})
+
+	t.Run("every schema is checked against a go type", func(t *testing.T) {
+		for name := range doc.Components.Schemas {
+			if _, ok := schemaTypes[name]; !ok {
+				t.Errorf("the %v schema is not checked against a go type", name)
+			}
+		}
+	})
+}
+
+// fails the test when the schema, or any schema it contains, refers to a
+// schema that does not exist.
+func checkRefs(t *testing.T, doc *Document, name string, schema *Schema) {
+	t.Helper()
+
+	if schema == nil {
+		return
+	}
+
+	if _, err := doc.Resolve(schema); err != nil {
+		t.Errorf("%v: %v", name, err)
+	}
+
+	checkRefs(t, doc, name, schema.Items)
+
+	for property, propertySchema := range schema.Properties {
+		checkRefs(t, doc, name+"."+property, propertySchema)
+	}
+}
+
+func TestSchemasMatchTypes(t *testing.T) {
+	doc, _ := Load()
+
+	for name, goType := range schemaTypes {
+		t.Run(name, func(t *testing.T) {
+			schema, ok := doc.Components.Schemas[name]
+			if !ok {
+				t.Fatalf("the document does not have a %v schema", name)
+			}
+
+			checkSchemaMatchesType(t, doc, name, schema, goType)
+		})
+	}
+}
+
+// fails the test when the properties of the schema are not the json fields of
+// the go type, or they are not of the same type.
+func checkSchemaMatchesType(t *testing.T, doc *Document, name string, schema *Schema, goType reflect.Type) {
+	t.Helper()
+
+	schema, err := doc.Resolve(schema)
+	if err != nil {
+		t.Fatalf("%v: %v", name, err)
+	}
+
+	switch goType.Kind() {
+	case reflect.String:
+		checkType(t, name, schema, "string")
+	case reflect.Bool:
+		checkType(t, name, schema, "boolean")
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		checkType(t, name, schema, "integer")
+	case reflect.Slice:
+		if checkType(t, name, schema, "array") && schema.Items != nil {
+			checkSchemaMatchesType(t, doc, name+"[]", schema.Items, goType.Elem())
+		}
+	case reflect.Struct:
+		if !checkType(t, name, schema, "object") {
+			return
+		}
+
+		if schema.AdditionalProperties == nil || *schema.AdditionalProperties {
+			t.Errorf("%v allows properties that are not fields of %v", name, goType)
+		}
+
+		fields := map[string]reflect.StructField{}
+		omitted := map[string]bool{}
+		jsonFields(goType, fields, omitted)
+
+		for property, propertySchema := range schema.Properties {
+			field, ok := fields[property]
+			if !ok {
+				t.Errorf("%v.%v is not a json field of %v", name, property, goType)
+				continue
+			}
+
+			checkSchemaMatchesType(t, doc, name+"."+property, propertySchema, field.Type)
+		}
+
+		for property := range fields {
+			if _, ok := schema.Properties[property]; !ok {
+				t.Errorf("the json field %v of %v is missing from %v", property, goType, name)
+			}
+		}
+
+		// fields that are left out when they are empty can not be required
+		for _, property := range schema.Required {
+			if omitted[property] {
+				t.Errorf("%v.%v is required but it is omitted from %v when it is empty", name, property, goType)
+			}
+		}
+	default:
+		t.Errorf("%v is a %v, which can not be checked", name, goType.Kind())
+	}
+}
+
+// collects the json fields of a struct, including those of the structs it
+// embeds, by their json names.
+func jsonFields(goType reflect.Type, fields map[string]reflect.StructField, omitted map[string]bool) {
+	for i := 0; i < goType.NumField(); i++ {
+		field := goType.Field(i)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			jsonFields(field.Type, fields, omitted)
+			continue
+		}
+
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		if tag[0] == "" || tag[0] == "-" {
+			continue
+		}
+
+		fields[tag[0]] = field
+		omitted[tag[0]] = len(tag) > 1 && tag[1] == "omitempty"
+	}
+}
+
+func checkType(t *testing.T, name string, schema *Schema, want string) bool {
+	t.Helper()
+
+	if schema.Type != want {
+		t.Errorf("%v is a %q want %q", name, schema.Type, want)
+		return false
+	}
+
+	return true
+}
+
+func TestMaxLengthsMatchValidation(t *testing.T) {
+	doc, _ := Load()
+
+	for _, name := range []string{"CreatePatientRequest", "UpdatePatientRequest"} {
+		schema := doc.Components.Schemas[name]
+
+		for property, propertySchema := range schema.Properties {
+			if propertySchema.MaxLength == nil {
+				continue
+			}
+
+			t.Run(name+"."+property, func(t *testing.T) {
+				// one character too long for the document
+				body, _ := json.Marshal(map[string]string{"first_name": "Jane", property: strings.Repeat("a", *propertySchema.MaxLength+1)})
+
+				var request patients.CreatePatientRequest
+				json.Unmarshal(body, &request)
+
+				want := validation.FieldError{Field: property, Reason: fmt.Sprintf("must be at most %d characters long", *propertySchema.MaxLength)}
+				for _, got := range validation.ValidateCreatePatientRequest(request) {
+					if got == want {
+						return
+					}
+				}
+
+				t.Errorf("the handlers accept %v longer than %v characters", property, *propertySchema.MaxLength)
+			})
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	t.Run("serves the document", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", Path, nil)
+		res := httptest.NewRecorder()
+
+		Handler().ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK {
+			t.Fatalf("got status code %v want %v", res.Code, http.StatusOK)
+		}
+
+		if got := res.Header().Get("content-type"); got != "application/json" {
+			t.Errorf("got content type %q want application/json", got)
+		}
+
+		if _, err := Parse(res.Body.Bytes()); err != nil {
+			t.Errorf("the served document could not be parsed: %v", err)
+		}
+	})
+
+	t.Run("only allows the document to be read", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", Path, nil)
+		res := httptest.NewRecorder()
+
+		Handler().ServeHTTP(res, req)
+
+		if res.Code != http.StatusMethodNotAllowed {
+			t.Errorf("got status code %v want %v", res.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}
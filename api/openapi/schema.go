@@ -0,0 +1,279 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
+)
+
+// Schema is the subset of an openapi 3 schema that the patients api is
+// described with. formats are documentation only and are not validated, the
+// handlers check them when they validate the request.
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Description          string             `json:"description"`
+	Enum                 []interface{}      `json:"enum"`
+	Default              interface{}        `json:"default"`
+	Nullable             bool               `json:"nullable"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+}
+
+// Resolve returns the schema a $ref points at, or the schema itself when it is
+// not a reference.
+func (doc *Document) Resolve(schema *Schema) (*Schema, error) {
+	for schema.Ref != "" {
+		resolved, ok := doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if !ok {
+			return nil, fmt.Errorf("the schema %q does not exist", schema.Ref)
+		}
+
+		schema = resolved
+	}
+
+	return schema, nil
+}
+
+// ValidateJSON decodes data and checks it against the schema, returning the
+// fields that do not match it. a body that is not json is reported against the
+// body field.
+func (doc *Document) ValidateJSON(schema *Schema, data []byte) ([]problem.FieldError, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return []problem.FieldError{{Field: "body", Reason: "must be valid json"}}, nil
+	}
+
+	return doc.Validate(schema, value)
+}
+
+// Validate checks a value decoded from json against the schema, returning the
+// fields that do not match it. numbers are expected to have been decoded as
+// json.Number. an error is only returned when the schema itself is invalid.
+func (doc *Document) Validate(schema *Schema, value interface{}) ([]problem.FieldError, error) {
+	v := &schemaValidator{doc: doc}
+	if err := v.validate(schema, value, ""); err != nil {
+		return nil, err
+	}
+
+	return v.errors, nil
+}
+
+type schemaValidator struct {
+	doc    *Document
+	errors []problem.FieldError
+}
+
+func (v *schemaValidator) fail(field string, reason string) {
+	if field == "" {
+		field = "body"
+	}
+
+	v.errors = append(v.errors, problem.FieldError{Field: field, Reason: reason})
+}
+
+func (v *schemaValidator) validate(schema *Schema, value interface{}, field string) error {
+	schema, err := v.doc.Resolve(schema)
+	if err != nil {
+		return err
+	}
+
+	if value == nil {
+		if !schema.Nullable && schema.Type != "" {
+			v.fail(field, "must not be null")
+		}
+
+		return nil
+	}
+
+	switch schema.Type {
+	case "":
+		return nil
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			v.fail(field, "must be a string")
+			return nil
+		}
+
+		if schema.MinLength != nil && utf8.RuneCountInString(s) < *schema.MinLength {
+			v.fail(field, fmt.Sprintf("must be at least %d characters long", *schema.MinLength))
+		}
+
+		if schema.MaxLength != nil && utf8.RuneCountInString(s) > *schema.MaxLength {
+			v.fail(field, fmt.Sprintf("must be at most %d characters long", *schema.MaxLength))
+		}
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			v.fail(field, "must be a number")
+			return nil
+		}
+
+		v.number(schema, n, field)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.fail(field, "must be either true or false")
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			v.fail(field, "must be an array")
+			return nil
+		}
+
+		if schema.Items == nil {
+			return nil
+		}
+
+		for i, item := range items {
+			if err := v.validate(schema.Items, item, fmt.Sprintf("%v[%d]", field, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.fail(field, "must be an object")
+			return nil
+		}
+
+		return v.object(schema, object, field)
+	default:
+		return fmt.Errorf("the schema type %q is not supported", schema.Type)
+	}
+
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, value) {
+		v.fail(field, fmt.Sprintf("must be one of %v", enumString(schema.Enum)))
+	}
+
+	return nil
+}
+
+func (v *schemaValidator) number(schema *Schema, n json.Number, field string) {
+	if schema.Type == "integer" {
+		if _, err := strconv.ParseInt(n.String(), 10, 64); err != nil {
+			v.fail(field, "must be an integer")
+			return
+		}
+	}
+
+	f, err := n.Float64()
+	if err != nil {
+		v.fail(field, "must be a number")
+		return
+	}
+
+	if schema.Minimum != nil && f < *schema.Minimum {
+		v.fail(field, fmt.Sprintf("must be at least %v", *schema.Minimum))
+	}
+
+	if schema.Maximum != nil && f > *schema.Maximum {
+		v.fail(field, fmt.Sprintf("must be at most %v", *schema.Maximum))
+	}
+}
+
+func (v *schemaValidator) object(schema *Schema, object map[string]interface{}, field string) error {
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			v.fail(join(field, name), "is required")
+		}
+	}
+
+	for _, name := range sortedKeys(object) {
+		property, ok := schema.Properties[name]
+		if !ok {
+			if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+				v.fail(join(field, name), "is not a known field")
+			}
+
+			continue
+		}
+
+		if err := v.validate(property, object[name], join(field, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// returns the name of a property of the field, properties of the body are
+// named on their own so that they match the errors of the handlers.
+func join(field string, name string) string {
+	if field == "" {
+		return name
+	}
+
+	return field + "." + name
+}
+
+// parseParameter converts the value of a query string or header parameter to
+// the type of its schema, so that it can be validated like json.
+func parseParameter(schema *Schema, value string) (interface{}, bool) {
+	switch schema.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return nil, false
+		}
+
+		return json.Number(value), true
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, false
+		}
+
+		return b, true
+	default:
+		return value, true
+	}
+}
+
+// returns the names of the properties of an object in order, so that the
+// errors are always reported in the same order.
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func enumString(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, value := range enum {
+		values[i] = fmt.Sprint(value)
+	}
+
+	return strings.Join(values, ", ")
+}
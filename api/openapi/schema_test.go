@@ -0,0 +1,108 @@
+package openapi
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
+)
+
+func TestValidateJSON(t *testing.T) {
+	doc, _ := Load()
+
+	cases := []struct {
+		name   string
+		schema string
+		body   string
+		want   []problem.FieldError
+	}{
+		{
+			name:   "a valid request",
+			schema: "CreatePatientRequest",
+			body:   `{"first_name": "Jane", "last_name": "Doe"}`,
+		},
+		{
+			name:   "a missing required field",
+			schema: "CreatePatientRequest",
+			body:   `{"last_name": "Doe"}`,
+			want:   []problem.FieldError{{Field: "first_name", Reason: "is required"}},
+		},
+		{
+			name:   "an unknown field",
+			schema: "CreatePatientRequest",
+			body:   `{"first_name": "Jane", "favourite_colour": "blue"}`,
+			want:   []problem.FieldError{{Field: "favourite_colour", Reason: "is not a known field"}},
+		},
+		{
+			name:   "a field of the wrong type",
+			schema: "CreatePatientRequest",
+			body:   `{"first_name": 42}`,
+			want:   []problem.FieldError{{Field: "first_name", Reason: "must be a string"}},
+		},
+		{
+			name:   "a field that is too long",
+			schema: "CreatePatientRequest",
+			body:   `{"first_name": "Jane", "title": "the most honourable lord"}`,
+			want:   []problem.FieldError{{Field: "title", Reason: "must be at most 20 characters long"}},
+		},
+		{
+			name:   "a null where it is not allowed",
+			schema: "CreatePatientRequest",
+			body:   `{"first_name": null}`,
+			want:   []problem.FieldError{{Field: "first_name", Reason: "must not be null"}},
+		},
+		{
+			name:   "a null in a merge patch",
+			schema: "PatientPatch",
+			body:   `{"middle_name": null}`,
+		},
+		{
+			name:   "an item of an array",
+			schema: "MergePatientsRequest",
+			body:   `{"source_patient_id": "test_patient_id", "fields": ["email", 3]}`,
+			want:   []problem.FieldError{{Field: "fields[1]", Reason: "must be a string"}},
+		},
+		{
+			name:   "a property of an item",
+			schema: "PatientHistoryResponse",
+			body:   `{"items": [{"patient_id": "test_patient_id", "version": 1.5, "operation": "deleted", "actor": "test_user_id", "timestamp": "2022-01-01T00:00:00Z", "changes": []}]}`,
+			want: []problem.FieldError{
+				{Field: "items[0].operation", Reason: "must be one of create, update, merge, merged"},
+				{Field: "items[0].version", Reason: "must be an integer"},
+			},
+		},
+		{
+			name:   "a body that is not an object",
+			schema: "CreatePatientRequest",
+			body:   `["Jane"]`,
+			want:   []problem.FieldError{{Field: "body", Reason: "must be an object"}},
+		},
+		{
+			name:   "a body that is not json",
+			schema: "CreatePatientRequest",
+			body:   `first_name=Jane`,
+			want:   []problem.FieldError{{Field: "body", Reason: "must be valid json"}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := doc.ValidateJSON(&Schema{Ref: "#/components/schemas/" + c.schema}, []byte(c.body))
+			if err != nil {
+				t.Fatalf("could not validate the body: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %+v want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestValidateUnknownSchema(t *testing.T) {
+	doc, _ := Load()
+
+	if _, err := doc.ValidateJSON(&Schema{Ref: "#/components/schemas/Unknown"}, []byte(`{}`)); err == nil {
+		t.Error("got no error for a schema that does not exist")
+	}
+}
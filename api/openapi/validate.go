@@ -0,0 +1,258 @@
+package openapi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
+	"go.uber.org/zap"
+)
+
+// ValidateRequests rejects requests whose parameters or json body do not match
+// the operation in the document with a 400, listing the fields that are
+// invalid. requests the document does not describe, and bodies of a media type
+// it does not list, are passed on for the handler to reject.
+func ValidateRequests(logger *zap.Logger, doc *Document, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context(), logger)
+
+		item, operation, pathParams := doc.findOperation(r.Method, r.URL.Path)
+		if operation == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		parameters := append(append([]*Parameter{}, item.Parameters...), operation.Parameters...)
+
+		fieldErrors, err := doc.validateParameters(parameters, r, pathParams)
+		if err != nil {
+			logger.Error("unable to validate the parameters of the request", zap.String("operationID", operation.OperationID), zap.Error(err))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if operation.RequestBody != nil {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				logger.Error("unable to read the request body", zap.Error(err))
+				problem.Error(w, r, "unable to read the request body", http.StatusBadRequest)
+				return
+			}
+
+			// the handler reads the body again once it has been validated
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			bodyErrors, err := doc.validateRequestBody(operation.RequestBody, r.Header.Get("content-type"), body)
+			if err != nil {
+				logger.Error("unable to validate the request body", zap.String("operationID", operation.OperationID), zap.Error(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			fieldErrors = append(fieldErrors, bodyErrors...)
+		}
+
+		if len(fieldErrors) > 0 {
+			logger.Info("the request does not match the openapi document", zap.String("operationID", operation.OperationID), zap.Any("errors", fieldErrors))
+
+			problem.Write(w, r, problem.Validation("request is invalid", fieldErrors))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checks the path, query string and header parameters of the request. the
+// parameters of the operation replace those of its path with the same name.
+func (doc *Document) validateParameters(parameters []*Parameter, r *http.Request, pathParams map[string]string) ([]problem.FieldError, error) {
+	byName := map[string]*Parameter{}
+	names := []string{}
+
+	for _, parameter := range parameters {
+		key := parameter.In + ":" + strings.ToLower(parameter.Name)
+		if _, ok := byName[key]; !ok {
+			names = append(names, key)
+		}
+
+		byName[key] = parameter
+	}
+
+	query := r.URL.Query()
+	fieldErrors := []problem.FieldError{}
+
+	for _, key := range names {
+		parameter := byName[key]
+
+		var value string
+		var ok bool
+
+		switch parameter.In {
+		case "path":
+			value, ok = pathParams[parameter.Name]
+		case "query":
+			value, ok = query.Get(parameter.Name), query.Has(parameter.Name)
+		case "header":
+			value = r.Header.Get(parameter.Name)
+			ok = value != ""
+		default:
+			continue
+		}
+
+		if !ok {
+			if parameter.Required {
+				fieldErrors = append(fieldErrors, problem.FieldError{Field: parameter.Name, Reason: "is required"})
+			}
+
+			continue
+		}
+
+		if parameter.Schema == nil {
+			continue
+		}
+
+		schema, err := doc.Resolve(parameter.Schema)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed, ok := parseParameter(schema, value)
+		if !ok {
+			fieldErrors = append(fieldErrors, problem.FieldError{Field: parameter.Name, Reason: fmt.Sprintf("must be a valid %v", schema.Type)})
+			continue
+		}
+
+		parameterErrors, err := doc.Validate(schema, parsed)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, parameterError := range parameterErrors {
+			fieldErrors = append(fieldErrors, problem.FieldError{Field: parameter.Name, Reason: parameterError.Reason})
+		}
+	}
+
+	return fieldErrors, nil
+}
+
+// checks a json request body against the schema of its media type.
+func (doc *Document) validateRequestBody(requestBody *RequestBody, contentType string, body []byte) ([]problem.FieldError, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, nil
+	}
+
+	content, ok := requestBody.Content[mediaType]
+	if !ok || content.Schema == nil {
+		return nil, nil
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if requestBody.Required {
+			return []problem.FieldError{{Field: "body", Reason: "is required"}}, nil
+		}
+
+		return nil, nil
+	}
+
+	return doc.ValidateJSON(content.Schema, body)
+}
+
+// ValidateResponses checks the status code, content type and json body of
+// every response to a request the document describes, calling report with
+// what does not match. the response is written as it is either way, so that
+// the tests of the handlers can check the document is kept up to date.
+func ValidateResponses(doc *Document, report func(error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, operation, _ := doc.findOperation(r.Method, r.URL.Path)
+		if operation == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if err := doc.validateResponse(operation, recorder.status, recorder.Header().Get("content-type"), recorder.body.Bytes()); err != nil {
+			report(fmt.Errorf("%v %v: %w", r.Method, r.URL.Path, err))
+		}
+	})
+}
+
+func (doc *Document) validateResponse(operation *Operation, status int, contentType string, body []byte) error {
+	response, ok := operation.Responses[strconv.Itoa(status)]
+	if !ok {
+		response, ok = operation.Responses["default"]
+	}
+
+	if !ok {
+		return fmt.Errorf("%v does not document the %d response", operation.OperationID, status)
+	}
+
+	// responses without content, such as redirects, may still have a body
+	// written by the standard library
+	if len(response.Content) == 0 {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("the %d response of %v has an invalid content type %q", status, operation.OperationID, contentType)
+	}
+
+	content, ok := response.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("the %d response of %v does not document the %v content type", status, operation.OperationID, mediaType)
+	}
+
+	if content.Schema == nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return nil
+	}
+
+	fieldErrors, err := doc.ValidateJSON(content.Schema, body)
+	if err != nil {
+		return err
+	}
+
+	if len(fieldErrors) > 0 {
+		reasons := make([]string, len(fieldErrors))
+		for i, fieldError := range fieldErrors {
+			reasons[i] = fieldError.Field + " " + fieldError.Reason
+		}
+
+		return fmt.Errorf("the %d response of %v does not match the document: %v", status, operation.OperationID, strings.Join(reasons, ", "))
+	}
+
+	return nil
+}
+
+// responseRecorder writes the response through while keeping a copy of its
+// status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+
+	return r.ResponseWriter.Write(b)
+}
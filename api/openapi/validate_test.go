@@ -0,0 +1,236 @@
+package openapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
+	"go.uber.org/zap"
+)
+
+func TestValidateRequests(t *testing.T) {
+	doc, _ := Load()
+
+	var gotBody string
+	handler := ValidateRequests(zap.NewNop(), doc, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	cases := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		want        []problem.FieldError
+	}{
+		{
+			name:        "a valid create request",
+			method:      "POST",
+			target:      "/patients",
+			contentType: "application/json",
+			body:        `{"first_name": "Jane"}`,
+		},
+		{
+			name:        "an invalid create request",
+			method:      "POST",
+			target:      "/patients?force=maybe",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"first_name": "Jane", "nickname": "JJ"}`,
+			want: []problem.FieldError{
+				{Field: "force", Reason: "must be a valid boolean"},
+				{Field: "nickname", Reason: "is not a known field"},
+			},
+		},
+		{
+			name:        "an empty body",
+			method:      "POST",
+			target:      "/patients",
+			contentType: "application/json",
+			want:        []problem.FieldError{{Field: "body", Reason: "is required"}},
+		},
+		{
+			name:        "a body of a content type the document does not list",
+			method:      "POST",
+			target:      "/patients",
+			contentType: "text/plain",
+			body:        "Jane",
+		},
+		{
+			name:   "a valid search",
+			method: "GET",
+			target: "/patients?search=doe&field=name&limit=10",
+		},
+		{
+			name:   "an invalid search",
+			method: "GET",
+			target: "/patients?search=doe&field=nickname&limit=500",
+			want: []problem.FieldError{
+				{Field: "field", Reason: "must be one of name, email, mobile_phone, post_code, date_of_birth"},
+				{Field: "limit", Reason: "must be at most 100"},
+			},
+		},
+		{
+			name:        "a merge patch",
+			method:      "PATCH",
+			target:      "/patients/test_patient_id",
+			contentType: "application/merge-patch+json",
+			body:        `{"middle_name": null}`,
+		},
+		{
+			name:   "history with an invalid limit",
+			method: "GET",
+			target: "/patients/test_patient_id/history?limit=ten",
+			want:   []problem.FieldError{{Field: "limit", Reason: "must be a valid integer"}},
+		},
+		{
+			name:   "a route the document does not describe",
+			method: "DELETE",
+			target: "/patients/test_patient_id",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			gotBody = ""
+
+			// create a request to pass to our handler
+			req, _ := http.NewRequest(c.method, c.target, strings.NewReader(c.body))
+			if c.contentType != "" {
+				req.Header.Set("content-type", c.contentType)
+			}
+
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, req)
+
+			if c.want == nil {
+				if res.Code != http.StatusTeapot {
+					t.Fatalf("got status code %v want the request to be handled, %v", res.Code, res.Body.String())
+				}
+
+				// the handler still gets the whole body
+				if gotBody != c.body {
+					t.Errorf("the handler got the body %q want %q", gotBody, c.body)
+				}
+
+				return
+			}
+
+			if res.Code != http.StatusBadRequest {
+				t.Fatalf("got status code %v want %v", res.Code, http.StatusBadRequest)
+			}
+
+			if got := res.Header().Get("content-type"); got != problem.ContentType {
+				t.Errorf("got content type %q want %q", got, problem.ContentType)
+			}
+
+			var got problem.Problem
+			json.NewDecoder(res.Body).Decode(&got)
+
+			if !reflect.DeepEqual(got.Errors, c.want) {
+				t.Errorf("got errors %+v want %+v", got.Errors, c.want)
+			}
+		})
+	}
+}
+
+func TestValidateResponses(t *testing.T) {
+	doc, _ := Load()
+
+	cases := []struct {
+		name        string
+		target      string
+		status      int
+		contentType string
+		body        string
+		wantError   string
+	}{
+		{
+			name:        "a valid response",
+			target:      "/patients/test_patient_id",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"patient_id": "test_patient_id", "first_name": "Jane"}`,
+		},
+		{
+			name:        "a body that does not match",
+			target:      "/patients/test_patient_id",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"patient_id": "test_patient_id", "first_name": "Jane", "shoe_size": 9}`,
+			wantError:   "shoe_size is not a known field",
+		},
+		{
+			name:        "a status code that is not documented",
+			target:      "/patients/test_patient_id",
+			status:      http.StatusTeapot,
+			contentType: "text/plain",
+			body:        "i'm a teapot",
+			wantError:   "does not document the 418 response",
+		},
+		{
+			name:        "a content type that is not documented",
+			target:      "/patients/test_patient_id",
+			status:      http.StatusOK,
+			contentType: "text/html",
+			body:        "<p>Jane</p>",
+			wantError:   "does not document the text/html content type",
+		},
+		{
+			name:   "a response without content",
+			target: "/patients/test_patient_id",
+			status: http.StatusNotModified,
+		},
+		{
+			name:        "a route the document does not describe",
+			target:      "/dentists",
+			status:      http.StatusNotFound,
+			contentType: "text/html",
+			body:        "not found",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var errs []error
+			handler := ValidateResponses(doc, func(err error) { errs = append(errs, err) }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if c.contentType != "" {
+					w.Header().Set("content-type", c.contentType)
+				}
+
+				w.WriteHeader(c.status)
+				io.WriteString(w, c.body)
+			}))
+
+			req, _ := http.NewRequest("GET", c.target, nil)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, req)
+
+			// the response is written as it is either way
+			if res.Code != c.status || res.Body.String() != c.body {
+				t.Errorf("got %v %q want %v %q", res.Code, res.Body.String(), c.status, c.body)
+			}
+
+			if c.wantError == "" {
+				if len(errs) != 0 {
+					t.Errorf("got errors %v want none", errs)
+				}
+
+				return
+			}
+
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), c.wantError) {
+				t.Errorf("got errors %v want one containing %q", errs, c.wantError)
+			}
+		})
+	}
+}
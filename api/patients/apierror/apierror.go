@@ -0,0 +1,55 @@
+package apierror
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
+)
+
+// how long clients are asked to wait before retrying a request that failed
+// because the database was busy or unavailable.
+const retryAfterSeconds string = "1"
+
+// StatusCode returns the http status code that describes an error returned by
+// a patients.PatientRepository.
+func StatusCode(err error) int {
+	switch {
+	case errors.Is(err, patients.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, patients.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, patients.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, patients.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, patients.ErrThrottled), errors.Is(err, patients.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Write replies to the request with a problem of the status code that describes
+// err. the message is used for errors that do not have a more specific
+// description.
+func Write(w http.ResponseWriter, r *http.Request, err error, message string) {
+	status := StatusCode(err)
+
+	switch status {
+	case http.StatusNotFound:
+		message = "requested patient could not be found"
+	case http.StatusConflict:
+		message = "the request conflicts with the current state of the patient"
+	case http.StatusPreconditionFailed:
+		message = "the patient has changed since it was read, get it again and retry"
+	case http.StatusUnprocessableEntity:
+		message = "the patient could not be stored"
+	case http.StatusServiceUnavailable:
+		w.Header().Set("retry-after", retryAfterSeconds)
+		message = "the service is temporarily unavailable, please try again"
+	}
+
+	problem.Error(w, r, message, status)
+}
@@ -0,0 +1,73 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
+)
+
+func TestStatusCode(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "not found", err: patients.ErrNotFound, want: http.StatusNotFound},
+		{name: "wrapped not found", err: fmt.Errorf("getting patient: %w", patients.ErrNotFound), want: http.StatusNotFound},
+		{name: "conflict", err: patients.ErrConflict, want: http.StatusConflict},
+		{name: "patient already exists", err: patients.ErrPatientAlreadyExists, want: http.StatusConflict},
+		{name: "precondition failed", err: patients.ErrPreconditionFailed, want: http.StatusPreconditionFailed},
+		{name: "validation", err: patients.ErrValidation, want: http.StatusUnprocessableEntity},
+		{name: "throttled", err: patients.ErrThrottled, want: http.StatusServiceUnavailable},
+		{name: "unavailable", err: patients.ErrUnavailable, want: http.StatusServiceUnavailable},
+		{name: "anything else", err: errors.New("call to dynamodb failed"), want: http.StatusInternalServerError},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := StatusCode(c.err); got != c.want {
+				t.Errorf("got status code %v want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	t.Run("asks clients to retry when the database is unavailable", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/patients/test_patient_id", nil)
+		res := httptest.NewRecorder()
+
+		Write(res, req, patients.ErrUnavailable, "failed to get patient")
+
+		if res.Code != http.StatusServiceUnavailable {
+			t.Errorf("got status code %v want %v", res.Code, http.StatusServiceUnavailable)
+		}
+
+		if res.Header().Get("retry-after") == "" {
+			t.Error("expected a retry-after header")
+		}
+	})
+
+	t.Run("uses the message for unexpected errors", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/patients/test_patient_id", nil)
+		res := httptest.NewRecorder()
+
+		Write(res, req, errors.New("call to dynamodb failed"), "failed to get patient")
+
+		var got problem.Problem
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatalf("unable to decode the problem, '%v'", err)
+		}
+
+		want := problem.Problem{Type: problem.TypeBlank, Title: "Internal Server Error", Status: http.StatusInternalServerError, Detail: "failed to get patient"}
+		if got.Type != want.Type || got.Title != want.Title || got.Status != want.Status || got.Detail != want.Detail {
+			t.Errorf("got problem %+v want %+v", got, want)
+		}
+	})
+}
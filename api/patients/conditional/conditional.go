@@ -0,0 +1,87 @@
+package conditional
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+)
+
+// ETag returns the entity tag of a patient, which is its version. the version
+// goes up every time the patient is written, so it changes whenever the
+// patient does.
+func ETag(patient patients.Patient) string {
+	return strconv.Quote(strconv.Itoa(patient.Version))
+}
+
+// IfMatch reports whether an If-Match header matches the entity tag, either by
+// listing it or by being "*". weak entity tags never match, as If-Match uses
+// the strong comparison (rfc 9110 section 13.1.1).
+func IfMatch(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IfNoneMatch reports whether an If-None-Match header matches the entity tag,
+// either by listing it or by being "*". If-None-Match uses the weak comparison
+// (rfc 9110 section 13.1.2), so W/"3" matches "3".
+func IfNoneMatch(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LastModified returns when a patient was last written, which is when it was
+// modified or, if it never has been, when it was created. it returns the zero
+// time when neither is known.
+func LastModified(patient patients.Patient) time.Time {
+	for _, timestamp := range []string{patient.ModifiedAt, patient.CreatedAt} {
+		if lastModified, err := time.Parse(time.RFC3339, timestamp); err == nil {
+			return lastModified.UTC()
+		}
+	}
+
+	return time.Time{}
+}
+
+// NotModified reports whether the client already has the current version of
+// the patient, so the request can be answered with 304 Not Modified. the
+// If-None-Match header takes precedence, If-Modified-Since is only used when
+// it is missing (rfc 9110 section 13.2.2).
+func NotModified(r *http.Request, patient patients.Patient) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if ifNoneMatch := r.Header.Get("if-none-match"); ifNoneMatch != "" {
+		return IfNoneMatch(ifNoneMatch, ETag(patient))
+	}
+
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("if-modified-since"))
+	if err != nil {
+		return false
+	}
+
+	lastModified := LastModified(patient)
+	if lastModified.IsZero() {
+		return false
+	}
+
+	// http dates only have whole seconds
+	return !lastModified.Truncate(time.Second).After(ifModifiedSince)
+}
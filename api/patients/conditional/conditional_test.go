@@ -0,0 +1,109 @@
+package conditional
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+)
+
+func TestETag(t *testing.T) {
+	if got := ETag(patients.Patient{Version: 3}); got != `"3"` {
+		t.Errorf("got %v want %v", got, `"3"`)
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	cases := []struct {
+		header string
+		want   bool
+	}{
+		{header: `"3"`, want: true},
+		{header: `"2", "3"`, want: true},
+		{header: `*`, want: true},
+		{header: `"2"`, want: false},
+		{header: `W/"3"`, want: false},
+		{header: `3`, want: false},
+		{header: ``, want: false},
+	}
+
+	for _, c := range cases {
+		if got := IfMatch(c.header, `"3"`); got != c.want {
+			t.Errorf("IfMatch(%q) got %v want %v", c.header, got, c.want)
+		}
+	}
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	cases := []struct {
+		header string
+		want   bool
+	}{
+		{header: `"3"`, want: true},
+		{header: `W/"3"`, want: true},
+		{header: `"2", W/"3"`, want: true},
+		{header: `*`, want: true},
+		{header: `"2"`, want: false},
+		{header: ``, want: false},
+	}
+
+	for _, c := range cases {
+		if got := IfNoneMatch(c.header, `"3"`); got != c.want {
+			t.Errorf("IfNoneMatch(%q) got %v want %v", c.header, got, c.want)
+		}
+	}
+}
+
+func TestLastModified(t *testing.T) {
+	cases := []struct {
+		name    string
+		patient patients.Patient
+		want    time.Time
+	}{
+		{name: "uses when the patient was modified", patient: patients.Patient{CreatedAt: "2022-10-01T09:00:00Z", ModifiedAt: "2022-10-02T09:00:00Z"}, want: time.Date(2022, 10, 2, 9, 0, 0, 0, time.UTC)},
+		{name: "uses when the patient was created if it was never modified", patient: patients.Patient{CreatedAt: "2022-10-01T09:00:00Z"}, want: time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC)},
+		{name: "is zero when neither is known", patient: patients.Patient{}, want: time.Time{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := LastModified(c.patient); !got.Equal(c.want) {
+				t.Errorf("got %v want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	patient := patients.Patient{Version: 3, CreatedAt: "2022-10-01T09:00:00Z", ModifiedAt: "2022-10-02T09:00:00Z"}
+
+	cases := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "without conditional headers", method: "GET", want: false},
+		{name: "with the current etag", method: "GET", headers: map[string]string{"if-none-match": `"3"`}, want: true},
+		{name: "with an older etag", method: "GET", headers: map[string]string{"if-none-match": `"2"`}, want: false},
+		{name: "modified before the date", method: "GET", headers: map[string]string{"if-modified-since": "Sun, 02 Oct 2022 09:00:00 GMT"}, want: true},
+		{name: "modified after the date", method: "GET", headers: map[string]string{"if-modified-since": "Sun, 02 Oct 2022 08:59:59 GMT"}, want: false},
+		{name: "with an invalid date", method: "GET", headers: map[string]string{"if-modified-since": "yesterday"}, want: false},
+		{name: "if-none-match wins over if-modified-since", method: "GET", headers: map[string]string{"if-none-match": `"2"`, "if-modified-since": "Sun, 02 Oct 2022 09:00:00 GMT"}, want: false},
+		{name: "only for reads", method: "PUT", headers: map[string]string{"if-none-match": `"3"`}, want: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, _ := http.NewRequest(c.method, "/patients/test_patient_id", nil)
+			for name, value := range c.headers {
+				req.Header.Set(name, value)
+			}
+
+			if got := NotModified(req, patient); got != c.want {
+				t.Errorf("got %v want %v", got, c.want)
+			}
+		})
+	}
+}
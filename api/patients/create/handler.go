@@ -2,28 +2,55 @@ package create
 
 import (
 	"encoding/json"
+	"errors"
 	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
 
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
 	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/apierror"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/redaction"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/validation"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
 	"go.uber.org/zap"
 )
 
+// the header the front-end sends a key in that is the same for every retry
+// of a request.
+const idempotencyKeyHeader string = "idempotency-key"
+
+// the header that marks a response as the replay of an earlier response.
+const idempotentReplayedHeader string = "idempotent-replayed"
+
+const maxIdempotencyKeyLength int = 255
+
 func CreatePatientHandler(logger *zap.Logger, repository patients.PatientRepository) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context(), logger)
+
+		identity, ok := auth.FromContext(r.Context())
+		if !ok {
+			logger.Error("no dental practice was found for the request")
+			problem.Error(w, r, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
 		// enforce a json content-type
 		contentType := r.Header.Get("content-type")
 
 		mediatype, _, err := mime.ParseMediaType(contentType)
 		if err != nil {
 			logger.Error("error when parsing the mime type", zap.Error(err))
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			problem.Error(w, r, err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		if mediatype != "application/json" {
 			logger.Error("unsupported content-type", zap.Error(err))
-			http.Error(w, "api expects application/json content-type", http.StatusUnsupportedMediaType)
+			problem.Error(w, r, "api expects application/json content-type", http.StatusUnsupportedMediaType)
 			return
 		}
 
@@ -32,28 +59,180 @@ func CreatePatientHandler(logger *zap.Logger, repository patients.PatientReposit
 		var createPatientRequest patients.CreatePatientRequest
 		if err := dec.Decode(&createPatientRequest); err != nil {
 			logger.Error("the request body is invalid", zap.Error(err))
-			http.Error(w, "request body is invalid", http.StatusBadRequest)
+			problem.Error(w, r, "request body is invalid", http.StatusBadRequest)
 			return
 		}
 
 		// validation
-		if createPatientRequest.FirstName == "" {
-			http.Error(w, "request body is invalid", http.StatusBadRequest)
+		if fieldErrors := validation.ValidateCreatePatientRequest(createPatientRequest); len(fieldErrors) > 0 {
+			logger.Error("the request body failed validation", zap.Any("fieldErrors", fieldErrors))
+			problem.Write(w, r, problem.Validation("request body is invalid", fieldErrors))
 			return
 		}
 
-		response, err := repository.CreatePatient(logger, r.Context(), createPatientRequest)
+		// the front-end retries requests that time out, so a retry of a request
+		// that already created the patient gets the same response rather than
+		// creating the patient again
+		var idempotencyKey patients.IdempotencyKey
+		if key := r.Header.Get(idempotencyKeyHeader); key != "" {
+			if !validIdempotencyKey(key) {
+				logger.Error("the idempotency key is invalid", zap.Int("length", len(key)))
+				problem.Error(w, r, "the idempotency key must be between 1 and 255 printable characters", http.StatusBadRequest)
+				return
+			}
+
+			idempotencyKey, err = patients.NewIdempotencyKey(key, createPatientRequest)
+			if err != nil {
+				logger.Error("failed to hash the request", zap.Error(err))
+				problem.Error(w, r, "failed to create the patient", http.StatusInternalServerError)
+				return
+			}
+
+			response, err := repository.GetIdempotentResponse(logging.NewContext(r.Context(), logger), identity.DentalPracticeID, idempotencyKey)
+			if err == nil {
+				logger.Info("the request was already made, replaying the response", zap.String("patientID", response.PatientID))
+				w.Header().Set(idempotentReplayedHeader, "true")
+				writeCreatedPatient(logger, w, response)
+				return
+			}
+
+			if !errors.Is(err, patients.ErrNotFound) {
+				logger.Error("failed to get the response for the idempotency key", zap.Error(err))
+				writeCreatePatientError(w, r, err)
+				return
+			}
+		}
+
+		// unless the caller has confirmed the patient is new, look for patients
+		// that are likely to be the same person first
+		force, err := parseForce(r.URL.Query())
 		if err != nil {
-			logger.Error("failed to create the patient", zap.Error(err))
-			http.Error(w, "failed to create the patient", http.StatusInternalServerError)
+			logger.Error("the force query string param is invalid", zap.Error(err))
+			problem.Error(w, r, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
+		if !force {
+			duplicatePatientIDs, err := repository.FindDuplicatePatients(logging.NewContext(r.Context(), logger), identity.DentalPracticeID, createPatientRequest)
+			if err != nil {
+				logger.Error("failed to look for duplicate patients", zap.Error(err))
+				apierror.Write(w, r, err, "failed to create the patient")
+				return
+			}
 
-		err = json.NewEncoder(w).Encode(response)
+			if len(duplicatePatientIDs) > 0 {
+				logger.Info("the patient may already exist", zap.Strings("duplicatePatientIDs", duplicatePatientIDs))
+				writeDuplicatePatients(w, r, identity.Role, duplicatePatientIDs)
+				return
+			}
+		}
+
+		var response patients.CreatePatientResponse
+		if idempotencyKey.Key != "" {
+			response, err = repository.CreatePatientIdempotently(logging.NewContext(r.Context(), logger), identity.DentalPracticeID, idempotencyKey, createPatientRequest)
+		} else {
+			response, err = repository.CreatePatient(logging.NewContext(r.Context(), logger), identity.DentalPracticeID, createPatientRequest)
+		}
 		if err != nil {
-			logger.Error("failed to encode the json for the create patient response", zap.Error(err))
+			logger.Error("failed to create the patient", zap.Error(err))
+			writeCreatePatientError(w, r, err)
+			return
+		}
+
+		writeCreatedPatient(logger, w, response)
+	})
+}
+
+// writes the response to a request that created a patient, which is the
+// same for every retry of the request.
+func writeCreatedPatient(logger *zap.Logger, w http.ResponseWriter, response patients.CreatePatientResponse) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		logger.Error("failed to encode the json for the create patient response", zap.Error(err))
+	}
+}
+
+// replies with the status code that describes err, an idempotency key that
+// was used with a different request is called out as such.
+func writeCreatePatientError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, patients.ErrIdempotencyKeyReused) {
+		problem.Write(w, r, &problem.Problem{
+			Type:   problem.TypeIdempotencyKeyReused,
+			Title:  "Idempotency Key Reused",
+			Status: http.StatusUnprocessableEntity,
+			Detail: "the idempotency key was already used with a different request",
+		})
+		return
+	}
+
+	apierror.Write(w, r, err, "failed to create the patient")
+}
+
+// idempotency keys end up in the table and in log lines, so only printable
+// ascii without spaces is accepted.
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] > '~' {
+			return false
 		}
+	}
+
+	return true
+}
+
+// DuplicatePatientsResponse is the problem returned when the patient being
+// created is likely to be the same person as existing patients, which it lists
+// to the roles that can see why they matched.
+type DuplicatePatientsResponse struct {
+	problem.Problem
+	DuplicatePatientIDs []string `json:"duplicate_patient_ids,omitempty"`
+}
+
+// the json names of the fields that patients are matched as duplicates by.
+var duplicateMatchFields = []string{"national_insurance_number", "date_of_birth", "email", "first_name", "last_name"}
+
+// parses the force query string param, which creates the patient even if it
+// looks like a duplicate.
+func parseForce(query url.Values) (bool, error) {
+	if !query.Has("force") {
+		return false, nil
+	}
+
+	force, err := strconv.ParseBool(query.Get("force"))
+	if err != nil {
+		return false, errors.New("force must be either true or false")
+	}
+
+	return force, nil
+}
+
+// writes the ids of the likely duplicates so the front-end can offer to open
+// one of them instead, or to create the patient anyway. a role that can not
+// see every field the patients are matched by is only told there are likely
+// duplicates, otherwise the ids would show it which patients share, say, the
+// national insurance number it typed in.
+func writeDuplicatePatients(w http.ResponseWriter, r *http.Request, role auth.Role, duplicatePatientIDs []string) {
+	for _, field := range duplicateMatchFields {
+		if redaction.FieldVisibility(role, field) != redaction.Visible {
+			duplicatePatientIDs = nil
+			break
+		}
+	}
+
+	problem.Write(w, r, &DuplicatePatientsResponse{
+		Problem: problem.Problem{
+			Type:   problem.TypeDuplicatePatient,
+			Title:  "Duplicate Patient",
+			Status: http.StatusConflict,
+			Detail: "the patient may already exist, set force=true to create them anyway",
+		},
+		DuplicatePatientIDs: duplicatePatientIDs,
 	})
 }
@@ -5,35 +5,75 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
 	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
 	"go.uber.org/zap"
 )
 
 type StubPatientStore struct {
-	createPatient  func(logger *zap.Logger, ctx context.Context, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error)
-	getPatient     func(logger *zap.Logger, ctx context.Context, patientID string) (patients.Patient, error)
-	searchPatients func(logger *zap.Logger, ctx context.Context, searchTerm string) ([]patients.PatientSearchResponseItem, error)
+	createPatient             func(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error)
+	getPatient                func(ctx context.Context, dentalPracticeID string, patientID string) (patients.Patient, error)
+	searchPatients            func(ctx context.Context, dentalPracticeID string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error)
+	updatePatient             func(ctx context.Context, dentalPracticeID string, patient patients.UpdatePatientRequest, expectedVersion int) (patients.Patient, error)
+	listPatients              func(ctx context.Context, dentalPracticeID string, request patients.ListPatientsRequest) (patients.PatientSearchResponse, error)
+	findDuplicatePatients     func(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) ([]string, error)
+	mergePatients             func(ctx context.Context, dentalPracticeID string, request patients.MergePatientsRequest, expectedVersion int) (patients.Patient, error)
+	getPatientHistory         func(ctx context.Context, dentalPracticeID string, request patients.PatientHistoryRequest) (patients.PatientHistoryResponse, error)
+	getIdempotentResponse     func(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey) (patients.CreatePatientResponse, error)
+	createPatientIdempotently func(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error)
 }
 
-func (s *StubPatientStore) CreatePatient(logger *zap.Logger, ctx context.Context, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
-	return s.createPatient(logger, ctx, patient)
+func (s *StubPatientStore) CreatePatient(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+	return s.createPatient(ctx, dentalPracticeID, patient)
 }
 
-func (s *StubPatientStore) GetPatient(logger *zap.Logger, ctx context.Context, patientID string) (patients.Patient, error) {
-	return s.getPatient(logger, ctx, patientID)
+func (s *StubPatientStore) GetPatient(ctx context.Context, dentalPracticeID string, patientID string) (patients.Patient, error) {
+	return s.getPatient(ctx, dentalPracticeID, patientID)
 }
 
-func (s *StubPatientStore) SearchPatients(logger *zap.Logger, ctx context.Context, searchTerm string) ([]patients.PatientSearchResponseItem, error) {
-	return s.searchPatients(logger, ctx, searchTerm)
+func (s *StubPatientStore) SearchPatients(ctx context.Context, dentalPracticeID string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+	return s.searchPatients(ctx, dentalPracticeID, request)
+}
+
+func (s *StubPatientStore) UpdatePatient(ctx context.Context, dentalPracticeID string, patient patients.UpdatePatientRequest, expectedVersion int) (patients.Patient, error) {
+	return s.updatePatient(ctx, dentalPracticeID, patient, expectedVersion)
+}
+
+func (s *StubPatientStore) ListPatients(ctx context.Context, dentalPracticeID string, request patients.ListPatientsRequest) (patients.PatientSearchResponse, error) {
+	return s.listPatients(ctx, dentalPracticeID, request)
+}
+
+func (s *StubPatientStore) FindDuplicatePatients(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) ([]string, error) {
+	return s.findDuplicatePatients(ctx, dentalPracticeID, patient)
+}
+
+func (s *StubPatientStore) MergePatients(ctx context.Context, dentalPracticeID string, request patients.MergePatientsRequest, expectedVersion int) (patients.Patient, error) {
+	return s.mergePatients(ctx, dentalPracticeID, request, expectedVersion)
+}
+
+func (s *StubPatientStore) GetPatientHistory(ctx context.Context, dentalPracticeID string, request patients.PatientHistoryRequest) (patients.PatientHistoryResponse, error) {
+	return s.getPatientHistory(ctx, dentalPracticeID, request)
+}
+
+func (s *StubPatientStore) GetIdempotentResponse(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey) (patients.CreatePatientResponse, error) {
+	return s.getIdempotentResponse(ctx, dentalPracticeID, key)
+}
+
+func (s *StubPatientStore) CreatePatientIdempotently(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+	return s.createPatientIdempotently(ctx, dentalPracticeID, key, patient)
 }
 
 func TestCreatePatient(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
 	contentType := "content-type"
 	applicationJson := "application/json"
 
@@ -45,7 +85,8 @@ func TestCreatePatient(t *testing.T) {
 
 		// create the stub patient store
 		patientsStore := StubPatientStore{
-			createPatient: func(_ *zap.Logger, _ context.Context, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+			findDuplicatePatients: noDuplicatePatients,
+			createPatient: func(_ context.Context, _ string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
 				if patient.FirstName != patientToBeCreated.FirstName {
 					t.Errorf("got: CreatePatient(%s) expected CreatePatient(%s)", patient.FirstName, patientToBeCreated.FirstName)
 				}
@@ -56,6 +97,9 @@ func TestCreatePatient(t *testing.T) {
 		// create a request to pass to our handler
 		req, _ := http.NewRequest("POST", "/patients", nil)
 
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
 		// set the content type
 		req.Header.Set(contentType, "text/csv")
 
@@ -79,7 +123,8 @@ func TestCreatePatient(t *testing.T) {
 
 		// create the stub patient store
 		patientsStore := StubPatientStore{
-			createPatient: func(_ *zap.Logger, _ context.Context, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+			findDuplicatePatients: noDuplicatePatients,
+			createPatient: func(_ context.Context, _ string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
 				if patient.FirstName != patientToBeCreated.FirstName {
 					t.Errorf("got: CreatePatient(%s) expected CreatePatient(%s)", patient.FirstName, patientToBeCreated.FirstName)
 				}
@@ -92,6 +137,9 @@ func TestCreatePatient(t *testing.T) {
 		// create a request to pass to our handler
 		req, _ := http.NewRequest("POST", "/patients", bytes.NewBuffer(jsonValue))
 
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
 		// set the content type
 		req.Header.Set(contentType, applicationJson)
 
@@ -109,13 +157,77 @@ func TestCreatePatient(t *testing.T) {
 		assertStatusCode(t, res.Code, http.StatusBadRequest)
 	})
 
+	t.Run("create returns 400 (bad request) listing every invalid field", func(t *testing.T) {
+		// the patient to be created
+		patientToBeCreated := patients.CreatePatientRequest{Email: "not_an_email", DateOfBirth: "2999-01-01"}
+
+		// create the stub patient store
+		patientsStore := StubPatientStore{
+			findDuplicatePatients: noDuplicatePatients,
+			createPatient: func(_ context.Context, _ string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+				t.Error("CreatePatient() should not be called with an invalid request")
+				return patients.CreatePatientResponse{}, nil
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patientToBeCreated)
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", "/patients", bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := CreatePatientHandler(logger, &patientsStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusBadRequest)
+
+		// the field errors are returned in a problem
+		if got := res.Header().Get(contentType); got != problem.ContentType {
+			t.Errorf("handler returned content type %q want %q", got, problem.ContentType)
+		}
+
+		// decode the json response into problem.Problem
+		var got problem.Problem
+		err := json.NewDecoder(res.Body).Decode(&got)
+		if err != nil {
+			t.Fatalf("unable to process response from server into a Problem, '%v'", err)
+		}
+
+		if got.Type != problem.TypeValidation || got.Status != http.StatusBadRequest {
+			t.Errorf("handler returned unexpected problem %+v", got)
+		}
+
+		var fields []string
+		for _, fieldError := range got.Errors {
+			fields = append(fields, fieldError.Field)
+		}
+
+		if diff := cmp.Diff(fields, []string{"first_name", "email", "date_of_birth"}); diff != "" {
+			t.Error("handler returned unexpected field errors", diff)
+		}
+	})
+
 	t.Run("create returns 500 (internal server error) when call to dynamodb fails", func(t *testing.T) {
 		// the patient to be created
 		patientToBeCreated := patients.CreatePatientRequest{FirstName: "Jason"}
 
 		// create the stub patient store
 		patientsStore := StubPatientStore{
-			createPatient: func(_ *zap.Logger, _ context.Context, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+			findDuplicatePatients: noDuplicatePatients,
+			createPatient: func(_ context.Context, _ string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
 				if patient.FirstName != patientToBeCreated.FirstName {
 					t.Errorf("got: CreatePatient(%s) expected CreatePatient(%s)", patient.FirstName, patientToBeCreated.FirstName)
 				}
@@ -128,6 +240,9 @@ func TestCreatePatient(t *testing.T) {
 		// create a request to pass to our handler
 		req, _ := http.NewRequest("POST", "/patients", bytes.NewBuffer(jsonValue))
 
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
 		// set the content type
 		req.Header.Set(contentType, applicationJson)
 
@@ -145,6 +260,43 @@ func TestCreatePatient(t *testing.T) {
 		assertStatusCode(t, res.Code, http.StatusInternalServerError)
 	})
 
+	t.Run("create returns 409 (conflict) when the patient already exists", func(t *testing.T) {
+		// the patient to be created
+		patientToBeCreated := patients.CreatePatientRequest{FirstName: "Jason"}
+
+		// create the stub patient store
+		patientsStore := StubPatientStore{
+			findDuplicatePatients: noDuplicatePatients,
+			createPatient: func(_ context.Context, _ string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+				return patients.CreatePatientResponse{}, patients.ErrPatientAlreadyExists
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patientToBeCreated)
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", "/patients", bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := CreatePatientHandler(logger, &patientsStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusConflict)
+	})
+
 	t.Run("create returns 201 (created) and new patient id when first name is set in the body", func(t *testing.T) {
 		// the patient to be created
 		expectedPatient := patients.CreatePatientRequest{FirstName: "James"}
@@ -154,7 +306,8 @@ func TestCreatePatient(t *testing.T) {
 
 		// create the stub patient store
 		patientsStore := StubPatientStore{
-			createPatient: func(_ *zap.Logger, _ context.Context, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+			findDuplicatePatients: noDuplicatePatients,
+			createPatient: func(_ context.Context, _ string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
 				if patient.FirstName != expectedPatient.FirstName {
 					t.Errorf("got: CreatePatient(%s) expected CreatePatient(%s)", patient, expectedPatient)
 				}
@@ -167,6 +320,9 @@ func TestCreatePatient(t *testing.T) {
 		// create a request to pass to our handler
 		req, _ := http.NewRequest("POST", "/patients", bytes.NewBuffer(jsonValue))
 
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
 		// set the content type
 		req.Header.Set(contentType, applicationJson)
 
@@ -189,6 +345,484 @@ func TestCreatePatient(t *testing.T) {
 		// assert response body
 		assertCreatePatientResponse(t, got, expectedResponse)
 	})
+
+	t.Run("create returns 401 (unauthorized) when the request is not made on behalf of a dental practice", func(t *testing.T) {
+		// create the stub patient store
+		patientsStore := StubPatientStore{
+			findDuplicatePatients: noDuplicatePatients,
+			createPatient: func(_ context.Context, _ string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+				t.Error("CreatePatient() should not be called without a dental practice")
+				return patients.CreatePatientResponse{}, nil
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patients.CreatePatientRequest{FirstName: "James"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", "/patients", bytes.NewBuffer(jsonValue))
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := CreatePatientHandler(logger, &patientsStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("check that the dental practice of the request is getting passed to the patients store", func(t *testing.T) {
+		// create the stub patient store
+		patientsStore := StubPatientStore{
+			findDuplicatePatients: noDuplicatePatients,
+			createPatient: func(_ context.Context, requestedDentalPracticeID string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+				if requestedDentalPracticeID != dentalPracticeID {
+					t.Errorf("%q was passed to CreatePatient() but the expected value was %q", requestedDentalPracticeID, dentalPracticeID)
+				}
+				return patients.CreatePatientResponse{PatientID: "test_id"}, nil
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patients.CreatePatientRequest{FirstName: "James"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", "/patients", bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := CreatePatientHandler(logger, &patientsStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusCreated)
+	})
+
+	t.Run("create returns 409 (conflict) along with the ids of the likely duplicates", func(t *testing.T) {
+		// the patient to be created
+		patientToBeCreated := patients.CreatePatientRequest{FirstName: "Jane", LastName: "Doe", DateOfBirth: "1985-03-14"}
+
+		// create the stub patient store
+		patientsStore := StubPatientStore{
+			findDuplicatePatients: func(_ context.Context, requestedDentalPracticeID string, patient patients.CreatePatientRequest) ([]string, error) {
+				if requestedDentalPracticeID != dentalPracticeID {
+					t.Errorf("%q was passed to FindDuplicatePatients() but the expected value was %q", requestedDentalPracticeID, dentalPracticeID)
+				}
+
+				if patient.FirstName != patientToBeCreated.FirstName {
+					t.Errorf("got: FindDuplicatePatients(%s) expected FindDuplicatePatients(%s)", patient.FirstName, patientToBeCreated.FirstName)
+				}
+
+				return []string{"test_patient_id_1", "test_patient_id_2"}, nil
+			},
+			createPatient: func(_ context.Context, _ string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+				t.Error("CreatePatient() should not be called when there are likely duplicates")
+				return patients.CreatePatientResponse{}, nil
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patientToBeCreated)
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", "/patients", bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := CreatePatientHandler(logger, &patientsStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusConflict)
+
+		// decode the json response into a DuplicatePatientsResponse
+		var got DuplicatePatientsResponse
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatalf("unable to process response from server into a DuplicatePatientsResponse, '%v'", err)
+		}
+
+		if diff := cmp.Diff(got.DuplicatePatientIDs, []string{"test_patient_id_1", "test_patient_id_2"}); diff != "" {
+			t.Error("handler returned unexpected duplicate patient ids", diff)
+		}
+
+		if got.Type != problem.TypeDuplicatePatient || got.Status != http.StatusConflict {
+			t.Errorf("handler returned unexpected problem %+v", got.Problem)
+		}
+	})
+
+	t.Run("create returns 409 (conflict) without the ids of the likely duplicates to a role that can not see every field they match by", func(t *testing.T) {
+		// the patient to be created
+		patientToBeCreated := patients.CreatePatientRequest{FirstName: "Jane", LastName: "Doe", NationalInsuranceNumber: "AB123456C"}
+
+		// create the stub patient store
+		patientsStore := StubPatientStore{
+			findDuplicatePatients: func(_ context.Context, _ string, _ patients.CreatePatientRequest) ([]string, error) {
+				return []string{"test_patient_id_1"}, nil
+			},
+			createPatient: func(_ context.Context, _ string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+				t.Error("CreatePatient() should not be called when there are likely duplicates")
+				return patients.CreatePatientResponse{}, nil
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patientToBeCreated)
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", "/patients", bytes.NewBuffer(jsonValue))
+
+		// set the dental practice and the role the request is made with
+		req = withRole(req, dentalPracticeID, auth.RoleReceptionist)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := CreatePatientHandler(logger, &patientsStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusConflict)
+
+		// decode the json response into a map, so a missing field can be told apart
+		// from an empty one
+		var got map[string]interface{}
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatalf("unable to process response from server into a map, '%v'", err)
+		}
+
+		if ids, ok := got["duplicate_patient_ids"]; ok {
+			t.Errorf("got duplicate patient ids %v want none", ids)
+		}
+
+		if got["type"] != problem.TypeDuplicatePatient {
+			t.Errorf("handler returned unexpected problem %+v", got)
+		}
+	})
+
+	t.Run("create returns 201 (created) for a likely duplicate when force is set", func(t *testing.T) {
+		// create the stub patient store
+		patientsStore := StubPatientStore{
+			findDuplicatePatients: func(_ context.Context, _ string, _ patients.CreatePatientRequest) ([]string, error) {
+				t.Error("FindDuplicatePatients() should not be called when force is set")
+				return []string{"test_patient_id_1"}, nil
+			},
+			createPatient: func(_ context.Context, _ string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+				return patients.CreatePatientResponse{PatientID: "test_id"}, nil
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patients.CreatePatientRequest{FirstName: "Jane"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", "/patients?force=true", bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := CreatePatientHandler(logger, &patientsStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusCreated)
+	})
+
+	t.Run("create returns 400 (bad request) when force is not a boolean", func(t *testing.T) {
+		// create the stub patient store
+		patientsStore := StubPatientStore{}
+
+		jsonValue, _ := json.Marshal(patients.CreatePatientRequest{FirstName: "Jane"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", "/patients?force=please", bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := CreatePatientHandler(logger, &patientsStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusBadRequest)
+	})
+
+	t.Run("create returns 503 (service unavailable) when looking for duplicates fails", func(t *testing.T) {
+		// create the stub patient store
+		patientsStore := StubPatientStore{
+			findDuplicatePatients: func(_ context.Context, _ string, _ patients.CreatePatientRequest) ([]string, error) {
+				return nil, fmt.Errorf("could not search patients: %w", patients.ErrThrottled)
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patients.CreatePatientRequest{FirstName: "Jane"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", "/patients", bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := CreatePatientHandler(logger, &patientsStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusServiceUnavailable)
+	})
+}
+
+func TestCreatePatientWithIdempotencyKey(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	contentType := "content-type"
+	applicationJson := "application/json"
+
+	// create the logger
+	logger, _ := zap.NewProduction()
+
+	// the patient to be created
+	patientToBeCreated := patients.CreatePatientRequest{FirstName: "James"}
+
+	// the key the front-end sends with every retry of the request
+	idempotencyKey, _ := patients.NewIdempotencyKey("test_idempotency_key", patientToBeCreated)
+
+	t.Run("create returns 201 (created) and remembers the response for a new idempotency key", func(t *testing.T) {
+		// the expected handler response
+		expectedResponse := patients.CreatePatientResponse{PatientID: "test_id"}
+
+		// create the stub patient store
+		patientsStore := StubPatientStore{
+			findDuplicatePatients: noDuplicatePatients,
+			getIdempotentResponse: func(_ context.Context, _ string, _ patients.IdempotencyKey) (patients.CreatePatientResponse, error) {
+				return patients.CreatePatientResponse{}, patients.ErrNotFound
+			},
+			createPatientIdempotently: func(_ context.Context, _ string, key patients.IdempotencyKey, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+				if key != idempotencyKey {
+					t.Errorf("got: CreatePatientIdempotently(%+v) expected CreatePatientIdempotently(%+v)", key, idempotencyKey)
+				}
+				return expectedResponse, nil
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patientToBeCreated)
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", "/patients", bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type and the idempotency key
+		req.Header.Set(contentType, applicationJson)
+		req.Header.Set("idempotency-key", idempotencyKey.Key)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := CreatePatientHandler(logger, &patientsStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusCreated)
+
+		// assert response body
+		assertCreatePatientResponse(t, getResponse(t, res.Body), expectedResponse)
+	})
+
+	t.Run("create returns 201 (created) and the original response when the request is retried", func(t *testing.T) {
+		// the response to the original request
+		expectedResponse := patients.CreatePatientResponse{PatientID: "test_id"}
+
+		// create the stub patient store, nothing is created or looked up again
+		patientsStore := StubPatientStore{
+			getIdempotentResponse: func(_ context.Context, requestedDentalPracticeID string, key patients.IdempotencyKey) (patients.CreatePatientResponse, error) {
+				if requestedDentalPracticeID != dentalPracticeID || key != idempotencyKey {
+					t.Errorf("got: GetIdempotentResponse(%s, %+v) expected GetIdempotentResponse(%s, %+v)", requestedDentalPracticeID, key, dentalPracticeID, idempotencyKey)
+				}
+				return expectedResponse, nil
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patientToBeCreated)
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", "/patients", bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type and the idempotency key
+		req.Header.Set(contentType, applicationJson)
+		req.Header.Set("idempotency-key", idempotencyKey.Key)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := CreatePatientHandler(logger, &patientsStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusCreated)
+
+		// assert response body
+		assertCreatePatientResponse(t, getResponse(t, res.Body), expectedResponse)
+
+		// assert the response is marked as a replay
+		if got := res.Header().Get("idempotent-replayed"); got != "true" {
+			t.Errorf("got idempotent-replayed header %q want %q", got, "true")
+		}
+
+		// a replay is the same json as the response it replays
+		if got := res.Header().Get(contentType); got != applicationJson {
+			t.Errorf("got content type %q want %q", got, applicationJson)
+		}
+	})
+
+	t.Run("create returns 422 (unprocessable entity) when the idempotency key is reused with a different body", func(t *testing.T) {
+		// create the stub patient store
+		patientsStore := StubPatientStore{
+			getIdempotentResponse: func(_ context.Context, _ string, _ patients.IdempotencyKey) (patients.CreatePatientResponse, error) {
+				return patients.CreatePatientResponse{}, patients.ErrIdempotencyKeyReused
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patients.CreatePatientRequest{FirstName: "Jim"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", "/patients", bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type and the idempotency key
+		req.Header.Set(contentType, applicationJson)
+		req.Header.Set("idempotency-key", idempotencyKey.Key)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := CreatePatientHandler(logger, &patientsStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusUnprocessableEntity)
+	})
+
+	t.Run("create returns 400 (bad request) when the idempotency key is invalid", func(t *testing.T) {
+		// create the stub patient store, the key is rejected before it is used
+		patientsStore := StubPatientStore{}
+
+		jsonValue, _ := json.Marshal(patientToBeCreated)
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", "/patients", bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type and the idempotency key
+		req.Header.Set(contentType, applicationJson)
+		req.Header.Set("idempotency-key", "not a valid key")
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := CreatePatientHandler(logger, &patientsStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusBadRequest)
+	})
+}
+
+// a FindDuplicatePatients stub for the tests where the patient is new.
+func noDuplicatePatients(_ context.Context, _ string, _ patients.CreatePatientRequest) ([]string, error) {
+	return nil, nil
+}
+
+func withDentalPractice(req *http.Request, dentalPracticeID string) *http.Request {
+	return withRole(req, dentalPracticeID, auth.RoleAdmin)
+}
+
+func withRole(req *http.Request, dentalPracticeID string, role auth.Role) *http.Request {
+	return req.WithContext(auth.NewContext(req.Context(), auth.Identity{DentalPracticeID: dentalPracticeID, Role: role}))
 }
 
 func assertStatusCode(t testing.TB, got, want int) {
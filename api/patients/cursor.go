@@ -0,0 +1,90 @@
+package patients
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded, or
+// was not issued for the dental practice that is using it.
+var ErrInvalidCursor = errors.New("the pagination cursor is invalid")
+
+// turns the last evaluated key of a query into an opaque cursor that can be
+// handed to api clients. an empty key means there are no more pages, which is
+// represented by an empty cursor.
+func encodeCursor(lastEvaluatedKey map[string]types.AttributeValue) (string, error) {
+	if len(lastEvaluatedKey) == 0 {
+		return "", nil
+	}
+
+	// all of the table and index keys are strings
+	key := map[string]string{}
+	for name, value := range lastEvaluatedKey {
+		s, ok := value.(*types.AttributeValueMemberS)
+		if !ok {
+			return "", fmt.Errorf("the %q key attribute is not a string", name)
+		}
+
+		key[name] = s.Value
+	}
+
+	cursor, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(cursor), nil
+}
+
+// turns a cursor back into the exclusive start key of a query. the partition
+// key inside the cursor has to match the partition being queried, so a cursor
+// can never be used to read another dental practice's patients. the cursor has
+// to hold exactly the key attributes of the table, along with the sort key of
+// the index when one is queried, which is empty for the table itself.
+func decodeCursor(cursor string, partitionKey types.AttributeValue, indexSortKey string) (map[string]types.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var key map[string]string
+	err = json.Unmarshal(decoded, &key)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	expectedPartitionKey, ok := partitionKey.(*types.AttributeValueMemberS)
+	if !ok || key["_pk"] != expectedPartitionKey.Value {
+		return nil, ErrInvalidCursor
+	}
+
+	keyAttributes := []string{"_pk", "_sk"}
+	if indexSortKey != "" {
+		keyAttributes = append(keyAttributes, indexSortKey)
+	}
+
+	if len(key) != len(keyAttributes) {
+		return nil, ErrInvalidCursor
+	}
+
+	for _, name := range keyAttributes {
+		if _, ok := key[name]; !ok {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	exclusiveStartKey := map[string]types.AttributeValue{}
+	for name, value := range key {
+		exclusiveStartKey[name] = &types.AttributeValueMemberS{Value: value}
+	}
+
+	return exclusiveStartKey, nil
+}
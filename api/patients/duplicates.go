@@ -0,0 +1,101 @@
+package patients
+
+import (
+	"strings"
+	"unicode"
+)
+
+// national insurance numbers are stored in the field-index so duplicate
+// patients can be found by them, but only as a keyed hash so the index never
+// holds the number itself, and the number can not be found by hashing every
+// possible one. they can not be searched for.
+var nationalInsuranceIndex = searchIndex{name: "field-index", attribute: "fst", prefix: "ni#"}
+
+// returns the sort key a national insurance number is stored under, the number
+// is hashed once spaces and case have been taken out of it.
+func (k searchKeyer) nationalInsuranceSearchKey(nationalInsuranceNumber string) string {
+	normalised := strings.ToUpper(strings.Join(strings.Fields(nationalInsuranceNumber), ""))
+
+	return nationalInsuranceIndex.prefix + k.blindIndex.Hash("national_insurance_number", normalised)
+}
+
+// returns a name with everything but its letters taken out, so that "O'Brien"
+// and "obrien" are the same name when looking for duplicates.
+func normaliseName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// returns the ids of the patients that are likely to be the same person as the
+// patient being created. a patient is a likely duplicate when they have the
+// same national insurance number, the same email, or the same name and date of
+// birth. every search item of each probe is read, a busy practice can have
+// many patients born on the same day.
+func findDuplicatePatients(scan searchIndexScanner, keys searchKeyer, patient CreatePatientRequest) ([]string, error) {
+	var patientIDs []string
+	seen := map[string]bool{}
+
+	found := func(items []PatientSearchResponseItem, isDuplicate func(PatientSearchResponseItem) bool) {
+		for _, item := range items {
+			if !seen[item.PatientID] && isDuplicate(item) {
+				seen[item.PatientID] = true
+				patientIDs = append(patientIDs, item.PatientID)
+			}
+		}
+	}
+
+	if patient.NationalInsuranceNumber != "" && keys.blindIndex != nil {
+		// the hashes are all the same length, so a prefix match is an exact match
+		items, err := scan(nationalInsuranceIndex, keys.nationalInsuranceSearchKey(patient.NationalInsuranceNumber))
+		if err != nil {
+			return nil, err
+		}
+
+		found(items, func(PatientSearchResponseItem) bool { return true })
+	}
+
+	if email := normaliseSearchValue(SearchFieldEmail, patient.Email); email != "" {
+		index, sortKeyPrefix, err := keys.searchQuery(SearchFieldEmail, email)
+		if err != nil {
+			return nil, err
+		}
+
+		items, err := scan(index, sortKeyPrefix)
+		if err != nil {
+			return nil, err
+		}
+
+		// the search is a prefix match, so longer emails are left out. hashed
+		// emails are only ever found by an equal email, and the search items
+		// do not hold them
+		found(items, func(item PatientSearchResponseItem) bool {
+			return keys.hashes(SearchFieldEmail) || normaliseSearchValue(SearchFieldEmail, item.Email) == email
+		})
+	}
+
+	firstName := normaliseName(patient.FirstName)
+	lastName := normaliseName(patient.LastName)
+	if dateOfBirth := normaliseSearchValue(SearchFieldDateOfBirth, patient.DateOfBirth); dateOfBirth != "" && firstName != "" {
+		index, sortKeyPrefix, err := keys.searchQuery(SearchFieldDateOfBirth, dateOfBirth)
+		if err != nil {
+			return nil, err
+		}
+
+		items, err := scan(index, sortKeyPrefix)
+		if err != nil {
+			return nil, err
+		}
+
+		// dates of birth are all the same length, so every search item that
+		// was found has the same date of birth, whether or not it is hashed
+		found(items, func(item PatientSearchResponseItem) bool {
+			return normaliseName(item.FirstName) == firstName && normaliseName(item.LastName) == lastName
+		})
+	}
+
+	return patientIDs, nil
+}
@@ -0,0 +1,152 @@
+package patients
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/encryption"
+)
+
+func TestFindDuplicatePatients(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	client := newFakeDynamoDBClient()
+
+	// both stores have to find the same duplicates
+	stores := map[string]PatientRepository{
+		"dynamodb": &PatientStore{client: client, tableName: "test_table", blindIndex: newTestBlindIndex(t)},
+		"memory":   NewMemoryPatientStore(),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			existing, err := store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{
+				FirstName:               "Siobhan",
+				LastName:                "O'Brien",
+				DateOfBirth:             "1985-03-14",
+				Email:                   "siobhan@example.com",
+				NationalInsuranceNumber: "AB123456C",
+			})
+			if err != nil {
+				t.Fatalf("could not create the patient: %v", err)
+			}
+
+			cases := []struct {
+				name      string
+				patient   CreatePatientRequest
+				duplicate bool
+			}{
+				{name: "same national insurance number", patient: CreatePatientRequest{FirstName: "Sam", NationalInsuranceNumber: "ab 12 34 56 c"}, duplicate: true},
+				{name: "same email", patient: CreatePatientRequest{FirstName: "Sam", Email: "Siobhan@Example.com"}, duplicate: true},
+				{name: "same name and date of birth", patient: CreatePatientRequest{FirstName: "siobhan", LastName: "OBrien", DateOfBirth: "14/03/1985"}, duplicate: true},
+				{name: "different national insurance number", patient: CreatePatientRequest{FirstName: "Sam", NationalInsuranceNumber: "AB123457C"}, duplicate: false},
+				{name: "email that only starts the same", patient: CreatePatientRequest{FirstName: "Sam", Email: "siobhan@example.co"}, duplicate: false},
+				{name: "same name but a different date of birth", patient: CreatePatientRequest{FirstName: "Siobhan", LastName: "O'Brien", DateOfBirth: "1985-03-15"}, duplicate: false},
+				{name: "same date of birth but a different name", patient: CreatePatientRequest{FirstName: "Sean", LastName: "O'Brien", DateOfBirth: "1985-03-14"}, duplicate: false},
+			}
+
+			for _, c := range cases {
+				t.Run(c.name, func(t *testing.T) {
+					got, err := store.FindDuplicatePatients(context.Background(), dentalPracticeID, c.patient)
+					if err != nil {
+						t.Fatalf("could not look for duplicate patients: %v", err)
+					}
+
+					if c.duplicate && (len(got) != 1 || got[0] != existing.PatientID) {
+						t.Errorf("got %v want [%v]", got, existing.PatientID)
+					}
+
+					if !c.duplicate && len(got) != 0 {
+						t.Errorf("got %v want no duplicates", got)
+					}
+				})
+			}
+
+			t.Run("a patient matching in several ways is returned once", func(t *testing.T) {
+				got, _ := store.FindDuplicatePatients(context.Background(), dentalPracticeID, CreatePatientRequest{
+					FirstName:               "Siobhan",
+					LastName:                "O'Brien",
+					DateOfBirth:             "1985-03-14",
+					Email:                   "siobhan@example.com",
+					NationalInsuranceNumber: "AB123456C",
+				})
+
+				if len(got) != 1 {
+					t.Errorf("got %v want [%v]", got, existing.PatientID)
+				}
+			})
+
+			t.Run("patients of another dental practice are not duplicates", func(t *testing.T) {
+				got, _ := store.FindDuplicatePatients(context.Background(), "other_dental_practice_id", CreatePatientRequest{FirstName: "Sam", NationalInsuranceNumber: "AB123456C"})
+
+				if len(got) != 0 {
+					t.Errorf("got %v want no duplicates", got)
+				}
+			})
+		})
+	}
+
+	t.Run("every duplicate is found however many pages of the index they fill", func(t *testing.T) {
+		stores := map[string]PatientRepository{
+			"dynamodb": &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"},
+			"memory":   NewMemoryPatientStore(),
+		}
+
+		for name, store := range stores {
+			t.Run(name, func(t *testing.T) {
+				// more patients are born on the day than fit in a page
+				want := int(MaxSearchLimit) + 1
+				for i := 0; i < want; i++ {
+					store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: "Jane", LastName: "Doe", DateOfBirth: "1985-03-14"})
+				}
+
+				got, err := store.FindDuplicatePatients(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: "Jane", LastName: "Doe", DateOfBirth: "1985-03-14"})
+				if err != nil {
+					t.Fatalf("could not look for duplicate patients: %v", err)
+				}
+
+				if len(got) != want {
+					t.Errorf("got %v duplicates want %v", len(got), want)
+				}
+			})
+		}
+	})
+
+	t.Run("the national insurance number is only stored in the index as a keyed hash", func(t *testing.T) {
+		// a hash without a key can be reversed by hashing every number
+		unkeyed := sha256.Sum256([]byte("AB123456C"))
+
+		for _, item := range client.items {
+			if fst := attributeString(item["fst"]); strings.Contains(fst, "AB123456C") || strings.Contains(fst, hex.EncodeToString(unkeyed[:])) {
+				t.Errorf("the national insurance number was found in the search item %v", attributeString(item["_sk"]))
+			}
+		}
+	})
+
+	t.Run("national insurance numbers are not indexed without a blind index key", func(t *testing.T) {
+		client := newFakeDynamoDBClient()
+		store := &PatientStore{client: client, tableName: "test_table"}
+
+		store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: "Siobhan", NationalInsuranceNumber: "AB123456C"})
+
+		for _, item := range client.items {
+			if strings.HasPrefix(attributeString(item["fst"]), nationalInsuranceIndex.prefix) {
+				t.Errorf("got the national insurance search item %v want none", attributeString(item["_sk"]))
+			}
+		}
+	})
+}
+
+func newTestBlindIndex(t testing.TB) *encryption.BlindIndex {
+	t.Helper()
+
+	blindIndex, err := encryption.NewRandomBlindIndex()
+	if err != nil {
+		t.Fatalf("could not create the blind index: %v", err)
+	}
+
+	return blindIndex
+}
@@ -0,0 +1,128 @@
+package patients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// the kinds of error returned by a PatientRepository. check for them with
+// errors.Is, the error returned will usually carry more detail.
+var (
+	// ErrNotFound means the requested patient does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict means the request clashes with the stored state, for example
+	// a patient that already exists.
+	ErrConflict = errors.New("conflict")
+
+	// ErrPreconditionFailed means the patient was changed after the version
+	// the request was based on was read.
+	ErrPreconditionFailed = errors.New("precondition failed")
+
+	// ErrValidation means the database rejected the request as invalid.
+	ErrValidation = errors.New("validation failed")
+
+	// ErrThrottled means the database is rejecting requests because too many
+	// are being made, the request can be retried later.
+	ErrThrottled = errors.New("throttled")
+
+	// ErrUnavailable means the database could not be reached or failed
+	// internally, the request can be retried later.
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// ErrPatientAlreadyExists is returned when a patient is created with an id
+// that is already in use, it is an ErrConflict.
+var ErrPatientAlreadyExists error = &repositoryError{kind: ErrConflict, err: errors.New("a patient with the same id already exists")}
+
+// ErrIdempotencyKeyReused is returned when an idempotency key is sent with a
+// different request to the one it was first used with, it is an
+// ErrValidation.
+var ErrIdempotencyKeyReused error = &repositoryError{kind: ErrValidation, err: errors.New("the idempotency key was already used with a different request")}
+
+// an error that is of one of the kinds above.
+type repositoryError struct {
+	kind error
+	err  error
+}
+
+func (e *repositoryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *repositoryError) Unwrap() error {
+	return e.err
+}
+
+func (e *repositoryError) Is(target error) bool {
+	return target == e.kind
+}
+
+// returns an error of the given kind with a formatted message, %w can be used
+// to wrap an underlying error.
+func newRepositoryError(kind error, format string, args ...interface{}) error {
+	return &repositoryError{kind: kind, err: fmt.Errorf(format, args...)}
+}
+
+// works out which kind of error a call to dynamodb failed with, and wraps it
+// along with a description of what was being done. errors that do not match
+// any kind are wrapped as they are.
+func classifyDynamoDBError(err error, format string, args ...interface{}) error {
+	wrapped := fmt.Errorf(format+": %w", append(args, err)...)
+
+	var (
+		conditionalCheckFailed *types.ConditionalCheckFailedException
+		transactionCanceled    *types.TransactionCanceledException
+		transactionConflict    *types.TransactionConflictException
+		throughputExceeded     *types.ProvisionedThroughputExceededException
+		requestLimitExceeded   *types.RequestLimitExceeded
+		internalServerError    *types.InternalServerError
+		resourceNotFound       *types.ResourceNotFoundException
+		apiError               smithy.APIError
+	)
+
+	switch {
+	case errors.As(err, &conditionalCheckFailed), errors.As(err, &transactionConflict):
+		return &repositoryError{kind: ErrConflict, err: wrapped}
+	case errors.As(err, &transactionCanceled):
+		return &repositoryError{kind: transactionCancellationKind(transactionCanceled), err: wrapped}
+	case errors.As(err, &throughputExceeded), errors.As(err, &requestLimitExceeded):
+		return &repositoryError{kind: ErrThrottled, err: wrapped}
+	case errors.As(err, &internalServerError), errors.As(err, &resourceNotFound):
+		return &repositoryError{kind: ErrUnavailable, err: wrapped}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &repositoryError{kind: ErrUnavailable, err: wrapped}
+	case errors.As(err, &apiError):
+		switch apiError.ErrorCode() {
+		case "ThrottlingException":
+			return &repositoryError{kind: ErrThrottled, err: wrapped}
+		case "ValidationException":
+			return &repositoryError{kind: ErrValidation, err: wrapped}
+		case "ServiceUnavailable":
+			return &repositoryError{kind: ErrUnavailable, err: wrapped}
+		}
+	}
+
+	return wrapped
+}
+
+// works out the kind of error from the reasons a transaction was cancelled.
+func transactionCancellationKind(transactionCanceled *types.TransactionCanceledException) error {
+	kind := ErrConflict
+
+	for _, reason := range transactionCanceled.CancellationReasons {
+		switch code := aws.ToString(reason.Code); code {
+		case "ThrottlingError", "ProvisionedThroughputExceeded":
+			return ErrThrottled
+		case "ValidationError", "ItemCollectionSizeLimitExceeded":
+			kind = ErrValidation
+		}
+	}
+
+	return kind
+}
@@ -0,0 +1,75 @@
+package patients
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestClassifyDynamoDBError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "conditional check failure", err: &types.ConditionalCheckFailedException{}, want: ErrConflict},
+		{name: "transaction conflict", err: &types.TransactionConflictException{}, want: ErrConflict},
+		{name: "cancelled transaction", err: &types.TransactionCanceledException{CancellationReasons: []types.CancellationReason{{Code: aws.String("ConditionalCheckFailed")}}}, want: ErrConflict},
+		{name: "cancelled transaction that was throttled", err: &types.TransactionCanceledException{CancellationReasons: []types.CancellationReason{{Code: aws.String("None")}, {Code: aws.String("ThrottlingError")}}}, want: ErrThrottled},
+		{name: "provisioned throughput exceeded", err: &types.ProvisionedThroughputExceededException{}, want: ErrThrottled},
+		{name: "request limit exceeded", err: &types.RequestLimitExceeded{}, want: ErrThrottled},
+		{name: "throttling exception", err: &smithy.GenericAPIError{Code: "ThrottlingException"}, want: ErrThrottled},
+		{name: "internal server error", err: &types.InternalServerError{}, want: ErrUnavailable},
+		{name: "missing table", err: &types.ResourceNotFoundException{}, want: ErrUnavailable},
+		{name: "timeout", err: context.DeadlineExceeded, want: ErrUnavailable},
+		{name: "validation exception", err: &smithy.GenericAPIError{Code: "ValidationException"}, want: ErrValidation},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := classifyDynamoDBError(c.err, "could not get patient %q", "test_patient_id")
+
+			if !errors.Is(got, c.want) {
+				t.Errorf("got %v want an error of kind %v", got, c.want)
+			}
+
+			if !errors.Is(got, c.err) {
+				t.Error("the original error is no longer wrapped")
+			}
+		})
+	}
+
+	t.Run("unknown errors are not given a kind", func(t *testing.T) {
+		got := classifyDynamoDBError(errors.New("something went wrong"), "could not get patient")
+
+		for _, kind := range []error{ErrNotFound, ErrConflict, ErrValidation, ErrThrottled, ErrUnavailable} {
+			if errors.Is(got, kind) {
+				t.Errorf("got an error of kind %v", kind)
+			}
+		}
+	})
+}
+
+func TestGetPatient(t *testing.T) {
+	t.Run("returns a not found error when the patient does not exist", func(t *testing.T) {
+		store := &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"}
+
+		_, err := store.GetPatient(context.Background(), "test_dental_practice_id", "test_patient_id")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("got %v want an error of kind %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("returns a not found error when updating a patient that does not exist", func(t *testing.T) {
+		store := &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"}
+
+		_, err := store.UpdatePatient(context.Background(), "test_dental_practice_id", UpdatePatientRequest{PatientID: "test_patient_id", FirstName: "Jane"}, 1)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("got %v want an error of kind %v", err, ErrNotFound)
+		}
+	})
+}
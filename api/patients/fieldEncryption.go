@@ -0,0 +1,166 @@
+package patients
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/encryption"
+)
+
+// the json names of the patient fields that are encrypted when no fields are
+// configured.
+var DefaultEncryptedFields = []string{
+	"national_insurance_number",
+	"date_of_birth",
+	"mobile_phone",
+	"home_phone",
+	"work_phone",
+	"emergency_contact_phone",
+	"address_line_1",
+	"address_line_2",
+	"post_code",
+}
+
+// the json names of the patient fields that can not be encrypted, because they
+// are keys, index sort keys or list filters that dynamodb has to read. the
+// names are the sort keys of the name-index, the other searchable fields are
+// indexed by their keyed hashes when they are encrypted.
+var unencryptable = map[string]bool{
+	"patient_id":         true,
+	"first_name":         true,
+	"last_name":          true,
+	"active":             true,
+	"created_at":         true,
+	"modified_at":        true,
+	"version":            true,
+	"merged_into":        true,
+	"assigned_dentist":   true,
+	"assigned_hygienist": true,
+}
+
+// the dynamodb attribute names of the patient fields, by their json names.
+var patientAttributes = func() map[string]string {
+	attributes := map[string]string{}
+
+	t := reflect.TypeOf(Patient{})
+	for i := 0; i < t.NumField(); i++ {
+		field := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		attributes[field] = strings.Split(t.Field(i).Tag.Get("dynamodbav"), ",")[0]
+	}
+
+	return attributes
+}()
+
+// NewFieldEncrypter returns a field encrypter for the patient fields, which are
+// named by their json names. the encrypted fields are left out of the search
+// items, searches read them from the patient items instead.
+func NewFieldEncrypter(provider encryption.KeyProvider, fields []string) (*encryption.FieldEncrypter, error) {
+	attributes := make([]string, 0, len(fields))
+	for _, field := range fields {
+		attribute, ok := patientAttributes[field]
+		if !ok {
+			return nil, fmt.Errorf("%q is not a patient field", field)
+		}
+
+		if unencryptable[field] {
+			return nil, fmt.Errorf("%q can not be encrypted", field)
+		}
+
+		attributes = append(attributes, attribute)
+	}
+
+	return encryption.NewFieldEncrypter(provider, attributes), nil
+}
+
+// encrypts the before and after values of the changes to encrypted fields in a
+// history item, so the history does not hold the plaintext the patient item
+// no longer does.
+func encryptHistoryItem(ctx context.Context, encrypter *encryption.FieldEncrypter, item map[string]types.AttributeValue) error {
+	changes, ok := item["ch"].(*types.AttributeValueMemberL)
+	if !ok {
+		return nil
+	}
+
+	var envelope *encryption.Envelope
+	for _, value := range changes.Value {
+		change := value.(*types.AttributeValueMemberM).Value
+		field := change["f"].(*types.AttributeValueMemberS).Value
+		if !encrypter.Encrypts(patientAttributes[field]) {
+			continue
+		}
+
+		// the data key is only generated once a change needs it
+		if envelope == nil {
+			var err error
+			envelope, err = encrypter.NewEnvelope(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, side := range []string{"b", "a"} {
+			plaintext, ok := change[side].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+
+			encrypted, err := envelope.Seal(plaintext.Value, historyAssociatedData(field, side))
+			if err != nil {
+				return fmt.Errorf("could not encrypt the change to %q: %w", field, err)
+			}
+
+			change[side] = encrypted
+		}
+	}
+
+	if envelope != nil {
+		envelope.Store(item)
+	}
+
+	return nil
+}
+
+// decrypts the changes encrypted by encryptHistoryItem.
+func decryptHistoryItem(ctx context.Context, encrypter *encryption.FieldEncrypter, item map[string]types.AttributeValue) error {
+	envelope, err := encrypter.OpenEnvelope(ctx, item)
+	if err != nil || envelope == nil {
+		return err
+	}
+
+	changes, ok := item["ch"].(*types.AttributeValueMemberL)
+	if !ok {
+		return nil
+	}
+
+	for _, value := range changes.Value {
+		change := value.(*types.AttributeValueMemberM).Value
+		field := change["f"].(*types.AttributeValueMemberS).Value
+
+		for _, side := range []string{"b", "a"} {
+			encrypted, ok := change[side].(*types.AttributeValueMemberB)
+			if !ok {
+				continue
+			}
+
+			plaintext, err := envelope.Open(encrypted, historyAssociatedData(field, side))
+			if err != nil {
+				return fmt.Errorf("could not decrypt the change to %q: %w", field, err)
+			}
+
+			change[side] = &types.AttributeValueMemberS{Value: plaintext}
+		}
+	}
+
+	delete(item, encryption.DataKeyAttribute)
+
+	return nil
+}
+
+// returns the data authenticated along with a value of a change, so values
+// can not be swapped between fields or between before and after.
+func historyAssociatedData(field string, side string) string {
+	return fmt.Sprintf("ch#%v#%v", field, side)
+}
@@ -0,0 +1,184 @@
+package patients
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/encryption"
+)
+
+func TestNewFieldEncrypter(t *testing.T) {
+	provider, _ := encryption.NewLocalKeyProvider(bytes.Repeat([]byte{1}, 32))
+
+	if _, err := NewFieldEncrypter(provider, DefaultEncryptedFields); err != nil {
+		t.Errorf("could not create a field encrypter for the default fields: %v", err)
+	}
+
+	for _, field := range []string{"nickname", "assigned_dentist", "created_at", "first_name"} {
+		if _, err := NewFieldEncrypter(provider, []string{field}); err == nil {
+			t.Errorf("expected %q to be rejected", field)
+		}
+	}
+}
+
+func TestEncryptedPatientStore(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	provider, _ := encryption.NewLocalKeyProvider(bytes.Repeat([]byte{1}, 32))
+	encrypter, _ := NewFieldEncrypter(provider, DefaultEncryptedFields)
+
+	client := newFakeDynamoDBClient()
+	store := &PatientStore{client: client, tableName: "test_table", encrypter: encrypter, blindIndex: newTestBlindIndex(t)}
+
+	created, err := store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{
+		FirstName:               "Siobhan",
+		LastName:                "O'Brien",
+		NationalInsuranceNumber: "AB123456C",
+		DateOfBirth:             "1985-03-14",
+		MobilePhone:             "07700 900123",
+		AddressLine1:            "1 Park Row",
+		PostCode:                "LS1 4AP",
+	})
+	if err != nil {
+		t.Fatalf("could not create the patient: %v", err)
+	}
+
+	_, err = store.UpdatePatient(context.Background(), dentalPracticeID, UpdatePatientRequest{
+		PatientID:               created.PatientID,
+		FirstName:               "Siobhan",
+		LastName:                "O'Brien",
+		NationalInsuranceNumber: "AB123456C",
+		DateOfBirth:             "1985-03-14",
+		MobilePhone:             "07700 900123",
+		AddressLine1:            "2 Park Row",
+		PostCode:                "LS1 4AP",
+	}, 1)
+	if err != nil {
+		t.Fatalf("could not update the patient: %v", err)
+	}
+
+	t.Run("the patient, its search items and its history are stored without the plaintext", func(t *testing.T) {
+		for _, item := range client.items {
+			sortKey := attributeString(item["_sk"])
+			for _, plaintext := range []string{"AB123456C", "Park Row", "1985-03-14", "07700 900123", "07700900123", "LS1 4AP", "LS14AP"} {
+				if itemContains(item, plaintext) {
+					t.Errorf("%q was found in the item %v", plaintext, sortKey)
+				}
+			}
+		}
+
+		patientItem := client.items[fakeItemKey(Patient{PatientID: created.PatientID}.GetKey(dentalPracticeID))]
+		if _, ok := patientItem["ni"].(*types.AttributeValueMemberB); !ok {
+			t.Errorf("got %#v want the national insurance number to be encrypted", patientItem["ni"])
+		}
+
+		if attributeString(patientItem["fn"]) != "Siobhan" {
+			t.Errorf("got %#v want the first name to be left as it is", patientItem["fn"])
+		}
+	})
+
+	t.Run("gets the patient decrypted", func(t *testing.T) {
+		patient, err := store.GetPatient(context.Background(), dentalPracticeID, created.PatientID)
+		if err != nil {
+			t.Fatalf("could not get the patient: %v", err)
+		}
+
+		if patient.NationalInsuranceNumber != "AB123456C" || patient.DateOfBirth != "1985-03-14" || patient.AddressLine1 != "2 Park Row" {
+			t.Errorf("got %+v want the decrypted patient", patient)
+		}
+	})
+
+	t.Run("searches the encrypted fields by their hashes", func(t *testing.T) {
+		for _, request := range []SearchPatientsRequest{
+			{Field: SearchFieldMobilePhone, SearchTerm: "07700 900123"},
+			{Field: SearchFieldPostCode, SearchTerm: "ls1 4ap"},
+			{Field: SearchFieldDateOfBirth, SearchTerm: "1985-03-14"},
+			{Field: SearchFieldName, SearchTerm: "siob"},
+			{SearchTerm: "siobhan o'brien"},
+		} {
+			results, err := store.SearchPatients(context.Background(), dentalPracticeID, request)
+			if err != nil {
+				t.Fatalf("could not search the patients by %+v: %v", request, err)
+			}
+
+			if len(results.Items) != 1 || results.Items[0].DateOfBirth != "1985-03-14" || results.Items[0].MobilePhone != "07700 900123" || results.Items[0].PostCode != "LS1 4AP" {
+				t.Errorf("got %+v want the decrypted patient when searching by %+v", results.Items, request)
+			}
+		}
+	})
+
+	t.Run("rejects searches of the encrypted fields for part of a value", func(t *testing.T) {
+		for _, request := range []SearchPatientsRequest{
+			{Field: SearchFieldMobilePhone, SearchTerm: "07700"},
+			{Field: SearchFieldPostCode, SearchTerm: "ls1"},
+			{Field: SearchFieldDateOfBirth, SearchTerm: "1985-03"},
+		} {
+			_, err := store.SearchPatients(context.Background(), dentalPracticeID, request)
+			if !errors.Is(err, ErrPartialSearchTerm) {
+				t.Errorf("got error %v want ErrPartialSearchTerm when searching by %+v", err, request)
+			}
+		}
+	})
+
+	t.Run("lists the patient decrypted", func(t *testing.T) {
+		results, err := store.ListPatients(context.Background(), dentalPracticeID, ListPatientsRequest{})
+		if err != nil {
+			t.Fatalf("could not list the patients: %v", err)
+		}
+
+		if len(results.Items) != 1 || results.Items[0].DateOfBirth != "1985-03-14" {
+			t.Errorf("got %+v want the decrypted patient", results.Items)
+		}
+	})
+
+	t.Run("gets the history decrypted", func(t *testing.T) {
+		history, err := store.GetPatientHistory(context.Background(), dentalPracticeID, PatientHistoryRequest{PatientID: created.PatientID})
+		if err != nil {
+			t.Fatalf("could not get the patient history: %v", err)
+		}
+
+		want := FieldChange{Field: "address_line_1", Before: "1 Park Row", After: "2 Park Row"}
+		if len(history.Items) != 2 || len(history.Items[0].Changes) != 1 || history.Items[0].Changes[0] != want {
+			t.Errorf("got %+v want the decrypted change to the address", history.Items)
+		}
+	})
+
+	t.Run("reads patients written before encryption was turned on", func(t *testing.T) {
+		plain := &PatientStore{client: client, tableName: "test_table"}
+		legacy, _ := plain.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: "Jane", NationalInsuranceNumber: "CD123456E"})
+
+		patient, err := store.GetPatient(context.Background(), dentalPracticeID, legacy.PatientID)
+		if err != nil || patient.NationalInsuranceNumber != "CD123456E" {
+			t.Errorf("got %+v, %v want the plaintext patient", patient, err)
+		}
+	})
+}
+
+// reports whether any string value in the item, or in the maps and lists it
+// holds, contains the text.
+func itemContains(item map[string]types.AttributeValue, text string) bool {
+	for _, value := range item {
+		switch v := value.(type) {
+		case *types.AttributeValueMemberS:
+			if strings.Contains(v.Value, text) {
+				return true
+			}
+		case *types.AttributeValueMemberM:
+			if itemContains(v.Value, text) {
+				return true
+			}
+		case *types.AttributeValueMemberL:
+			for _, element := range v.Value {
+				if itemContains(map[string]types.AttributeValue{"": element}, text) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,178 @@
+package patients
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// names are also stored by how they sound, so that a fuzzy search for
+// "steven" finds "stephen". the phonetic keys share the field-index with the
+// other searchable fields.
+var phoneticIndex = searchIndex{name: "field-index", attribute: "fst", prefix: "ph#"}
+
+// the soundex digit of each letter, vowels and the letters h, w and y have no
+// digit.
+var soundexDigits = map[rune]byte{
+	'b': '1', 'f': '1', 'p': '1', 'v': '1',
+	'c': '2', 'g': '2', 'j': '2', 'k': '2', 'q': '2', 's': '2', 'x': '2', 'z': '2',
+	'd': '3', 't': '3',
+	'l': '4',
+	'm': '5', 'n': '5',
+	'r': '6',
+}
+
+// returns the american soundex code of a name, e.g. "stephen" and "steven" are
+// both S315. anything that is not an ascii letter is ignored, and an empty
+// string is returned for a name without any letters.
+func soundex(name string) string {
+	var code []byte
+	var last byte
+
+	for _, r := range strings.ToLower(name) {
+		if r < 'a' || r > 'z' {
+			continue
+		}
+
+		digit := soundexDigits[r]
+
+		if len(code) == 0 {
+			code = append(code, byte(r-'a'+'A'))
+			last = digit
+			continue
+		}
+
+		switch {
+		case digit != 0 && digit != last:
+			code = append(code, digit)
+			last = digit
+		case r == 'h' || r == 'w':
+			// letters with the same digit either side of an h or w are coded once
+		default:
+			last = digit
+		}
+
+		if len(code) == 4 {
+			break
+		}
+	}
+
+	if len(code) == 0 {
+		return ""
+	}
+
+	for len(code) < 4 {
+		code = append(code, '0')
+	}
+
+	return string(code)
+}
+
+// returns the sort key prefix used to find names that sound like the search
+// term. the padding is left off, so a search term that is only the start of a
+// name matches every name that starts with the same sounds.
+func phoneticSearchPrefix(searchTerm string) string {
+	code := strings.TrimRight(soundex(searchTerm), "0")
+	if code == "" {
+		return ""
+	}
+
+	return phoneticIndex.prefix + code
+}
+
+// returns the number of single character edits needed to turn a into b.
+func levenshtein(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	source := []rune(a)
+	target := []rune(b)
+
+	previous := make([]int, len(target)+1)
+	current := make([]int, len(target)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(source); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(target); j++ {
+			cost := 1
+			if source[i-1] == target[j-1] {
+				cost = 0
+			}
+
+			current[j] = minimum(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(target)]
+}
+
+func minimum(values ...int) int {
+	smallest := values[0]
+	for _, value := range values[1:] {
+		if value < smallest {
+			smallest = value
+		}
+	}
+
+	return smallest
+}
+
+// returns how far the closest of the patient's names is from the search term.
+func nameDistance(searchTerm string, item PatientSearchResponseItem) int {
+	searchTerm = strings.ToLower(searchTerm)
+
+	distance := utf8.RuneCountInString(searchTerm) + utf8.RuneCountInString(item.FirstName) + utf8.RuneCountInString(item.LastName)
+	for _, name := range []string{item.FirstName, item.LastName} {
+		if name == "" {
+			continue
+		}
+
+		distance = minimum(distance, levenshtein(searchTerm, strings.ToLower(name)))
+	}
+
+	return distance
+}
+
+// combines the results of a fuzzy search into a single ranked list. patients
+// whose name starts with the search term come first, in the order the
+// name-index returned them, followed by the patients whose name only sounds
+// like the search term with the closest spelling first. each patient is only
+// returned once.
+func rankFuzzyMatches(searchTerm string, prefixMatches []PatientSearchResponseItem, phoneticMatches []PatientSearchResponseItem, limit int32) []PatientSearchResponseItem {
+	seen := map[string]bool{}
+	ranked := make([]PatientSearchResponseItem, 0, len(prefixMatches)+len(phoneticMatches))
+
+	for _, item := range prefixMatches {
+		if !seen[item.PatientID] {
+			seen[item.PatientID] = true
+			ranked = append(ranked, item)
+		}
+	}
+
+	var soundsLike []PatientSearchResponseItem
+	for _, item := range phoneticMatches {
+		if !seen[item.PatientID] {
+			seen[item.PatientID] = true
+			soundsLike = append(soundsLike, item)
+		}
+	}
+
+	sort.SliceStable(soundsLike, func(i, j int) bool {
+		return nameDistance(searchTerm, soundsLike[i]) < nameDistance(searchTerm, soundsLike[j])
+	})
+
+	ranked = append(ranked, soundsLike...)
+
+	if limit > 0 && int(limit) < len(ranked) {
+		ranked = ranked[:limit]
+	}
+
+	return ranked
+}
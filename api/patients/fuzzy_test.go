@@ -0,0 +1,178 @@
+package patients
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSoundex(t *testing.T) {
+	cases := map[string]string{
+		"Robert":   "R163",
+		"Rupert":   "R163",
+		"Ashcraft": "A261",
+		"Tymczak":  "T522",
+		"Pfister":  "P236",
+		"Stephen":  "S315",
+		"Steven":   "S315",
+		"Lee":      "L000",
+		"O'Brien":  "O165",
+		"":         "",
+		"123":      "",
+	}
+
+	for name, want := range cases {
+		if got := soundex(name); got != want {
+			t.Errorf("soundex(%q) got %q want %q", name, got, want)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{a: "john", b: "john", want: 0},
+		{a: "jhon", b: "john", want: 2},
+		{a: "steven", b: "stephen", want: 2},
+		{a: "smyth", b: "smith", want: 1},
+		{a: "", b: "abc", want: 3},
+		{a: "zoë", b: "zoe", want: 1},
+	}
+
+	for _, c := range cases {
+		if got := levenshtein(c.a, c.b); got != c.want {
+			t.Errorf("levenshtein(%q, %q) got %d want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestFuzzySearchPatients(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	// both stores have to rank fuzzy matches the same way
+	stores := map[string]PatientRepository{
+		"dynamodb": &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"},
+		"memory":   NewMemoryPatientStore(),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			for _, patient := range []CreatePatientRequest{
+				{FirstName: "Stephen", LastName: "Smith"},
+				{FirstName: "Steven", LastName: "Stevens"},
+				{FirstName: "Stefan", LastName: "Jones"},
+				{FirstName: "John", LastName: "Smyth"},
+			} {
+				_, err := store.CreatePatient(context.Background(), dentalPracticeID, patient)
+				if err != nil {
+					t.Fatalf("could not create the patient: %v", err)
+				}
+			}
+
+			search := func(t testing.TB, searchTerm string) []string {
+				t.Helper()
+
+				results, err := store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: searchTerm, Fuzzy: true})
+				if err != nil {
+					t.Fatalf("could not search patients: %v", err)
+				}
+
+				if results.NextCursor != "" {
+					t.Errorf("fuzzy results should be a single page, got cursor %q", results.NextCursor)
+				}
+
+				firstNames := []string{}
+				for _, item := range results.Items {
+					firstNames = append(firstNames, item.FirstName)
+				}
+
+				return firstNames
+			}
+
+			t.Run("exact prefix matches come before names that sound alike", func(t *testing.T) {
+				got := search(t, "steven")
+
+				if len(got) != 3 || got[0] != "Steven" {
+					t.Fatalf("got %v want Steven followed by Stephen and Stefan", got)
+				}
+
+				if !(got[1] == "Stephen" && got[2] == "Stefan") && !(got[1] == "Stefan" && got[2] == "Stephen") {
+					t.Errorf("got %v want Steven followed by Stephen and Stefan", got)
+				}
+			})
+
+			t.Run("finds a name with a typo", func(t *testing.T) {
+				got := search(t, "jhon")
+
+				if len(got) == 0 || got[0] != "John" {
+					t.Errorf("got %v want John first", got)
+				}
+			})
+
+			t.Run("returns each patient once", func(t *testing.T) {
+				// smith and smyth sound the same, and steven stevens matches on
+				// both of their names
+				for _, searchTerm := range []string{"smith", "stev"} {
+					seen := map[string]bool{}
+					for _, firstName := range search(t, searchTerm) {
+						if seen[firstName] {
+							t.Errorf("searching for %q returned %v more than once", searchTerm, firstName)
+						}
+						seen[firstName] = true
+					}
+				}
+			})
+
+			t.Run("rejects fuzzy searches of other fields and with a cursor", func(t *testing.T) {
+				requests := []SearchPatientsRequest{
+					{SearchTerm: "jane@", Field: SearchFieldEmail, Fuzzy: true},
+					{SearchTerm: "steven", Cursor: "cursor", Fuzzy: true},
+				}
+
+				for _, request := range requests {
+					_, err := store.SearchPatients(context.Background(), dentalPracticeID, request)
+					if !errors.Is(err, ErrValidation) {
+						t.Errorf("got error %v want %v", err, ErrValidation)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestRankFuzzyMatches(t *testing.T) {
+	prefixMatches := []PatientSearchResponseItem{
+		{PatientID: "1", FirstName: "Smithson"},
+		{PatientID: "2", FirstName: "Jane", LastName: "Smith"},
+	}
+
+	phoneticMatches := []PatientSearchResponseItem{
+		{PatientID: "3", LastName: "Schmidt"},
+		{PatientID: "2", FirstName: "Jane", LastName: "Smith"},
+		{PatientID: "4", LastName: "Smyth"},
+	}
+
+	got := rankFuzzyMatches("smith", prefixMatches, phoneticMatches, 10)
+
+	var patientIDs []string
+	for _, item := range got {
+		patientIDs = append(patientIDs, item.PatientID)
+	}
+
+	// prefix matches keep their order, then the closest spelling comes first
+	want := []string{"1", "2", "4", "3"}
+	if len(patientIDs) != len(want) {
+		t.Fatalf("got %v want %v", patientIDs, want)
+	}
+	for i := range want {
+		if patientIDs[i] != want[i] {
+			t.Fatalf("got %v want %v", patientIDs, want)
+		}
+	}
+
+	if got := rankFuzzyMatches("smith", prefixMatches, phoneticMatches, 2); len(got) != 2 {
+		t.Errorf("got %d results want the limit of 2", len(got))
+	}
+}
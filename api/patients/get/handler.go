@@ -5,7 +5,14 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
 	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/apierror"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/conditional"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/redaction"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/validation"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
 	"go.uber.org/zap"
 )
 
@@ -14,28 +21,76 @@ const jsonContentType string = "application/json"
 
 func GetPatientHandler(logger *zap.Logger, repository patients.PatientRepository) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context(), logger)
+
 		logger.Info("running the get patient handler...")
 
+		identity, ok := auth.FromContext(r.Context())
+		if !ok {
+			logger.Error("no dental practice was found for the request")
+			problem.Error(w, r, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// the fields returned depend on the role, so a role that is not known
+		// gets nothing
+		if !redaction.Allowed(identity.Role) {
+			logger.Error("the role is not allowed to read patients", zap.String("role", string(identity.Role)))
+			problem.Error(w, r, "forbidden", http.StatusForbidden)
+			return
+		}
+
 		patientID := strings.TrimPrefix(r.URL.Path, "/patients/")
 
+		// a path that does not hold a patient id can not name a patient
+		if !validation.IsPatientID(patientID) {
+			logger.Error("the patient id is invalid", zap.String("patientID", patientID))
+			problem.Error(w, r, "requested patient could not be found", http.StatusNotFound)
+			return
+		}
+
 		logger.Info("requested patient", zap.String("patientID", patientID))
 
 		logger = logger.With(zap.String("patientID", patientID))
 
 		w.Header().Set(contentTypeHeader, jsonContentType)
 
-		patient, err := repository.GetPatient(logger, r.Context(), patientID)
+		// callers with different roles see different fields of the same patient
+		w.Header().Set("vary", "authorization")
+
+		patient, err := repository.GetPatient(logging.NewContext(r.Context(), logger), identity.DentalPracticeID, patientID)
 		if err != nil {
 			logger.Error("failed to get patient", zap.Error(err))
-			http.Error(w, "requested patient could not be found", http.StatusNotFound)
+			apierror.Write(w, r, err, "failed to get the patient")
 			return
 		}
 
-		err = json.NewEncoder(w).Encode(patient)
-		if err != nil {
-			logger.Error("error in json marshal", zap.Error(err))
+		// a patient that was merged into another patient lives on as that patient
+		if patient.MergedInto != "" {
+			logger.Info("the patient was merged", zap.String("mergedInto", patient.MergedInto))
+			http.Redirect(w, r, "/patients/"+patient.MergedInto, http.StatusPermanentRedirect)
+			return
+		}
+
+		// the front-end polls patients, so it is told how to ask for the patient
+		// only when it has changed
+		w.Header().Set("etag", conditional.ETag(patient))
+		if lastModified := conditional.LastModified(patient); !lastModified.IsZero() {
+			w.Header().Set("last-modified", lastModified.Format(http.TimeFormat))
+		}
+		w.Header().Set("cache-control", "private, no-cache")
+
+		if conditional.NotModified(r, patient) {
+			w.Header().Del(contentTypeHeader)
+			w.WriteHeader(http.StatusNotModified)
+			return
 		}
 
 		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(redaction.Patient(identity.Role, patient))
+		if err != nil {
+			logger.Error("error in json marshal", zap.Error(err))
+		}
 	})
 }
@@ -1,59 +1,103 @@
 package get
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
 	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
 	"go.uber.org/zap"
 )
 
 type StubPatientStore struct {
-	createPatient  func(logger *zap.Logger, ctx context.Context, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error)
-	getPatient     func(logger *zap.Logger, ctx context.Context, patientID string) (patients.Patient, error)
-	searchPatients func(logger *zap.Logger, ctx context.Context, searchTerm string) ([]patients.PatientSearchResponseItem, error)
+	createPatient             func(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error)
+	getPatient                func(ctx context.Context, dentalPracticeID string, patientID string) (patients.Patient, error)
+	searchPatients            func(ctx context.Context, dentalPracticeID string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error)
+	updatePatient             func(ctx context.Context, dentalPracticeID string, patient patients.UpdatePatientRequest, expectedVersion int) (patients.Patient, error)
+	listPatients              func(ctx context.Context, dentalPracticeID string, request patients.ListPatientsRequest) (patients.PatientSearchResponse, error)
+	findDuplicatePatients     func(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) ([]string, error)
+	mergePatients             func(ctx context.Context, dentalPracticeID string, request patients.MergePatientsRequest, expectedVersion int) (patients.Patient, error)
+	getPatientHistory         func(ctx context.Context, dentalPracticeID string, request patients.PatientHistoryRequest) (patients.PatientHistoryResponse, error)
+	getIdempotentResponse     func(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey) (patients.CreatePatientResponse, error)
+	createPatientIdempotently func(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error)
 }
 
-func (s *StubPatientStore) CreatePatient(logger *zap.Logger, ctx context.Context, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
-	return s.createPatient(logger, ctx, patient)
+func (s *StubPatientStore) CreatePatient(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+	return s.createPatient(ctx, dentalPracticeID, patient)
 }
 
-func (s *StubPatientStore) GetPatient(logger *zap.Logger, ctx context.Context, patientID string) (patients.Patient, error) {
-	return s.getPatient(logger, ctx, patientID)
+func (s *StubPatientStore) GetPatient(ctx context.Context, dentalPracticeID string, patientID string) (patients.Patient, error) {
+	return s.getPatient(ctx, dentalPracticeID, patientID)
 }
 
-func (s *StubPatientStore) SearchPatients(logger *zap.Logger, ctx context.Context, searchTerm string) ([]patients.PatientSearchResponseItem, error) {
-	return s.searchPatients(logger, ctx, searchTerm)
+func (s *StubPatientStore) SearchPatients(ctx context.Context, dentalPracticeID string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+	return s.searchPatients(ctx, dentalPracticeID, request)
+}
+
+func (s *StubPatientStore) UpdatePatient(ctx context.Context, dentalPracticeID string, patient patients.UpdatePatientRequest, expectedVersion int) (patients.Patient, error) {
+	return s.updatePatient(ctx, dentalPracticeID, patient, expectedVersion)
+}
+
+func (s *StubPatientStore) ListPatients(ctx context.Context, dentalPracticeID string, request patients.ListPatientsRequest) (patients.PatientSearchResponse, error) {
+	return s.listPatients(ctx, dentalPracticeID, request)
+}
+
+func (s *StubPatientStore) FindDuplicatePatients(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) ([]string, error) {
+	return s.findDuplicatePatients(ctx, dentalPracticeID, patient)
+}
+
+func (s *StubPatientStore) MergePatients(ctx context.Context, dentalPracticeID string, request patients.MergePatientsRequest, expectedVersion int) (patients.Patient, error) {
+	return s.mergePatients(ctx, dentalPracticeID, request, expectedVersion)
+}
+
+func (s *StubPatientStore) GetPatientHistory(ctx context.Context, dentalPracticeID string, request patients.PatientHistoryRequest) (patients.PatientHistoryResponse, error) {
+	return s.getPatientHistory(ctx, dentalPracticeID, request)
+}
+
+func (s *StubPatientStore) GetIdempotentResponse(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey) (patients.CreatePatientResponse, error) {
+	return s.getIdempotentResponse(ctx, dentalPracticeID, key)
+}
+
+func (s *StubPatientStore) CreatePatientIdempotently(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+	return s.createPatientIdempotently(ctx, dentalPracticeID, key, patient)
 }
 
 func TestGetPatient(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
 	// create the logger
 	logger, _ := zap.NewProduction()
 
 	t.Run("return 404 when requested patient does not exist", func(t *testing.T) {
-		requestedPatientID := "test_patient_id"
+		requestedPatientID := "3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34"
 
 		// create the stub patient store
 		patientStore := StubPatientStore{
-			getPatient: func(_ *zap.Logger, _ context.Context, patientID string) (patients.Patient, error) {
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
 				if patientID != requestedPatientID {
 					t.Errorf("%q was passed to GetPatient() but the expected value was %q", patientID, requestedPatientID)
 				}
 
-				return patients.Patient{}, errors.New("requested patient could not be found")
+				return patients.Patient{}, fmt.Errorf("could not find patient with id %q: %w", patientID, patients.ErrNotFound)
 			},
 		}
 
 		// create a request to pass to our handler
 		req, _ := http.NewRequest("GET", fmt.Sprintf("/patients/%v", requestedPatientID), nil)
 
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
 		// create a response recorder
 		res := httptest.NewRecorder()
 
@@ -68,13 +112,44 @@ func TestGetPatient(t *testing.T) {
 		assertStatusCode(t, res.Code, http.StatusNotFound)
 	})
 
+	t.Run("return 404 when the path does not hold a patient id", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				t.Errorf("%q was passed to GetPatient() but it is not a patient id", patientID)
+				return patients.Patient{}, nil
+			},
+		}
+
+		for _, path := range []string{"/patients/not_a_patient_id", "/patients/3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34%23h%232022"} {
+			// create a request to pass to our handler
+			req := httptest.NewRequest("GET", path, nil)
+
+			// set the dental practice the request is made on behalf of
+			req = withDentalPractice(req, dentalPracticeID)
+
+			// create a response recorder
+			res := httptest.NewRecorder()
+
+			// get the handler
+			handler := GetPatientHandler(logger, &patientStore)
+
+			// our handler satisfies http.handler, so we can call its serve http method
+			// directly and pass in our request and response recorder
+			handler.ServeHTTP(res, req)
+
+			// assert status code is what we expect
+			assertStatusCode(t, res.Code, http.StatusNotFound)
+		}
+	})
+
 	t.Run("return 200 along with the patient details when requested patient does exist", func(t *testing.T) {
-		requestedPatientID := "test_patient_id"
-		expectedPatient := patients.Patient{PatientID: "test_patient_id", FirstName: "Jane", LastName: "Doe"}
+		requestedPatientID := "3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34"
+		expectedPatient := patients.Patient{PatientID: "3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", FirstName: "Jane", LastName: "Doe", Version: 2}
 
 		// create the stub patient store
 		patientStore := StubPatientStore{
-			getPatient: func(_ *zap.Logger, _ context.Context, patientID string) (patients.Patient, error) {
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
 				if patientID != requestedPatientID {
 					t.Errorf("%q was passed to GetPatient() but the expected value was %q", patientID, requestedPatientID)
 				}
@@ -86,6 +161,9 @@ func TestGetPatient(t *testing.T) {
 		// create a request to pass to our handler
 		req, _ := http.NewRequest("GET", fmt.Sprintf("/patients/%v", requestedPatientID), nil)
 
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
 		// create a response recorder
 		res := httptest.NewRecorder()
 
@@ -104,9 +182,352 @@ func TestGetPatient(t *testing.T) {
 
 		// check the response body is what we expect
 		assertPatient(t, got, expectedPatient)
+
+		// check the version of the patient is returned as its etag
+		if etag := res.Header().Get("etag"); etag != `"2"` {
+			t.Errorf("handler returned wrong etag: got %v want %v", etag, `"2"`)
+		}
+	})
+
+	t.Run("return 401 when the request is not made on behalf of a dental practice", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				t.Error("GetPatient() should not be called without a dental practice")
+				return patients.Patient{}, nil
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients/3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", nil)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := GetPatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("check that the dental practice of the request is getting passed to the patients store", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, requestedDentalPracticeID string, patientID string) (patients.Patient, error) {
+				if requestedDentalPracticeID != dentalPracticeID {
+					t.Errorf("%q was passed to GetPatient() but the expected value was %q", requestedDentalPracticeID, dentalPracticeID)
+				}
+
+				return patients.Patient{PatientID: patientID}, nil
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients/3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := GetPatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+	})
+
+	t.Run("return 503 when the database is unavailable", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return patients.Patient{}, fmt.Errorf("could not get patient %q: %w", patientID, patients.ErrUnavailable)
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients/3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := GetPatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusServiceUnavailable)
+	})
+
+	t.Run("return 500 when getting the patient fails unexpectedly", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return patients.Patient{}, errors.New("could not unmarshal response")
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients/3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := GetPatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusInternalServerError)
+	})
+
+	t.Run("return 304 when the client already has the current version of the patient", func(t *testing.T) {
+		patient := patients.Patient{PatientID: "3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", FirstName: "Jane", Version: 2, CreatedAt: "2022-10-01T09:00:00Z", ModifiedAt: "2022-10-02T09:00:00Z"}
+
+		cases := map[string]map[string]string{
+			"if-none-match":     {"if-none-match": `"2"`},
+			"if-modified-since": {"if-modified-since": "Sun, 02 Oct 2022 09:00:00 GMT"},
+		}
+
+		for name, headers := range cases {
+			t.Run(name, func(t *testing.T) {
+				// create the stub patient store
+				patientStore := StubPatientStore{
+					getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+						return patient, nil
+					},
+				}
+
+				// create a request to pass to our handler
+				req, _ := http.NewRequest("GET", "/patients/3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", nil)
+
+				// set the dental practice the request is made on behalf of
+				req = withDentalPractice(req, dentalPracticeID)
+
+				// set the conditional headers
+				for name, value := range headers {
+					req.Header.Set(name, value)
+				}
+
+				// create a response recorder
+				res := httptest.NewRecorder()
+
+				// get the handler
+				handler := GetPatientHandler(logger, &patientStore)
+
+				// our handler satisfies http.handler, so we can call its serve http method
+				// directly and pass in our request and response recorder
+				handler.ServeHTTP(res, req)
+
+				// assert status code is what we expect
+				assertStatusCode(t, res.Code, http.StatusNotModified)
+
+				// assert the body is left out but the validators are not
+				if res.Body.Len() != 0 {
+					t.Errorf("handler returned a body for a 304: %q", res.Body.String())
+				}
+
+				if got := res.Header().Get("etag"); got != `"2"` {
+					t.Errorf("handler returned wrong etag: got %v want %v", got, `"2"`)
+				}
+
+				if got := res.Header().Get("last-modified"); got != "Sun, 02 Oct 2022 09:00:00 GMT" {
+					t.Errorf("handler returned wrong last-modified: got %v want %v", got, "Sun, 02 Oct 2022 09:00:00 GMT")
+				}
+			})
+		}
+	})
+
+	t.Run("return 200 when the patient has changed since the client got it", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return patients.Patient{PatientID: patientID, FirstName: "Janet", Version: 3, ModifiedAt: "2022-10-03T09:00:00Z"}, nil
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients/3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the etag of the version the client has
+		req.Header.Set("if-none-match", `"2"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := GetPatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		// assert the new version of the patient is returned
+		if got := getPatientFromResponse(t, res.Body); got.FirstName != "Janet" {
+			t.Errorf("handler returned an unexpected patient %+v", got)
+		}
+	})
+
+	t.Run("return 308 to the surviving patient when the patient was merged", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return patients.Patient{PatientID: patientID, MergedInto: "surviving_patient_id"}, nil
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients/3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := GetPatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusPermanentRedirect)
+
+		// assert the patient is redirected to the surviving patient
+		if got := res.Header().Get("location"); got != "/patients/surviving_patient_id" {
+			t.Errorf("handler returned wrong location: got %q want %q", got, "/patients/surviving_patient_id")
+		}
+	})
+}
+
+func TestGetPatientWithMemoryStore(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	// create the logger
+	logger, _ := zap.NewProduction()
+
+	// create an in memory patient store holding a single patient
+	patientStore := patients.NewMemoryPatientStore()
+	created, err := patientStore.CreatePatient(context.Background(), dentalPracticeID, patients.CreatePatientRequest{FirstName: "Jane", LastName: "Doe"})
+	if err != nil {
+		t.Fatalf("could not create the patient: %v", err)
+	}
+
+	t.Run("return 200 along with the stored patient", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/patients/%v", created.PatientID), nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := GetPatientHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		got := getPatientFromResponse(t, res.Body)
+		if got.PatientID != created.PatientID || got.FirstName != "Jane" || !got.Active || got.CreatedAt == "" {
+			t.Errorf("handler returned unexpected patient %+v", got)
+		}
+	})
+
+	t.Run("return 404 when the patient belongs to another dental practice", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/patients/%v", created.PatientID), nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, "other_dental_practice_id")
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := GetPatientHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusNotFound)
+	})
+
+	t.Run("the status is written before the patient", func(t *testing.T) {
+		// create a server that records what net/http logs, it warns about a
+		// status that is written after the body
+		var serverLog bytes.Buffer
+		handler := GetPatientHandler(logger, patientStore)
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler.ServeHTTP(w, withDentalPractice(r, dentalPracticeID))
+		}))
+		server.Config.ErrorLog = log.New(&serverLog, "", 0)
+		server.Start()
+
+		res, err := http.Get(fmt.Sprintf("%v/patients/%v", server.URL, created.PatientID))
+		if err != nil {
+			t.Fatalf("could not get the patient: %v", err)
+		}
+		res.Body.Close()
+
+		// close the server so everything it logs has been logged
+		server.Close()
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.StatusCode, http.StatusOK)
+
+		if strings.Contains(serverLog.String(), "superfluous") {
+			t.Errorf("the status was written twice: %v", serverLog.String())
+		}
 	})
 }
 
+// the request is made by an admin, who sees every field.
+func withDentalPractice(req *http.Request, dentalPracticeID string) *http.Request {
+	return withRole(req, dentalPracticeID, auth.RoleAdmin)
+}
+
+func withRole(req *http.Request, dentalPracticeID string, role auth.Role) *http.Request {
+	return req.WithContext(auth.NewContext(req.Context(), auth.Identity{DentalPracticeID: dentalPracticeID, Role: role}))
+}
+
 func assertStatusCode(t testing.TB, got, want int) {
 	t.Helper()
 
@@ -134,3 +555,92 @@ func assertPatient(t testing.TB, got, want patients.Patient) {
 		t.Error("handler returned unexpected body", diff)
 	}
 }
+
+func TestGetPatientFieldVisibility(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	storedPatient := patients.Patient{
+		PatientID:               "3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34",
+		FirstName:               "Jane",
+		LastName:                "Doe",
+		NationalInsuranceNumber: "QQ123456C",
+		DateOfBirth:             "1985-03-14",
+		Ethnicity:               "test_ethnicity",
+		MobilePhone:             "07865154788",
+		Version:                 1,
+	}
+
+	// create the logger
+	logger, _ := zap.NewProduction()
+
+	// create the stub patient store
+	patientStore := StubPatientStore{
+		getPatient: func(_ context.Context, _ string, _ string) (patients.Patient, error) {
+			return storedPatient, nil
+		},
+	}
+
+	t.Run("return 403 when the request has no role", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients/3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", nil)
+
+		// set the dental practice the request is made on behalf of, without a role
+		req = withRole(req, dentalPracticeID, "")
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get handler
+		handler := GetPatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusForbidden)
+	})
+
+	t.Run("a receptionist sees the national insurance number masked and not the ethnicity", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients/3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", nil)
+
+		// set the dental practice and the role the request is made with
+		req = withRole(req, dentalPracticeID, auth.RoleReceptionist)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get handler
+		handler := GetPatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		// the response depends on who asked for it
+		if vary := res.Header().Get("vary"); vary != "authorization" {
+			t.Errorf("got vary header %q want %q", vary, "authorization")
+		}
+
+		var got map[string]interface{}
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatalf("unable to process response from server into a map, '%v'", err)
+		}
+
+		if got["national_insurance_number"] != "QQ******C" {
+			t.Errorf("got national insurance number %v want %q", got["national_insurance_number"], "QQ******C")
+		}
+
+		if got["mobile_phone"] != storedPatient.MobilePhone || got["first_name"] != storedPatient.FirstName {
+			t.Errorf("got %v want the contact details and name as they are", got)
+		}
+
+		if _, ok := got["ethnicity"]; ok {
+			t.Errorf("got %v want the ethnicity to be left out", got)
+		}
+	})
+}
@@ -4,8 +4,11 @@ import (
 	"net/http"
 
 	"github.com/akrylysov/algnhsa"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
 	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
 	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/get"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/ratelimit"
 	"go.uber.org/zap"
 )
 
@@ -17,6 +20,9 @@ func main() {
 
 	mux := http.NewServeMux()
 
-	mux.Handle("/", get.GetPatientHandler(logger, patients.NewPatientStore(logger)))
+	// every lambda instance shares the rate limit buckets through the table
+	limiter := ratelimit.NewLimiter(ratelimit.NewDynamoDBStore(logger), ratelimit.LimitsFromEnv(logger))
+
+	mux.Handle("/", logging.RequestIDs(logger, auth.APIGatewayClaims(logger, limiter.Enforce(logger, ratelimit.RouteGetPatient, get.GetPatientHandler(logger, patients.NewPatientStore(logger))))))
 	algnhsa.ListenAndServe(mux, nil)
 }
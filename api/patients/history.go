@@ -0,0 +1,81 @@
+package patients
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+)
+
+// the operations recorded in the history of a patient.
+const (
+	HistoryOperationCreate = "create"
+	HistoryOperationUpdate = "update"
+	// another patient was merged into the patient
+	HistoryOperationMerge = "merge"
+	// the patient was merged into another patient
+	HistoryOperationMerged = "merged"
+)
+
+// the json names of the patient fields that are not recorded in the history,
+// as every change touches them.
+var unaudited = map[string]bool{"patient_id": true, "created_at": true, "modified_at": true, "version": true}
+
+// returns the sort key of the history item for a version of a patient. each
+// write moves the patient on to a new version, so there is exactly one history
+// item per version, and the zero padding keeps them in version order.
+func historySortKey(patientID string, version int) string {
+	return fmt.Sprintf("%v%010d", historySortKeyPrefix(patientID), version)
+}
+
+// returns the prefix shared by the sort keys of every history item of a
+// patient.
+func historySortKeyPrefix(patientID string) string {
+	return fmt.Sprintf("p#%v#h#", patientID)
+}
+
+// returns the history item recording a change to a patient, attributed to the
+// user the request is made by.
+func newHistoryItem(ctx context.Context, operation string, before Patient, after Patient) PatientHistoryItem {
+	var actor string
+	if identity, ok := auth.FromContext(ctx); ok {
+		actor = identity.UserID
+	}
+
+	return PatientHistoryItem{
+		PatientID: after.PatientID,
+		Version:   after.Version,
+		Operation: operation,
+		Actor:     actor,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Changes:   diffPatients(before, after),
+	}
+}
+
+// returns the fields that differ between two versions of a patient, in the
+// order they are declared in.
+func diffPatients(before Patient, after Patient) []FieldChange {
+	changes := []FieldChange{}
+
+	beforeValue := reflect.ValueOf(before)
+	afterValue := reflect.ValueOf(after)
+
+	t := beforeValue.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if unaudited[field] {
+			continue
+		}
+
+		b := fmt.Sprint(beforeValue.Field(i).Interface())
+		a := fmt.Sprint(afterValue.Field(i).Interface())
+		if b != a {
+			changes = append(changes, FieldChange{Field: field, Before: b, After: a})
+		}
+	}
+
+	return changes
+}
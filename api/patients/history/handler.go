@@ -0,0 +1,123 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/apierror"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/redaction"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/validation"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
+	"go.uber.org/zap"
+)
+
+const contentTypeHeader string = "content-type"
+const jsonContentType string = "application/json"
+
+// PatientHistoryHandler returns the changes made to the patient in the path,
+// newest first, a page at a time.
+func PatientHistoryHandler(logger *zap.Logger, repository patients.PatientRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context(), logger)
+
+		logger.Info("running the patient history handler...")
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("allow", http.MethodGet)
+			problem.Error(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		identity, ok := auth.FromContext(r.Context())
+		if !ok {
+			logger.Error("no dental practice was found for the request")
+			problem.Error(w, r, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// the fields returned depend on the role, so a role that is not known
+		// gets nothing
+		if !redaction.Allowed(identity.Role) {
+			logger.Error("the role is not allowed to read patients", zap.String("role", string(identity.Role)))
+			problem.Error(w, r, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		patientID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/patients/"), "/history")
+
+		// a path that does not hold a patient id can not name a patient
+		if !validation.IsPatientID(patientID) {
+			logger.Error("the patient id is invalid", zap.String("patientID", patientID))
+			problem.Error(w, r, "requested patient could not be found", http.StatusNotFound)
+			return
+		}
+
+		logger.Info("requested patient", zap.String("patientID", patientID))
+
+		logger = logger.With(zap.String("patientID", patientID))
+
+		query := r.URL.Query()
+
+		limit, err := parseLimit(query.Get("limit"))
+		if err != nil {
+			logger.Error("the limit query string param is invalid", zap.Error(err))
+			problem.Error(w, r, fmt.Sprintf("limit must be a number between 1 and %v", patients.MaxSearchLimit), http.StatusBadRequest)
+			return
+		}
+
+		history, err := repository.GetPatientHistory(logging.NewContext(r.Context(), logger), identity.DentalPracticeID, patients.PatientHistoryRequest{
+			PatientID: patientID,
+			Limit:     limit,
+			Cursor:    query.Get("cursor"),
+		})
+
+		if errors.Is(err, patients.ErrInvalidCursor) {
+			logger.Error("the cursor query string param is invalid", zap.Error(err))
+			problem.Error(w, r, "cursor is invalid", http.StatusBadRequest)
+			return
+		}
+
+		if err != nil {
+			logger.Error("failed to get the patient history", zap.Error(err))
+			apierror.Write(w, r, err, "failed to get the patient history")
+			return
+		}
+
+		w.Header().Set(contentTypeHeader, jsonContentType)
+
+		// callers with different roles see different fields of the same patient
+		w.Header().Set("vary", "authorization")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(redaction.History(identity.Role, history))
+		if err != nil {
+			logger.Error("error in json marshal", zap.Error(err))
+		}
+	})
+}
+
+// parses the limit query string param, falling back to the default page size
+// when it is not set.
+func parseLimit(value string) (int32, error) {
+	if value == "" {
+		return patients.DefaultSearchLimit, nil
+	}
+
+	limit, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	if limit < 1 || int32(limit) > patients.MaxSearchLimit {
+		return 0, errors.New("limit is out of range")
+	}
+
+	return int32(limit), nil
+}
@@ -0,0 +1,334 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"go.uber.org/zap"
+)
+
+// a patient store whose history can not be read, it is used to check how the
+// handler reports the errors of the store. everything else is tested against
+// the memory patient store.
+type StubPatientStore struct {
+	patients.PatientRepository
+	getPatientHistory func(ctx context.Context, dentalPracticeID string, request patients.PatientHistoryRequest) (patients.PatientHistoryResponse, error)
+}
+
+func (s *StubPatientStore) GetPatientHistory(ctx context.Context, dentalPracticeID string, request patients.PatientHistoryRequest) (patients.PatientHistoryResponse, error) {
+	return s.getPatientHistory(ctx, dentalPracticeID, request)
+}
+
+func TestPatientHistory(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	// create an in memory patient store holding a patient that has been changed
+	// once since it was created
+	patientStore := patients.NewMemoryPatientStore()
+	created, err := patientStore.CreatePatient(context.Background(), dentalPracticeID, patients.CreatePatientRequest{FirstName: "Jane", LastName: "Doe", NationalInsuranceNumber: "AB123456C"})
+	if err != nil {
+		t.Fatalf("could not create the patient: %v", err)
+	}
+
+	_, err = patientStore.UpdatePatient(context.Background(), dentalPracticeID, patients.UpdatePatientRequest{PatientID: created.PatientID, FirstName: "Janet", LastName: "Doe", NationalInsuranceNumber: "AB123456D", Ethnicity: "test_ethnicity"}, 1)
+	if err != nil {
+		t.Fatalf("could not update the patient: %v", err)
+	}
+
+	requestedPatientID := created.PatientID
+
+	// create the logger
+	logger, _ := zap.NewProduction()
+
+	t.Run("returns 405 (method not allowed) when the request is not a get", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/patients/%v/history", requestedPatientID), nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := PatientHistoryHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusMethodNotAllowed)
+	})
+
+	t.Run("returns 404 (not found) when the path does not hold a patient id", func(t *testing.T) {
+		// create a request to pass to our handler
+		req := httptest.NewRequest("GET", fmt.Sprintf("/patients/%v%%23h%%230/history", requestedPatientID), nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := PatientHistoryHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusNotFound)
+	})
+
+	t.Run("returns 400 (bad request) when the limit is invalid", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/patients/%v/history?limit=0", requestedPatientID), nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := PatientHistoryHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusBadRequest)
+	})
+
+	t.Run("returns 400 (bad request) when the cursor is invalid", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/patients/%v/history?cursor=invalid", requestedPatientID), nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := PatientHistoryHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusBadRequest)
+	})
+
+	t.Run("returns 404 (not found) when the patient does not exist", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients/3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34/history", nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := PatientHistoryHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusNotFound)
+	})
+
+	t.Run("returns 404 (not found) when the patient belongs to another dental practice", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/patients/%v/history", requestedPatientID), nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, "other_dental_practice_id")
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := PatientHistoryHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusNotFound)
+	})
+
+	t.Run("returns 503 (service unavailable) when the database is unavailable", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatientHistory: func(_ context.Context, _ string, _ patients.PatientHistoryRequest) (patients.PatientHistoryResponse, error) {
+				return patients.PatientHistoryResponse{}, fmt.Errorf("could not get the patient history: %w", patients.ErrUnavailable)
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/patients/%v/history", requestedPatientID), nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := PatientHistoryHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusServiceUnavailable)
+	})
+
+	t.Run("returns 200 and a page of the patient history at a time, newest first", func(t *testing.T) {
+		var operations []string
+		cursor := ""
+
+		for {
+			// create a request to pass to our handler
+			req, _ := http.NewRequest("GET", fmt.Sprintf("/patients/%v/history?limit=1&cursor=%v", requestedPatientID, cursor), nil)
+
+			// set the dental practice the request is made on behalf of
+			req = withDentalPractice(req, dentalPracticeID)
+
+			// create a response recorder
+			res := httptest.NewRecorder()
+
+			// get the handler
+			handler := PatientHistoryHandler(logger, patientStore)
+
+			// our handler satisfies http.handler, so we can call its serve http method
+			// directly and pass in our request and response recorder
+			handler.ServeHTTP(res, req)
+
+			// assert status code is what we expect
+			assertStatusCode(t, res.Code, http.StatusOK)
+
+			history := getHistoryFromResponse(t, res.Body)
+			if len(history.Items) != 1 {
+				t.Fatalf("got %v history items want 1", len(history.Items))
+			}
+
+			operations = append(operations, history.Items[0].Operation)
+
+			if history.NextCursor == "" {
+				break
+			}
+
+			cursor = history.NextCursor
+		}
+
+		// assert the pages are what we expect
+		want := []string{patients.HistoryOperationUpdate, patients.HistoryOperationCreate}
+		if diff := cmp.Diff(operations, want); diff != "" {
+			t.Error("handler returned unexpected history", diff)
+		}
+	})
+
+	t.Run("returns 403 (forbidden) when the role is not known", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/patients/%v/history", requestedPatientID), nil)
+
+		// set the dental practice and the role the request is made with
+		req = withRole(req, dentalPracticeID, "patient")
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := PatientHistoryHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusForbidden)
+	})
+
+	t.Run("a receptionist does not see changes to fields that are hidden from them", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/patients/%v/history?limit=1", requestedPatientID), nil)
+
+		// set the dental practice and the role the request is made with
+		req = withRole(req, dentalPracticeID, auth.RoleReceptionist)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := PatientHistoryHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		// the ethnicity is left out and the national insurance number is masked
+		got := map[string]patients.FieldChange{}
+		for _, change := range getHistoryFromResponse(t, res.Body).Items[0].Changes {
+			got[change.Field] = change
+		}
+
+		want := map[string]patients.FieldChange{
+			"first_name":                {Field: "first_name", Before: "Jane", After: "Janet"},
+			"national_insurance_number": {Field: "national_insurance_number", Before: "AB******C", After: "AB******D"},
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Error("handler returned unexpected changes", diff)
+		}
+	})
+}
+
+// the request is made by an admin, who sees every field.
+func withDentalPractice(req *http.Request, dentalPracticeID string) *http.Request {
+	return withRole(req, dentalPracticeID, auth.RoleAdmin)
+}
+
+func withRole(req *http.Request, dentalPracticeID string, role auth.Role) *http.Request {
+	return req.WithContext(auth.NewContext(req.Context(), auth.Identity{DentalPracticeID: dentalPracticeID, Role: role}))
+}
+
+func assertStatusCode(t testing.TB, got, want int) {
+	t.Helper()
+
+	if got != want {
+		t.Errorf("handler returned wrong status code: got %v want %v", got, want)
+	}
+}
+
+func getHistoryFromResponse(t testing.TB, body io.Reader) (history patients.PatientHistoryResponse) {
+	t.Helper()
+
+	err := json.NewDecoder(body).Decode(&history)
+
+	if err != nil {
+		t.Fatalf("unable to process response from server %q into a PatientHistoryResponse, '%v'", body, err)
+	}
+
+	return
+}
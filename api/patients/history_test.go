@@ -0,0 +1,171 @@
+package patients
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+)
+
+func TestDiffPatients(t *testing.T) {
+	before := Patient{PatientID: "test_patient_id", FirstName: "Jane", Email: "jane@example.com", Active: true, Version: 1, ModifiedAt: "2022-10-01T09:00:00Z"}
+	after := Patient{PatientID: "test_patient_id", FirstName: "Janet", PostCode: "LS1 3LP", Active: true, Version: 2, ModifiedAt: "2022-10-02T09:00:00Z"}
+
+	want := []FieldChange{
+		{Field: "first_name", Before: "Jane", After: "Janet"},
+		{Field: "email", Before: "jane@example.com", After: ""},
+		{Field: "post_code", Before: "", After: "LS1 3LP"},
+	}
+
+	if diff := cmp.Diff(diffPatients(before, after), want); diff != "" {
+		t.Error("unexpected changes", diff)
+	}
+}
+
+func TestGetPatientHistory(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	// the changes are attributed to the user in the context
+	ctx := auth.NewContext(context.Background(), auth.Identity{DentalPracticeID: dentalPracticeID, UserID: "test_user_id"})
+
+	// both stores have to record the same history
+	stores := map[string]PatientRepository{
+		"dynamodb": &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"},
+		"memory":   NewMemoryPatientStore(),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			created, err := store.CreatePatient(ctx, dentalPracticeID, CreatePatientRequest{FirstName: "Jane", LastName: "Doe"})
+			if err != nil {
+				t.Fatalf("could not create the patient: %v", err)
+			}
+
+			_, err = store.UpdatePatient(ctx, dentalPracticeID, UpdatePatientRequest{PatientID: created.PatientID, FirstName: "Janet", LastName: "Doe"}, 1)
+			if err != nil {
+				t.Fatalf("could not update the patient: %v", err)
+			}
+
+			source, _ := store.CreatePatient(ctx, dentalPracticeID, CreatePatientRequest{FirstName: "Janet", LastName: "Doe", Email: "janet@example.com"})
+			_, err = store.MergePatients(ctx, dentalPracticeID, MergePatientsRequest{PatientID: created.PatientID, SourcePatientID: source.PatientID, Fields: []string{"email"}}, 2)
+			if err != nil {
+				t.Fatalf("could not merge the patients: %v", err)
+			}
+
+			t.Run("records every change newest first", func(t *testing.T) {
+				history, err := store.GetPatientHistory(ctx, dentalPracticeID, PatientHistoryRequest{PatientID: created.PatientID})
+				if err != nil {
+					t.Fatalf("could not get the patient history: %v", err)
+				}
+
+				if len(history.Items) != 3 {
+					t.Fatalf("got %+v want 3 history items", history.Items)
+				}
+
+				for i, want := range []struct {
+					operation string
+					version   int
+					changes   []FieldChange
+				}{
+					{operation: HistoryOperationMerge, version: 3, changes: []FieldChange{{Field: "email", Before: "", After: "janet@example.com"}}},
+					{operation: HistoryOperationUpdate, version: 2, changes: []FieldChange{{Field: "first_name", Before: "Jane", After: "Janet"}}},
+					{operation: HistoryOperationCreate, version: 1, changes: []FieldChange{
+						{Field: "first_name", Before: "", After: "Jane"},
+						{Field: "last_name", Before: "", After: "Doe"},
+						{Field: "active", Before: "false", After: "true"},
+					}},
+				} {
+					got := history.Items[i]
+
+					if got.Operation != want.operation || got.Version != want.version || got.Actor != "test_user_id" || got.Timestamp == "" || got.PatientID != created.PatientID {
+						t.Errorf("got %+v want a %v of version %v by test_user_id", got, want.operation, want.version)
+					}
+
+					if diff := cmp.Diff(got.Changes, want.changes); diff != "" {
+						t.Errorf("unexpected changes for the %v %v", want.operation, diff)
+					}
+				}
+			})
+
+			t.Run("records the merged patient being merged", func(t *testing.T) {
+				history, err := store.GetPatientHistory(ctx, dentalPracticeID, PatientHistoryRequest{PatientID: source.PatientID})
+				if err != nil {
+					t.Fatalf("could not get the patient history: %v", err)
+				}
+
+				want := []FieldChange{
+					{Field: "active", Before: "true", After: "false"},
+					{Field: "merged_into", Before: "", After: created.PatientID},
+				}
+
+				if len(history.Items) != 2 || history.Items[0].Operation != HistoryOperationMerged {
+					t.Fatalf("got %+v want the merge followed by the create", history.Items)
+				}
+
+				if diff := cmp.Diff(history.Items[0].Changes, want); diff != "" {
+					t.Error("unexpected changes for the merge", diff)
+				}
+			})
+
+			t.Run("pages through the history using the next cursor", func(t *testing.T) {
+				var versions []int
+				cursor := ""
+				for {
+					history, err := store.GetPatientHistory(ctx, dentalPracticeID, PatientHistoryRequest{PatientID: created.PatientID, Limit: 2, Cursor: cursor})
+					if err != nil {
+						t.Fatalf("could not get the patient history: %v", err)
+					}
+
+					for _, item := range history.Items {
+						versions = append(versions, item.Version)
+					}
+
+					if history.NextCursor == "" {
+						break
+					}
+
+					cursor = history.NextCursor
+				}
+
+				if diff := cmp.Diff(versions, []int{3, 2, 1}); diff != "" {
+					t.Error("unexpected versions", diff)
+				}
+			})
+
+			t.Run("a cursor issued to one practice cannot be used by another", func(t *testing.T) {
+				history, _ := store.GetPatientHistory(ctx, dentalPracticeID, PatientHistoryRequest{PatientID: created.PatientID, Limit: 1})
+
+				// the patient is not found in the other practice either, which
+				// one is checked first does not matter
+				_, err := store.GetPatientHistory(ctx, "other_dental_practice_id", PatientHistoryRequest{PatientID: created.PatientID, Cursor: history.NextCursor})
+				if !errors.Is(err, ErrInvalidCursor) && !errors.Is(err, ErrNotFound) {
+					t.Errorf("got error %v want %v or %v", err, ErrInvalidCursor, ErrNotFound)
+				}
+			})
+
+			t.Run("returns not found for a patient that does not exist", func(t *testing.T) {
+				_, err := store.GetPatientHistory(ctx, dentalPracticeID, PatientHistoryRequest{PatientID: "unknown"})
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("got error %v want %v", err, ErrNotFound)
+				}
+			})
+		})
+	}
+
+	t.Run("history items are written in the same transaction as the patient", func(t *testing.T) {
+		client := newFakeDynamoDBClient()
+		client.transactWriteItemsErr = errors.New("the transaction failed")
+		store := &PatientStore{client: client, tableName: "test_table"}
+
+		_, err := store.CreatePatient(ctx, dentalPracticeID, CreatePatientRequest{FirstName: "Jane"})
+		if err == nil {
+			t.Fatal("expected the create to fail")
+		}
+
+		if len(client.items) != 0 {
+			t.Errorf("got %v items want nothing written", len(client.items))
+		}
+	})
+}
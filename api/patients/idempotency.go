@@ -0,0 +1,64 @@
+package patients
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// IdempotencyKeyTTL is how long the response to a create patient request made
+// with an idempotency key is remembered. a retry made after it has passed
+// creates another patient.
+const IdempotencyKeyTTL time.Duration = 24 * time.Hour
+
+// the prefix of the sort key of the items that remember the idempotency keys.
+const idempotencySortKeyPrefix string = "idem#"
+
+// IdempotencyKey identifies a create patient request that the front-end may
+// retry. the hash of the request tells a retry of the request apart from a
+// different request that reuses the key.
+type IdempotencyKey struct {
+	Key         string
+	RequestHash string
+}
+
+// NewIdempotencyKey returns the idempotency key of a create patient request.
+func NewIdempotencyKey(key string, request CreatePatientRequest) (IdempotencyKey, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return IdempotencyKey{}, fmt.Errorf("could not hash the create patient request: %w", err)
+	}
+
+	hash := sha256.Sum256(body)
+
+	return IdempotencyKey{Key: key, RequestHash: hex.EncodeToString(hash[:])}, nil
+}
+
+// remembers the response to a create patient request made with an
+// idempotency key, the ttl attribute has dynamodb delete it once it expires.
+type idempotencyRecord struct {
+	RequestHash string `dynamodbav:"rh"`
+	PatientID   string `dynamodbav:"pid"`
+	ExpiresAt   int64  `dynamodbav:"ttl"`
+}
+
+func newIdempotencyRecord(key IdempotencyKey, response CreatePatientResponse, now time.Time) idempotencyRecord {
+	return idempotencyRecord{RequestHash: key.RequestHash, PatientID: response.PatientID, ExpiresAt: now.Add(IdempotencyKeyTTL).Unix()}
+}
+
+// dynamodb only deletes expired items eventually, so they are checked too.
+func (r idempotencyRecord) expired(now time.Time) bool {
+	return r.ExpiresAt <= now.Unix()
+}
+
+// returns the response the record remembers, as long as it was for the same
+// request.
+func (r idempotencyRecord) response(key IdempotencyKey) (CreatePatientResponse, error) {
+	if r.RequestHash != key.RequestHash {
+		return CreatePatientResponse{}, ErrIdempotencyKeyReused
+	}
+
+	return CreatePatientResponse{PatientID: r.PatientID}, nil
+}
@@ -0,0 +1,140 @@
+package patients
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestNewIdempotencyKey(t *testing.T) {
+	first, _ := NewIdempotencyKey("test_key", CreatePatientRequest{FirstName: "Jane", LastName: "Doe"})
+	retry, _ := NewIdempotencyKey("test_key", CreatePatientRequest{FirstName: "Jane", LastName: "Doe"})
+	different, _ := NewIdempotencyKey("test_key", CreatePatientRequest{FirstName: "Janet", LastName: "Doe"})
+
+	if first != retry {
+		t.Errorf("the same request got different keys %+v and %+v", first, retry)
+	}
+
+	if first.RequestHash == different.RequestHash {
+		t.Errorf("different requests got the same hash %q", first.RequestHash)
+	}
+}
+
+func TestCreatePatientIdempotently(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+	ctx := context.Background()
+
+	// both stores have to remember the keys the same way
+	stores := map[string]PatientRepository{
+		"dynamodb": &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"},
+		"memory":   NewMemoryPatientStore(),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			request := CreatePatientRequest{FirstName: "Jane", LastName: "Doe"}
+			key, _ := NewIdempotencyKey("test_key", request)
+
+			t.Run("an unused key is not found", func(t *testing.T) {
+				_, err := store.GetIdempotentResponse(ctx, dentalPracticeID, key)
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("got %v want ErrNotFound", err)
+				}
+			})
+
+			created, err := store.CreatePatientIdempotently(ctx, dentalPracticeID, key, request)
+			if err != nil {
+				t.Fatalf("could not create the patient: %v", err)
+			}
+
+			t.Run("remembers the response for the key", func(t *testing.T) {
+				got, err := store.GetIdempotentResponse(ctx, dentalPracticeID, key)
+				if err != nil || got != created {
+					t.Errorf("got %+v, '%v' want %+v", got, err, created)
+				}
+			})
+
+			t.Run("a retry returns the patient created the first time", func(t *testing.T) {
+				got, err := store.CreatePatientIdempotently(ctx, dentalPracticeID, key, request)
+				if err != nil || got != created {
+					t.Errorf("got %+v, '%v' want %+v", got, err, created)
+				}
+
+				patients, _ := store.ListPatients(ctx, dentalPracticeID, ListPatientsRequest{})
+				if len(patients.Items) != 1 {
+					t.Errorf("got %v patients want 1", len(patients.Items))
+				}
+			})
+
+			t.Run("the key cannot be used with a different request", func(t *testing.T) {
+				different, _ := NewIdempotencyKey("test_key", CreatePatientRequest{FirstName: "Janet", LastName: "Doe"})
+
+				if _, err := store.GetIdempotentResponse(ctx, dentalPracticeID, different); !errors.Is(err, ErrIdempotencyKeyReused) {
+					t.Errorf("got %v want ErrIdempotencyKeyReused", err)
+				}
+
+				if _, err := store.CreatePatientIdempotently(ctx, dentalPracticeID, different, request); !errors.Is(err, ErrIdempotencyKeyReused) {
+					t.Errorf("got %v want ErrIdempotencyKeyReused", err)
+				}
+			})
+
+			t.Run("keys are not shared between dental practices", func(t *testing.T) {
+				got, err := store.CreatePatientIdempotently(ctx, "another_dental_practice_id", key, request)
+				if err != nil || got == created {
+					t.Errorf("got %+v, '%v' want a new patient", got, err)
+				}
+			})
+		})
+	}
+}
+
+func TestExpiredIdempotencyKeys(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+	ctx := context.Background()
+
+	request := CreatePatientRequest{FirstName: "Jane", LastName: "Doe"}
+	key, _ := NewIdempotencyKey("test_key", request)
+
+	t.Run("dynamodb", func(t *testing.T) {
+		client := newFakeDynamoDBClient()
+		store := &PatientStore{client: client, tableName: "test_table"}
+
+		created, _ := store.CreatePatientIdempotently(ctx, dentalPracticeID, key, request)
+
+		// dynamodb has not got round to deleting the expired key yet
+		item := client.items[fakeItemKey(idempotencyItemKey(dentalPracticeID, key.Key))]
+		item["ttl"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)}
+
+		if _, err := store.GetIdempotentResponse(ctx, dentalPracticeID, key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("got %v want ErrNotFound", err)
+		}
+
+		got, err := store.CreatePatientIdempotently(ctx, dentalPracticeID, key, request)
+		if err != nil || got == created {
+			t.Errorf("got %+v, '%v' want a new patient", got, err)
+		}
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		store := NewMemoryPatientStore()
+
+		created, _ := store.CreatePatientIdempotently(ctx, dentalPracticeID, key, request)
+
+		record := store.idempotencyKeys[partitionKeyValue(dentalPracticeID)][key.Key]
+		record.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+		store.idempotencyKeys[partitionKeyValue(dentalPracticeID)][key.Key] = record
+
+		if _, err := store.GetIdempotentResponse(ctx, dentalPracticeID, key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("got %v want ErrNotFound", err)
+		}
+
+		got, err := store.CreatePatientIdempotently(ctx, dentalPracticeID, key, request)
+		if err != nil || got == created {
+			t.Errorf("got %+v, '%v' want a new patient", got, err)
+		}
+	})
+}
@@ -0,0 +1,586 @@
+package patients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/encryption"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
+	"go.uber.org/zap"
+)
+
+// MemoryPatientStore is a PatientRepository that keeps patients in memory. it
+// behaves like PatientStore, including its cursors, so it can stand in for the
+// table in tests and when running the api locally. it is safe for concurrent
+// use.
+type MemoryPatientStore struct {
+	mu sync.RWMutex
+	// patients keyed by partition key and then by patient id.
+	patients map[string]map[string]Patient
+	// the history of each patient, oldest change first, keyed the same way.
+	history map[string]map[string][]PatientHistoryItem
+	// the idempotency keys keyed by partition key and then by key.
+	idempotencyKeys map[string]map[string]idempotencyRecord
+	// hashes the national insurance numbers, the index is rebuilt from the
+	// patients on every search so its key never has to outlive the store
+	blindIndex *encryption.BlindIndex
+}
+
+func NewMemoryPatientStore() *MemoryPatientStore {
+	blindIndex, err := encryption.NewRandomBlindIndex()
+	if err != nil {
+		panic(err)
+	}
+
+	return &MemoryPatientStore{
+		patients:        make(map[string]map[string]Patient),
+		history:         make(map[string]map[string][]PatientHistoryItem),
+		idempotencyKeys: make(map[string]map[string]idempotencyRecord),
+		blindIndex:      blindIndex,
+	}
+}
+
+// a row of an index, the sort key is compared first and the table sort key is
+// used to break ties, the same as dynamodb orders items with equal sort keys.
+type memoryIndexRow struct {
+	sortKey string
+	_sk     string
+	item    PatientSearchResponseItem
+}
+
+func (m *MemoryPatientStore) CreatePatient(ctx context.Context, dentalPracticeID string, patient CreatePatientRequest) (CreatePatientResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.createPatient(ctx, dentalPracticeID, patient)
+}
+
+// creates the patient, the caller has to hold the write lock.
+func (m *MemoryPatientStore) createPatient(ctx context.Context, dentalPracticeID string, patient CreatePatientRequest) (CreatePatientResponse, error) {
+	logger := logging.FromContext(ctx, zap.NewNop())
+
+	// generate the unique patient id
+	patient.PatientID = uuid.New().String()
+	logger.Info("creating patient", zap.String("dentalPracticeID", dentalPracticeID), zap.String("patientID", patient.PatientID))
+
+	stored, err := toPatient(patient)
+	if err != nil {
+		return CreatePatientResponse{}, fmt.Errorf("could not create patient %q: %w", patient.PatientID, err)
+	}
+
+	stored.Active = true
+	stored.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	stored.Version = 1
+
+	partitionKey := partitionKeyValue(dentalPracticeID)
+	if m.patients[partitionKey] == nil {
+		m.patients[partitionKey] = make(map[string]Patient)
+	}
+
+	if _, exists := m.patients[partitionKey][patient.PatientID]; exists {
+		return CreatePatientResponse{}, ErrPatientAlreadyExists
+	}
+
+	m.patients[partitionKey][patient.PatientID] = stored
+	m.recordHistory(partitionKey, newHistoryItem(ctx, HistoryOperationCreate, Patient{}, stored))
+
+	return CreatePatientResponse{PatientID: patient.PatientID}, nil
+}
+
+func (m *MemoryPatientStore) GetIdempotentResponse(ctx context.Context, dentalPracticeID string, key IdempotencyKey) (CreatePatientResponse, error) {
+	logger := logging.FromContext(ctx, zap.NewNop())
+	logger.Info("getting the response for the idempotency key", zap.String("dentalPracticeID", dentalPracticeID))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.idempotentResponse(dentalPracticeID, key)
+}
+
+// returns the response remembered for the key, the caller has to hold the
+// lock.
+func (m *MemoryPatientStore) idempotentResponse(dentalPracticeID string, key IdempotencyKey) (CreatePatientResponse, error) {
+	record, ok := m.idempotencyKeys[partitionKeyValue(dentalPracticeID)][key.Key]
+	if !ok || record.expired(time.Now()) {
+		return CreatePatientResponse{}, newRepositoryError(ErrNotFound, "idempotency key %q has not been used", key.Key)
+	}
+
+	return record.response(key)
+}
+
+func (m *MemoryPatientStore) CreatePatientIdempotently(ctx context.Context, dentalPracticeID string, key IdempotencyKey, patient CreatePatientRequest) (CreatePatientResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// another request with the same key got there first
+	response, err := m.idempotentResponse(dentalPracticeID, key)
+	if !errors.Is(err, ErrNotFound) {
+		return response, err
+	}
+
+	response, err = m.createPatient(ctx, dentalPracticeID, patient)
+	if err != nil {
+		return CreatePatientResponse{}, err
+	}
+
+	partitionKey := partitionKeyValue(dentalPracticeID)
+	if m.idempotencyKeys[partitionKey] == nil {
+		m.idempotencyKeys[partitionKey] = make(map[string]idempotencyRecord)
+	}
+
+	m.idempotencyKeys[partitionKey][key.Key] = newIdempotencyRecord(key, response, time.Now())
+
+	return response, nil
+}
+
+func (m *MemoryPatientStore) GetPatient(ctx context.Context, dentalPracticeID string, patientID string) (Patient, error) {
+	logger := logging.FromContext(ctx, zap.NewNop())
+	logger.Info("getting patient", zap.String("dentalPracticeID", dentalPracticeID))
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	patient, ok := m.patients[partitionKeyValue(dentalPracticeID)][patientID]
+	if !ok {
+		return Patient{}, newRepositoryError(ErrNotFound, "could not find patient with id %q in the database", patientID)
+	}
+
+	return patient, nil
+}
+
+func (m *MemoryPatientStore) UpdatePatient(ctx context.Context, dentalPracticeID string, patient UpdatePatientRequest, expectedVersion int) (Patient, error) {
+	logger := logging.FromContext(ctx, zap.NewNop())
+	logger.Info("updating patient", zap.String("dentalPracticeID", dentalPracticeID), zap.String("patientID", patient.PatientID))
+
+	updated, err := toPatient(patient)
+	if err != nil {
+		return Patient{}, fmt.Errorf("could not update patient %q: %w", patient.PatientID, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	partitionKey := partitionKeyValue(dentalPracticeID)
+
+	existing, ok := m.patients[partitionKey][patient.PatientID]
+	if !ok {
+		return Patient{}, newRepositoryError(ErrNotFound, "could not find patient with id %q in the database", patient.PatientID)
+	}
+
+	if err := checkNotMerged(existing); err != nil {
+		return Patient{}, err
+	}
+
+	if err := checkVersion(existing, expectedVersion); err != nil {
+		return Patient{}, err
+	}
+
+	// the fields that are not part of the request are kept
+	updated.Active = existing.Active
+	updated.CreatedAt = existing.CreatedAt
+	updated.ModifiedAt = time.Now().UTC().Format(time.RFC3339)
+	updated.Version = existing.Version + 1
+
+	m.patients[partitionKey][patient.PatientID] = updated
+	m.recordHistory(partitionKey, newHistoryItem(ctx, HistoryOperationUpdate, existing, updated))
+
+	return updated, nil
+}
+
+func (m *MemoryPatientStore) MergePatients(ctx context.Context, dentalPracticeID string, request MergePatientsRequest, expectedVersion int) (Patient, error) {
+	logger := logging.FromContext(ctx, zap.NewNop())
+	logger.Info("merging patients", zap.String("dentalPracticeID", dentalPracticeID), zap.String("patientID", request.PatientID), zap.String("sourcePatientID", request.SourcePatientID))
+
+	if err := validateMergePatientsRequest(request); err != nil {
+		return Patient{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	partitionKey := partitionKeyValue(dentalPracticeID)
+
+	survivor, ok := m.patients[partitionKey][request.PatientID]
+	if !ok {
+		return Patient{}, newRepositoryError(ErrNotFound, "could not find patient with id %q in the database", request.PatientID)
+	}
+
+	source, ok := m.patients[partitionKey][request.SourcePatientID]
+	if !ok {
+		return Patient{}, newRepositoryError(ErrNotFound, "could not find patient with id %q in the database", request.SourcePatientID)
+	}
+
+	if err := checkNotMerged(survivor, source); err != nil {
+		return Patient{}, err
+	}
+
+	if err := checkVersion(survivor, expectedVersion); err != nil {
+		return Patient{}, err
+	}
+
+	mergeRequest, err := mergePatients(survivor, source, request.Fields)
+	if err != nil {
+		return Patient{}, fmt.Errorf("could not merge patient %q into patient %q: %w", source.PatientID, survivor.PatientID, err)
+	}
+
+	merged, err := toPatient(mergeRequest)
+	if err != nil {
+		return Patient{}, fmt.Errorf("could not merge patient %q into patient %q: %w", source.PatientID, survivor.PatientID, err)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	// the fields that are not part of the request are kept
+	merged.Active = survivor.Active
+	merged.CreatedAt = survivor.CreatedAt
+	merged.ModifiedAt = now
+	merged.Version = survivor.Version + 1
+
+	sourceBefore := source
+	source.MergedInto = survivor.PatientID
+	source.Active = false
+	source.ModifiedAt = now
+	source.Version++
+
+	m.patients[partitionKey][survivor.PatientID] = merged
+	m.patients[partitionKey][source.PatientID] = source
+	m.recordHistory(partitionKey, newHistoryItem(ctx, HistoryOperationMerge, survivor, merged))
+	m.recordHistory(partitionKey, newHistoryItem(ctx, HistoryOperationMerged, sourceBefore, source))
+
+	return merged, nil
+}
+
+// adds a history item to the history of its patient, the caller has to hold
+// the write lock.
+func (m *MemoryPatientStore) recordHistory(partitionKey string, historyItem PatientHistoryItem) {
+	if m.history[partitionKey] == nil {
+		m.history[partitionKey] = make(map[string][]PatientHistoryItem)
+	}
+
+	m.history[partitionKey][historyItem.PatientID] = append(m.history[partitionKey][historyItem.PatientID], historyItem)
+}
+
+func (m *MemoryPatientStore) GetPatientHistory(ctx context.Context, dentalPracticeID string, request PatientHistoryRequest) (PatientHistoryResponse, error) {
+	logger := logging.FromContext(ctx, zap.NewNop())
+	logger.Info("getting patient history", zap.String("dentalPracticeID", dentalPracticeID), zap.String("patientID", request.PatientID))
+
+	partitionKey := getPartitionKey(dentalPracticeID)
+
+	exclusiveStartKey, err := decodeCursor(request.Cursor, partitionKey, "")
+	if err != nil {
+		return PatientHistoryResponse{}, err
+	}
+
+	// the cursor holds the sort key of the last history item of the previous
+	// page, the same as the last evaluated key of the table
+	startSortKey := ""
+	if exclusiveStartKey != nil {
+		sortKey, ok := exclusiveStartKey["_sk"].(*types.AttributeValueMemberS)
+		if !ok {
+			return PatientHistoryResponse{}, ErrInvalidCursor
+		}
+
+		startSortKey = sortKey.Value
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.patients[partitionKeyValue(dentalPracticeID)][request.PatientID]; !ok {
+		return PatientHistoryResponse{}, newRepositoryError(ErrNotFound, "could not find patient with id %q in the database", request.PatientID)
+	}
+
+	limit := request.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	// newest change first
+	history := m.history[partitionKeyValue(dentalPracticeID)][request.PatientID]
+	response := PatientHistoryResponse{Items: []PatientHistoryItem{}}
+	for i := len(history) - 1; i >= 0; i-- {
+		sortKey := historySortKey(history[i].PatientID, history[i].Version)
+		if startSortKey != "" && sortKey >= startSortKey {
+			continue
+		}
+
+		if len(response.Items) == int(limit) {
+			last := response.Items[len(response.Items)-1]
+			response.NextCursor, err = encodeCursor(map[string]types.AttributeValue{
+				"_pk": partitionKey,
+				"_sk": &types.AttributeValueMemberS{Value: historySortKey(last.PatientID, last.Version)},
+			})
+			if err != nil {
+				return PatientHistoryResponse{}, err
+			}
+			break
+		}
+
+		response.Items = append(response.Items, history[i])
+	}
+
+	return response, nil
+}
+
+func (m *MemoryPatientStore) SearchPatients(ctx context.Context, dentalPracticeID string, request SearchPatientsRequest) (PatientSearchResponse, error) {
+	logger := logging.FromContext(ctx, zap.NewNop())
+	logger.Info("searching patients", zap.String("dentalPracticeID", dentalPracticeID), zap.String("field", string(request.Field)))
+
+	if isRankedSearch(request) {
+		return rankedSearch(m.searchIndexReader(dentalPracticeID), m.searchIndexScanner(dentalPracticeID), request)
+	}
+
+	index, sortKeyPrefix, err := m.searchKeyer().searchQuery(request.Field, request.SearchTerm)
+	if err != nil {
+		return PatientSearchResponse{}, newRepositoryError(ErrValidation, "%w", err)
+	}
+
+	rows := m.searchRows(dentalPracticeID, index, sortKeyPrefix)
+
+	// a patient is only returned once even if both of their names match
+	if index == searchIndexes[SearchFieldName] {
+		unique := rows[:0]
+		for _, row := range rows {
+			if !isDuplicateNameMatch(indexedSearchItem{PatientSearchResponseItem: row.item, SortKey: row._sk}, sortKeyPrefix) {
+				unique = append(unique, row)
+			}
+		}
+		rows = unique
+	}
+
+	return page(dentalPracticeID, index.attribute, false, rows, request.Limit, request.Cursor)
+}
+
+func (m *MemoryPatientStore) FindDuplicatePatients(ctx context.Context, dentalPracticeID string, patient CreatePatientRequest) ([]string, error) {
+	logger := logging.FromContext(ctx, zap.NewNop())
+	logger.Info("looking for duplicate patients", zap.String("dentalPracticeID", dentalPracticeID))
+
+	return findDuplicatePatients(m.searchIndexScanner(dentalPracticeID), m.searchKeyer(), patient)
+}
+
+// returns the search keyer for the patients of the store.
+func (m *MemoryPatientStore) searchKeyer() searchKeyer {
+	return searchKeyer{blindIndex: m.blindIndex}
+}
+
+// returns a reader for the search indexes of the dental practice.
+func (m *MemoryPatientStore) searchIndexReader(dentalPracticeID string) searchIndexReader {
+	return func(index searchIndex, sortKeyPrefix string, limit int32) ([]PatientSearchResponseItem, error) {
+		return rowItems(m.searchRows(dentalPracticeID, index, sortKeyPrefix), limit), nil
+	}
+}
+
+// returns a scanner for the search indexes of the dental practice.
+func (m *MemoryPatientStore) searchIndexScanner(dentalPracticeID string) searchIndexScanner {
+	return func(index searchIndex, sortKeyPrefix string) ([]PatientSearchResponseItem, error) {
+		rows := m.searchRows(dentalPracticeID, index, sortKeyPrefix)
+		return rowItems(rows, int32(len(rows))), nil
+	}
+}
+
+// returns the rows of a search index whose sort key starts with the prefix, in
+// index order. each search key is a separate search item, so a patient
+// matching on both names has two rows.
+func (m *MemoryPatientStore) searchRows(dentalPracticeID string, index searchIndex, sortKeyPrefix string) []memoryIndexRow {
+	m.mu.RLock()
+	var rows []memoryIndexRow
+	for _, patient := range m.patients[partitionKeyValue(dentalPracticeID)] {
+		// merged patients have no search items
+		if patient.MergedInto != "" {
+			continue
+		}
+
+		item := toSearchResponseItem(patient)
+
+		for _, key := range m.searchKeyer().searchKeys(item, patient.NationalInsuranceNumber) {
+			if key.attribute != index.attribute || !strings.HasPrefix(key.value, sortKeyPrefix) {
+				continue
+			}
+
+			rows = append(rows, memoryIndexRow{
+				sortKey: key.value,
+				_sk:     fmt.Sprintf("p#%v#%v", patient.PatientID, key.suffix),
+				item:    item,
+			})
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(rows, func(i, j int) bool {
+		return compareRows(rows[i], rows[j]) < 0
+	})
+
+	return rows
+}
+
+// returns the items of up to limit rows.
+func rowItems(rows []memoryIndexRow, limit int32) []PatientSearchResponseItem {
+	if int(limit) < len(rows) {
+		rows = rows[:limit]
+	}
+
+	items := make([]PatientSearchResponseItem, 0, len(rows))
+	for _, row := range rows {
+		items = append(items, row.item)
+	}
+
+	return items
+}
+
+func (m *MemoryPatientStore) ListPatients(ctx context.Context, dentalPracticeID string, request ListPatientsRequest) (PatientSearchResponse, error) {
+	logger := logging.FromContext(ctx, zap.NewNop())
+	logger.Info("listing patients", zap.String("dentalPracticeID", dentalPracticeID))
+
+	m.mu.RLock()
+	var rows []memoryIndexRow
+	for _, patient := range m.patients[partitionKeyValue(dentalPracticeID)] {
+		if patient.MergedInto != "" {
+			continue
+		}
+		if request.CreatedFrom != "" && patient.CreatedAt < request.CreatedFrom {
+			continue
+		}
+		if request.CreatedTo != "" && patient.CreatedAt > request.CreatedTo {
+			continue
+		}
+		if request.Active != nil && patient.Active != *request.Active {
+			continue
+		}
+		if request.AssignedDentist != "" && patient.AssignedDentist != request.AssignedDentist {
+			continue
+		}
+		if request.AssignedHygienist != "" && patient.AssignedHygienist != request.AssignedHygienist {
+			continue
+		}
+
+		rows = append(rows, memoryIndexRow{
+			sortKey: patient.CreatedAt,
+			_sk:     fmt.Sprintf("p#%v", patient.PatientID),
+			item:    toSearchResponseItem(patient),
+		})
+	}
+	m.mu.RUnlock()
+
+	// newest patients first
+	sort.Slice(rows, func(i, j int) bool {
+		return compareRows(rows[i], rows[j]) > 0
+	})
+
+	return page(dentalPracticeID, "ca", true, rows, request.Limit, request.Cursor)
+}
+
+// returns the page of rows that follows the cursor. the cursors are made of
+// the same keys as the last evaluated keys of the indexes, so they are only
+// accepted by the dental practice they were issued to.
+func page(dentalPracticeID string, sortKeyName string, descending bool, rows []memoryIndexRow, limit int32, cursor string) (PatientSearchResponse, error) {
+	partitionKey := getPartitionKey(dentalPracticeID)
+
+	exclusiveStartKey, err := decodeCursor(cursor, partitionKey, sortKeyName)
+	if err != nil {
+		return PatientSearchResponse{}, err
+	}
+
+	start := 0
+	if exclusiveStartKey != nil {
+		sortKey, ok := exclusiveStartKey[sortKeyName].(*types.AttributeValueMemberS)
+		if !ok {
+			return PatientSearchResponse{}, ErrInvalidCursor
+		}
+
+		tableSortKey, ok := exclusiveStartKey["_sk"].(*types.AttributeValueMemberS)
+		if !ok {
+			return PatientSearchResponse{}, ErrInvalidCursor
+		}
+
+		startRow := memoryIndexRow{sortKey: sortKey.Value, _sk: tableSortKey.Value}
+
+		// skip the rows up to and including the last row of the previous page
+		for start < len(rows) {
+			c := compareRows(rows[start], startRow)
+			if descending {
+				c = -c
+			}
+			if c > 0 {
+				break
+			}
+			start++
+		}
+	}
+
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	end := start + int(limit)
+	if end > len(rows) {
+		end = len(rows)
+	}
+
+	results := PatientSearchResponse{Items: make([]PatientSearchResponseItem, 0, end-start)}
+	for _, row := range rows[start:end] {
+		results.Items = append(results.Items, row.item)
+	}
+
+	if end < len(rows) {
+		last := rows[end-1]
+		results.NextCursor, err = encodeCursor(map[string]types.AttributeValue{
+			"_pk":       partitionKey,
+			"_sk":       &types.AttributeValueMemberS{Value: last._sk},
+			sortKeyName: &types.AttributeValueMemberS{Value: last.sortKey},
+		})
+		if err != nil {
+			return PatientSearchResponse{}, err
+		}
+	}
+
+	return results, nil
+}
+
+func compareRows(a, b memoryIndexRow) int {
+	if c := strings.Compare(a.sortKey, b.sortKey); c != 0 {
+		return c
+	}
+
+	return strings.Compare(a._sk, b._sk)
+}
+
+// returns the value of the partition key that holds the dental practice's
+// items, which is what the patients are grouped by.
+func partitionKeyValue(dentalPracticeID string) string {
+	return fmt.Sprintf("dp#%v", dentalPracticeID)
+}
+
+// copies the fields of a create or update request onto a patient, the same way
+// they end up on the patient item in the table.
+func toPatient(request interface{}) (Patient, error) {
+	item, err := attributevalue.MarshalMap(request)
+	if err != nil {
+		return Patient{}, err
+	}
+
+	var patient Patient
+	err = attributevalue.UnmarshalMap(item, &patient)
+
+	return patient, err
+}
+
+func toSearchResponseItem(patient Patient) PatientSearchResponseItem {
+	return PatientSearchResponseItem{
+		PatientID:   patient.PatientID,
+		FirstName:   patient.FirstName,
+		MiddleName:  patient.MiddleName,
+		LastName:    patient.LastName,
+		DateOfBirth: patient.DateOfBirth,
+		Email:       patient.Email,
+		MobilePhone: patient.MobilePhone,
+		PostCode:    patient.PostCode,
+	}
+}
@@ -0,0 +1,214 @@
+package patients
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMemoryPatientStore(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+	otherDentalPracticeID := "other_dental_practice_id"
+
+	// the memory store has to satisfy the same interface as the dynamodb store
+	var store PatientRepository = NewMemoryPatientStore()
+
+	created, err := store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: "Jane", LastName: "Doe"})
+	if err != nil {
+		t.Fatalf("could not create the patient: %v", err)
+	}
+
+	t.Run("creates patients with a generated id, a created timestamp and active", func(t *testing.T) {
+		if _, err := uuid.Parse(created.PatientID); err != nil {
+			t.Errorf("the patient id %q is not a uuid", created.PatientID)
+		}
+
+		patient, err := store.GetPatient(context.Background(), dentalPracticeID, created.PatientID)
+		if err != nil {
+			t.Fatalf("could not get the patient: %v", err)
+		}
+
+		if !patient.Active || patient.CreatedAt == "" || patient.ModifiedAt != "" {
+			t.Errorf("unexpected patient %+v", patient)
+		}
+
+		if !strings.HasSuffix(patient.CreatedAt, "Z") {
+			t.Errorf("the created timestamp %q is not in utc", patient.CreatedAt)
+		}
+	})
+
+	t.Run("returns not found for patients of another dental practice", func(t *testing.T) {
+		_, err := store.GetPatient(context.Background(), otherDentalPracticeID, created.PatientID)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("got error %v want %v", err, ErrNotFound)
+		}
+
+		_, err = store.UpdatePatient(context.Background(), otherDentalPracticeID, UpdatePatientRequest{PatientID: created.PatientID, FirstName: "Janet"}, 1)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("got error %v want %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("searches first and last names case insensitively", func(t *testing.T) {
+		for _, searchTerm := range []string{"JA", "do"} {
+			results, err := store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: searchTerm})
+			if err != nil {
+				t.Fatalf("could not search patients: %v", err)
+			}
+
+			if len(results.Items) != 1 || results.Items[0].PatientID != created.PatientID {
+				t.Errorf("searching for %q returned %+v", searchTerm, results.Items)
+			}
+		}
+	})
+
+	t.Run("updates the patient and keeps the created timestamp", func(t *testing.T) {
+		before, _ := store.GetPatient(context.Background(), dentalPracticeID, created.PatientID)
+
+		updated, err := store.UpdatePatient(context.Background(), dentalPracticeID, UpdatePatientRequest{PatientID: created.PatientID, FirstName: "Janet", LastName: "Doe"}, before.Version)
+		if err != nil {
+			t.Fatalf("could not update the patient: %v", err)
+		}
+
+		if updated.FirstName != "Janet" || updated.CreatedAt != before.CreatedAt || updated.ModifiedAt == "" || !updated.Active {
+			t.Errorf("unexpected patient %+v", updated)
+		}
+
+		results, _ := store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: "janet"})
+		if len(results.Items) != 1 {
+			t.Errorf("the updated first name could not be searched, got %+v", results.Items)
+		}
+	})
+}
+
+func TestMemoryPatientStorePagination(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	store := NewMemoryPatientStore()
+
+	for _, firstName := range []string{"James", "Jamie", "Janet"} {
+		_, err := store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: firstName, LastName: "Oliver"})
+		if err != nil {
+			t.Fatalf("could not create the patient: %v", err)
+		}
+	}
+
+	t.Run("pages through the results using the next cursor", func(t *testing.T) {
+		var firstNames []string
+		cursor := ""
+		pages := 0
+
+		for {
+			results, err := store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: "ja", Limit: 2, Cursor: cursor})
+			if err != nil {
+				t.Fatalf("could not search patients: %v", err)
+			}
+
+			pages++
+			for _, item := range results.Items {
+				firstNames = append(firstNames, item.FirstName)
+			}
+
+			if results.NextCursor == "" {
+				break
+			}
+
+			cursor = results.NextCursor
+		}
+
+		if pages != 2 {
+			t.Errorf("got %d pages want 2", pages)
+		}
+
+		want := []string{"James", "Jamie", "Janet"}
+		if strings.Join(firstNames, ",") != strings.Join(want, ",") {
+			t.Errorf("got %v want %v", firstNames, want)
+		}
+	})
+
+	t.Run("pages through the list of patients using the next cursor", func(t *testing.T) {
+		seen := map[string]bool{}
+		cursor := ""
+
+		for {
+			results, err := store.ListPatients(context.Background(), dentalPracticeID, ListPatientsRequest{Limit: 1, Cursor: cursor})
+			if err != nil {
+				t.Fatalf("could not list patients: %v", err)
+			}
+
+			for _, item := range results.Items {
+				if seen[item.PatientID] {
+					t.Errorf("patient %q was listed twice", item.PatientID)
+				}
+				seen[item.PatientID] = true
+			}
+
+			if results.NextCursor == "" {
+				break
+			}
+
+			cursor = results.NextCursor
+		}
+
+		if len(seen) != 3 {
+			t.Errorf("got %d patients want 3", len(seen))
+		}
+	})
+
+	t.Run("returns an empty list rather than null when nothing matches", func(t *testing.T) {
+		results, err := store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: "zz"})
+		if err != nil {
+			t.Fatalf("could not search patients: %v", err)
+		}
+
+		if results.Items == nil || len(results.Items) != 0 || results.NextCursor != "" {
+			t.Errorf("got %+v want an empty page", results)
+		}
+	})
+
+	t.Run("rejects a cursor issued to another dental practice", func(t *testing.T) {
+		results, _ := store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: "ja", Limit: 1})
+
+		_, err := store.SearchPatients(context.Background(), "other_dental_practice_id", SearchPatientsRequest{SearchTerm: "ja", Cursor: results.NextCursor})
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("got error %v want %v", err, ErrInvalidCursor)
+		}
+	})
+}
+
+func TestMemoryPatientStoreConcurrency(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	store := NewMemoryPatientStore()
+
+	// create and search patients at the same time, run with -race to catch
+	// unsynchronised access
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: "Jane"})
+		}()
+
+		go func() {
+			defer wg.Done()
+			store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: "ja"})
+		}()
+	}
+	wg.Wait()
+
+	results, err := store.ListPatients(context.Background(), dentalPracticeID, ListPatientsRequest{Limit: MaxSearchLimit})
+	if err != nil {
+		t.Fatalf("could not list patients: %v", err)
+	}
+
+	if len(results.Items) != 20 {
+		t.Errorf("got %d patients want 20", len(results.Items))
+	}
+}
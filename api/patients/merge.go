@@ -0,0 +1,107 @@
+package patients
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// the json names of the fields that can be copied from one patient to another
+// when they are merged, which is every field that can be updated apart from
+// the patient id.
+var mergeableFields = func() map[string]bool {
+	fields := map[string]bool{}
+
+	t := reflect.TypeOf(UpdatePatientRequest{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" && name != "patient_id" {
+			fields[name] = true
+		}
+	}
+
+	return fields
+}()
+
+// IsMergeableField reports whether the field with the json name can be copied
+// from one patient to another when they are merged.
+func IsMergeableField(name string) bool {
+	return mergeableFields[name]
+}
+
+// checks that a merge names two different patients and only copies fields that
+// can be updated.
+func validateMergePatientsRequest(request MergePatientsRequest) error {
+	if request.SourcePatientID == "" {
+		return newRepositoryError(ErrValidation, "the patient to merge from is missing")
+	}
+
+	if request.SourcePatientID == request.PatientID {
+		return newRepositoryError(ErrValidation, "patient %q can not be merged into itself", request.PatientID)
+	}
+
+	for _, field := range request.Fields {
+		if !IsMergeableField(field) {
+			return newRepositoryError(ErrValidation, "the field %q can not be merged", field)
+		}
+	}
+
+	return nil
+}
+
+// checks that neither patient of a merge has already been merged into another
+// patient.
+func checkNotMerged(patients ...Patient) error {
+	for _, patient := range patients {
+		if patient.MergedInto != "" {
+			return newRepositoryError(ErrConflict, "patient %q has already been merged into patient %q", patient.PatientID, patient.MergedInto)
+		}
+	}
+
+	return nil
+}
+
+// returns the update request that turns the surviving patient into the merged
+// patient, which is the surviving patient with the listed fields copied over
+// from the source patient.
+func mergePatients(survivor Patient, source Patient, fields []string) (UpdatePatientRequest, error) {
+	var merged UpdatePatientRequest
+
+	survivorFields, err := jsonFields(survivor)
+	if err != nil {
+		return merged, err
+	}
+
+	sourceFields, err := jsonFields(source)
+	if err != nil {
+		return merged, err
+	}
+
+	for _, field := range fields {
+		survivorFields[field] = sourceFields[field]
+	}
+
+	document, err := json.Marshal(survivorFields)
+	if err != nil {
+		return merged, err
+	}
+
+	// the document still carries the read only patient attributes, which are
+	// not part of the update request and so are left out
+	err = json.Unmarshal(document, &merged)
+
+	return merged, err
+}
+
+// returns the fields of a patient keyed by their json names.
+func jsonFields(patient Patient) (map[string]json.RawMessage, error) {
+	document, err := json.Marshal(patient)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(document, &fields)
+
+	return fields, err
+}
@@ -0,0 +1,146 @@
+package merge
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/apierror"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/conditional"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/redaction"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/validation"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
+	"go.uber.org/zap"
+)
+
+const contentTypeHeader string = "content-type"
+const jsonContentType string = "application/json"
+const ifMatchHeader string = "if-match"
+const etagHeader string = "etag"
+
+// MergePatientsHandler merges the patient in the request body into the patient
+// in the path, which is the one that survives the merge. the fields listed in
+// the request body are copied over from the merged patient. like an update, the
+// merge has to send the etag of the surviving patient it is based on.
+func MergePatientsHandler(logger *zap.Logger, repository patients.PatientRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context(), logger)
+
+		logger.Info("running the merge patients handler...")
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("allow", http.MethodPost)
+			problem.Error(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		identity, ok := auth.FromContext(r.Context())
+		if !ok {
+			logger.Error("no dental practice was found for the request")
+			problem.Error(w, r, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// the fields returned depend on the role, so a role that is not known
+		// gets nothing
+		if !redaction.Allowed(identity.Role) {
+			logger.Error("the role is not allowed to read patients", zap.String("role", string(identity.Role)))
+			problem.Error(w, r, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		// enforce a json content-type
+		mediatype, _, err := mime.ParseMediaType(r.Header.Get(contentTypeHeader))
+		if err != nil {
+			logger.Error("error when parsing the mime type", zap.Error(err))
+			problem.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if mediatype != jsonContentType {
+			logger.Error("unsupported content-type", zap.String("contentType", mediatype))
+			problem.Error(w, r, "api expects application/json content-type", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		// merges have to say which version of the surviving patient they are
+		// based on, so that a merge can not silently overwrite an update
+		ifMatch := r.Header.Get(ifMatchHeader)
+		if ifMatch == "" {
+			logger.Error("the if-match header is missing")
+			problem.Error(w, r, "merges must send the etag of the surviving patient in an if-match header", http.StatusPreconditionRequired)
+			return
+		}
+
+		patientID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/patients/"), "/merge")
+
+		// a path that does not hold a patient id can not name a patient
+		if !validation.IsPatientID(patientID) {
+			logger.Error("the patient id is invalid", zap.String("patientID", patientID))
+			problem.Error(w, r, "requested patient could not be found", http.StatusNotFound)
+			return
+		}
+
+		logger.Info("requested patient", zap.String("patientID", patientID))
+
+		logger = logger.With(zap.String("patientID", patientID))
+
+		survivor, err := repository.GetPatient(logging.NewContext(r.Context(), logger), identity.DentalPracticeID, patientID)
+		if err != nil {
+			logger.Error("failed to get patient", zap.Error(err))
+			apierror.Write(w, r, err, "failed to get the patient")
+			return
+		}
+
+		if !conditional.IfMatch(ifMatch, conditional.ETag(survivor)) {
+			logger.Error("the patient has changed since the etag was read", zap.String("ifMatch", ifMatch), zap.Int("version", survivor.Version))
+			w.Header().Set(etagHeader, conditional.ETag(survivor))
+			problem.Error(w, r, "the patient has changed since it was read, get it again and retry", http.StatusPreconditionFailed)
+			return
+		}
+
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		var mergePatientsRequest patients.MergePatientsRequest
+		if err := dec.Decode(&mergePatientsRequest); err != nil {
+			logger.Error("the request body is invalid", zap.Error(err))
+			problem.Error(w, r, "request body is invalid", http.StatusBadRequest)
+			return
+		}
+
+		// the patient id in the path always wins over anything in the body
+		mergePatientsRequest.PatientID = patientID
+
+		// validation
+		if fieldErrors := validation.ValidateMergePatientsRequest(mergePatientsRequest); len(fieldErrors) > 0 {
+			logger.Error("the request body failed validation", zap.Any("fieldErrors", fieldErrors))
+			problem.Write(w, r, problem.Validation("request body is invalid", fieldErrors))
+			return
+		}
+
+		// the store checks the version again as it writes, in case the surviving
+		// patient has been updated since it was read
+		patient, err := repository.MergePatients(logging.NewContext(r.Context(), logger), identity.DentalPracticeID, mergePatientsRequest, survivor.Version)
+		if err != nil {
+			logger.Error("failed to merge the patients", zap.Error(err), zap.String("sourcePatientID", mergePatientsRequest.SourcePatientID))
+			apierror.Write(w, r, err, "failed to merge the patients")
+			return
+		}
+
+		w.Header().Set(contentTypeHeader, jsonContentType)
+		w.Header().Set(etagHeader, conditional.ETag(patient))
+
+		// callers with different roles see different fields of the same patient
+		w.Header().Set("vary", "authorization")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(redaction.Patient(identity.Role, patient))
+		if err != nil {
+			logger.Error("error in json marshal", zap.Error(err))
+		}
+	})
+}
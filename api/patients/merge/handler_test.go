@@ -0,0 +1,508 @@
+package merge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"go.uber.org/zap"
+)
+
+// a patient store that can not merge patients, it is used to check how the
+// handler reports the errors of the store. everything else is read from the
+// repository it wraps, and tested against the memory patient store.
+type StubPatientStore struct {
+	patients.PatientRepository
+	mergePatients func(ctx context.Context, dentalPracticeID string, request patients.MergePatientsRequest, expectedVersion int) (patients.Patient, error)
+}
+
+func (s *StubPatientStore) MergePatients(ctx context.Context, dentalPracticeID string, request patients.MergePatientsRequest, expectedVersion int) (patients.Patient, error) {
+	return s.mergePatients(ctx, dentalPracticeID, request, expectedVersion)
+}
+
+func TestMergePatients(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	contentType := "content-type"
+	applicationJson := "application/json"
+	ifMatch := "if-match"
+
+	// create the logger
+	logger, _ := zap.NewProduction()
+
+	t.Run("returns 405 (method not allowed) when the request is not a post", func(t *testing.T) {
+		// create an in memory patient store holding the patients to merge
+		patientStore, survivingPatientID, _ := newPatientsToMerge(t, dentalPracticeID)
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/patients/%v/merge", survivingPatientID), nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := MergePatientsHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusMethodNotAllowed)
+	})
+
+	t.Run("returns 404 (not found) when the path does not hold a patient id", func(t *testing.T) {
+		// create an in memory patient store holding the patients to merge
+		patientStore, _, sourcePatientID := newPatientsToMerge(t, dentalPracticeID)
+
+		jsonValue, _ := json.Marshal(patients.MergePatientsRequest{SourcePatientID: sourcePatientID})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", "/patients/not_a_patient_id/merge", bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the etag of the surviving patient the merge is based on
+		req.Header.Set(ifMatch, `"1"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := MergePatientsHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusNotFound)
+	})
+
+	t.Run("returns 415 (unsupported media type) when the request does not have content-type set as application/json", func(t *testing.T) {
+		// create an in memory patient store holding the patients to merge
+		patientStore, survivingPatientID, _ := newPatientsToMerge(t, dentalPracticeID)
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/patients/%v/merge", survivingPatientID), nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, "text/csv")
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := MergePatientsHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusUnsupportedMediaType)
+	})
+
+	t.Run("returns 400 (bad request) when the request body fails validation", func(t *testing.T) {
+		// create an in memory patient store holding the patients to merge
+		patientStore, survivingPatientID, _ := newPatientsToMerge(t, dentalPracticeID)
+
+		jsonValue, _ := json.Marshal(patients.MergePatientsRequest{SourcePatientID: survivingPatientID, Fields: []string{"created_at"}})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/patients/%v/merge", survivingPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the etag of the surviving patient the merge is based on
+		req.Header.Set(ifMatch, `"1"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := MergePatientsHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusBadRequest)
+	})
+
+	t.Run("returns 404 (not found) when the source patient does not exist", func(t *testing.T) {
+		// create an in memory patient store holding the patients to merge
+		patientStore, survivingPatientID, _ := newPatientsToMerge(t, dentalPracticeID)
+
+		jsonValue, _ := json.Marshal(patients.MergePatientsRequest{SourcePatientID: "9c1d2e3f-4a5b-4c6d-8e7f-0a1b2c3d4e5f"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/patients/%v/merge", survivingPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the etag of the surviving patient the merge is based on
+		req.Header.Set(ifMatch, `"1"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := MergePatientsHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusNotFound)
+	})
+
+	t.Run("returns 428 (precondition required) when the if-match header is missing", func(t *testing.T) {
+		// create an in memory patient store holding the patients to merge
+		patientStore, survivingPatientID, sourcePatientID := newPatientsToMerge(t, dentalPracticeID)
+
+		jsonValue, _ := json.Marshal(patients.MergePatientsRequest{SourcePatientID: sourcePatientID})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/patients/%v/merge", survivingPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := MergePatientsHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusPreconditionRequired)
+	})
+
+	t.Run("returns 412 (precondition failed) when the surviving patient has changed since the etag was read", func(t *testing.T) {
+		// create an in memory patient store holding the patients to merge
+		patientStore, survivingPatientID, sourcePatientID := newPatientsToMerge(t, dentalPracticeID)
+
+		// update the surviving patient, moving it on to version 2
+		_, err := patientStore.UpdatePatient(context.Background(), dentalPracticeID, patients.UpdatePatientRequest{PatientID: survivingPatientID, FirstName: "Janet", LastName: "Doe"}, 1)
+		if err != nil {
+			t.Fatalf("could not update the patient: %v", err)
+		}
+
+		jsonValue, _ := json.Marshal(patients.MergePatientsRequest{SourcePatientID: sourcePatientID, Fields: []string{"mobile_phone"}})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/patients/%v/merge", survivingPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the etag of the surviving patient before it was updated
+		req.Header.Set(ifMatch, `"1"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := MergePatientsHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusPreconditionFailed)
+
+		if got := res.Header().Get("etag"); got != `"2"` {
+			t.Errorf("handler returned etag %q want %q", got, `"2"`)
+		}
+
+		// assert the patients were not merged
+		source, err := patientStore.GetPatient(context.Background(), dentalPracticeID, sourcePatientID)
+		if err != nil || source.MergedInto != "" {
+			t.Errorf("got %+v, %v want the source patient left as it was", source, err)
+		}
+	})
+
+	t.Run("returns 409 (conflict) when one of the patients has already been merged", func(t *testing.T) {
+		// create an in memory patient store holding the patients to merge
+		patientStore, survivingPatientID, sourcePatientID := newPatientsToMerge(t, dentalPracticeID)
+
+		// merge the patients once
+		_, err := patientStore.MergePatients(context.Background(), dentalPracticeID, patients.MergePatientsRequest{PatientID: survivingPatientID, SourcePatientID: sourcePatientID}, 1)
+		if err != nil {
+			t.Fatalf("could not merge the patients: %v", err)
+		}
+
+		jsonValue, _ := json.Marshal(patients.MergePatientsRequest{SourcePatientID: sourcePatientID})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/patients/%v/merge", survivingPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the etag of the surviving patient, which the first merge moved on
+		req.Header.Set(ifMatch, `"2"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := MergePatientsHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusConflict)
+	})
+
+	t.Run("returns 503 (service unavailable) when the database is unavailable", func(t *testing.T) {
+		// create an in memory patient store holding the patients to merge
+		memoryPatientStore, survivingPatientID, sourcePatientID := newPatientsToMerge(t, dentalPracticeID)
+
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			PatientRepository: memoryPatientStore,
+			mergePatients: func(_ context.Context, _ string, _ patients.MergePatientsRequest, _ int) (patients.Patient, error) {
+				return patients.Patient{}, fmt.Errorf("could not merge the patients: %w", patients.ErrUnavailable)
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patients.MergePatientsRequest{SourcePatientID: sourcePatientID})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/patients/%v/merge", survivingPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the etag of the surviving patient the merge is based on
+		req.Header.Set(ifMatch, `"1"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := MergePatientsHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusServiceUnavailable)
+	})
+
+	t.Run("returns 200 and the surviving patient when the patients are merged", func(t *testing.T) {
+		// create an in memory patient store holding the patients to merge
+		patientStore, survivingPatientID, sourcePatientID := newPatientsToMerge(t, dentalPracticeID)
+
+		// the patient id in the body is ignored in favour of the one in the path
+		jsonValue, _ := json.Marshal(patients.MergePatientsRequest{PatientID: sourcePatientID, SourcePatientID: sourcePatientID, Fields: []string{"mobile_phone"}})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/patients/%v/merge", survivingPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the etag of the surviving patient the merge is based on
+		req.Header.Set(ifMatch, `"1"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := MergePatientsHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		// assert the response body is what we expect
+		got := getPatientFromResponse(t, res.Body)
+		if got.PatientID != survivingPatientID || got.FirstName != "Jane" || got.MobilePhone != "07865154788" {
+			t.Errorf("got %+v want the surviving patient with the mobile phone of the source patient", got)
+		}
+
+		if got := res.Header().Get("etag"); got != `"2"` {
+			t.Errorf("handler returned etag %q want %q", got, `"2"`)
+		}
+
+		// assert the source patient was merged into the surviving patient
+		source, err := patientStore.GetPatient(context.Background(), dentalPracticeID, sourcePatientID)
+		if err != nil || source.MergedInto != survivingPatientID {
+			t.Errorf("got %+v, %v want the source patient merged into %q", source, err, survivingPatientID)
+		}
+	})
+
+	t.Run("returns 403 (forbidden) when the role is not known", func(t *testing.T) {
+		// create an in memory patient store holding the patients to merge
+		patientStore, survivingPatientID, sourcePatientID := newPatientsToMerge(t, dentalPracticeID)
+
+		jsonValue, _ := json.Marshal(patients.MergePatientsRequest{SourcePatientID: sourcePatientID})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/patients/%v/merge", survivingPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice and the role the request is made with
+		req = withRole(req, dentalPracticeID, "patient")
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the etag of the surviving patient the merge is based on
+		req.Header.Set(ifMatch, `"1"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := MergePatientsHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusForbidden)
+	})
+
+	t.Run("the surviving patient is returned with the fields the role can not see taken out", func(t *testing.T) {
+		// create an in memory patient store holding the patients to merge
+		patientStore, survivingPatientID, sourcePatientID := newPatientsToMerge(t, dentalPracticeID)
+
+		jsonValue, _ := json.Marshal(patients.MergePatientsRequest{SourcePatientID: sourcePatientID, Fields: []string{"mobile_phone"}})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/patients/%v/merge", survivingPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice and the role the request is made with
+		req = withRole(req, dentalPracticeID, auth.RoleReceptionist)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the etag of the surviving patient the merge is based on
+		req.Header.Set(ifMatch, `"1"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := MergePatientsHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		got := getPatientFromResponse(t, res.Body)
+		if got.MobilePhone != "07865154788" || got.NationalInsuranceNumber != "AB******C" || got.Ethnicity != "" {
+			t.Errorf("got %+v want the national insurance number masked and the ethnicity left out", got)
+		}
+	})
+}
+
+// returns a memory patient store holding a patient and a duplicate of them,
+// which has the mobile phone the patient is missing.
+func newPatientsToMerge(t testing.TB, dentalPracticeID string) (*patients.MemoryPatientStore, string, string) {
+	t.Helper()
+
+	patientStore := patients.NewMemoryPatientStore()
+
+	surviving, err := patientStore.CreatePatient(context.Background(), dentalPracticeID, patients.CreatePatientRequest{FirstName: "Jane", LastName: "Doe", NationalInsuranceNumber: "AB123456C", Ethnicity: "test_ethnicity"})
+	if err != nil {
+		t.Fatalf("could not create the surviving patient: %v", err)
+	}
+
+	source, err := patientStore.CreatePatient(context.Background(), dentalPracticeID, patients.CreatePatientRequest{FirstName: "Jane", LastName: "Doe", MobilePhone: "07865154788"})
+	if err != nil {
+		t.Fatalf("could not create the source patient: %v", err)
+	}
+
+	return patientStore, surviving.PatientID, source.PatientID
+}
+
+// the request is made by an admin, who sees every field.
+func withDentalPractice(req *http.Request, dentalPracticeID string) *http.Request {
+	return withRole(req, dentalPracticeID, auth.RoleAdmin)
+}
+
+func withRole(req *http.Request, dentalPracticeID string, role auth.Role) *http.Request {
+	return req.WithContext(auth.NewContext(req.Context(), auth.Identity{DentalPracticeID: dentalPracticeID, Role: role}))
+}
+
+func assertStatusCode(t testing.TB, got, want int) {
+	t.Helper()
+
+	if got != want {
+		t.Errorf("handler returned wrong status code: got %v want %v", got, want)
+	}
+}
+
+func getPatientFromResponse(t testing.TB, body io.Reader) (patient patients.Patient) {
+	t.Helper()
+
+	err := json.NewDecoder(body).Decode(&patient)
+
+	if err != nil {
+		t.Fatalf("unable to process response from server %q into a Patient, '%v'", body, err)
+	}
+
+	return
+}
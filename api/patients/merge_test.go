@@ -0,0 +1,153 @@
+package patients
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMergePatients(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	// both stores have to merge patients the same way
+	stores := map[string]PatientRepository{
+		"dynamodb": &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"},
+		"memory":   NewMemoryPatientStore(),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			create := func(t testing.TB, patient CreatePatientRequest) string {
+				t.Helper()
+
+				created, err := store.CreatePatient(context.Background(), dentalPracticeID, patient)
+				if err != nil {
+					t.Fatalf("could not create the patient: %v", err)
+				}
+
+				return created.PatientID
+			}
+
+			survivorID := create(t, CreatePatientRequest{FirstName: "Siobhan", LastName: "O'Brien", Email: "siobhan@example.com", City: "Leeds"})
+			sourceID := create(t, CreatePatientRequest{FirstName: "Shiobhan", LastName: "OBrien", Email: "s.obrien@example.com", MobilePhone: "07700900123", City: "York"})
+
+			merged, err := store.MergePatients(context.Background(), dentalPracticeID, MergePatientsRequest{
+				PatientID:       survivorID,
+				SourcePatientID: sourceID,
+				Fields:          []string{"mobile_phone", "email"},
+			}, 1)
+			if err != nil {
+				t.Fatalf("could not merge the patients: %v", err)
+			}
+
+			t.Run("copies the listed fields onto the surviving patient", func(t *testing.T) {
+				if merged.PatientID != survivorID || merged.MobilePhone != "07700900123" || merged.Email != "s.obrien@example.com" {
+					t.Errorf("got %+v want the mobile phone and email of the merged patient", merged)
+				}
+
+				if merged.FirstName != "Siobhan" || merged.City != "Leeds" || !merged.Active {
+					t.Errorf("got %+v want the other fields of the surviving patient kept", merged)
+				}
+
+				got, _ := store.GetPatient(context.Background(), dentalPracticeID, survivorID)
+				if got.MobilePhone != "07700900123" {
+					t.Errorf("got mobile phone %q want %q", got.MobilePhone, "07700900123")
+				}
+			})
+
+			t.Run("marks the merged patient as merged into the surviving patient", func(t *testing.T) {
+				got, err := store.GetPatient(context.Background(), dentalPracticeID, sourceID)
+				if err != nil {
+					t.Fatalf("could not get the merged patient: %v", err)
+				}
+
+				if got.MergedInto != survivorID || got.Active {
+					t.Errorf("got %+v want an inactive patient merged into %v", got, survivorID)
+				}
+			})
+
+			t.Run("only the surviving patient can be found", func(t *testing.T) {
+				for _, request := range []SearchPatientsRequest{
+					{SearchTerm: "07700900123", Field: SearchFieldMobilePhone},
+					{SearchTerm: "s.obrien", Field: SearchFieldEmail},
+					{SearchTerm: "shiobhan"},
+				} {
+					results, err := store.SearchPatients(context.Background(), dentalPracticeID, request)
+					if err != nil {
+						t.Fatalf("could not search patients: %v", err)
+					}
+
+					for _, item := range results.Items {
+						if item.PatientID == sourceID {
+							t.Errorf("searching %q found the merged patient", request.SearchTerm)
+						}
+					}
+				}
+
+				results, _ := store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: "07700900123", Field: SearchFieldMobilePhone})
+				if len(results.Items) != 1 || results.Items[0].PatientID != survivorID {
+					t.Errorf("got %v want the surviving patient found by the copied mobile phone", results.Items)
+				}
+			})
+
+			t.Run("the merged patient is not listed", func(t *testing.T) {
+				inactive := false
+				results, err := store.ListPatients(context.Background(), dentalPracticeID, ListPatientsRequest{Active: &inactive})
+				if err != nil {
+					t.Fatalf("could not list patients: %v", err)
+				}
+
+				if len(results.Items) != 0 {
+					t.Errorf("got %v want no inactive patients", results.Items)
+				}
+			})
+
+			t.Run("a merged patient can not be merged or updated again", func(t *testing.T) {
+				otherID := create(t, CreatePatientRequest{FirstName: "Sam"})
+
+				_, err := store.MergePatients(context.Background(), dentalPracticeID, MergePatientsRequest{PatientID: otherID, SourcePatientID: sourceID}, 1)
+				if !errors.Is(err, ErrConflict) {
+					t.Errorf("got error %v want %v", err, ErrConflict)
+				}
+
+				_, err = store.MergePatients(context.Background(), dentalPracticeID, MergePatientsRequest{PatientID: sourceID, SourcePatientID: otherID}, 2)
+				if !errors.Is(err, ErrConflict) {
+					t.Errorf("got error %v want %v", err, ErrConflict)
+				}
+
+				_, err = store.UpdatePatient(context.Background(), dentalPracticeID, UpdatePatientRequest{PatientID: sourceID, FirstName: "Shiobhan"}, 2)
+				if !errors.Is(err, ErrConflict) {
+					t.Errorf("got error %v want %v", err, ErrConflict)
+				}
+			})
+
+			t.Run("rejects a surviving patient that has changed since the merge was based on it", func(t *testing.T) {
+				_, err := store.MergePatients(context.Background(), dentalPracticeID, MergePatientsRequest{PatientID: survivorID, SourcePatientID: create(t, CreatePatientRequest{FirstName: "Sam"})}, merged.Version-1)
+				if !errors.Is(err, ErrPreconditionFailed) {
+					t.Errorf("got error %v want %v", err, ErrPreconditionFailed)
+				}
+			})
+
+			cases := []struct {
+				name    string
+				request MergePatientsRequest
+				want    error
+			}{
+				{name: "rejects a field that can not be merged", request: MergePatientsRequest{PatientID: survivorID, SourcePatientID: create(t, CreatePatientRequest{FirstName: "Sam"}), Fields: []string{"created_at"}}, want: ErrValidation},
+				{name: "rejects merging a patient into itself", request: MergePatientsRequest{PatientID: survivorID, SourcePatientID: survivorID}, want: ErrValidation},
+				{name: "rejects a missing source patient", request: MergePatientsRequest{PatientID: survivorID}, want: ErrValidation},
+				{name: "reports a source patient that does not exist", request: MergePatientsRequest{PatientID: survivorID, SourcePatientID: "unknown"}, want: ErrNotFound},
+				{name: "reports a surviving patient that does not exist", request: MergePatientsRequest{PatientID: "unknown", SourcePatientID: survivorID}, want: ErrNotFound},
+			}
+
+			for _, c := range cases {
+				t.Run(c.name, func(t *testing.T) {
+					_, err := store.MergePatients(context.Background(), dentalPracticeID, c.request, merged.Version)
+					if !errors.Is(err, c.want) {
+						t.Errorf("got error %v want %v", err, c.want)
+					}
+				})
+			}
+		})
+	}
+}
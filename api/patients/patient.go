@@ -37,6 +37,12 @@ type Patient struct {
 	Active                            bool   `dynamodbav:"a" json:"active"`
 	CreatedAt                         string `dynamodbav:"ca" json:"created_at"`
 	ModifiedAt                        string `dynamodbav:"ma" json:"modified_at"`
+	// the version goes up by one every time the patient is written, patients
+	// stored before versions were added are version 0.
+	Version int `dynamodbav:"v" json:"version"`
+	// the id of the patient this patient was merged into, merged patients are
+	// kept so that their id can be redirected to the surviving patient.
+	MergedInto string `dynamodbav:"mi,omitempty" json:"merged_into,omitempty"`
 }
 
 type CreatePatientRequest struct {
@@ -68,6 +74,35 @@ type CreatePatientRequest struct {
 	AssignedHygienist                 string `dynamodbav:"ah" json:"assigned_hygienist"`
 }
 
+type UpdatePatientRequest struct {
+	PatientID                         string `dynamodbav:"pid" json:"patient_id"`
+	Title                             string `dynamodbav:"t" json:"title"`
+	FirstName                         string `dynamodbav:"fn" json:"first_name"`
+	MiddleName                        string `dynamodbav:"mn" json:"middle_name"`
+	LastName                          string `dynamodbav:"ln" json:"last_name"`
+	NationalInsuranceNumber           string `dynamodbav:"ni" json:"national_insurance_number"`
+	Email                             string `dynamodbav:"e" json:"email"`
+	Gender                            string `dynamodbav:"g" json:"gender"`
+	DateOfBirth                       string `dynamodbav:"dob" json:"date_of_birth"`
+	AddressLine1                      string `dynamodbav:"al1" json:"address_line_1"`
+	AddressLine2                      string `dynamodbav:"al2" json:"address_line_2"`
+	City                              string `dynamodbav:"c" json:"city"`
+	County                            string `dynamodbav:"cty" json:"county"`
+	PostCode                          string `dynamodbav:"pc" json:"post_code"`
+	Country                           string `dynamodbav:"ctry" json:"country"`
+	MobilePhone                       string `dynamodbav:"mp" json:"mobile_phone"`
+	HomePhone                         string `dynamodbav:"hp" json:"home_phone"`
+	WorkPhone                         string `dynamodbav:"wp" json:"work_phone"`
+	EmergencyContactFullName          string `dynamodbav:"ecfn" json:"emergency_contact_full_name"`
+	EmergencyContactPhone             string `dynamodbav:"ecp" json:"emergency_contact_phone"`
+	EmergencyContactRelationToPatient string `dynamodbav:"ecrtp" json:"emergency_contact_relation_to_patient"`
+	Ethnicity                         string `dynamodbav:"eth" json:"ethnicity"`
+	Occupation                        string `dynamodbav:"o" json:"occupation"`
+	AcquisitionSource                 string `dynamodbav:"as" json:"acquisition_source"`
+	AssignedDentist                   string `dynamodbav:"ad" json:"assigned_dentist"`
+	AssignedHygienist                 string `dynamodbav:"ah" json:"assigned_hygienist"`
+}
+
 type CreatePatientResponse struct {
 	PatientID string `dynamodbav:"pid" json:"patient_id"`
 }
@@ -83,34 +118,118 @@ type PatientSearchResponseItem struct {
 	PostCode    string `dynamodbav:"pc" json:"post_code"`
 }
 
+type SearchPatientsRequest struct {
+	SearchTerm string
+	Field      SearchField
+	Fuzzy      bool
+	Limit      int32
+	Cursor     string
+}
+
+type ListPatientsRequest struct {
+	Active            *bool
+	AssignedDentist   string
+	AssignedHygienist string
+	CreatedFrom       string
+	CreatedTo         string
+	Limit             int32
+	Cursor            string
+}
+
+type PatientSearchResponse struct {
+	Items      []PatientSearchResponseItem `json:"items"`
+	NextCursor string                      `json:"next_cursor,omitempty"`
+}
+
+// PatientHistoryItem records a single change to a patient. history items are
+// never changed once they have been written.
+type PatientHistoryItem struct {
+	PatientID string `dynamodbav:"pid" json:"patient_id"`
+	// the version of the patient the change produced
+	Version   int           `dynamodbav:"v" json:"version"`
+	Operation string        `dynamodbav:"op" json:"operation"`
+	Actor     string        `dynamodbav:"ac" json:"actor"`
+	Timestamp string        `dynamodbav:"ts" json:"timestamp"`
+	Changes   []FieldChange `dynamodbav:"ch" json:"changes"`
+}
+
+// FieldChange is the value of a patient field before and after a change, the
+// fields are named by their json names.
+type FieldChange struct {
+	Field  string `dynamodbav:"f" json:"field"`
+	Before string `dynamodbav:"b" json:"before"`
+	After  string `dynamodbav:"a" json:"after"`
+}
+
+type PatientHistoryRequest struct {
+	PatientID string
+	Limit     int32
+	Cursor    string
+}
+
+type PatientHistoryResponse struct {
+	Items      []PatientHistoryItem `json:"items"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// returns the partition key that holds all of the items belonging to a dental
+// practice in a format that can be sent to dynamo.
+func getPartitionKey(dentalPracticeID string) types.AttributeValue {
+	partitionKey, err := attributevalue.Marshal(fmt.Sprintf("dp#%v", dentalPracticeID))
+	if err != nil {
+		panic(err)
+	}
+
+	return partitionKey
+}
+
 // returns the composite primary key of the patient in a format that can be
 // sent to dynamo.
-func (p Patient) GetKey() map[string]types.AttributeValue {
+func (p Patient) GetKey(dentalPracticeID string) map[string]types.AttributeValue {
 	patientID, err := attributevalue.Marshal(fmt.Sprintf("p#%v", p.PatientID))
 	if err != nil {
 		panic(err)
 	}
 
-	dentalPracticeID, err := attributevalue.Marshal(fmt.Sprintf("dp#%v", "c9ec3cfe-9f2c-4d68-aec6-9c6a43bf9aec"))
+	return map[string]types.AttributeValue{"_pk": getPartitionKey(dentalPracticeID), "_sk": patientID}
+}
+
+// returns the composite primary key of the patient in a format that can be
+// sent to dynamo.
+func (p CreatePatientRequest) GetKey(dentalPracticeID string) map[string]types.AttributeValue {
+	patientID, err := attributevalue.Marshal(fmt.Sprintf("p#%v", p.PatientID))
 	if err != nil {
 		panic(err)
 	}
 
-	return map[string]types.AttributeValue{"_pk": dentalPracticeID, "_sk": patientID}
+	return map[string]types.AttributeValue{"_pk": getPartitionKey(dentalPracticeID), "_sk": patientID}
 }
 
 // returns the composite primary key of the patient in a format that can be
 // sent to dynamo.
-func (p CreatePatientRequest) GetKey() map[string]types.AttributeValue {
+func (p UpdatePatientRequest) GetKey(dentalPracticeID string) map[string]types.AttributeValue {
 	patientID, err := attributevalue.Marshal(fmt.Sprintf("p#%v", p.PatientID))
 	if err != nil {
 		panic(err)
 	}
 
-	dentalPracticeID, err := attributevalue.Marshal(fmt.Sprintf("dp#%v", "c9ec3cfe-9f2c-4d68-aec6-9c6a43bf9aec"))
-	if err != nil {
-		panic(err)
+	return map[string]types.AttributeValue{"_pk": getPartitionKey(dentalPracticeID), "_sk": patientID}
+}
+
+// MergePatientsRequest merges the source patient into the patient, which is
+// the one that survives the merge. the fields listed are copied over from the
+// source patient, using their json names.
+type MergePatientsRequest struct {
+	PatientID       string   `json:"patient_id"`
+	SourcePatientID string   `json:"source_patient_id"`
+	Fields          []string `json:"fields"`
+}
+
+// checks that a patient is still at the version an update was based on.
+func checkVersion(patient Patient, expectedVersion int) error {
+	if patient.Version != expectedVersion {
+		return newRepositoryError(ErrPreconditionFailed, "patient %q is at version %v not %v", patient.PatientID, patient.Version, expectedVersion)
 	}
 
-	return map[string]types.AttributeValue{"_pk": dentalPracticeID, "_sk": patientID}
+	return nil
 }
@@ -2,8 +2,10 @@ package patients
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,20 +14,57 @@ import (
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/aws/jsii-runtime-go"
 	"github.com/google/uuid"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/encryption"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
 	"go.uber.org/zap"
 )
 
+// the subset of the dynamodb client used by the patient store.
+type dynamoDBClient interface {
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// the number of search results returned per page when no limit is requested,
+// and the most that can be requested.
+const (
+	DefaultSearchLimit int32 = 25
+	MaxSearchLimit     int32 = 100
+)
+
 type PatientStore struct {
-	client    *dynamodb.Client
+	client    dynamoDBClient
 	tableName string
+	// encrypts the sensitive fields of the patient and history items, nil
+	// when encryption is not configured
+	encrypter *encryption.FieldEncrypter
+	// hashes the national insurance numbers in the field-index, nil when no
+	// blind index key is configured
+	blindIndex *encryption.BlindIndex
+	// logs for requests that did not bring a logger of their own
+	logger *zap.Logger
 }
 
 type PatientRepository interface {
-	CreatePatient(logger *zap.Logger, ctx context.Context, patient CreatePatientRequest) (CreatePatientResponse, error)
-	GetPatient(logger *zap.Logger, ctx context.Context, patientID string) (Patient, error)
-	SearchPatients(logger *zap.Logger, ctx context.Context, searchTerm string) ([]PatientSearchResponseItem, error)
+	CreatePatient(ctx context.Context, dentalPracticeID string, patient CreatePatientRequest) (CreatePatientResponse, error)
+	GetPatient(ctx context.Context, dentalPracticeID string, patientID string) (Patient, error)
+	SearchPatients(ctx context.Context, dentalPracticeID string, request SearchPatientsRequest) (PatientSearchResponse, error)
+	ListPatients(ctx context.Context, dentalPracticeID string, request ListPatientsRequest) (PatientSearchResponse, error)
+	UpdatePatient(ctx context.Context, dentalPracticeID string, patient UpdatePatientRequest, expectedVersion int) (Patient, error)
+	FindDuplicatePatients(ctx context.Context, dentalPracticeID string, patient CreatePatientRequest) ([]string, error)
+	MergePatients(ctx context.Context, dentalPracticeID string, request MergePatientsRequest, expectedVersion int) (Patient, error)
+	GetPatientHistory(ctx context.Context, dentalPracticeID string, request PatientHistoryRequest) (PatientHistoryResponse, error)
+	// GetIdempotentResponse returns the response to the create patient request
+	// the key was first used with, ErrNotFound means the key has not been used.
+	GetIdempotentResponse(ctx context.Context, dentalPracticeID string, key IdempotencyKey) (CreatePatientResponse, error)
+	// CreatePatientIdempotently creates the patient and remembers the response
+	// for the key, so that retries get the same response.
+	CreatePatientIdempotently(ctx context.Context, dentalPracticeID string, key IdempotencyKey, patient CreatePatientRequest) (CreatePatientResponse, error)
 }
 
 func NewPatientStore(logger *zap.Logger) *PatientStore {
@@ -41,126 +80,1058 @@ func NewPatientStore(logger *zap.Logger) *PatientStore {
 		logger.Fatal("unable to load sdk config", zap.Error(err))
 	}
 
-	return &PatientStore{
+	store := &PatientStore{
 		client:    dynamodb.NewFromConfig(cfg),
 		tableName: dynamodbTableName,
+		logger:    logger,
+	}
+
+	kmsClient := kms.NewFromConfig(cfg)
+
+	// national insurance numbers are hashed with a key derived from the kms
+	// hmac key when one is set
+	blindIndexKeyID, ok := os.LookupEnv("BLIND_INDEX_KMS_KEY_ID")
+	if ok {
+		store.blindIndex, err = encryption.NewKMSBlindIndex(context.TODO(), kmsClient, blindIndexKeyID)
+		if err != nil {
+			logger.Fatal("unable to derive the blind index key", zap.Error(err))
+		}
+	} else {
+		logger.Warn("the BLIND_INDEX_KMS_KEY_ID variable was not set, national insurance numbers will not be used to find duplicate patients")
+	}
+
+	// the sensitive fields are encrypted under the kms key when one is set
+	kmsKeyID, ok := os.LookupEnv("ENCRYPTION_KMS_KEY_ID")
+	if !ok {
+		logger.Warn("the ENCRYPTION_KMS_KEY_ID variable was not set, patient fields will not be encrypted")
+		return store
+	}
+
+	encryptedFields := DefaultEncryptedFields
+	if fields, ok := os.LookupEnv("ENCRYPTED_FIELDS"); ok {
+		encryptedFields = strings.Split(fields, ",")
+	}
+
+	store.encrypter, err = NewFieldEncrypter(encryption.NewKMSKeyProvider(kmsClient, kmsKeyID), encryptedFields)
+	if err != nil {
+		logger.Fatal("the ENCRYPTED_FIELDS variable is invalid", zap.Error(err))
 	}
+
+	// the encrypted searchable fields are only indexed by their keyed hashes
+	for field := range store.searchKeyer().hashed {
+		if store.blindIndex == nil {
+			logger.Fatal("the BLIND_INDEX_KMS_KEY_ID variable has to be set when searchable fields are encrypted", zap.String("field", string(field)))
+		}
+	}
+
+	logger.Info("patient fields are encrypted", zap.Strings("encryptedFields", encryptedFields))
+
+	return store
 }
 
-func (p *PatientStore) CreatePatient(logger *zap.Logger, ctx context.Context, patient CreatePatientRequest) (CreatePatientResponse, error) {
+func (p *PatientStore) CreatePatient(ctx context.Context, dentalPracticeID string, patient CreatePatientRequest) (CreatePatientResponse, error) {
+	response, transactItems, err := p.createPatientWrites(ctx, dentalPracticeID, patient)
+	if err != nil {
+		return CreatePatientResponse{}, err
+	}
+
+	err = p.writeCreatedPatient(ctx, response.PatientID, transactItems)
+	if err != nil {
+		return CreatePatientResponse{}, err
+	}
+
+	return response, nil
+}
+
+// returns the writes that create the patient, along with its search and
+// history items.
+func (p *PatientStore) createPatientWrites(ctx context.Context, dentalPracticeID string, patient CreatePatientRequest) (CreatePatientResponse, []types.TransactWriteItem, error) {
+	logger := logging.FromContext(ctx, p.logger)
+
 	// generate the unique patient id
 	patient.PatientID = uuid.New().String()
 
 	item, err := attributevalue.MarshalMap(patient)
 	if err != nil {
 		logger.Error("could not marshal the create patient request for dynamodb", zap.Error(err))
+		return CreatePatientResponse{}, nil, fmt.Errorf("could not marshal patient %q: %w", patient.PatientID, err)
 	}
 
-	partitionKey := patient.GetKey()["_pk"]
-	sortKey := patient.GetKey()["_sk"]
+	partitionKey := patient.GetKey(dentalPracticeID)["_pk"]
+	sortKey := patient.GetKey(dentalPracticeID)["_sk"]
 	item["_pk"] = partitionKey
 	item["_sk"] = sortKey
 	item["et"] = &types.AttributeValueMemberS{Value: "patient"}
-	item["ca"] = &types.AttributeValueMemberS{Value: time.Now().Format(time.RFC3339)}
+	// created at is the sort key of the created-index, so it is always stored in
+	// utc to keep it sortable
+	item["ca"] = &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)}
 	item["a"] = &types.AttributeValueMemberBOOL{Value: true}
+	item["v"] = &types.AttributeValueMemberN{Value: "1"}
+
+	searchItems, err := p.newSearchItems(partitionKey, PatientSearchResponseItem{
+		PatientID:   patient.PatientID,
+		FirstName:   patient.FirstName,
+		MiddleName:  patient.MiddleName,
+		LastName:    patient.LastName,
+		DateOfBirth: patient.DateOfBirth,
+		Email:       patient.Email,
+		MobilePhone: patient.MobilePhone,
+		PostCode:    patient.PostCode,
+	}, patient.NationalInsuranceNumber)
+	if err != nil {
+		logger.Error("could not marshal the search items for dynamodb", zap.Error(err))
+		return CreatePatientResponse{}, nil, fmt.Errorf("could not marshal search items for patient %q: %w", patient.PatientID, err)
+	}
+
+	var created Patient
+	err = attributevalue.UnmarshalMap(item, &created)
+	if err != nil {
+		logger.Error("could not unmarshal the created patient", zap.Error(err))
+		return CreatePatientResponse{}, nil, fmt.Errorf("could not unmarshal patient %q: %w", patient.PatientID, err)
+	}
+
+	err = p.encrypter.EncryptItem(ctx, item)
+	if err != nil {
+		logger.Error("could not encrypt the patient", zap.Error(err))
+		return CreatePatientResponse{}, nil, fmt.Errorf("could not encrypt patient %q: %w", patient.PatientID, err)
+	}
+
+	// the patient and its search items are written in a single transaction so
+	// that a patient is never stored without being searchable, or vice versa
+	transactItems := []types.TransactWriteItem{
+		{
+			Put: &types.Put{
+				TableName:           aws.String(p.tableName),
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(#_pk)"),
+				ExpressionAttributeNames: map[string]string{
+					"#_pk": "_pk",
+				},
+			},
+		},
+	}
+
+	for _, searchItem := range searchItems {
+		transactItems = append(transactItems, types.TransactWriteItem{
+			Put: &types.Put{TableName: aws.String(p.tableName), Item: searchItem},
+		})
+	}
+
+	historyPut, err := p.historyPut(ctx, partitionKey, newHistoryItem(ctx, HistoryOperationCreate, Patient{}, created))
+	if err != nil {
+		logger.Error("could not marshal the history item for dynamodb", zap.Error(err))
+		return CreatePatientResponse{}, nil, err
+	}
+	transactItems = append(transactItems, historyPut)
+
+	return CreatePatientResponse{PatientID: patient.PatientID}, transactItems, nil
+}
+
+// writes the items of a new patient in a single transaction, the patient is
+// always the first of them.
+func (p *PatientStore) writeCreatedPatient(ctx context.Context, patientID string, transactItems []types.TransactWriteItem) error {
+	logger := logging.FromContext(ctx, p.logger)
 
-	_, err = p.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
-		TableName: aws.String(p.tableName), Item: item,
+	_, err := p.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: transactItems,
 	})
 	if err != nil {
+		if isConditionalCheckFailure(err, 0) {
+			logger.Error("a patient with the same id already exists", zap.String("patientID", patientID))
+			return ErrPatientAlreadyExists
+		}
+
 		logger.Error("could not add new patient to dynamodb table", zap.Error(err))
+		return classifyDynamoDBError(err, "could not create patient %q", patientID)
+	}
+
+	return nil
+}
+
+func (p *PatientStore) GetIdempotentResponse(ctx context.Context, dentalPracticeID string, key IdempotencyKey) (CreatePatientResponse, error) {
+	logger := logging.FromContext(ctx, p.logger)
+	logger.Info("getting the response for the idempotency key", zap.String("dentalPracticeID", dentalPracticeID))
+
+	response, err := p.client.GetItem(ctx, &dynamodb.GetItemInput{
+		Key:       idempotencyItemKey(dentalPracticeID, key.Key),
+		TableName: aws.String(p.tableName),
+	})
+	if err != nil {
+		logger.Error("could not get the idempotency key", zap.Error(err))
+		return CreatePatientResponse{}, classifyDynamoDBError(err, "could not get idempotency key %q", key.Key)
+	}
+
+	if response.Item == nil {
+		return CreatePatientResponse{}, newRepositoryError(ErrNotFound, "idempotency key %q has not been used", key.Key)
+	}
+
+	var record idempotencyRecord
+	err = attributevalue.UnmarshalMap(response.Item, &record)
+	if err != nil {
+		logger.Error("could not unmarshal the idempotency key", zap.Error(err))
+		return CreatePatientResponse{}, fmt.Errorf("could not unmarshal idempotency key %q: %w", key.Key, err)
 	}
 
-	sharedAttributesMap := map[string]types.AttributeValue{
-		"_pk": &types.AttributeValueMemberS{Value: "dp#c9ec3cfe-9f2c-4d68-aec6-9c6a43bf9aec"},
-		"pid": &types.AttributeValueMemberS{Value: patient.PatientID},
-		"fn":  &types.AttributeValueMemberS{Value: patient.FirstName},
-		"mn":  &types.AttributeValueMemberS{Value: patient.MiddleName},
-		"ln":  &types.AttributeValueMemberS{Value: patient.LastName},
-		"dob": &types.AttributeValueMemberS{Value: patient.DateOfBirth},
-		"e":   &types.AttributeValueMemberS{Value: patient.Email},
-		"mp":  &types.AttributeValueMemberS{Value: patient.MobilePhone},
-		"pc":  &types.AttributeValueMemberS{Value: patient.PostCode},
-		"et":  &types.AttributeValueMemberS{Value: "search-item"},
+	if record.expired(time.Now()) {
+		return CreatePatientResponse{}, newRepositoryError(ErrNotFound, "idempotency key %q has expired", key.Key)
 	}
 
-	// for first name
-	sharedAttributesMap["_sk"] = &types.AttributeValueMemberS{Value: fmt.Sprintf("p#%v#fn", patient.PatientID)}
-	sharedAttributesMap["st"] = &types.AttributeValueMemberS{Value: strings.ToLower(patient.FirstName)}
+	return record.response(key)
+}
+
+func (p *PatientStore) CreatePatientIdempotently(ctx context.Context, dentalPracticeID string, key IdempotencyKey, patient CreatePatientRequest) (CreatePatientResponse, error) {
+	logger := logging.FromContext(ctx, p.logger)
+
+	response, transactItems, err := p.createPatientWrites(ctx, dentalPracticeID, patient)
+	if err != nil {
+		return CreatePatientResponse{}, err
+	}
 
-	_, err = p.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
-		TableName: aws.String(p.tableName), Item: sharedAttributesMap,
+	now := time.Now()
+	item, err := attributevalue.MarshalMap(newIdempotencyRecord(key, response, now))
+	if err != nil {
+		logger.Error("could not marshal the idempotency key for dynamodb", zap.Error(err))
+		return CreatePatientResponse{}, fmt.Errorf("could not marshal idempotency key %q: %w", key.Key, err)
+	}
+
+	for name, value := range idempotencyItemKey(dentalPracticeID, key.Key) {
+		item[name] = value
+	}
+
+	// the key is stored with the patient so that a patient is never created
+	// without it, a key that expired but has not been deleted yet is replaced
+	transactItems = append(transactItems, types.TransactWriteItem{
+		Put: &types.Put{
+			TableName:           aws.String(p.tableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(#_pk) or #ttl <= :now"),
+			ExpressionAttributeNames: map[string]string{
+				"#_pk": "_pk",
+				"#ttl": "ttl",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			},
+		},
 	})
+
+	err = p.writeCreatedPatient(ctx, response.PatientID, transactItems)
+
+	// another request with the same key got there first, which is most likely
+	// a retry that overlapped with this one
+	if err != nil && isConditionalCheckFailure(err, len(transactItems)-1) {
+		logger.Info("the idempotency key was used by another request", zap.String("idempotencyKey", key.Key))
+		return p.GetIdempotentResponse(ctx, dentalPracticeID, key)
+	}
+
 	if err != nil {
-		logger.Error("could not add first name for the name gsi", zap.Error(err))
+		return CreatePatientResponse{}, err
 	}
 
-	// clear the first name attribute items
-	delete(sharedAttributesMap, "_sk")
-	delete(sharedAttributesMap, "st")
+	return response, nil
+}
 
-	// for first name
-	sharedAttributesMap["_sk"] = &types.AttributeValueMemberS{Value: fmt.Sprintf("p#%v#ln", patient.PatientID)}
-	sharedAttributesMap["st"] = &types.AttributeValueMemberS{Value: strings.ToLower(patient.LastName)}
+// the key of the item that remembers an idempotency key.
+func idempotencyItemKey(dentalPracticeID string, key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"_pk": getPartitionKey(dentalPracticeID),
+		"_sk": &types.AttributeValueMemberS{Value: idempotencySortKeyPrefix + key},
+	}
+}
+
+// updates the patient as long as it is still at the expected version, which is
+// the version the update was based on.
+func (p *PatientStore) UpdatePatient(ctx context.Context, dentalPracticeID string, patient UpdatePatientRequest, expectedVersion int) (Patient, error) {
+	logger := logging.FromContext(ctx, p.logger)
+	logger.Info("updating patient", zap.String("dentalPracticeID", dentalPracticeID))
+
+	// the created at and active attributes are not part of the update request,
+	// so they are carried over from the stored patient
+	existingPatient, err := p.GetPatient(ctx, dentalPracticeID, patient.PatientID)
+	if err != nil {
+		return Patient{}, err
+	}
 
-	_, err = p.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
-		TableName: aws.String(p.tableName), Item: sharedAttributesMap,
+	if err := checkNotMerged(existingPatient); err != nil {
+		return Patient{}, err
+	}
+
+	if err := checkVersion(existingPatient, expectedVersion); err != nil {
+		return Patient{}, err
+	}
+
+	updatedPatient, transactItems, err := p.updatePatientWrites(ctx, dentalPracticeID, patient, existingPatient)
+	if err != nil {
+		logger.Error("could not marshal the updated patient for dynamodb", zap.Error(err))
+		return Patient{}, err
+	}
+
+	historyPut, err := p.historyPut(ctx, getPartitionKey(dentalPracticeID), newHistoryItem(ctx, HistoryOperationUpdate, existingPatient, updatedPatient))
+	if err != nil {
+		logger.Error("could not marshal the history item for dynamodb", zap.Error(err))
+		return Patient{}, err
+	}
+	transactItems = append(transactItems, historyPut)
+
+	_, err = p.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: transactItems,
 	})
 	if err != nil {
-		logger.Error("could not add last name for the name gsi", zap.Error(err))
+		logger.Error("could not update the patient in dynamodb", zap.Error(err))
+
+		// the patient was read at the expected version, so it has been written
+		// by another request since
+		if isConditionalCheckFailure(err, 0) {
+			return Patient{}, newRepositoryError(ErrPreconditionFailed, "patient %q was changed by another request", patient.PatientID)
+		}
+
+		return Patient{}, classifyDynamoDBError(err, "could not update patient %q", patient.PatientID)
+	}
+
+	return updatedPatient, nil
+}
+
+// returns the patient as it is after an update, along with the writes that
+// store it and rewrite its search items. the patient write comes first and
+// only succeeds if the patient has not been merged or written since the
+// existing patient was read.
+func (p *PatientStore) updatePatientWrites(ctx context.Context, dentalPracticeID string, patient UpdatePatientRequest, existingPatient Patient) (Patient, []types.TransactWriteItem, error) {
+	item, err := attributevalue.MarshalMap(patient)
+	if err != nil {
+		return Patient{}, nil, fmt.Errorf("could not marshal patient %q: %w", patient.PatientID, err)
+	}
+
+	partitionKey := patient.GetKey(dentalPracticeID)["_pk"]
+	sortKey := patient.GetKey(dentalPracticeID)["_sk"]
+	item["_pk"] = partitionKey
+	item["_sk"] = sortKey
+	item["et"] = &types.AttributeValueMemberS{Value: "patient"}
+	item["ca"] = &types.AttributeValueMemberS{Value: existingPatient.CreatedAt}
+	item["ma"] = &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)}
+	item["a"] = &types.AttributeValueMemberBOOL{Value: existingPatient.Active}
+	item["v"] = &types.AttributeValueMemberN{Value: strconv.Itoa(existingPatient.Version + 1)}
+
+	// the search items hold copies of the name, date of birth and contact
+	// details, so they have to be rewritten whenever the patient changes
+	searchItems, err := p.newSearchItems(partitionKey, PatientSearchResponseItem{
+		PatientID:   patient.PatientID,
+		FirstName:   patient.FirstName,
+		MiddleName:  patient.MiddleName,
+		LastName:    patient.LastName,
+		DateOfBirth: patient.DateOfBirth,
+		Email:       patient.Email,
+		MobilePhone: patient.MobilePhone,
+		PostCode:    patient.PostCode,
+	}, patient.NationalInsuranceNumber)
+	if err != nil {
+		return Patient{}, nil, fmt.Errorf("could not marshal search items for patient %q: %w", patient.PatientID, err)
+	}
+
+	var updatedPatient Patient
+	err = attributevalue.UnmarshalMap(item, &updatedPatient)
+	if err != nil {
+		return Patient{}, nil, fmt.Errorf("could not unmarshal patient %q: %w", patient.PatientID, err)
+	}
+
+	err = p.encrypter.EncryptItem(ctx, item)
+	if err != nil {
+		return Patient{}, nil, fmt.Errorf("could not encrypt patient %q: %w", patient.PatientID, err)
+	}
+
+	transactItems := []types.TransactWriteItem{
+		{Put: p.conditionalPut(item, existingPatient.Version)},
+	}
+
+	written := map[string]bool{}
+	for _, searchItem := range searchItems {
+		written[searchItem["_sk"].(*types.AttributeValueMemberS).Value] = true
+
+		transactItems = append(transactItems, types.TransactWriteItem{
+			Put: &types.Put{TableName: aws.String(p.tableName), Item: searchItem},
+		})
+	}
+
+	// fields that were cleared no longer have a search item, so any that was
+	// written before is removed
+	transactItems = append(transactItems, p.deleteSearchItems(partitionKey, patient.PatientID, written)...)
+
+	return updatedPatient, transactItems, nil
+}
+
+// returns a put of a patient item that only succeeds if the stored patient has
+// not been merged and is still at the version it was read at.
+func (p *PatientStore) conditionalPut(item map[string]types.AttributeValue, version int) *types.Put {
+	put := &types.Put{
+		TableName:           aws.String(p.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_exists(#_pk) and attribute_not_exists(#mi) and #v = :v"),
+		ExpressionAttributeNames: map[string]string{
+			"#_pk": "_pk",
+			"#mi":  "mi",
+			"#v":   "v",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":v": &types.AttributeValueMemberN{Value: strconv.Itoa(version)},
+		},
+	}
+
+	// patients stored before versions were added have no version attribute
+	if version == 0 {
+		put.ConditionExpression = aws.String("attribute_exists(#_pk) and attribute_not_exists(#mi) and attribute_not_exists(#v)")
+		put.ExpressionAttributeValues = nil
+	}
+
+	return put
+}
+
+// returns the deletes for the search items of a patient, apart from the ones
+// whose sort keys are being kept.
+func (p *PatientStore) deleteSearchItems(partitionKey types.AttributeValue, patientID string, keep map[string]bool) []types.TransactWriteItem {
+	var transactItems []types.TransactWriteItem
+	for _, suffix := range searchKeySuffixes {
+		searchItemSortKey := fmt.Sprintf("p#%v#%v", patientID, suffix)
+		if keep[searchItemSortKey] {
+			continue
+		}
+
+		transactItems = append(transactItems, types.TransactWriteItem{
+			Delete: &types.Delete{
+				TableName: aws.String(p.tableName),
+				Key: map[string]types.AttributeValue{
+					"_pk": partitionKey,
+					"_sk": &types.AttributeValueMemberS{Value: searchItemSortKey},
+				},
+			},
+		})
+	}
+
+	return transactItems
+}
+
+// merges the source patient into the surviving patient in a single
+// transaction. the survivor is updated with the fields copied from the source,
+// and the source is marked as merged into the survivor and made inactive. the
+// search items of the source are removed so that only the survivor is found.
+// the survivor has to still be at the expected version, which is the version
+// the merge was based on.
+func (p *PatientStore) MergePatients(ctx context.Context, dentalPracticeID string, request MergePatientsRequest, expectedVersion int) (Patient, error) {
+	logger := logging.FromContext(ctx, p.logger)
+	logger.Info("merging patients", zap.String("dentalPracticeID", dentalPracticeID), zap.String("sourcePatientID", request.SourcePatientID))
+
+	if err := validateMergePatientsRequest(request); err != nil {
+		return Patient{}, err
+	}
+
+	survivor, err := p.GetPatient(ctx, dentalPracticeID, request.PatientID)
+	if err != nil {
+		return Patient{}, err
+	}
+
+	source, err := p.GetPatient(ctx, dentalPracticeID, request.SourcePatientID)
+	if err != nil {
+		return Patient{}, err
+	}
+
+	if err := checkNotMerged(survivor, source); err != nil {
+		return Patient{}, err
+	}
+
+	if err := checkVersion(survivor, expectedVersion); err != nil {
+		return Patient{}, err
+	}
+
+	merged, err := mergePatients(survivor, source, request.Fields)
+	if err != nil {
+		logger.Error("could not copy the fields of the merged patient", zap.Error(err))
+		return Patient{}, fmt.Errorf("could not merge patient %q into patient %q: %w", source.PatientID, survivor.PatientID, err)
+	}
+
+	mergedPatient, survivorWrites, err := p.updatePatientWrites(ctx, dentalPracticeID, merged, survivor)
+	if err != nil {
+		logger.Error("could not marshal the merged patient for dynamodb", zap.Error(err))
+		return Patient{}, err
+	}
+
+	sourceBefore := source
+	sourceVersion := source.Version
+	source.MergedInto = survivor.PatientID
+	source.Active = false
+	source.ModifiedAt = time.Now().UTC().Format(time.RFC3339)
+	source.Version++
+
+	sourceItem, err := attributevalue.MarshalMap(source)
+	if err != nil {
+		logger.Error("could not marshal the merged patient for dynamodb", zap.Error(err))
+		return Patient{}, fmt.Errorf("could not marshal patient %q: %w", source.PatientID, err)
+	}
+
+	partitionKey := getPartitionKey(dentalPracticeID)
+	sourceItem["_pk"] = partitionKey
+	sourceItem["_sk"] = source.GetKey(dentalPracticeID)["_sk"]
+	sourceItem["et"] = &types.AttributeValueMemberS{Value: "patient"}
+
+	err = p.encrypter.EncryptItem(ctx, sourceItem)
+	if err != nil {
+		logger.Error("could not encrypt the merged patient", zap.Error(err))
+		return Patient{}, fmt.Errorf("could not encrypt patient %q: %w", source.PatientID, err)
+	}
+
+	// the survivor is written first and the source second, so the conditions
+	// that fail can be told apart
+	const sourceIndex = 1
+	transactItems := []types.TransactWriteItem{
+		survivorWrites[0],
+		{Put: p.conditionalPut(sourceItem, sourceVersion)},
+	}
+	transactItems = append(transactItems, survivorWrites[1:]...)
+	transactItems = append(transactItems, p.deleteSearchItems(partitionKey, source.PatientID, nil)...)
+
+	for _, historyItem := range []PatientHistoryItem{
+		newHistoryItem(ctx, HistoryOperationMerge, survivor, mergedPatient),
+		newHistoryItem(ctx, HistoryOperationMerged, sourceBefore, source),
+	} {
+		historyPut, err := p.historyPut(ctx, partitionKey, historyItem)
+		if err != nil {
+			logger.Error("could not marshal the history item for dynamodb", zap.Error(err))
+			return Patient{}, err
+		}
+		transactItems = append(transactItems, historyPut)
+	}
+
+	_, err = p.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: transactItems,
+	})
+	if err != nil {
+		logger.Error("could not merge the patients in dynamodb", zap.Error(err))
+
+		// the survivor was read at the expected version, so it has been written
+		// by another request since
+		if isConditionalCheckFailure(err, 0) {
+			return Patient{}, newRepositoryError(ErrPreconditionFailed, "patient %q was changed by another request", survivor.PatientID)
+		}
+
+		// the source was removed or merged since it was read
+		if isConditionalCheckFailure(err, sourceIndex) {
+			return Patient{}, newRepositoryError(ErrConflict, "patient %q changed while it was being merged", source.PatientID)
+		}
+
+		return Patient{}, classifyDynamoDBError(err, "could not merge patient %q into patient %q", source.PatientID, survivor.PatientID)
 	}
 
-	return CreatePatientResponse{PatientID: patient.PatientID}, err
+	return mergedPatient, nil
 }
 
-func (p *PatientStore) GetPatient(logger *zap.Logger, ctx context.Context, patientID string) (Patient, error) {
-	logger.Info("getting patient")
+// returns the write of a history item. history items are never overwritten,
+// which the version in their sort key guarantees for patients that are written
+// with a version condition.
+func (p *PatientStore) historyPut(ctx context.Context, partitionKey types.AttributeValue, historyItem PatientHistoryItem) (types.TransactWriteItem, error) {
+	item, err := attributevalue.MarshalMap(historyItem)
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("could not marshal the history of patient %q: %w", historyItem.PatientID, err)
+	}
+
+	err = encryptHistoryItem(ctx, p.encrypter, item)
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("could not encrypt the history of patient %q: %w", historyItem.PatientID, err)
+	}
+
+	item["_pk"] = partitionKey
+	item["_sk"] = &types.AttributeValueMemberS{Value: historySortKey(historyItem.PatientID, historyItem.Version)}
+	item["et"] = &types.AttributeValueMemberS{Value: "history"}
+
+	return types.TransactWriteItem{
+		Put: &types.Put{
+			TableName:           aws.String(p.tableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(#_sk)"),
+			ExpressionAttributeNames: map[string]string{
+				"#_sk": "_sk",
+			},
+		},
+	}, nil
+}
+
+// returns the history of a patient, newest change first. the history items
+// share the patient's partition and are read straight from the table.
+func (p *PatientStore) GetPatientHistory(ctx context.Context, dentalPracticeID string, request PatientHistoryRequest) (PatientHistoryResponse, error) {
+	logger := logging.FromContext(ctx, p.logger)
+	logger.Info("getting patient history", zap.String("dentalPracticeID", dentalPracticeID))
+
+	// merged patients keep their history, but a patient that never existed
+	// has none to return
+	if _, err := p.GetPatient(ctx, dentalPracticeID, request.PatientID); err != nil {
+		return PatientHistoryResponse{}, err
+	}
+
+	partitionKey := getPartitionKey(dentalPracticeID)
+
+	exclusiveStartKey, err := decodeCursor(request.Cursor, partitionKey, "")
+	if err != nil {
+		logger.Error("could not decode the history cursor", zap.Error(err))
+		return PatientHistoryResponse{}, err
+	}
+
+	limit := request.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	response, err := p.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(p.tableName),
+		KeyConditionExpression: aws.String("#_pk = :dpid and begins_with(#_sk, :_sk)"),
+		ExpressionAttributeNames: map[string]string{
+			"#_pk": "_pk",
+			"#_sk": "_sk",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":dpid": partitionKey,
+			":_sk":  &types.AttributeValueMemberS{Value: historySortKeyPrefix(request.PatientID)},
+		},
+		ScanIndexForward:  aws.Bool(false),
+		Limit:             aws.Int32(limit),
+		ExclusiveStartKey: exclusiveStartKey,
+	})
+	if err != nil {
+		logger.Error("could not get the patient history", zap.Error(err))
+		return PatientHistoryResponse{}, classifyDynamoDBError(err, "could not get the history of patient %q", request.PatientID)
+	}
+
+	for _, item := range response.Items {
+		err = decryptHistoryItem(ctx, p.encrypter, item)
+		if err != nil {
+			logger.Error("could not decrypt the history item", zap.Error(err))
+			return PatientHistoryResponse{}, fmt.Errorf("could not decrypt the history of patient %q: %w", request.PatientID, err)
+		}
+	}
+
+	items := make([]PatientHistoryItem, 0, len(response.Items))
+	err = attributevalue.UnmarshalListOfMaps(response.Items, &items)
+	if err != nil {
+		logger.Error("could not unmarshal response", zap.Error(err))
+		return PatientHistoryResponse{}, err
+	}
+
+	nextCursor, err := encodeCursor(response.LastEvaluatedKey)
+	if err != nil {
+		logger.Error("could not encode the history cursor", zap.Error(err))
+		return PatientHistoryResponse{}, err
+	}
+
+	return PatientHistoryResponse{Items: items, NextCursor: nextCursor}, nil
+}
+
+// returns the search items for a patient, which are what the name-index and
+// the field-index are built from. there is one search item per name and per
+// searchable field that has a value. the sort keys are fixed per patient, so
+// writing them overwrites any existing search items for the patient. the
+// search items never hold the encrypted fields, searches read them from the
+// patient item.
+func (p *PatientStore) newSearchItems(partitionKey types.AttributeValue, searchItem PatientSearchResponseItem, nationalInsuranceNumber string) ([]map[string]types.AttributeValue, error) {
+	var searchItems []map[string]types.AttributeValue
+
+	for _, key := range p.searchKeyer().searchKeys(searchItem, nationalInsuranceNumber) {
+		item, err := attributevalue.MarshalMap(searchItem)
+		if err != nil {
+			return nil, err
+		}
+
+		for attribute := range item {
+			if p.encrypter.Encrypts(attribute) {
+				delete(item, attribute)
+			}
+		}
+
+		item["_pk"] = partitionKey
+		item["_sk"] = &types.AttributeValueMemberS{Value: fmt.Sprintf("p#%v#%v", searchItem.PatientID, key.suffix)}
+		item["et"] = &types.AttributeValueMemberS{Value: "search-item"}
+		item[key.attribute] = &types.AttributeValueMemberS{Value: key.value}
+
+		searchItems = append(searchItems, item)
+	}
+
+	return searchItems, nil
+}
+
+// reports whether err is a cancelled transaction where the condition of the
+// item at index failed.
+func isConditionalCheckFailure(err error, index int) bool {
+	var transactionCanceled *types.TransactionCanceledException
+	if !errors.As(err, &transactionCanceled) {
+		return false
+	}
+
+	reasons := transactionCanceled.CancellationReasons
+	if index >= len(reasons) || reasons[index].Code == nil {
+		return false
+	}
+
+	return *reasons[index].Code == "ConditionalCheckFailed"
+}
+
+func (p *PatientStore) GetPatient(ctx context.Context, dentalPracticeID string, patientID string) (Patient, error) {
+	logger := logging.FromContext(ctx, p.logger)
+	logger.Info("getting patient", zap.String("dentalPracticeID", dentalPracticeID))
 	patient := Patient{PatientID: patientID}
-	response, err := p.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
-		Key: patient.GetKey(), TableName: aws.String(p.tableName),
+	response, err := p.client.GetItem(ctx, &dynamodb.GetItemInput{
+		Key: patient.GetKey(dentalPracticeID), TableName: aws.String(p.tableName),
 	})
 	if err != nil {
 		logger.Error("could not get find matching patient", zap.Error(err))
-	} else {
-		if len(response.Item) == 0 {
-			return Patient{}, fmt.Errorf("could not find patient with id %q in the database", patientID)
+		return Patient{}, classifyDynamoDBError(err, "could not get patient %q", patientID)
+	}
+
+	if len(response.Item) == 0 {
+		return Patient{}, newRepositoryError(ErrNotFound, "could not find patient with id %q in the database", patientID)
+	}
+
+	err = p.encrypter.DecryptItem(ctx, response.Item)
+	if err != nil {
+		logger.Error("could not decrypt the patient", zap.Error(err))
+		return Patient{}, fmt.Errorf("could not decrypt patient %q: %w", patientID, err)
+	}
+
+	err = attributevalue.UnmarshalMap(response.Item, &patient)
+	if err != nil {
+		logger.Error("could not unmarshal response", zap.Error(err))
+		return Patient{}, fmt.Errorf("could not unmarshal patient %q: %w", patientID, err)
+	}
+
+	return patient, nil
+}
+
+func (p *PatientStore) SearchPatients(ctx context.Context, dentalPracticeID string, request SearchPatientsRequest) (PatientSearchResponse, error) {
+	logger := logging.FromContext(ctx, p.logger)
+	logger.Info("searching patients", zap.String("dentalPracticeID", dentalPracticeID), zap.String("field", string(request.Field)))
+
+	partitionKey := getPartitionKey(dentalPracticeID)
+
+	if isRankedSearch(request) {
+		response, err := rankedSearch(p.searchIndexReader(ctx, partitionKey), p.searchIndexScanner(ctx, partitionKey), request)
+		if err != nil {
+			return PatientSearchResponse{}, err
+		}
+
+		response.Items, err = p.withEncryptedFields(ctx, dentalPracticeID, response.Items)
+		if err != nil {
+			return PatientSearchResponse{}, err
+		}
+
+		return response, nil
+	}
+
+	index, sortKeyPrefix, err := p.searchKeyer().searchQuery(request.Field, request.SearchTerm)
+	if err != nil {
+		return PatientSearchResponse{}, newRepositoryError(ErrValidation, "%w", err)
+	}
+
+	exclusiveStartKey, err := decodeCursor(request.Cursor, partitionKey, index.attribute)
+	if err != nil {
+		logger.Error("could not decode the search cursor", zap.Error(err))
+		return PatientSearchResponse{}, err
+	}
+
+	limit := request.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	items, lastEvaluatedKey, err := p.querySearchIndex(ctx, partitionKey, index, sortKeyPrefix, limit, exclusiveStartKey)
+	if err != nil {
+		return PatientSearchResponse{}, err
+	}
+
+	// a patient is only returned once even if both of their names match, so
+	// a page can be shorter than the limit
+	patients := make([]PatientSearchResponseItem, 0, len(items))
+	for _, item := range items {
+		if index == searchIndexes[SearchFieldName] && isDuplicateNameMatch(item, sortKeyPrefix) {
+			continue
 		}
 
-		err = attributevalue.UnmarshalMap(response.Item, &patient)
+		patients = append(patients, item.PatientSearchResponseItem)
+	}
+
+	patients, err = p.withEncryptedFields(ctx, dentalPracticeID, patients)
+	if err != nil {
+		return PatientSearchResponse{}, err
+	}
+
+	nextCursor, err := encodeCursor(lastEvaluatedKey)
+	if err != nil {
+		logger.Error("could not encode the search cursor", zap.Error(err))
+		return PatientSearchResponse{}, err
+	}
+
+	return PatientSearchResponse{Items: patients, NextCursor: nextCursor}, nil
+}
+
+// fills in the encrypted fields of the search results, which the search items
+// do not hold, by reading and decrypting the patient items. a patient whose
+// item has gone since the search keeps the fields of its search item.
+func (p *PatientStore) withEncryptedFields(ctx context.Context, dentalPracticeID string, patients []PatientSearchResponseItem) ([]PatientSearchResponseItem, error) {
+	if p.encrypter == nil || len(patients) == 0 {
+		return patients, nil
+	}
+
+	logger := logging.FromContext(ctx, p.logger)
+
+	items := map[string]map[string]types.AttributeValue{}
+	for start := 0; start < len(patients); start += int(MaxSearchLimit) {
+		end := start + int(MaxSearchLimit)
+		if end > len(patients) {
+			end = len(patients)
+		}
+
+		keys := make([]map[string]types.AttributeValue, 0, end-start)
+		for _, patient := range patients[start:end] {
+			keys = append(keys, Patient{PatientID: patient.PatientID}.GetKey(dentalPracticeID))
+		}
+
+		requestItems := map[string]types.KeysAndAttributes{p.tableName: {Keys: keys}}
+
+		// dynamodb can leave some of the keys unread, those are read again
+		for len(requestItems) > 0 {
+			response, err := p.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: requestItems})
+			if err != nil {
+				logger.Error("could not get the searched patients", zap.Error(err))
+				return nil, classifyDynamoDBError(err, "could not get the searched patients")
+			}
+
+			for _, item := range response.Responses[p.tableName] {
+				if patientID, ok := item["pid"].(*types.AttributeValueMemberS); ok {
+					items[patientID.Value] = item
+				}
+			}
+
+			requestItems = response.UnprocessedKeys
+		}
+	}
+
+	hydrated := make([]PatientSearchResponseItem, 0, len(patients))
+	for _, patient := range patients {
+		item, ok := items[patient.PatientID]
+		if !ok {
+			hydrated = append(hydrated, patient)
+			continue
+		}
+
+		err := p.encrypter.DecryptItem(ctx, item)
+		if err != nil {
+			logger.Error("could not decrypt the patient", zap.Error(err))
+			return nil, fmt.Errorf("could not decrypt patient %q: %w", patient.PatientID, err)
+		}
+
+		err = attributevalue.UnmarshalMap(item, &patient)
 		if err != nil {
 			logger.Error("could not unmarshal response", zap.Error(err))
+			return nil, fmt.Errorf("could not unmarshal patient %q: %w", patient.PatientID, err)
 		}
+
+		hydrated = append(hydrated, patient)
 	}
 
-	return patient, err
+	return hydrated, nil
 }
 
-func (p *PatientStore) SearchPatients(logger *zap.Logger, ctx context.Context, searchTerm string) ([]PatientSearchResponseItem, error) {
-	dentalPracticeID := "c9ec3cfe-9f2c-4d68-aec6-9c6a43bf9aec"
-	lowerCaseSearchTerm := strings.ToLower(searchTerm)
-	logger.Info("searching patients", zap.String("dentalPracticeID", dentalPracticeID))
-	var patients []PatientSearchResponseItem
-	response, err := p.client.Query(context.TODO(), &dynamodb.QueryInput{
+// returns a reader for the search indexes of the partition.
+func (p *PatientStore) searchIndexReader(ctx context.Context, partitionKey types.AttributeValue) searchIndexReader {
+	return func(index searchIndex, sortKeyPrefix string, limit int32) ([]PatientSearchResponseItem, error) {
+		items, _, err := p.querySearchIndex(ctx, partitionKey, index, sortKeyPrefix, limit, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		patients := make([]PatientSearchResponseItem, 0, len(items))
+		for _, item := range items {
+			patients = append(patients, item.PatientSearchResponseItem)
+		}
+
+		return patients, nil
+	}
+}
+
+// returns a scanner for the search indexes of the partition, which reads a
+// page at a time until there are no more search items with the prefix.
+func (p *PatientStore) searchIndexScanner(ctx context.Context, partitionKey types.AttributeValue) searchIndexScanner {
+	return func(index searchIndex, sortKeyPrefix string) ([]PatientSearchResponseItem, error) {
+		var patients []PatientSearchResponseItem
+		var exclusiveStartKey map[string]types.AttributeValue
+
+		for {
+			items, lastEvaluatedKey, err := p.querySearchIndex(ctx, partitionKey, index, sortKeyPrefix, MaxSearchLimit, exclusiveStartKey)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, item := range items {
+				patients = append(patients, item.PatientSearchResponseItem)
+			}
+
+			if len(lastEvaluatedKey) == 0 {
+				return patients, nil
+			}
+
+			exclusiveStartKey = lastEvaluatedKey
+		}
+	}
+}
+
+// returns the search items of a search index whose sort key starts with the
+// prefix, along with the key to carry on reading from.
+func (p *PatientStore) querySearchIndex(ctx context.Context, partitionKey types.AttributeValue, index searchIndex, sortKeyPrefix string, limit int32, exclusiveStartKey map[string]types.AttributeValue) ([]indexedSearchItem, map[string]types.AttributeValue, error) {
+	logger := logging.FromContext(ctx, p.logger)
+
+	response, err := p.client.Query(ctx, &dynamodb.QueryInput{
 		TableName:              aws.String(p.tableName),
-		IndexName:              jsii.String("name-index"),
-		KeyConditionExpression: jsii.String("#_pk = :dpid and begins_with(#st, :st)"),
+		IndexName:              jsii.String(index.name),
+		KeyConditionExpression: jsii.String(fmt.Sprintf("#_pk = :dpid and begins_with(#%[1]v, :%[1]v)", index.attribute)),
 		ExpressionAttributeNames: map[string]string{
-			"#_pk": "_pk",
-			"#st":  "st",
+			"#_pk":                "_pk",
+			"#" + index.attribute: index.attribute,
 		},
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":dpid": &types.AttributeValueMemberS{Value: fmt.Sprintf("dp#%v", dentalPracticeID)},
-			":st":   &types.AttributeValueMemberS{Value: lowerCaseSearchTerm},
+			":dpid":               partitionKey,
+			":" + index.attribute: &types.AttributeValueMemberS{Value: sortKeyPrefix},
 		},
+		Limit:             aws.Int32(limit),
+		ExclusiveStartKey: exclusiveStartKey,
 	})
 	if err != nil {
 		logger.Error("could not find matching patients", zap.Error(err))
-	} else {
-		err = attributevalue.UnmarshalListOfMaps(response.Items, &patients)
+		return nil, nil, classifyDynamoDBError(err, "could not search patients")
+	}
+
+	items := make([]indexedSearchItem, 0, len(response.Items))
+	err = attributevalue.UnmarshalListOfMaps(response.Items, &items)
+	if err != nil {
+		logger.Error("could not unmarshal response", zap.Error(err))
+		return nil, nil, err
+	}
+
+	return items, response.LastEvaluatedKey, nil
+}
+
+func (p *PatientStore) FindDuplicatePatients(ctx context.Context, dentalPracticeID string, patient CreatePatientRequest) ([]string, error) {
+	logger := logging.FromContext(ctx, p.logger)
+	logger.Info("looking for duplicate patients", zap.String("dentalPracticeID", dentalPracticeID))
+
+	return findDuplicatePatients(p.searchIndexScanner(ctx, getPartitionKey(dentalPracticeID)), p.searchKeyer(), patient)
+}
+
+// returns the search keyer for the patients of the store.
+func (p *PatientStore) searchKeyer() searchKeyer {
+	hashed := map[SearchField]bool{}
+	for _, field := range SearchFields {
+		if field != SearchFieldName && p.encrypter.Encrypts(patientAttributes[string(field)]) {
+			hashed[field] = true
+		}
+	}
+
+	return searchKeyer{blindIndex: p.blindIndex, hashed: hashed}
+}
+
+// lists the patients of a dental practice, newest first, using the created-index.
+// only patient items have a created at attribute, so the search items never
+// show up in the index.
+func (p *PatientStore) ListPatients(ctx context.Context, dentalPracticeID string, request ListPatientsRequest) (PatientSearchResponse, error) {
+	logger := logging.FromContext(ctx, p.logger)
+	logger.Info("listing patients", zap.String("dentalPracticeID", dentalPracticeID))
+
+	partitionKey := getPartitionKey(dentalPracticeID)
+
+	exclusiveStartKey, err := decodeCursor(request.Cursor, partitionKey, "ca")
+	if err != nil {
+		logger.Error("could not decode the list cursor", zap.Error(err))
+		return PatientSearchResponse{}, err
+	}
+
+	limit := request.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	expressionAttributeNames := map[string]string{"#_pk": "_pk"}
+	expressionAttributeValues := map[string]types.AttributeValue{":dpid": partitionKey}
+
+	// the created date range is part of the key condition
+	keyConditionExpression := "#_pk = :dpid"
+	if request.CreatedFrom != "" || request.CreatedTo != "" {
+		expressionAttributeNames["#ca"] = "ca"
+	}
+
+	switch {
+	case request.CreatedFrom != "" && request.CreatedTo != "":
+		keyConditionExpression += " and #ca between :from and :to"
+		expressionAttributeValues[":from"] = &types.AttributeValueMemberS{Value: request.CreatedFrom}
+		expressionAttributeValues[":to"] = &types.AttributeValueMemberS{Value: request.CreatedTo}
+	case request.CreatedFrom != "":
+		keyConditionExpression += " and #ca >= :from"
+		expressionAttributeValues[":from"] = &types.AttributeValueMemberS{Value: request.CreatedFrom}
+	case request.CreatedTo != "":
+		keyConditionExpression += " and #ca <= :to"
+		expressionAttributeValues[":to"] = &types.AttributeValueMemberS{Value: request.CreatedTo}
+	}
+
+	// everything else is filtered after the items have been read, patients
+	// that were merged into another patient are never listed
+	filters := []string{"attribute_not_exists(#mi)"}
+	expressionAttributeNames["#mi"] = "mi"
+
+	if request.Active != nil {
+		filters = append(filters, "#a = :a")
+		expressionAttributeNames["#a"] = "a"
+		expressionAttributeValues[":a"] = &types.AttributeValueMemberBOOL{Value: *request.Active}
+	}
+
+	if request.AssignedDentist != "" {
+		filters = append(filters, "#ad = :ad")
+		expressionAttributeNames["#ad"] = "ad"
+		expressionAttributeValues[":ad"] = &types.AttributeValueMemberS{Value: request.AssignedDentist}
+	}
+
+	if request.AssignedHygienist != "" {
+		filters = append(filters, "#ah = :ah")
+		expressionAttributeNames["#ah"] = "ah"
+		expressionAttributeValues[":ah"] = &types.AttributeValueMemberS{Value: request.AssignedHygienist}
+	}
+
+	response, err := p.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(p.tableName),
+		IndexName:                 aws.String("created-index"),
+		KeyConditionExpression:    aws.String(keyConditionExpression),
+		FilterExpression:          aws.String(strings.Join(filters, " and ")),
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+		ScanIndexForward:          aws.Bool(false),
+		Limit:                     aws.Int32(limit),
+		ExclusiveStartKey:         exclusiveStartKey,
+	})
+	if err != nil {
+		logger.Error("could not list patients", zap.Error(err))
+		return PatientSearchResponse{}, classifyDynamoDBError(err, "could not list patients")
+	}
+
+	// the created-index projects the data key along with the encrypted fields
+	for _, item := range response.Items {
+		err = p.encrypter.DecryptItem(ctx, item)
 		if err != nil {
-			logger.Error("could not unmarshal response", zap.Error(err))
+			logger.Error("could not decrypt the patient", zap.Error(err))
+			return PatientSearchResponse{}, fmt.Errorf("could not decrypt the patients: %w", err)
 		}
 	}
 
-	return patients, err
+	patients := make([]PatientSearchResponseItem, 0, len(response.Items))
+	err = attributevalue.UnmarshalListOfMaps(response.Items, &patients)
+	if err != nil {
+		logger.Error("could not unmarshal response", zap.Error(err))
+		return PatientSearchResponse{}, err
+	}
+
+	nextCursor, err := encodeCursor(response.LastEvaluatedKey)
+	if err != nil {
+		logger.Error("could not encode the list cursor", zap.Error(err))
+		return PatientSearchResponse{}, err
+	}
+
+	return PatientSearchResponse{Items: patients, NextCursor: nextCursor}, nil
 }
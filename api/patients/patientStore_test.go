@@ -0,0 +1,718 @@
+package patients
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// a very small stand in for a dynamodb table, it only understands the access
+// patterns used by the patient store.
+type fakeDynamoDBClient struct {
+	mu    sync.Mutex
+	items map[string]map[string]types.AttributeValue
+
+	// when set, every transaction fails with this error
+	transactWriteItemsErr error
+}
+
+func newFakeDynamoDBClient() *fakeDynamoDBClient {
+	return &fakeDynamoDBClient{items: map[string]map[string]types.AttributeValue{}}
+}
+
+func (f *fakeDynamoDBClient) BatchGetItem(_ context.Context, params *dynamodb.BatchGetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	output := &dynamodb.BatchGetItemOutput{Responses: map[string][]map[string]types.AttributeValue{}}
+	for tableName, keysAndAttributes := range params.RequestItems {
+		for _, key := range keysAndAttributes.Keys {
+			if item, ok := f.items[fakeItemKey(key)]; ok {
+				output.Responses[tableName] = append(output.Responses[tableName], copyItem(item))
+			}
+		}
+	}
+
+	return output, nil
+}
+
+func (f *fakeDynamoDBClient) GetItem(_ context.Context, params *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &dynamodb.GetItemOutput{Item: copyItem(f.items[fakeItemKey(params.Key)])}, nil
+}
+
+func (f *fakeDynamoDBClient) TransactWriteItems(_ context.Context, params *dynamodb.TransactWriteItemsInput, _ ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.transactWriteItemsErr != nil {
+		return nil, f.transactWriteItemsErr
+	}
+
+	// check every condition before writing anything, the whole transaction is
+	// cancelled if a single condition fails
+	reasons := make([]types.CancellationReason, len(params.TransactItems))
+	cancelled := false
+	for i, transactItem := range params.TransactItems {
+		reasons[i] = types.CancellationReason{Code: aws.String("None")}
+
+		if transactItem.Put == nil || transactItem.Put.ConditionExpression == nil {
+			continue
+		}
+
+		existing := f.items[fakeItemKey(transactItem.Put.Item)]
+		if !fakeConditionHolds(*transactItem.Put.ConditionExpression, transactItem.Put.ExpressionAttributeNames, transactItem.Put.ExpressionAttributeValues, existing) {
+			reasons[i] = types.CancellationReason{Code: aws.String("ConditionalCheckFailed")}
+			cancelled = true
+		}
+	}
+
+	if cancelled {
+		return nil, &types.TransactionCanceledException{CancellationReasons: reasons}
+	}
+
+	for _, transactItem := range params.TransactItems {
+		if transactItem.Delete != nil {
+			delete(f.items, fakeItemKey(transactItem.Delete.Key))
+			continue
+		}
+
+		if transactItem.Put == nil {
+			continue
+		}
+
+		// the real client serialises the item straight away, so copy it in case
+		// the caller goes on to reuse the map
+		item := map[string]types.AttributeValue{}
+		for name, value := range transactItem.Put.Item {
+			item[name] = value
+		}
+
+		f.items[fakeItemKey(item)] = item
+	}
+
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func (f *fakeDynamoDBClient) Query(_ context.Context, params *dynamodb.QueryInput, _ ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	values := params.ExpressionAttributeValues
+	keyCondition := aws.ToString(params.KeyConditionExpression)
+
+	// work out which items are in the index and match the key condition, a
+	// query without an index reads the table itself
+	sortKeyAttribute := map[string]string{
+		"":              "_sk",
+		"name-index":    "st",
+		"field-index":   "fst",
+		"created-index": "ca",
+	}[aws.ToString(params.IndexName)]
+
+	var items []map[string]types.AttributeValue
+	for _, item := range f.items {
+		sortKey, ok := item[sortKeyAttribute]
+		if !ok || attributeString(item["_pk"]) != attributeString(values[":dpid"]) {
+			continue
+		}
+
+		value := attributeString(sortKey)
+		switch {
+		case strings.Contains(keyCondition, "begins_with") && !strings.HasPrefix(value, attributeString(values[":"+sortKeyAttribute])):
+			continue
+		case strings.Contains(keyCondition, ":from") && value < attributeString(values[":from"]):
+			continue
+		case strings.Contains(keyCondition, ":to") && value > attributeString(values[":to"]):
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	// items come back in index sort key order
+	sort.Slice(items, func(i, j int) bool {
+		less := attributeString(items[i][sortKeyAttribute])+"|"+attributeString(items[i]["_sk"]) < attributeString(items[j][sortKeyAttribute])+"|"+attributeString(items[j]["_sk"])
+		if params.ScanIndexForward != nil && !*params.ScanIndexForward {
+			return !less
+		}
+
+		return less
+	})
+
+	if params.ExclusiveStartKey != nil {
+		for i, item := range items {
+			if fakeItemKey(item) == fakeItemKey(params.ExclusiveStartKey) {
+				items = items[i+1:]
+				break
+			}
+		}
+	}
+
+	output := &dynamodb.QueryOutput{}
+	if params.Limit != nil && int(*params.Limit) < len(items) {
+		last := items[*params.Limit-1]
+		items = items[:*params.Limit]
+		output.LastEvaluatedKey = map[string]types.AttributeValue{"_pk": last["_pk"], "_sk": last["_sk"], sortKeyAttribute: last[sortKeyAttribute]}
+	}
+
+	// like dynamodb, filters are applied after the limit
+	for _, item := range items {
+		if _, merged := item["mi"]; merged && strings.Contains(aws.ToString(params.FilterExpression), "attribute_not_exists(#mi)") {
+			continue
+		}
+
+		if active, ok := values[":a"]; ok && item["a"].(*types.AttributeValueMemberBOOL).Value != active.(*types.AttributeValueMemberBOOL).Value {
+			continue
+		}
+
+		if dentist, ok := values[":ad"]; ok && attributeString(item["ad"]) != attributeString(dentist) {
+			continue
+		}
+
+		if hygienist, ok := values[":ah"]; ok && attributeString(item["ah"]) != attributeString(hygienist) {
+			continue
+		}
+
+		output.Items = append(output.Items, copyItem(item))
+	}
+
+	return output, nil
+}
+
+// returns a deep copy of an item, the real client unmarshals a new copy of the
+// items for every response so callers are free to change them.
+func copyItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	if item == nil {
+		return nil
+	}
+
+	copied := make(map[string]types.AttributeValue, len(item))
+	for name, value := range item {
+		copied[name] = copyAttributeValue(value)
+	}
+
+	return copied
+}
+
+func copyAttributeValue(value types.AttributeValue) types.AttributeValue {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberM:
+		return &types.AttributeValueMemberM{Value: copyItem(v.Value)}
+	case *types.AttributeValueMemberL:
+		values := make([]types.AttributeValue, 0, len(v.Value))
+		for _, element := range v.Value {
+			values = append(values, copyAttributeValue(element))
+		}
+		return &types.AttributeValueMemberL{Value: values}
+	default:
+		return value
+	}
+}
+
+// evaluates a condition made of attribute_exists, attribute_not_exists,
+// equality and number comparisons joined by and or by or, against the item
+// that is already stored.
+func fakeConditionHolds(condition string, names map[string]string, values map[string]types.AttributeValue, existing map[string]types.AttributeValue) bool {
+	if strings.Contains(condition, " or ") {
+		for _, alternative := range strings.Split(condition, " or ") {
+			if fakeConditionHolds(alternative, names, values, existing) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, check := range strings.Split(condition, " and ") {
+		if name, value, ok := strings.Cut(check, " = "); ok {
+			if !reflect.DeepEqual(existing[names[name]], values[value]) {
+				return false
+			}
+			continue
+		}
+
+		if name, value, ok := strings.Cut(check, " <= "); ok {
+			stored, _ := existing[names[name]].(*types.AttributeValueMemberN)
+			if stored == nil || attributeNumber(stored) > attributeNumber(values[value]) {
+				return false
+			}
+			continue
+		}
+
+		function, name, _ := strings.Cut(strings.TrimSuffix(strings.TrimSpace(check), ")"), "(")
+		_, exists := existing[names[name]]
+
+		if (function == "attribute_exists" && !exists) || (function == "attribute_not_exists" && exists) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func fakeItemKey(item map[string]types.AttributeValue) string {
+	return attributeString(item["_pk"]) + "|" + attributeString(item["_sk"])
+}
+
+func attributeNumber(value types.AttributeValue) int64 {
+	n, ok := value.(*types.AttributeValueMemberN)
+	if !ok {
+		return 0
+	}
+
+	number, _ := strconv.ParseInt(n.Value, 10, 64)
+	return number
+}
+
+func attributeString(value types.AttributeValue) string {
+	s, ok := value.(*types.AttributeValueMemberS)
+	if !ok {
+		return ""
+	}
+
+	return s.Value
+}
+
+func TestDentalPracticeIsolation(t *testing.T) {
+	practiceA := "practice_a"
+	practiceB := "practice_b"
+
+	t.Run("patients are keyed by the dental practice they belong to", func(t *testing.T) {
+		patient := Patient{PatientID: "test_patient_id"}
+
+		keyA := patient.GetKey(practiceA)
+		keyB := patient.GetKey(practiceB)
+
+		if attributeString(keyA["_pk"]) != "dp#practice_a" {
+			t.Errorf("got partition key %q want %q", attributeString(keyA["_pk"]), "dp#practice_a")
+		}
+
+		if attributeString(keyA["_pk"]) == attributeString(keyB["_pk"]) {
+			t.Error("two dental practices share the same partition key")
+		}
+	})
+
+	t.Run("a patient created by one practice cannot be read by another", func(t *testing.T) {
+		store := &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"}
+
+		created, err := store.CreatePatient(context.Background(), practiceA, CreatePatientRequest{FirstName: "Jane", LastName: "Doe"})
+		if err != nil {
+			t.Fatalf("could not create the patient: %v", err)
+		}
+
+		_, err = store.GetPatient(context.Background(), practiceB, created.PatientID)
+		if err == nil {
+			t.Error("a patient of practice a was returned to practice b")
+		}
+
+		patient, err := store.GetPatient(context.Background(), practiceA, created.PatientID)
+		if err != nil {
+			t.Fatalf("could not get the patient for the practice that created it: %v", err)
+		}
+
+		if patient.FirstName != "Jane" {
+			t.Errorf("got first name %q want %q", patient.FirstName, "Jane")
+		}
+	})
+
+	t.Run("a patient created by one practice cannot be found by another practice's search", func(t *testing.T) {
+		store := &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"}
+
+		_, err := store.CreatePatient(context.Background(), practiceA, CreatePatientRequest{FirstName: "Jane", LastName: "Doe"})
+		if err != nil {
+			t.Fatalf("could not create the patient: %v", err)
+		}
+
+		results, err := store.SearchPatients(context.Background(), practiceB, SearchPatientsRequest{SearchTerm: "ja"})
+		if err != nil {
+			t.Fatalf("could not search patients: %v", err)
+		}
+
+		if len(results.Items) != 0 {
+			t.Errorf("practice b found %d patients belonging to practice a", len(results.Items))
+		}
+
+		results, err = store.SearchPatients(context.Background(), practiceA, SearchPatientsRequest{SearchTerm: "ja"})
+		if err != nil {
+			t.Fatalf("could not search patients: %v", err)
+		}
+
+		if len(results.Items) != 1 {
+			t.Errorf("got %d search results want 1", len(results.Items))
+		}
+	})
+
+	t.Run("a cursor issued to one practice cannot be used by another", func(t *testing.T) {
+		store := &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"}
+
+		for _, firstName := range []string{"James", "Jamie"} {
+			_, err := store.CreatePatient(context.Background(), practiceA, CreatePatientRequest{FirstName: firstName, LastName: "Oliver"})
+			if err != nil {
+				t.Fatalf("could not create the patient: %v", err)
+			}
+		}
+
+		results, err := store.SearchPatients(context.Background(), practiceA, SearchPatientsRequest{SearchTerm: "ja", Limit: 1})
+		if err != nil {
+			t.Fatalf("could not search patients: %v", err)
+		}
+
+		_, err = store.SearchPatients(context.Background(), practiceB, SearchPatientsRequest{SearchTerm: "ja", Cursor: results.NextCursor})
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("got error %v want %v", err, ErrInvalidCursor)
+		}
+	})
+
+	t.Run("a patient of one practice cannot be updated by another", func(t *testing.T) {
+		store := &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"}
+
+		created, err := store.CreatePatient(context.Background(), practiceA, CreatePatientRequest{FirstName: "Jane", LastName: "Doe"})
+		if err != nil {
+			t.Fatalf("could not create the patient: %v", err)
+		}
+
+		_, err = store.UpdatePatient(context.Background(), practiceB, UpdatePatientRequest{PatientID: created.PatientID, FirstName: "Janet"}, 1)
+		if err == nil {
+			t.Error("practice b was able to update a patient of practice a")
+		}
+	})
+}
+
+func TestCreatePatient(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	t.Run("the patient and its search items are written in a single transaction", func(t *testing.T) {
+		client := newFakeDynamoDBClient()
+		store := &PatientStore{client: client, tableName: "test_table"}
+
+		created, err := store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: "Jane", LastName: "Doe"})
+		if err != nil {
+			t.Fatalf("could not create the patient: %v", err)
+		}
+
+		for _, sortKey := range []string{"p#%v", "p#%v#fn", "p#%v#ln"} {
+			key := "dp#" + dentalPracticeID + "|" + strings.Replace(sortKey, "%v", created.PatientID, 1)
+			if _, ok := client.items[key]; !ok {
+				t.Errorf("expected an item with the key %q to be written", key)
+			}
+		}
+	})
+
+	t.Run("nothing is written when the transaction fails", func(t *testing.T) {
+		client := newFakeDynamoDBClient()
+		client.transactWriteItemsErr = errors.New("call to dynamodb failed")
+		store := &PatientStore{client: client, tableName: "test_table"}
+
+		_, err := store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: "Jane", LastName: "Doe"})
+		if err == nil {
+			t.Fatal("expected an error when the transaction fails")
+		}
+
+		if errors.Is(err, ErrPatientAlreadyExists) {
+			t.Error("a failed transaction was reported as an existing patient")
+		}
+
+		if len(client.items) != 0 {
+			t.Errorf("got %d items written want 0", len(client.items))
+		}
+	})
+
+	t.Run("a conditional check failure on the patient is reported as an existing patient", func(t *testing.T) {
+		err := &types.TransactionCanceledException{
+			CancellationReasons: []types.CancellationReason{
+				{Code: aws.String("ConditionalCheckFailed")},
+				{Code: aws.String("None")},
+			},
+		}
+
+		if !isConditionalCheckFailure(err, 0) {
+			t.Error("expected the condition of the first item to be reported as failed")
+		}
+
+		if isConditionalCheckFailure(err, 1) {
+			t.Error("expected the condition of the second item to be reported as passed")
+		}
+	})
+}
+
+func TestSearchPatients(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	store := &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"}
+
+	for _, firstName := range []string{"James", "Jamie", "Janet"} {
+		_, err := store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: firstName, LastName: "Oliver"})
+		if err != nil {
+			t.Fatalf("could not create the patient: %v", err)
+		}
+	}
+
+	t.Run("pages through the results using the next cursor", func(t *testing.T) {
+		var firstNames []string
+		cursor := ""
+		pages := 0
+
+		for {
+			results, err := store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: "ja", Limit: 2, Cursor: cursor})
+			if err != nil {
+				t.Fatalf("could not search patients: %v", err)
+			}
+
+			pages++
+			for _, item := range results.Items {
+				firstNames = append(firstNames, item.FirstName)
+			}
+
+			if results.NextCursor == "" {
+				break
+			}
+
+			cursor = results.NextCursor
+		}
+
+		if pages != 2 {
+			t.Errorf("got %d pages want 2", pages)
+		}
+
+		want := []string{"James", "Jamie", "Janet"}
+		if strings.Join(firstNames, ",") != strings.Join(want, ",") {
+			t.Errorf("got %v want %v", firstNames, want)
+		}
+	})
+
+	t.Run("returns an empty list rather than null when nothing matches", func(t *testing.T) {
+		results, err := store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: "zz"})
+		if err != nil {
+			t.Fatalf("could not search patients: %v", err)
+		}
+
+		if results.Items == nil || len(results.Items) != 0 || results.NextCursor != "" {
+			t.Errorf("got %+v want an empty page", results)
+		}
+	})
+
+	t.Run("rejects a cursor that cannot be decoded", func(t *testing.T) {
+		_, err := store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: "ja", Cursor: "not a cursor"})
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("got error %v want %v", err, ErrInvalidCursor)
+		}
+	})
+
+	t.Run("rejects a cursor that does not hold the keys of the index", func(t *testing.T) {
+		partitionKey := getPartitionKey(dentalPracticeID)
+
+		for _, key := range []map[string]types.AttributeValue{
+			// the keys of the created-index rather than the name-index
+			{"_pk": partitionKey, "_sk": &types.AttributeValueMemberS{Value: "p#1"}, "ca": &types.AttributeValueMemberS{Value: "2022-10-01T09:00:00Z"}},
+			// an attribute that is not a key
+			{"_pk": partitionKey, "_sk": &types.AttributeValueMemberS{Value: "p#1#fn"}, "st": &types.AttributeValueMemberS{Value: "jam"}, "ni": &types.AttributeValueMemberS{Value: "AB123456C"}},
+			// a key that is missing
+			{"_pk": partitionKey, "st": &types.AttributeValueMemberS{Value: "jam"}},
+		} {
+			cursor, _ := encodeCursor(key)
+
+			_, err := store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: "ja", Cursor: cursor})
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("got error %v want %v for the cursor %v", err, ErrInvalidCursor, key)
+			}
+		}
+	})
+}
+
+func TestListPatients(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	client := newFakeDynamoDBClient()
+	store := &PatientStore{client: client, tableName: "test_table"}
+
+	patientsToCreate := []struct {
+		createdAt string
+		request   CreatePatientRequest
+		active    bool
+	}{
+		{createdAt: "2022-09-15T10:00:00Z", request: CreatePatientRequest{FirstName: "James", AssignedDentist: "dentist_1"}, active: true},
+		{createdAt: "2022-10-01T10:00:00Z", request: CreatePatientRequest{FirstName: "Jamie", AssignedDentist: "dentist_2"}, active: true},
+		{createdAt: "2022-10-20T10:00:00Z", request: CreatePatientRequest{FirstName: "Janet", AssignedDentist: "dentist_1", AssignedHygienist: "hygienist_1"}, active: false},
+	}
+
+	for _, patient := range patientsToCreate {
+		created, err := store.CreatePatient(context.Background(), dentalPracticeID, patient.request)
+		if err != nil {
+			t.Fatalf("could not create the patient: %v", err)
+		}
+
+		// backdate the patient so the date range filters can be tested
+		item := client.items["dp#"+dentalPracticeID+"|p#"+created.PatientID]
+		item["ca"] = &types.AttributeValueMemberS{Value: patient.createdAt}
+		item["a"] = &types.AttributeValueMemberBOOL{Value: patient.active}
+	}
+
+	listFirstNames := func(t testing.TB, request ListPatientsRequest) []string {
+		t.Helper()
+
+		results, err := store.ListPatients(context.Background(), dentalPracticeID, request)
+		if err != nil {
+			t.Fatalf("could not list patients: %v", err)
+		}
+
+		firstNames := []string{}
+		for _, item := range results.Items {
+			firstNames = append(firstNames, item.FirstName)
+		}
+
+		return firstNames
+	}
+
+	active := true
+
+	cases := []struct {
+		name    string
+		request ListPatientsRequest
+		want    []string
+	}{
+		{name: "lists every patient newest first", request: ListPatientsRequest{}, want: []string{"Janet", "Jamie", "James"}},
+		{name: "filters by active", request: ListPatientsRequest{Active: &active}, want: []string{"Jamie", "James"}},
+		{name: "filters by assigned dentist", request: ListPatientsRequest{AssignedDentist: "dentist_1"}, want: []string{"Janet", "James"}},
+		{name: "filters by assigned hygienist", request: ListPatientsRequest{AssignedHygienist: "hygienist_1"}, want: []string{"Janet"}},
+		{name: "filters by created from", request: ListPatientsRequest{CreatedFrom: "2022-10-01T00:00:00Z"}, want: []string{"Janet", "Jamie"}},
+		{name: "filters by created to", request: ListPatientsRequest{CreatedTo: "2022-09-30T23:59:59Z"}, want: []string{"James"}},
+		{name: "filters by a created date range", request: ListPatientsRequest{CreatedFrom: "2022-09-01T00:00:00Z", CreatedTo: "2022-10-10T00:00:00Z"}, want: []string{"Jamie", "James"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := listFirstNames(t, c.request)
+
+			if strings.Join(got, ",") != strings.Join(c.want, ",") {
+				t.Errorf("got %v want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSearchPatientsByField(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	// both stores have to find patients by the same fields
+	stores := map[string]PatientRepository{
+		"dynamodb": &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"},
+		"memory":   NewMemoryPatientStore(),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			created, err := store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{
+				FirstName:   "Jane",
+				LastName:    "Doe",
+				Email:       "Jane.Doe@example.com",
+				MobilePhone: "07700 900123",
+				PostCode:    "SW1A 1AA",
+				DateOfBirth: "1985-03-14",
+			})
+			if err != nil {
+				t.Fatalf("could not create the patient: %v", err)
+			}
+
+			// a patient without any contact details must not be found by them
+			_, err = store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: "John"})
+			if err != nil {
+				t.Fatalf("could not create the patient: %v", err)
+			}
+
+			search := func(t testing.TB, field SearchField, searchTerm string) []string {
+				t.Helper()
+
+				results, err := store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: searchTerm, Field: field})
+				if err != nil {
+					t.Fatalf("could not search patients: %v", err)
+				}
+
+				patientIDs := []string{}
+				for _, item := range results.Items {
+					patientIDs = append(patientIDs, item.PatientID)
+				}
+
+				return patientIDs
+			}
+
+			cases := []struct {
+				field      SearchField
+				searchTerm string
+				found      bool
+			}{
+				{field: SearchFieldEmail, searchTerm: "jane.doe@", found: true},
+				{field: SearchFieldEmail, searchTerm: "JANE.DOE@EXAMPLE.COM", found: true},
+				{field: SearchFieldEmail, searchTerm: "john", found: false},
+				{field: SearchFieldMobilePhone, searchTerm: "077009", found: true},
+				{field: SearchFieldMobilePhone, searchTerm: "+44 7700 900123", found: true},
+				{field: SearchFieldMobilePhone, searchTerm: "0044-7700", found: true},
+				{field: SearchFieldMobilePhone, searchTerm: "07711", found: false},
+				{field: SearchFieldPostCode, searchTerm: "sw1a1", found: true},
+				{field: SearchFieldPostCode, searchTerm: "SW1A 1AA", found: true},
+				{field: SearchFieldPostCode, searchTerm: "SW2", found: false},
+				{field: SearchFieldDateOfBirth, searchTerm: "1985-03", found: true},
+				{field: SearchFieldDateOfBirth, searchTerm: "14/03/1985", found: true},
+				{field: SearchFieldDateOfBirth, searchTerm: "15/03/1985", found: false},
+				{field: SearchFieldName, searchTerm: "do", found: true},
+				{field: SearchFieldName, searchTerm: "jane.doe", found: false},
+			}
+
+			for _, c := range cases {
+				got := search(t, c.field, c.searchTerm)
+
+				if c.found && (len(got) != 1 || got[0] != created.PatientID) {
+					t.Errorf("searching the %v field for %q returned %v want [%v]", c.field, c.searchTerm, got, created.PatientID)
+				}
+
+				if !c.found && len(got) != 0 {
+					t.Errorf("searching the %v field for %q returned %v want none", c.field, c.searchTerm, got)
+				}
+			}
+
+			t.Run("a cleared field can no longer be searched", func(t *testing.T) {
+				_, err := store.UpdatePatient(context.Background(), dentalPracticeID, UpdatePatientRequest{
+					PatientID:   created.PatientID,
+					FirstName:   "Jane",
+					LastName:    "Doe",
+					Email:       "jane@example.org",
+					PostCode:    "SW1A 1AA",
+					DateOfBirth: "1985-03-14",
+				}, 1)
+				if err != nil {
+					t.Fatalf("could not update the patient: %v", err)
+				}
+
+				if got := search(t, SearchFieldMobilePhone, "07700"); len(got) != 0 {
+					t.Errorf("the cleared mobile phone was still found, got %v", got)
+				}
+
+				if got := search(t, SearchFieldEmail, "jane.doe"); len(got) != 0 {
+					t.Errorf("the old email was still found, got %v", got)
+				}
+
+				if got := search(t, SearchFieldEmail, "jane@example.org"); len(got) != 1 {
+					t.Errorf("the new email was not found, got %v", got)
+				}
+			})
+
+			t.Run("rejects a field that can not be searched", func(t *testing.T) {
+				_, err := store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: "x", Field: "ethnicity"})
+				if !errors.Is(err, ErrValidation) {
+					t.Errorf("got error %v want %v", err, ErrValidation)
+				}
+			})
+		})
+	}
+}
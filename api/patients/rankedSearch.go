@@ -0,0 +1,238 @@
+package patients
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// a search item read from one of the search indexes, along with the sort key
+// of the search item in the table, which tells which of the patient's search
+// keys it was found by.
+type indexedSearchItem struct {
+	PatientSearchResponseItem
+	SortKey string `dynamodbav:"_sk"`
+}
+
+// reads up to limit search items from a search index whose sort key starts
+// with the prefix, in index order. each store provides its own.
+type searchIndexReader func(index searchIndex, sortKeyPrefix string, limit int32) ([]PatientSearchResponseItem, error)
+
+// reads every search item of a search index whose sort key starts with the
+// prefix, in index order, however many pages that takes. each store provides
+// its own.
+type searchIndexScanner func(index searchIndex, sortKeyPrefix string) ([]PatientSearchResponseItem, error)
+
+// SearchTokens splits a name search term into the separate names it is made
+// of, e.g. "john smi" is searched as "john" and "smi". repeated names are only
+// returned once.
+func SearchTokens(searchTerm string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(searchTerm), func(r rune) bool {
+		return unicode.IsSpace(r) || r == ','
+	})
+
+	var tokens []string
+	seen := map[string]bool{}
+	for _, field := range fields {
+		if !seen[field] {
+			seen[field] = true
+			tokens = append(tokens, field)
+		}
+	}
+
+	return tokens
+}
+
+// reports whether the search returns a single ranked page rather than a page
+// in index order. fuzzy searches and names made of more than one token have
+// their results ranked.
+func isRankedSearch(request SearchPatientsRequest) bool {
+	if request.Field != "" && request.Field != SearchFieldName {
+		return request.Fuzzy
+	}
+
+	return request.Fuzzy || len(SearchTokens(request.SearchTerm)) > 1
+}
+
+// checks that a search can be ranked. only names can be matched by how they
+// sound, and the ranked results are a single page that can not be paged
+// through.
+func validateRankedSearch(request SearchPatientsRequest) error {
+	if request.Fuzzy && request.Field != "" && request.Field != SearchFieldName {
+		return newRepositoryError(ErrValidation, "only names can be searched fuzzily, not %q", request.Field)
+	}
+
+	if request.Cursor != "" {
+		return newRepositoryError(ErrValidation, "ranked search results can not be paged through")
+	}
+
+	return nil
+}
+
+// searches names and returns a single page of results ranked by how well they
+// match the search term. the matches that are ranked are scanned in full, so
+// the best match is never left unread however many patients match.
+func rankedSearch(read searchIndexReader, scan searchIndexScanner, request SearchPatientsRequest) (PatientSearchResponse, error) {
+	if err := validateRankedSearch(request); err != nil {
+		return PatientSearchResponse{}, err
+	}
+
+	limit := request.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	tokens := SearchTokens(request.SearchTerm)
+	if len(tokens) > 1 {
+		return multiTokenSearch(scan, tokens, request.Fuzzy, limit)
+	}
+
+	index, sortKeyPrefix, _ := searchQuery(SearchFieldName, request.SearchTerm)
+	prefixMatches, err := read(index, sortKeyPrefix, limit)
+	if err != nil {
+		return PatientSearchResponse{}, err
+	}
+
+	// the phonetic matches are ranked by spelling once they have been read,
+	// so all of them are read
+	var phoneticMatches []PatientSearchResponseItem
+	if phoneticPrefix := phoneticSearchPrefix(request.SearchTerm); phoneticPrefix != "" {
+		phoneticMatches, err = scan(phoneticIndex, phoneticPrefix)
+		if err != nil {
+			return PatientSearchResponse{}, err
+		}
+	}
+
+	return PatientSearchResponse{Items: rankFuzzyMatches(request.SearchTerm, prefixMatches, phoneticMatches, limit)}, nil
+}
+
+// how well a patient matched the tokens of a search term.
+type tokenMatch struct {
+	item PatientSearchResponseItem
+	// the number of tokens that one of the patient's names matched
+	tokens int
+	// the number of those tokens that were prefix matches rather than names
+	// that only sound alike
+	prefixTokens int
+	// how far the names that only sound alike are from their tokens
+	distance int
+	// the position the patient was first found in
+	order int
+}
+
+// searches for the names of each token separately and combines the results,
+// so that "john smi" finds john smith. patients are ranked by how many of the
+// tokens they match, and each patient is only returned once however many of
+// their names match. every match of every token is read, a patient that only
+// some pages in would otherwise lose the tokens they match on the others.
+func multiTokenSearch(scan searchIndexScanner, tokens []string, fuzzy bool, limit int32) (PatientSearchResponse, error) {
+	matches := map[string]*tokenMatch{}
+
+	match := func(item PatientSearchResponseItem, prefix bool, distance int) {
+		m, ok := matches[item.PatientID]
+		if !ok {
+			m = &tokenMatch{item: item, order: len(matches)}
+			matches[item.PatientID] = m
+		}
+
+		m.tokens++
+		if prefix {
+			m.prefixTokens++
+		}
+		m.distance += distance
+	}
+
+	for _, token := range tokens {
+		// a patient whose first and last names both match the token has two
+		// search items, but only counts as matching the token once
+		matched := map[string]bool{}
+
+		prefixMatches, err := scan(searchIndexes[SearchFieldName], token)
+		if err != nil {
+			return PatientSearchResponse{}, err
+		}
+
+		for _, item := range prefixMatches {
+			if !matched[item.PatientID] {
+				matched[item.PatientID] = true
+				match(item, true, 0)
+			}
+		}
+
+		if !fuzzy {
+			continue
+		}
+
+		phoneticPrefix := phoneticSearchPrefix(token)
+		if phoneticPrefix == "" {
+			continue
+		}
+
+		phoneticMatches, err := scan(phoneticIndex, phoneticPrefix)
+		if err != nil {
+			return PatientSearchResponse{}, err
+		}
+
+		for _, item := range phoneticMatches {
+			if !matched[item.PatientID] {
+				matched[item.PatientID] = true
+				match(item, false, nameDistance(token, item))
+			}
+		}
+	}
+
+	ranked := make([]*tokenMatch, 0, len(matches))
+	for _, m := range matches {
+		ranked = append(ranked, m)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+
+		switch {
+		case a.tokens != b.tokens:
+			return a.tokens > b.tokens
+		case a.prefixTokens != b.prefixTokens:
+			return a.prefixTokens > b.prefixTokens
+		case a.distance != b.distance:
+			return a.distance < b.distance
+		default:
+			return a.order < b.order
+		}
+	})
+
+	if int(limit) < len(ranked) {
+		ranked = ranked[:limit]
+	}
+
+	items := make([]PatientSearchResponseItem, 0, len(ranked))
+	for _, m := range ranked {
+		items = append(items, m.item)
+	}
+
+	return PatientSearchResponse{Items: items}, nil
+}
+
+// reports whether a name search item is a second match for the same patient.
+// a patient whose first and last names both start with the search term has a
+// search item for each name, only the one that comes first in the name-index
+// is returned so the patient is listed once, even across pages.
+func isDuplicateNameMatch(item indexedSearchItem, lowerCaseSearchTerm string) bool {
+	firstName := strings.ToLower(item.FirstName)
+	lastName := strings.ToLower(item.LastName)
+
+	if !strings.HasPrefix(firstName, lowerCaseSearchTerm) || !strings.HasPrefix(lastName, lowerCaseSearchTerm) {
+		return false
+	}
+
+	// the first name search item comes first when the names are the same,
+	// as its sort key ends in fn rather than ln
+	switch {
+	case strings.HasSuffix(item.SortKey, "#ln"):
+		return firstName <= lastName
+	case strings.HasSuffix(item.SortKey, "#fn"):
+		return lastName < firstName
+	default:
+		return false
+	}
+}
@@ -0,0 +1,181 @@
+package patients
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSearchTokens(t *testing.T) {
+	cases := map[string]string{
+		"john smi":          "john,smi",
+		"  Smith,  John ":   "smith,john",
+		"john john":         "john",
+		"jo":                "jo",
+		"":                  "",
+		"mary\tann o'brien": "mary,ann,o'brien",
+	}
+
+	for searchTerm, want := range cases {
+		if got := strings.Join(SearchTokens(searchTerm), ","); got != want {
+			t.Errorf("SearchTokens(%q) got %q want %q", searchTerm, got, want)
+		}
+	}
+}
+
+func TestMultiTokenSearchPatients(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	// both stores have to rank multi token searches the same way
+	stores := map[string]PatientRepository{
+		"dynamodb": &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"},
+		"memory":   NewMemoryPatientStore(),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			patientIDs := map[string]string{}
+			for _, patient := range []CreatePatientRequest{
+				{FirstName: "John", LastName: "Smith"},
+				{FirstName: "John", LastName: "Jones"},
+				{FirstName: "Sarah", LastName: "Smith"},
+				{FirstName: "Jane", LastName: "Doe"},
+				{FirstName: "Smith", LastName: "Smithson"},
+			} {
+				created, err := store.CreatePatient(context.Background(), dentalPracticeID, patient)
+				if err != nil {
+					t.Fatalf("could not create the patient: %v", err)
+				}
+
+				patientIDs[created.PatientID] = patient.FirstName + " " + patient.LastName
+			}
+
+			search := func(t testing.TB, request SearchPatientsRequest) []string {
+				t.Helper()
+
+				results, err := store.SearchPatients(context.Background(), dentalPracticeID, request)
+				if err != nil {
+					t.Fatalf("could not search patients: %v", err)
+				}
+
+				names := []string{}
+				for _, item := range results.Items {
+					names = append(names, patientIDs[item.PatientID])
+				}
+
+				return names
+			}
+
+			t.Run("ranks the patients matching every token first", func(t *testing.T) {
+				got := search(t, SearchPatientsRequest{SearchTerm: "john smi"})
+
+				if len(got) != 4 || got[0] != "John Smith" {
+					t.Fatalf("got %v want John Smith followed by the patients matching one token", got)
+				}
+
+				for _, name := range got[1:] {
+					if name == "John Smith" || name == "Jane Doe" {
+						t.Errorf("got %v, %v should not be ranked after the first result", got, name)
+					}
+				}
+			})
+
+			t.Run("the order of the tokens does not matter", func(t *testing.T) {
+				got := search(t, SearchPatientsRequest{SearchTerm: "Smith, John"})
+
+				if len(got) == 0 || got[0] != "John Smith" {
+					t.Errorf("got %v want John Smith first", got)
+				}
+			})
+
+			t.Run("matches tokens fuzzily when asked to", func(t *testing.T) {
+				got := search(t, SearchPatientsRequest{SearchTerm: "jon smyth", Fuzzy: true})
+
+				if len(got) == 0 || got[0] != "John Smith" {
+					t.Errorf("got %v want John Smith first", got)
+				}
+			})
+
+			t.Run("returns a patient whose names both match once", func(t *testing.T) {
+				// page through one result at a time, so the two search items of
+				// smith smithson end up on separate pages
+				var got []string
+				cursor := ""
+				for {
+					results, err := store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: "smith", Limit: 1, Cursor: cursor})
+					if err != nil {
+						t.Fatalf("could not search patients: %v", err)
+					}
+
+					for _, item := range results.Items {
+						got = append(got, patientIDs[item.PatientID])
+					}
+
+					if results.NextCursor == "" {
+						break
+					}
+
+					cursor = results.NextCursor
+				}
+
+				seen := map[string]bool{}
+				for _, name := range got {
+					if seen[name] {
+						t.Errorf("%v was returned more than once in %v", name, got)
+					}
+					seen[name] = true
+				}
+
+				if len(seen) != 3 {
+					t.Errorf("got %v want the three patients named smith", got)
+				}
+			})
+
+			t.Run("rejects a cursor for a multi token search", func(t *testing.T) {
+				_, err := store.SearchPatients(context.Background(), dentalPracticeID, SearchPatientsRequest{SearchTerm: "john smi", Cursor: "cursor"})
+				if !errors.Is(err, ErrValidation) {
+					t.Errorf("got error %v want %v", err, ErrValidation)
+				}
+			})
+		})
+	}
+}
+
+func TestMultiTokenSearchReadsEveryMatch(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	stores := map[string]PatientRepository{
+		"dynamodb": &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"},
+		"memory":   NewMemoryPatientStore(),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			// more patients match the first token than fit in a page of the
+			// index, and they all come before the patient being searched for
+			for i := 0; i < int(MaxSearchLimit)+20; i++ {
+				_, err := store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: "Johnathan", LastName: "Jones"})
+				if err != nil {
+					t.Fatalf("could not create the patient: %v", err)
+				}
+			}
+
+			target, err := store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: "Johnson", LastName: "Smith"})
+			if err != nil {
+				t.Fatalf("could not create the patient: %v", err)
+			}
+
+			for _, request := range []SearchPatientsRequest{{SearchTerm: "john smi"}, {SearchTerm: "john smi", Fuzzy: true}} {
+				results, err := store.SearchPatients(context.Background(), dentalPracticeID, request)
+				if err != nil {
+					t.Fatalf("could not search patients: %v", err)
+				}
+
+				if len(results.Items) == 0 || results.Items[0].PatientID != target.PatientID {
+					t.Errorf("got %+v want Johnson Smith first when searching for %+v", results.Items, request)
+				}
+			}
+		})
+	}
+}
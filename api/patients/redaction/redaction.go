@@ -0,0 +1,291 @@
+// Package redaction decides which patient fields each role can see, and takes
+// the rest out of the responses.
+package redaction
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+)
+
+// Visibility is how much of a field a role can see.
+type Visibility int
+
+const (
+	// the field is left out of the response
+	Hidden Visibility = iota
+	// the field is returned with most of its characters masked
+	Masked
+	// the field is returned as it is
+	Visible
+)
+
+// the json names of the fields every role sees, which identify the patient and
+// who looks after them.
+var alwaysVisible = []string{
+	"patient_id",
+	"title",
+	"first_name",
+	"middle_name",
+	"last_name",
+	"active",
+	"created_at",
+	"modified_at",
+	"version",
+	"merged_into",
+	"assigned_dentist",
+	"assigned_hygienist",
+}
+
+// the contact details and address, which every role that deals with the
+// patient directly sees.
+var contactDetails = []string{
+	"email",
+	"mobile_phone",
+	"home_phone",
+	"work_phone",
+	"address_line_1",
+	"address_line_2",
+	"city",
+	"county",
+	"post_code",
+	"country",
+}
+
+// the fields each role sees, and how much of them. fields that are not listed
+// are hidden.
+var policy = map[auth.Role]map[string]Visibility{
+	auth.RoleAdmin: fields(Visible, allFields()...),
+	auth.RoleDentist: merge(
+		fields(Visible, contactDetails...),
+		fields(Visible, "gender", "date_of_birth", "ethnicity", "occupation", "acquisition_source"),
+		fields(Visible, "emergency_contact_full_name", "emergency_contact_phone", "emergency_contact_relation_to_patient"),
+		fields(Masked, "national_insurance_number"),
+	),
+	auth.RoleHygienist: merge(
+		fields(Visible, contactDetails...),
+		fields(Visible, "gender", "date_of_birth"),
+		fields(Visible, "emergency_contact_full_name", "emergency_contact_phone", "emergency_contact_relation_to_patient"),
+		fields(Masked, "national_insurance_number"),
+	),
+	auth.RoleReceptionist: merge(
+		fields(Visible, contactDetails...),
+		fields(Visible, "gender", "date_of_birth", "acquisition_source"),
+		fields(Visible, "emergency_contact_full_name", "emergency_contact_phone", "emergency_contact_relation_to_patient"),
+		fields(Masked, "national_insurance_number"),
+	),
+	auth.RoleFinance: merge(
+		fields(Visible, contactDetails...),
+		fields(Visible, "acquisition_source"),
+		fields(Masked, "date_of_birth"),
+	),
+}
+
+// how each field is masked, fields without a mask of their own are masked
+// completely.
+var masks = map[string]func(string) string{
+	// QQ123456C becomes QQ******C
+	"national_insurance_number": func(value string) string { return mask(value, 2, 1) },
+	// 1985-03-14 becomes 1985-**-**, so the age can still be worked out
+	"date_of_birth": func(value string) string { return mask(value, 4, 0) },
+}
+
+// Allowed reports whether the role is one that patients can be returned to.
+func Allowed(role auth.Role) bool {
+	_, ok := policy[role]
+	return ok
+}
+
+// FieldVisibility returns how much of the field, named by its json name, the
+// role can see.
+func FieldVisibility(role auth.Role, field string) Visibility {
+	for _, visible := range alwaysVisible {
+		if field == visible {
+			return Visible
+		}
+	}
+
+	return policy[role][field]
+}
+
+// IsField reports whether field is the json name of a patient field.
+func IsField(field string) bool {
+	// admins see every field
+	_, ok := policy[auth.RoleAdmin][field]
+	return ok
+}
+
+// Patient returns the fields of the patient the role can see, keyed by their
+// json names.
+func Patient(role auth.Role, patient patients.Patient) map[string]interface{} {
+	return redact(role, patient)
+}
+
+// SearchResponse is a page of search or list results with the fields the role
+// can not see taken out.
+type SearchResponse struct {
+	Items      []map[string]interface{} `json:"items"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+}
+
+// Search returns the search or list results with the fields the role can not
+// see taken out of each patient.
+func Search(role auth.Role, response patients.PatientSearchResponse) SearchResponse {
+	items := make([]map[string]interface{}, 0, len(response.Items))
+	for _, item := range response.Items {
+		items = append(items, redact(role, item))
+	}
+
+	return SearchResponse{Items: items, NextCursor: response.NextCursor}
+}
+
+// History returns the history of a patient with the changes to fields the role
+// can not see taken out, and the values of fields it can only partly see
+// masked.
+func History(role auth.Role, response patients.PatientHistoryResponse) patients.PatientHistoryResponse {
+	items := make([]patients.PatientHistoryItem, 0, len(response.Items))
+	for _, item := range response.Items {
+		changes := []patients.FieldChange{}
+		for _, change := range item.Changes {
+			switch FieldVisibility(role, change.Field) {
+			case Visible:
+				changes = append(changes, change)
+			case Masked:
+				changes = append(changes, patients.FieldChange{Field: change.Field, Before: maskField(change.Field, change.Before), After: maskField(change.Field, change.After)})
+			}
+		}
+
+		item.Changes = changes
+		items = append(items, item)
+	}
+
+	return patients.PatientHistoryResponse{Items: items, NextCursor: response.NextCursor}
+}
+
+// KeepHidden returns the update request with the fields the role can not see
+// taken from the existing patient, along with the masked fields it sent back
+// as they were given to it. a role can then send back the patient it was
+// given without wiping or corrupting what it was not shown.
+func KeepHidden(role auth.Role, existing patients.Patient, request patients.UpdatePatientRequest) patients.UpdatePatientRequest {
+	existingFields := map[string]string{}
+
+	existingValue := reflect.ValueOf(existing)
+	for i := 0; i < existingValue.NumField(); i++ {
+		name, _, _ := strings.Cut(existingValue.Type().Field(i).Tag.Get("json"), ",")
+		if existingValue.Field(i).Kind() == reflect.String {
+			existingFields[name] = existingValue.Field(i).String()
+		}
+	}
+
+	requestValue := reflect.ValueOf(&request).Elem()
+	for i := 0; i < requestValue.NumField(); i++ {
+		name, _, _ := strings.Cut(requestValue.Type().Field(i).Tag.Get("json"), ",")
+		field := requestValue.Field(i)
+
+		existingField, ok := existingFields[name]
+		if !ok {
+			continue
+		}
+
+		switch FieldVisibility(role, name) {
+		case Hidden:
+			field.SetString(existingField)
+		case Masked:
+			if field.String() == maskField(name, existingField) {
+				field.SetString(existingField)
+			}
+		}
+	}
+
+	return request
+}
+
+// returns the json fields of a struct that the role can see, masking the ones
+// it can only partly see.
+func redact(role auth.Role, v interface{}) map[string]interface{} {
+	redacted := map[string]interface{}{}
+
+	value := reflect.ValueOf(v)
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, options, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fieldValue := value.Field(i)
+
+		if strings.Contains(options, "omitempty") && fieldValue.IsZero() {
+			continue
+		}
+
+		switch FieldVisibility(role, name) {
+		case Visible:
+			redacted[name] = fieldValue.Interface()
+		case Masked:
+			// only string fields are ever masked
+			redacted[name] = maskField(name, fieldValue.String())
+		}
+	}
+
+	return redacted
+}
+
+// masks the value of a field with the mask of the field, or completely when it
+// does not have one.
+func maskField(name string, value string) string {
+	if masked, ok := masks[name]; ok {
+		return masked(value)
+	}
+
+	return mask(value, 0, 0)
+}
+
+// replaces every character of the value with an asterisk, apart from the
+// first keepStart and the last keepEnd. values that are too short to keep
+// anything back are masked completely, and empty values are left empty.
+func mask(value string, keepStart int, keepEnd int) string {
+	runes := []rune(value)
+	if len(runes) <= keepStart+keepEnd {
+		return strings.Repeat("*", len(runes))
+	}
+
+	for i := keepStart; i < len(runes)-keepEnd; i++ {
+		if runes[i] != '-' && runes[i] != ' ' {
+			runes[i] = '*'
+		}
+	}
+
+	return string(runes)
+}
+
+// returns the json names of every patient field.
+func allFields() []string {
+	var names []string
+
+	t := reflect.TypeOf(patients.Patient{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func fields(visibility Visibility, names ...string) map[string]Visibility {
+	visibilities := map[string]Visibility{}
+	for _, name := range names {
+		visibilities[name] = visibility
+	}
+
+	return visibilities
+}
+
+func merge(policies ...map[string]Visibility) map[string]Visibility {
+	merged := map[string]Visibility{}
+	for _, policy := range policies {
+		for name, visibility := range policy {
+			merged[name] = visibility
+		}
+	}
+
+	return merged
+}
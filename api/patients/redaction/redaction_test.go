@@ -0,0 +1,213 @@
+package redaction
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+)
+
+func TestMask(t *testing.T) {
+	cases := []struct {
+		value     string
+		keepStart int
+		keepEnd   int
+		want      string
+	}{
+		{value: "QQ123456C", keepStart: 2, keepEnd: 1, want: "QQ******C"},
+		{value: "QQ 12 34 56 C", keepStart: 2, keepEnd: 1, want: "QQ ** ** ** C"},
+		{value: "1985-03-14", keepStart: 4, keepEnd: 0, want: "1985-**-**"},
+		{value: "QQ1", keepStart: 2, keepEnd: 1, want: "***"},
+		{value: "", keepStart: 2, keepEnd: 1, want: ""},
+	}
+
+	for _, c := range cases {
+		if got := mask(c.value, c.keepStart, c.keepEnd); got != c.want {
+			t.Errorf("mask(%q, %v, %v) got %q want %q", c.value, c.keepStart, c.keepEnd, got, c.want)
+		}
+	}
+}
+
+func TestPatient(t *testing.T) {
+	patient := patients.Patient{
+		PatientID:               "test_patient_id",
+		FirstName:               "Jane",
+		LastName:                "Doe",
+		NationalInsuranceNumber: "QQ123456C",
+		DateOfBirth:             "1985-03-14",
+		Ethnicity:               "test_ethnicity",
+		AddressLine1:            "1 Park Row",
+		EmergencyContactPhone:   "07700900123",
+		Version:                 3,
+	}
+
+	t.Run("every role sees who the patient is", func(t *testing.T) {
+		for _, role := range auth.Roles {
+			got := Patient(role, patient)
+
+			if got["patient_id"] != patient.PatientID || got["first_name"] != patient.FirstName || got["version"] != patient.Version {
+				t.Errorf("%v got %v want the patient id, name and version", role, got)
+			}
+		}
+	})
+
+	t.Run("an admin sees every field as it is", func(t *testing.T) {
+		got := Patient(auth.RoleAdmin, patient)
+
+		if got["national_insurance_number"] != "QQ123456C" || got["ethnicity"] != "test_ethnicity" {
+			t.Errorf("got %v want every field as it is", got)
+		}
+
+		// merged into is only returned for merged patients
+		if _, ok := got["merged_into"]; ok {
+			t.Errorf("got %v want merged into to be left out", got)
+		}
+	})
+
+	t.Run("each role sees only what it is permitted", func(t *testing.T) {
+		cases := map[auth.Role]map[string]interface{}{
+			auth.RoleDentist: {
+				"national_insurance_number": "QQ******C",
+				"date_of_birth":             "1985-03-14",
+				"ethnicity":                 "test_ethnicity",
+			},
+			auth.RoleHygienist: {
+				"national_insurance_number": "QQ******C",
+				"date_of_birth":             "1985-03-14",
+				"ethnicity":                 nil,
+			},
+			auth.RoleReceptionist: {
+				"national_insurance_number": "QQ******C",
+				"emergency_contact_phone":   "07700900123",
+				"ethnicity":                 nil,
+			},
+			auth.RoleFinance: {
+				"national_insurance_number": nil,
+				"date_of_birth":             "1985-**-**",
+				"address_line_1":            "1 Park Row",
+				"emergency_contact_phone":   nil,
+				"ethnicity":                 nil,
+			},
+		}
+
+		for role, want := range cases {
+			got := Patient(role, patient)
+
+			for field, value := range want {
+				if diff := cmp.Diff(got[field], value); diff != "" {
+					t.Errorf("%v saw an unexpected %v %v", role, field, diff)
+				}
+			}
+		}
+	})
+
+	t.Run("a role that is not known is not allowed", func(t *testing.T) {
+		for _, role := range []auth.Role{"", "patient"} {
+			if Allowed(role) {
+				t.Errorf("%q should not be allowed", role)
+			}
+		}
+
+		for _, role := range auth.Roles {
+			if !Allowed(role) {
+				t.Errorf("%q should be allowed", role)
+			}
+		}
+	})
+}
+
+func TestHistory(t *testing.T) {
+	history := patients.PatientHistoryResponse{
+		Items: []patients.PatientHistoryItem{
+			{
+				PatientID: "test_patient_id",
+				Version:   2,
+				Operation: "update",
+				Changes: []patients.FieldChange{
+					{Field: "first_name", Before: "Jane", After: "Janet"},
+					{Field: "national_insurance_number", Before: "", After: "QQ123456C"},
+					{Field: "ethnicity", Before: "test_ethnicity", After: "another_ethnicity"},
+				},
+			},
+		},
+		NextCursor: "test_cursor",
+	}
+
+	t.Run("an admin sees every change as it is", func(t *testing.T) {
+		if diff := cmp.Diff(History(auth.RoleAdmin, history), history); diff != "" {
+			t.Error("unexpected history", diff)
+		}
+	})
+
+	t.Run("changes are masked or taken out by field", func(t *testing.T) {
+		want := patients.PatientHistoryResponse{
+			Items: []patients.PatientHistoryItem{
+				{
+					PatientID: "test_patient_id",
+					Version:   2,
+					Operation: "update",
+					Changes: []patients.FieldChange{
+						{Field: "first_name", Before: "Jane", After: "Janet"},
+						{Field: "national_insurance_number", Before: "", After: "QQ******C"},
+					},
+				},
+			},
+			NextCursor: "test_cursor",
+		}
+
+		if diff := cmp.Diff(History(auth.RoleReceptionist, history), want); diff != "" {
+			t.Error("unexpected history", diff)
+		}
+
+		// the history itself is left as it is
+		if len(history.Items[0].Changes) != 3 || history.Items[0].Changes[1].After != "QQ123456C" {
+			t.Errorf("got %+v want the history to be unchanged", history.Items[0].Changes)
+		}
+	})
+}
+
+func TestKeepHidden(t *testing.T) {
+	existing := patients.Patient{
+		PatientID:               "test_patient_id",
+		FirstName:               "Jane",
+		NationalInsuranceNumber: "QQ123456C",
+		DateOfBirth:             "1985-03-14",
+		Ethnicity:               "test_ethnicity",
+		Occupation:              "test_occupation",
+	}
+
+	t.Run("a role keeps the fields it was not shown when it sends back what it was given", func(t *testing.T) {
+		request := patients.UpdatePatientRequest{
+			PatientID:               "test_patient_id",
+			FirstName:               "Janet",
+			NationalInsuranceNumber: "QQ******C",
+			DateOfBirth:             "1985-03-14",
+		}
+
+		want := request
+		want.NationalInsuranceNumber = "QQ123456C"
+		want.Ethnicity = "test_ethnicity"
+		want.Occupation = "test_occupation"
+
+		if diff := cmp.Diff(KeepHidden(auth.RoleReceptionist, existing, request), want); diff != "" {
+			t.Error("unexpected update request", diff)
+		}
+	})
+
+	t.Run("a role can still change a field it only sees masked", func(t *testing.T) {
+		request := patients.UpdatePatientRequest{FirstName: "Jane", NationalInsuranceNumber: "QQ654321C"}
+
+		if got := KeepHidden(auth.RoleReceptionist, existing, request); got.NationalInsuranceNumber != "QQ654321C" {
+			t.Errorf("got national insurance number %q want %q", got.NationalInsuranceNumber, "QQ654321C")
+		}
+	})
+
+	t.Run("a role that sees every field can clear any of them", func(t *testing.T) {
+		request := patients.UpdatePatientRequest{FirstName: "Jane"}
+
+		if diff := cmp.Diff(KeepHidden(auth.RoleAdmin, existing, request), request); diff != "" {
+			t.Error("unexpected update request", diff)
+		}
+	})
+}
@@ -2,44 +2,311 @@ package search
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
 	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/apierror"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/redaction"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
 	"go.uber.org/zap"
 )
 
 const contentTypeHeader string = "content-type"
 const jsonContentType string = "application/json"
 
+// the query string params that can be used to filter the list of patients.
+var listFilterParams = []string{"active", "assigned_dentist", "assigned_hygienist", "created_from", "created_to"}
+
 func SearchPatientsHandler(logger *zap.Logger, repository patients.PatientRepository) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context(), logger)
+
 		logger.Info("running the search patients handler...")
 
+		identity, ok := auth.FromContext(r.Context())
+		if !ok {
+			logger.Error("no dental practice was found for the request")
+			problem.Error(w, r, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// the fields returned depend on the role, so a role that is not known
+		// gets nothing
+		if !redaction.Allowed(identity.Role) {
+			logger.Error("the role is not allowed to read patients", zap.String("role", string(identity.Role)))
+			problem.Error(w, r, "forbidden", http.StatusForbidden)
+			return
+		}
+
 		w.Header().Set(contentTypeHeader, jsonContentType)
 
-		v, exist := r.URL.Query()["search"]
-		if !exist {
-			logger.Error("no search term set as part of the query string params")
-			http.Error(w, "bad request", http.StatusBadRequest)
+		// callers with different roles see different fields of the same patients
+		w.Header().Set("vary", "authorization")
+
+		query := r.URL.Query()
+
+		limit, err := parseLimit(query.Get("limit"))
+		if err != nil {
+			logger.Error("the limit query string param is invalid", zap.Error(err))
+			problem.Error(w, r, fmt.Sprintf("limit must be a number between 1 and %v", patients.MaxSearchLimit), http.StatusBadRequest)
 			return
 		}
 
-		searchTerm := v[0]
+		var results patients.PatientSearchResponse
+
+		// without a search term the patients of the practice are listed instead
+		v, exist := query["search"]
+		if exist {
+			for _, param := range listFilterParams {
+				if query.Has(param) {
+					logger.Error("a list filter was used together with a search term", zap.String("param", param))
+					problem.Error(w, r, fmt.Sprintf("%v can only be used when listing patients", param), http.StatusBadRequest)
+					return
+				}
+			}
+
+			field, parseErr := parseSearchField(identity.Role, query.Get("field"))
+			if errors.Is(parseErr, errFieldNotVisible) {
+				logger.Error("the field query string param is not visible to the role", zap.String("role", string(identity.Role)), zap.Error(parseErr))
+				problem.Error(w, r, parseErr.Error(), http.StatusForbidden)
+				return
+			}
+
+			if parseErr != nil {
+				logger.Error("the field query string param is invalid", zap.Error(parseErr))
+				problem.Error(w, r, parseErr.Error(), http.StatusBadRequest)
+				return
+			}
+
+			fuzzy, parseErr := parseFuzzy(query, field)
+			if parseErr != nil {
+				logger.Error("the fuzzy query string param is invalid", zap.Error(parseErr))
+				problem.Error(w, r, parseErr.Error(), http.StatusBadRequest)
+				return
+			}
+
+			searchTerm := v[0]
+
+			// searches for more than one name return a single ranked page
+			if field == patients.SearchFieldName && len(patients.SearchTokens(searchTerm)) > 1 && query.Get("cursor") != "" {
+				logger.Error("a cursor was used with a multi name search")
+				problem.Error(w, r, "searches for more than one name can not be paged through with a cursor", http.StatusBadRequest)
+				return
+			}
+
+			logger := logger.With(zap.String("searchTerm", searchTerm), zap.String("field", string(field)), zap.Bool("fuzzy", fuzzy))
+
+			results, err = repository.SearchPatients(logging.NewContext(r.Context(), logger), identity.DentalPracticeID, patients.SearchPatientsRequest{
+				SearchTerm: searchTerm,
+				Field:      field,
+				Fuzzy:      fuzzy,
+				Limit:      limit,
+				Cursor:     query.Get("cursor"),
+			})
+		} else {
+			for _, param := range []string{"field", "fuzzy"} {
+				if query.Has(param) {
+					logger.Error("a search param was used without a search term", zap.String("param", param))
+					problem.Error(w, r, fmt.Sprintf("%v can only be used together with search", param), http.StatusBadRequest)
+					return
+				}
+			}
 
-		logger = logger.With(zap.String("searchTerm", searchTerm))
+			listPatientsRequest, parseErr := parseListPatientsRequest(query)
+			if parseErr != nil {
+				logger.Error("the list filters are invalid", zap.Error(parseErr))
+				problem.Error(w, r, parseErr.Error(), http.StatusBadRequest)
+				return
+			}
+
+			listPatientsRequest.Limit = limit
+			listPatientsRequest.Cursor = query.Get("cursor")
+
+			results, err = repository.ListPatients(logging.NewContext(r.Context(), logger), identity.DentalPracticeID, listPatientsRequest)
+		}
+
+		if errors.Is(err, patients.ErrInvalidCursor) {
+			logger.Error("the cursor query string param is invalid", zap.Error(err))
+			problem.Error(w, r, "cursor is invalid", http.StatusBadRequest)
+			return
+		}
+
+		// encrypted fields can only be matched against whole values
+		if errors.Is(err, patients.ErrPartialSearchTerm) {
+			logger.Error("an encrypted field was searched for part of a value", zap.Error(err))
+			problem.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		searchResults, err := repository.SearchPatients(logger, r.Context(), searchTerm)
 		if err != nil {
 			logger.Error("failed to search patients", zap.Error(err))
-			http.Error(w, "requested patient could not be found", http.StatusNotFound)
+			apierror.Write(w, r, err, "failed to search patients")
 			return
 		}
 
-		err = json.NewEncoder(w).Encode(searchResults)
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(redaction.Search(identity.Role, results))
 		if err != nil {
 			logger.Error("error in json marshal", zap.Error(err))
 		}
-
-		w.WriteHeader(http.StatusOK)
 	})
 }
+
+// parses the limit query string param, falling back to the default page size
+// when it is not set.
+func parseLimit(value string) (int32, error) {
+	if value == "" {
+		return patients.DefaultSearchLimit, nil
+	}
+
+	limit, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	if limit < 1 || int32(limit) > patients.MaxSearchLimit {
+		return 0, errors.New("limit is out of range")
+	}
+
+	return int32(limit), nil
+}
+
+// errFieldNotVisible is returned when the field query string param names a
+// field the role can not see in full, which would tell them what it holds.
+var errFieldNotVisible = errors.New("forbidden")
+
+// the patient fields, by their json names, that a search field is matched
+// against. every other search field is matched against the field of the same
+// name.
+var searchFieldPatientFields = map[patients.SearchField][]string{
+	patients.SearchFieldName: {"first_name", "last_name"},
+}
+
+// parses the field query string param, names are searched when it is not set.
+// a role can only search by the fields it sees in full, and is told so for any
+// patient field it can not, whether or not it could be searched by.
+func parseSearchField(role auth.Role, value string) (patients.SearchField, error) {
+	if value == "" {
+		return patients.SearchFieldName, nil
+	}
+
+	notVisible := func(field string) bool {
+		return redaction.FieldVisibility(role, field) != redaction.Visible
+	}
+
+	var fields []string
+	for _, field := range patients.SearchFields {
+		if string(field) == value {
+			patientFields, ok := searchFieldPatientFields[field]
+			if !ok {
+				patientFields = []string{string(field)}
+			}
+
+			for _, patientField := range patientFields {
+				if notVisible(patientField) {
+					return "", fmt.Errorf("%w: %v is not visible in full to the %v role", errFieldNotVisible, value, role)
+				}
+			}
+
+			return field, nil
+		}
+
+		fields = append(fields, string(field))
+	}
+
+	if redaction.IsField(value) && notVisible(value) {
+		return "", fmt.Errorf("%w: %v is not visible in full to the %v role", errFieldNotVisible, value, role)
+	}
+
+	return "", fmt.Errorf("field must be one of %v", strings.Join(fields, ", "))
+}
+
+// parses the fuzzy query string param. fuzzy searches only match names, and
+// return a single ranked page that can not be paged through with a cursor.
+func parseFuzzy(query url.Values, field patients.SearchField) (bool, error) {
+	if !query.Has("fuzzy") {
+		return false, nil
+	}
+
+	fuzzy, err := strconv.ParseBool(query.Get("fuzzy"))
+	if err != nil {
+		return false, errors.New("fuzzy must be either true or false")
+	}
+
+	if fuzzy && field != patients.SearchFieldName {
+		return false, errors.New("only names can be searched fuzzily")
+	}
+
+	if fuzzy && query.Get("cursor") != "" {
+		return false, errors.New("fuzzy search results can not be paged through with a cursor")
+	}
+
+	return fuzzy, nil
+}
+
+// parses the filters used when listing patients from the query string.
+func parseListPatientsRequest(query url.Values) (patients.ListPatientsRequest, error) {
+	listPatientsRequest := patients.ListPatientsRequest{
+		AssignedDentist:   query.Get("assigned_dentist"),
+		AssignedHygienist: query.Get("assigned_hygienist"),
+	}
+
+	if query.Has("active") {
+		active, err := strconv.ParseBool(query.Get("active"))
+		if err != nil {
+			return listPatientsRequest, errors.New("active must be either true or false")
+		}
+
+		listPatientsRequest.Active = &active
+	}
+
+	var err error
+	listPatientsRequest.CreatedFrom, err = parseCreatedBound(query.Get("created_from"), false)
+	if err != nil {
+		return listPatientsRequest, errors.New("created_from must be a date (2006-01-02) or a timestamp (2006-01-02T15:04:05Z)")
+	}
+
+	listPatientsRequest.CreatedTo, err = parseCreatedBound(query.Get("created_to"), true)
+	if err != nil {
+		return listPatientsRequest, errors.New("created_to must be a date (2006-01-02) or a timestamp (2006-01-02T15:04:05Z)")
+	}
+
+	if listPatientsRequest.CreatedFrom != "" && listPatientsRequest.CreatedTo != "" && listPatientsRequest.CreatedFrom > listPatientsRequest.CreatedTo {
+		return listPatientsRequest, errors.New("created_from must not be after created_to")
+	}
+
+	return listPatientsRequest, nil
+}
+
+// turns a date or timestamp into the utc rfc 3339 format the created at
+// attribute is stored in. a date on its own covers the whole day, so it is the
+// start of the day for a lower bound and the end of the day for an upper bound.
+func parseCreatedBound(value string, upper bool) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		if upper {
+			date = date.Add(24*time.Hour - time.Second)
+		}
+
+		return date.UTC().Format(time.RFC3339), nil
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", err
+	}
+
+	return timestamp.UTC().Format(time.RFC3339), nil
+}
@@ -3,52 +3,103 @@ package search
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
 	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
 	"go.uber.org/zap"
 )
 
 type StubPatientStore struct {
-	createPatient  func(logger *zap.Logger, ctx context.Context, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error)
-	getPatient     func(logger *zap.Logger, ctx context.Context, patientID string) (patients.Patient, error)
-	searchPatients func(logger *zap.Logger, ctx context.Context, searchTerm string) ([]patients.PatientSearchResponseItem, error)
+	createPatient             func(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error)
+	getPatient                func(ctx context.Context, dentalPracticeID string, patientID string) (patients.Patient, error)
+	searchPatients            func(ctx context.Context, dentalPracticeID string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error)
+	updatePatient             func(ctx context.Context, dentalPracticeID string, patient patients.UpdatePatientRequest, expectedVersion int) (patients.Patient, error)
+	listPatients              func(ctx context.Context, dentalPracticeID string, request patients.ListPatientsRequest) (patients.PatientSearchResponse, error)
+	findDuplicatePatients     func(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) ([]string, error)
+	mergePatients             func(ctx context.Context, dentalPracticeID string, request patients.MergePatientsRequest, expectedVersion int) (patients.Patient, error)
+	getPatientHistory         func(ctx context.Context, dentalPracticeID string, request patients.PatientHistoryRequest) (patients.PatientHistoryResponse, error)
+	getIdempotentResponse     func(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey) (patients.CreatePatientResponse, error)
+	createPatientIdempotently func(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error)
 }
 
-func (s *StubPatientStore) CreatePatient(logger *zap.Logger, ctx context.Context, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
-	return s.createPatient(logger, ctx, patient)
+func (s *StubPatientStore) CreatePatient(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+	return s.createPatient(ctx, dentalPracticeID, patient)
 }
 
-func (s *StubPatientStore) GetPatient(logger *zap.Logger, ctx context.Context, patientID string) (patients.Patient, error) {
-	return s.getPatient(logger, ctx, patientID)
+func (s *StubPatientStore) GetPatient(ctx context.Context, dentalPracticeID string, patientID string) (patients.Patient, error) {
+	return s.getPatient(ctx, dentalPracticeID, patientID)
 }
 
-func (s *StubPatientStore) SearchPatients(logger *zap.Logger, ctx context.Context, searchTerm string) ([]patients.PatientSearchResponseItem, error) {
-	return s.searchPatients(logger, ctx, searchTerm)
+func (s *StubPatientStore) SearchPatients(ctx context.Context, dentalPracticeID string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+	return s.searchPatients(ctx, dentalPracticeID, request)
+}
+
+func (s *StubPatientStore) UpdatePatient(ctx context.Context, dentalPracticeID string, patient patients.UpdatePatientRequest, expectedVersion int) (patients.Patient, error) {
+	return s.updatePatient(ctx, dentalPracticeID, patient, expectedVersion)
+}
+
+func (s *StubPatientStore) ListPatients(ctx context.Context, dentalPracticeID string, request patients.ListPatientsRequest) (patients.PatientSearchResponse, error) {
+	return s.listPatients(ctx, dentalPracticeID, request)
+}
+
+func (s *StubPatientStore) FindDuplicatePatients(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) ([]string, error) {
+	return s.findDuplicatePatients(ctx, dentalPracticeID, patient)
+}
+
+func (s *StubPatientStore) MergePatients(ctx context.Context, dentalPracticeID string, request patients.MergePatientsRequest, expectedVersion int) (patients.Patient, error) {
+	return s.mergePatients(ctx, dentalPracticeID, request, expectedVersion)
+}
+
+func (s *StubPatientStore) GetPatientHistory(ctx context.Context, dentalPracticeID string, request patients.PatientHistoryRequest) (patients.PatientHistoryResponse, error) {
+	return s.getPatientHistory(ctx, dentalPracticeID, request)
+}
+
+func (s *StubPatientStore) GetIdempotentResponse(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey) (patients.CreatePatientResponse, error) {
+	return s.getIdempotentResponse(ctx, dentalPracticeID, key)
+}
+
+func (s *StubPatientStore) CreatePatientIdempotently(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+	return s.createPatientIdempotently(ctx, dentalPracticeID, key, patient)
 }
 
 func TestSearchPatient(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
 	// create the logger
 	logger, _ := zap.NewProduction()
 
-	t.Run("returns a bad request if endpoint is called without search query param", func(t *testing.T) {
-		var p = make([]patients.PatientSearchResponseItem, 0)
+	t.Run("lists the patients if endpoint is called without search query param", func(t *testing.T) {
+		expectedPatients := patients.PatientSearchResponse{
+			Items: []patients.PatientSearchResponseItem{
+				{PatientID: "test_patient_id_1", FirstName: "jamie", LastName: "oliver"},
+			},
+		}
 
 		// create the stub patient store
 		patientStore := StubPatientStore{
-			searchPatients: func(_ *zap.Logger, _ context.Context, searchTerm string) ([]patients.PatientSearchResponseItem, error) {
-				return p, nil
+			searchPatients: func(_ context.Context, _ string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+				t.Error("SearchPatients() should not be called without a search term")
+				return patients.PatientSearchResponse{}, nil
+			},
+			listPatients: func(_ context.Context, _ string, request patients.ListPatientsRequest) (patients.PatientSearchResponse, error) {
+				return expectedPatients, nil
 			},
 		}
 
 		// create a request to pass to our handler
 		req, _ := http.NewRequest("GET", "/patients", nil)
 
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
 		// create a response recorder
 		res := httptest.NewRecorder()
 
@@ -59,17 +110,98 @@ func TestSearchPatient(t *testing.T) {
 		// directly and pass in our request and response recorder
 		handler.ServeHTTP(res, req)
 
+		// decode the json response into patients.PatientSearchResponse
+		got := getPatientsFromResponse(t, res.Body)
+
 		// assert status code is what we expect
-		assertStatusCode(t, res.Code, http.StatusBadRequest)
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		// assert the listed patients are returned
+		assertSearchResponse(t, got, expectedPatients)
+	})
+
+	t.Run("check that the list filters are getting passed to the patients store", func(t *testing.T) {
+		active := false
+		expectedRequest := patients.ListPatientsRequest{
+			Active:            &active,
+			AssignedDentist:   "test_dentist_id",
+			AssignedHygienist: "test_hygienist_id",
+			CreatedFrom:       "2022-10-01T00:00:00Z",
+			CreatedTo:         "2022-10-31T23:59:59Z",
+			Limit:             10,
+			Cursor:            "test_cursor",
+		}
+
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			listPatients: func(_ context.Context, _ string, request patients.ListPatientsRequest) (patients.PatientSearchResponse, error) {
+				if diff := cmp.Diff(request, expectedRequest); diff != "" {
+					t.Error("unexpected list request passed to ListPatients()", diff)
+				}
+
+				return patients.PatientSearchResponse{Items: []patients.PatientSearchResponseItem{}}, nil
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients?active=false&assigned_dentist=test_dentist_id&assigned_hygienist=test_hygienist_id&created_from=2022-10-01&created_to=2022-10-31&limit=10&cursor=test_cursor", nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := SearchPatientsHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+	})
+
+	t.Run("returns a bad request when a list filter is invalid", func(t *testing.T) {
+		for _, queryString := range []string{
+			"active=maybe",
+			"created_from=01/10/2022",
+			"created_to=yesterday",
+			"created_from=2022-10-31&created_to=2022-10-01",
+			"search=jam&active=true",
+		} {
+			// create the stub patient store
+			patientStore := StubPatientStore{}
+
+			// create a request to pass to our handler
+			req, _ := http.NewRequest("GET", fmt.Sprintf("/patients?%v", queryString), nil)
+
+			// set the dental practice the request is made on behalf of
+			req = withDentalPractice(req, dentalPracticeID)
+
+			// create a response recorder
+			res := httptest.NewRecorder()
+
+			// get the handler
+			handler := SearchPatientsHandler(logger, &patientStore)
+
+			// our handler satisfies http.handler, so we can call its serve http method
+			// directly and pass in our request and response recorder
+			handler.ServeHTTP(res, req)
+
+			// assert status code is what we expect
+			assertStatusCode(t, res.Code, http.StatusBadRequest)
+		}
 	})
 
 	t.Run("returns empty list when search param has no value", func(t *testing.T) {
 		searchParam := ""
-		var p = make([]patients.PatientSearchResponseItem, 0)
+		var p = patients.PatientSearchResponse{Items: make([]patients.PatientSearchResponseItem, 0)}
 
 		// create the stub patient store
 		patientStore := StubPatientStore{
-			searchPatients: func(_ *zap.Logger, _ context.Context, searchTerm string) ([]patients.PatientSearchResponseItem, error) {
+			searchPatients: func(_ context.Context, _ string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
 				return p, nil
 			},
 		}
@@ -77,6 +209,9 @@ func TestSearchPatient(t *testing.T) {
 		// create a request to pass to our handler
 		req, _ := http.NewRequest("GET", fmt.Sprintf("/patients?search=%v", searchParam), nil)
 
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
 		// create a response recorder
 		res := httptest.NewRecorder()
 
@@ -87,7 +222,7 @@ func TestSearchPatient(t *testing.T) {
 		// directly and pass in our request and response recorder
 		handler.ServeHTTP(res, req)
 
-		// decode the json response into []patients.PatientSearchResponseItem
+		// decode the json response into patients.PatientSearchResponse
 		got := getPatientsFromResponse(t, res.Body)
 
 		// assert status code is what we expect
@@ -102,18 +237,21 @@ func TestSearchPatient(t *testing.T) {
 
 		// create the stub patient store
 		patientStore := StubPatientStore{
-			searchPatients: func(_ *zap.Logger, _ context.Context, searchTerm string) ([]patients.PatientSearchResponseItem, error) {
-				if searchTerm != searchParam {
-					t.Errorf("%q was passed to SearchPatients() but the expected value was %q", searchTerm, searchParam)
+			searchPatients: func(_ context.Context, _ string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+				if request.SearchTerm != searchParam {
+					t.Errorf("%q was passed to SearchPatients() but the expected value was %q", request.SearchTerm, searchParam)
 				}
 
-				return []patients.PatientSearchResponseItem{}, nil
+				return patients.PatientSearchResponse{Items: []patients.PatientSearchResponseItem{}}, nil
 			},
 		}
 
 		// create a request to pass to our handler
 		req, _ := http.NewRequest("GET", fmt.Sprintf("/patients?search=%v", searchParam), nil)
 
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
 		// create a response recorder
 		res := httptest.NewRecorder()
 
@@ -124,21 +262,179 @@ func TestSearchPatient(t *testing.T) {
 		// directly and pass in our request and response recorder
 		handler.ServeHTTP(res, req)
 
-		// decode the json response into []patients.PatientSearchResponseItem
+		// decode the json response into patients.PatientSearchResponse
 		getPatientsFromResponse(t, res.Body)
 	})
 
+	t.Run("check that the field param is getting passed to the patients store", func(t *testing.T) {
+		cases := []struct {
+			query string
+			want  patients.SearchField
+		}{
+			{query: "search=jam", want: patients.SearchFieldName},
+			{query: "search=jane%40example.com&field=email", want: patients.SearchFieldEmail},
+			{query: "search=07700&field=mobile_phone", want: patients.SearchFieldMobilePhone},
+			{query: "search=LS18&field=post_code", want: patients.SearchFieldPostCode},
+			{query: "search=1985-03-14&field=date_of_birth", want: patients.SearchFieldDateOfBirth},
+		}
+
+		for _, c := range cases {
+			// create the stub patient store
+			patientStore := StubPatientStore{
+				searchPatients: func(_ context.Context, _ string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+					if request.Field != c.want {
+						t.Errorf("%q was passed to SearchPatients() but the expected value was %q", request.Field, c.want)
+					}
+
+					return patients.PatientSearchResponse{Items: []patients.PatientSearchResponseItem{}}, nil
+				},
+			}
+
+			// create a request to pass to our handler
+			req, _ := http.NewRequest("GET", "/patients?"+c.query, nil)
+
+			// set the dental practice the request is made on behalf of
+			req = withDentalPractice(req, dentalPracticeID)
+
+			// create a response recorder
+			res := httptest.NewRecorder()
+
+			// get the handler
+			handler := SearchPatientsHandler(logger, &patientStore)
+
+			// our handler satisfies http.handler, so we can call its serve http method
+			// directly and pass in our request and response recorder
+			handler.ServeHTTP(res, req)
+
+			// assert status code is what we expect
+			assertStatusCode(t, res.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("returns a bad request when the field param is invalid", func(t *testing.T) {
+		for _, query := range []string{"search=x&field=ethnicity", "field=email"} {
+			// create the stub patient store
+			patientStore := StubPatientStore{
+				searchPatients: func(_ context.Context, _ string, _ patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+					t.Error("SearchPatients() should not be called with an invalid field")
+					return patients.PatientSearchResponse{}, nil
+				},
+				listPatients: func(_ context.Context, _ string, _ patients.ListPatientsRequest) (patients.PatientSearchResponse, error) {
+					t.Error("ListPatients() should not be called with a field")
+					return patients.PatientSearchResponse{}, nil
+				},
+			}
+
+			// create a request to pass to our handler
+			req, _ := http.NewRequest("GET", "/patients?"+query, nil)
+
+			// set the dental practice the request is made on behalf of
+			req = withDentalPractice(req, dentalPracticeID)
+
+			// create a response recorder
+			res := httptest.NewRecorder()
+
+			// get the handler
+			handler := SearchPatientsHandler(logger, &patientStore)
+
+			// our handler satisfies http.handler, so we can call its serve http method
+			// directly and pass in our request and response recorder
+			handler.ServeHTTP(res, req)
+
+			// assert status code is what we expect
+			assertStatusCode(t, res.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("check that the fuzzy param is getting passed to the patients store", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			searchPatients: func(_ context.Context, _ string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+				if !request.Fuzzy {
+					t.Error("a fuzzy search was not passed to SearchPatients()")
+				}
+
+				return patients.PatientSearchResponse{Items: []patients.PatientSearchResponseItem{}}, nil
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients?search=steven&fuzzy=true", nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := SearchPatientsHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+	})
+
+	t.Run("returns a bad request when a ranked search is paged or the fuzzy param is invalid", func(t *testing.T) {
+		queries := []string{
+			"search=steven&fuzzy=maybe",
+			"search=jane%40&field=email&fuzzy=true",
+			"search=steven&fuzzy=true&cursor=abc",
+			"search=john+smi&cursor=abc",
+			"fuzzy=true",
+		}
+
+		for _, query := range queries {
+			// create the stub patient store
+			patientStore := StubPatientStore{
+				searchPatients: func(_ context.Context, _ string, _ patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+					t.Errorf("SearchPatients() should not be called for %q", query)
+					return patients.PatientSearchResponse{}, nil
+				},
+				listPatients: func(_ context.Context, _ string, _ patients.ListPatientsRequest) (patients.PatientSearchResponse, error) {
+					t.Errorf("ListPatients() should not be called for %q", query)
+					return patients.PatientSearchResponse{}, nil
+				},
+			}
+
+			// create a request to pass to our handler
+			req, _ := http.NewRequest("GET", "/patients?"+query, nil)
+
+			// set the dental practice the request is made on behalf of
+			req = withDentalPractice(req, dentalPracticeID)
+
+			// create a response recorder
+			res := httptest.NewRecorder()
+
+			// get the handler
+			handler := SearchPatientsHandler(logger, &patientStore)
+
+			// our handler satisfies http.handler, so we can call its serve http method
+			// directly and pass in our request and response recorder
+			handler.ServeHTTP(res, req)
+
+			// assert status code is what we expect
+			assertStatusCode(t, res.Code, http.StatusBadRequest)
+		}
+	})
+
 	t.Run("check response is correct when an array of patients is returned", func(t *testing.T) {
 		searchParam := "jam"
 
-		expectedPatients := []patients.PatientSearchResponseItem{
-			{PatientID: "test_patient_id_1", FirstName: "jamie", LastName: "oliver", DateOfBirth: "test_dob", Email: "j.oliver@gmail.com", MobilePhone: "07865154788", PostCode: "LS18 9BQ"},
-			{PatientID: "test_patient_id_2", FirstName: "james", LastName: "watt", DateOfBirth: "test_dob", Email: "j.watt@gmail.com", MobilePhone: "07531247866", PostCode: "LS1 3LP"},
+		expectedPatients := patients.PatientSearchResponse{
+			Items: []patients.PatientSearchResponseItem{
+				{PatientID: "test_patient_id_1", FirstName: "jamie", LastName: "oliver", DateOfBirth: "test_dob", Email: "j.oliver@gmail.com", MobilePhone: "07865154788", PostCode: "LS18 9BQ"},
+				{PatientID: "test_patient_id_2", FirstName: "james", LastName: "watt", DateOfBirth: "test_dob", Email: "j.watt@gmail.com", MobilePhone: "07531247866", PostCode: "LS1 3LP"},
+			},
+			NextCursor: "test_cursor",
 		}
 
 		// create the stub patient store
 		patientStore := StubPatientStore{
-			searchPatients: func(_ *zap.Logger, _ context.Context, searchTerm string) ([]patients.PatientSearchResponseItem, error) {
+			searchPatients: func(_ context.Context, _ string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
 				return expectedPatients, nil
 			},
 		}
@@ -146,6 +442,9 @@ func TestSearchPatient(t *testing.T) {
 		// create a request to pass to our handler
 		req, _ := http.NewRequest("GET", fmt.Sprintf("/patients?search=%v", searchParam), nil)
 
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
 		// create a response recorder
 		res := httptest.NewRecorder()
 
@@ -156,7 +455,7 @@ func TestSearchPatient(t *testing.T) {
 		// directly and pass in our request and response recorder
 		handler.ServeHTTP(res, req)
 
-		// decode the json response into []patients.PatientSearchResponseItem
+		// decode the json response into patients.PatientSearchResponse
 		got := getPatientsFromResponse(t, res.Body)
 
 		// assert status code is what we expect
@@ -165,6 +464,366 @@ func TestSearchPatient(t *testing.T) {
 		// assert response is an epty json list
 		assertSearchResponse(t, got, expectedPatients)
 	})
+
+	t.Run("returns 401 when the request is not made on behalf of a dental practice", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			searchPatients: func(_ context.Context, _ string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+				t.Error("SearchPatients() should not be called without a dental practice")
+				return patients.PatientSearchResponse{}, nil
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients?search=jam", nil)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := SearchPatientsHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("check that the dental practice of the request is getting passed to the patients store", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			searchPatients: func(_ context.Context, requestedDentalPracticeID string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+				if requestedDentalPracticeID != dentalPracticeID {
+					t.Errorf("%q was passed to SearchPatients() but the expected value was %q", requestedDentalPracticeID, dentalPracticeID)
+				}
+
+				return patients.PatientSearchResponse{Items: []patients.PatientSearchResponseItem{}}, nil
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients?search=jam", nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := SearchPatientsHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+	})
+
+	t.Run("check that the limit and cursor params are getting passed to the patients store", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			searchPatients: func(_ context.Context, _ string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+				if request.Limit != 10 {
+					t.Errorf("%v was passed as the limit to SearchPatients() but the expected value was %v", request.Limit, 10)
+				}
+
+				if request.Cursor != "test_cursor" {
+					t.Errorf("%q was passed as the cursor to SearchPatients() but the expected value was %q", request.Cursor, "test_cursor")
+				}
+
+				return patients.PatientSearchResponse{Items: []patients.PatientSearchResponseItem{}}, nil
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients?search=jam&limit=10&cursor=test_cursor", nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := SearchPatientsHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+	})
+
+	t.Run("uses the default limit when no limit param is set", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			searchPatients: func(_ context.Context, _ string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+				if request.Limit != patients.DefaultSearchLimit {
+					t.Errorf("%v was passed as the limit to SearchPatients() but the expected value was %v", request.Limit, patients.DefaultSearchLimit)
+				}
+
+				return patients.PatientSearchResponse{Items: []patients.PatientSearchResponseItem{}}, nil
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients?search=jam", nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := SearchPatientsHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+	})
+
+	t.Run("returns a bad request when the limit param is invalid", func(t *testing.T) {
+		for _, limit := range []string{"0", "-1", "101", "ten"} {
+			// create the stub patient store
+			patientStore := StubPatientStore{}
+
+			// create a request to pass to our handler
+			req, _ := http.NewRequest("GET", fmt.Sprintf("/patients?search=jam&limit=%v", limit), nil)
+
+			// set the dental practice the request is made on behalf of
+			req = withDentalPractice(req, dentalPracticeID)
+
+			// create a response recorder
+			res := httptest.NewRecorder()
+
+			// get the handler
+			handler := SearchPatientsHandler(logger, &patientStore)
+
+			// our handler satisfies http.handler, so we can call its serve http method
+			// directly and pass in our request and response recorder
+			handler.ServeHTTP(res, req)
+
+			// assert status code is what we expect
+			assertStatusCode(t, res.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("returns a bad request when the cursor param is invalid", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			searchPatients: func(_ context.Context, _ string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+				return patients.PatientSearchResponse{}, patients.ErrInvalidCursor
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients?search=jam&cursor=not_a_cursor", nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := SearchPatientsHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusBadRequest)
+	})
+
+	t.Run("returns a bad request when an encrypted field is searched for part of a value", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			searchPatients: func(_ context.Context, _ string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+				return patients.PatientSearchResponse{}, fmt.Errorf("%v is encrypted, so %w", request.Field, patients.ErrPartialSearchTerm)
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients?search=07700&field=mobile_phone", nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := SearchPatientsHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusBadRequest)
+
+		// the problem says why the search was rejected
+		if body := res.Body.String(); !strings.Contains(body, "mobile_phone is encrypted, so only whole values can be searched for") {
+			t.Errorf("got body %v want it to say the field is encrypted", body)
+		}
+	})
+
+	t.Run("returns 503 when the database is throttling requests", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			searchPatients: func(_ context.Context, _ string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+				return patients.PatientSearchResponse{}, fmt.Errorf("could not search patients: %w", patients.ErrThrottled)
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients?search=jam", nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := SearchPatientsHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusServiceUnavailable)
+	})
+
+	t.Run("returns 500 when searching fails unexpectedly", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			searchPatients: func(_ context.Context, _ string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+				return patients.PatientSearchResponse{}, errors.New("could not unmarshal response")
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients?search=jam", nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := SearchPatientsHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusInternalServerError)
+	})
+}
+
+func TestSearchPatientWithMemoryStore(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	// create the logger
+	logger, _ := zap.NewProduction()
+
+	// create an in memory patient store holding a few patients
+	patientStore := patients.NewMemoryPatientStore()
+	for _, firstName := range []string{"James", "Jamie", "Janet"} {
+		_, err := patientStore.CreatePatient(context.Background(), dentalPracticeID, patients.CreatePatientRequest{FirstName: firstName, LastName: "Oliver"})
+		if err != nil {
+			t.Fatalf("could not create the patient: %v", err)
+		}
+	}
+
+	t.Run("pages through the matching patients using the next cursor", func(t *testing.T) {
+		var firstNames []string
+		cursor := ""
+
+		for {
+			// create a request to pass to our handler
+			req, _ := http.NewRequest("GET", "/patients?search=ja&limit=2&cursor="+cursor, nil)
+
+			// set the dental practice the request is made on behalf of
+			req = withDentalPractice(req, dentalPracticeID)
+
+			// create a response recorder
+			res := httptest.NewRecorder()
+
+			// get the handler
+			handler := SearchPatientsHandler(logger, patientStore)
+
+			// our handler satisfies http.handler, so we can call its serve http method
+			// directly and pass in our request and response recorder
+			handler.ServeHTTP(res, req)
+
+			// assert status code is what we expect
+			assertStatusCode(t, res.Code, http.StatusOK)
+
+			got := getPatientsFromResponse(t, res.Body)
+			for _, item := range got.Items {
+				firstNames = append(firstNames, item.FirstName)
+			}
+
+			if got.NextCursor == "" {
+				break
+			}
+
+			cursor = got.NextCursor
+		}
+
+		want := []string{"James", "Jamie", "Janet"}
+		if fmt.Sprint(firstNames) != fmt.Sprint(want) {
+			t.Errorf("got %v want %v", firstNames, want)
+		}
+	})
+
+	t.Run("returns a bad request when the cursor was issued to another dental practice", func(t *testing.T) {
+		// get a cursor issued to the dental practice holding the patients
+		firstPage, _ := patientStore.SearchPatients(context.Background(), dentalPracticeID, patients.SearchPatientsRequest{SearchTerm: "ja", Limit: 1})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients?search=ja&cursor="+firstPage.NextCursor, nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, "other_dental_practice_id")
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := SearchPatientsHandler(logger, patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusBadRequest)
+	})
+}
+
+// the request is made by an admin, who sees every field.
+func withDentalPractice(req *http.Request, dentalPracticeID string) *http.Request {
+	return withRole(req, dentalPracticeID, auth.RoleAdmin)
+}
+
+func withRole(req *http.Request, dentalPracticeID string, role auth.Role) *http.Request {
+	return req.WithContext(auth.NewContext(req.Context(), auth.Identity{DentalPracticeID: dentalPracticeID, Role: role}))
 }
 
 func assertStatusCode(t testing.TB, got, want int) {
@@ -175,22 +834,142 @@ func assertStatusCode(t testing.TB, got, want int) {
 	}
 }
 
-func getPatientsFromResponse(t testing.TB, body io.Reader) (patients []patients.PatientSearchResponseItem) {
+func getPatientsFromResponse(t testing.TB, body io.Reader) (patients patients.PatientSearchResponse) {
 	t.Helper()
 
 	err := json.NewDecoder(body).Decode(&patients)
 
 	if err != nil {
-		t.Fatalf("unable to process response from server %q into a Patient, '%v'", body, err)
+		t.Fatalf("unable to process response from server %q into a PatientSearchResponse, '%v'", body, err)
 	}
 
 	return
 }
 
-func assertSearchResponse(t testing.TB, got, want []patients.PatientSearchResponseItem) {
+func assertSearchResponse(t testing.TB, got, want patients.PatientSearchResponse) {
 	t.Helper()
 
 	if diff := cmp.Diff(got, want); diff != "" {
 		t.Error("handler returned unexpected body", diff)
 	}
 }
+
+func TestSearchPatientFieldVisibility(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	// create the logger
+	logger, _ := zap.NewProduction()
+
+	// create the stub patient store
+	patientStore := StubPatientStore{
+		searchPatients: func(_ context.Context, _ string, _ patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+			return patients.PatientSearchResponse{
+				Items: []patients.PatientSearchResponseItem{
+					{PatientID: "test_patient_id", FirstName: "Jane", LastName: "Doe", DateOfBirth: "1985-03-14", PostCode: "LS1 3LP"},
+				},
+				NextCursor: "test_cursor",
+			}, nil
+		},
+	}
+
+	t.Run("return 403 when the request has no role", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients?search=jane", nil)
+
+		// set the dental practice the request is made on behalf of, without a role
+		req = withRole(req, dentalPracticeID, "")
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := SearchPatientsHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusForbidden)
+	})
+
+	t.Run("finance sees the date of birth masked", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients?search=jane", nil)
+
+		// set the dental practice and the role the request is made with
+		req = withRole(req, dentalPracticeID, auth.RoleFinance)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := SearchPatientsHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		// the response depends on who asked for it
+		if vary := res.Header().Get("vary"); vary != "authorization" {
+			t.Errorf("got vary header %q want %q", vary, "authorization")
+		}
+
+		// decode the json response into patients.PatientSearchResponse
+		got := getPatientsFromResponse(t, res.Body)
+
+		want := patients.PatientSearchResponse{
+			Items: []patients.PatientSearchResponseItem{
+				{PatientID: "test_patient_id", FirstName: "Jane", LastName: "Doe", DateOfBirth: "1985-**-**", PostCode: "LS1 3LP"},
+			},
+			NextCursor: "test_cursor",
+		}
+
+		assertSearchResponse(t, got, want)
+	})
+
+	t.Run("return 403 when finance searches by the date of birth", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients?search=1985-03-14&field=date_of_birth", nil)
+
+		// set the dental practice and the role the request is made with
+		req = withRole(req, dentalPracticeID, auth.RoleFinance)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := SearchPatientsHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusForbidden)
+	})
+
+	t.Run("return 403 when a receptionist searches by the national insurance number", func(t *testing.T) {
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("GET", "/patients?search=QQ123456C&field=national_insurance_number", nil)
+
+		// set the dental practice and the role the request is made with
+		req = withRole(req, dentalPracticeID, auth.RoleReceptionist)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := SearchPatientsHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusForbidden)
+	})
+}
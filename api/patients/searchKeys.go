@@ -0,0 +1,233 @@
+package patients
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/encryption"
+)
+
+// SearchField is the patient detail that a search term is matched against.
+type SearchField string
+
+const (
+	SearchFieldName        SearchField = "name"
+	SearchFieldEmail       SearchField = "email"
+	SearchFieldMobilePhone SearchField = "mobile_phone"
+	SearchFieldPostCode    SearchField = "post_code"
+	SearchFieldDateOfBirth SearchField = "date_of_birth"
+)
+
+// SearchFields are all of the fields that patients can be searched by.
+var SearchFields = []SearchField{SearchFieldName, SearchFieldEmail, SearchFieldMobilePhone, SearchFieldPostCode, SearchFieldDateOfBirth}
+
+// ErrPartialSearchTerm is returned when an encrypted field is searched for by
+// part of a value. encrypted fields are indexed by the hashes of their whole
+// values, so they can not be searched for by what they start with.
+var ErrPartialSearchTerm = errors.New("only whole values can be searched for")
+
+// how the whole value of each field that can be hashed looks once it has been
+// normalised, a search term that does not look like one can only be the start
+// of a value.
+var wholeSearchValues = map[SearchField]*regexp.Regexp{
+	SearchFieldEmail:       regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`),
+	SearchFieldMobilePhone: regexp.MustCompile(`^07[0-9]{9}$`),
+	SearchFieldPostCode:    regexp.MustCompile(`^(gir0aa|[a-z]{1,2}[0-9][0-9a-z]?[0-9][a-z]{2})$`),
+	SearchFieldDateOfBirth: regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`),
+}
+
+// the index and sort key attribute that a search field is queried on. names
+// have their own index, every other field shares the field-index and is told
+// apart by a prefix on the sort key.
+type searchIndex struct {
+	name      string
+	attribute string
+	prefix    string
+}
+
+var searchIndexes = map[SearchField]searchIndex{
+	SearchFieldName:        {name: "name-index", attribute: "st"},
+	SearchFieldEmail:       {name: "field-index", attribute: "fst", prefix: "e#"},
+	SearchFieldMobilePhone: {name: "field-index", attribute: "fst", prefix: "mp#"},
+	SearchFieldPostCode:    {name: "field-index", attribute: "fst", prefix: "pc#"},
+	SearchFieldDateOfBirth: {name: "field-index", attribute: "fst", prefix: "dob#"},
+}
+
+// a search key of a patient, each one is stored as a separate search item
+// whose sort key is the patient's sort key followed by the suffix.
+type searchKey struct {
+	suffix    string
+	attribute string
+	value     string
+}
+
+// searchKeyer works out the search keys of a store's patients. the national
+// insurance number, and the fields whose values are encrypted, are only
+// indexed by their keyed hashes, so they are left out of the index when there
+// is no blind index to hash them with.
+type searchKeyer struct {
+	blindIndex *encryption.BlindIndex
+	// the fields that are indexed by their hashes
+	hashed map[SearchField]bool
+}
+
+// reports whether the field is indexed by the hashes of its values, which can
+// only be searched for a value that is equal to the search term.
+func (k searchKeyer) hashes(field SearchField) bool {
+	return k.hashed[field]
+}
+
+// returns the sort key a value of a field is stored under in its index.
+func (k searchKeyer) sortKey(field SearchField, normalised string) (string, bool) {
+	index := searchIndexes[field]
+	if !k.hashes(field) {
+		return index.prefix + normalised, true
+	}
+
+	if k.blindIndex == nil {
+		return "", false
+	}
+
+	return index.prefix + k.blindIndex.Hash(string(field), normalised), true
+}
+
+// returns the search keys of a patient. fields without a value are left out,
+// an empty string can not be used as an index key. the national insurance
+// number is not part of the search item, it is only used to find duplicate
+// patients.
+func (k searchKeyer) searchKeys(searchItem PatientSearchResponseItem, nationalInsuranceNumber string) []searchKey {
+	fields := []struct {
+		suffix string
+		field  SearchField
+		value  string
+	}{
+		{suffix: "fn", field: SearchFieldName, value: searchItem.FirstName},
+		{suffix: "ln", field: SearchFieldName, value: searchItem.LastName},
+		{suffix: "e", field: SearchFieldEmail, value: searchItem.Email},
+		{suffix: "mp", field: SearchFieldMobilePhone, value: searchItem.MobilePhone},
+		{suffix: "pc", field: SearchFieldPostCode, value: searchItem.PostCode},
+		{suffix: "dob", field: SearchFieldDateOfBirth, value: searchItem.DateOfBirth},
+	}
+
+	var keys []searchKey
+	for _, field := range fields {
+		value := normaliseSearchValue(field.field, field.value)
+		if value == "" {
+			continue
+		}
+
+		sortKey, ok := k.sortKey(field.field, value)
+		if !ok {
+			continue
+		}
+
+		keys = append(keys, searchKey{suffix: field.suffix, attribute: searchIndexes[field.field].attribute, value: sortKey})
+	}
+
+	names := []struct {
+		suffix string
+		value  string
+	}{
+		{suffix: "fnph", value: searchItem.FirstName},
+		{suffix: "lnph", value: searchItem.LastName},
+	}
+
+	for _, name := range names {
+		code := soundex(name.value)
+		if code == "" {
+			continue
+		}
+
+		keys = append(keys, searchKey{suffix: name.suffix, attribute: phoneticIndex.attribute, value: phoneticIndex.prefix + code})
+	}
+
+	if nationalInsuranceNumber != "" && k.blindIndex != nil {
+		keys = append(keys, searchKey{suffix: "ni", attribute: nationalInsuranceIndex.attribute, value: k.nationalInsuranceSearchKey(nationalInsuranceNumber)})
+	}
+
+	return keys
+}
+
+// all of the suffixes that search items can have, used to remove the search
+// items of fields that no longer have a value.
+var searchKeySuffixes = []string{"fn", "ln", "e", "mp", "pc", "dob", "fnph", "lnph", "ni"}
+
+// returns the index to query and the sort key prefix to look for when
+// searching a field of the store's patients for the search term. a search of
+// a hashed field looks for the hash of the search term, and the hashes are all
+// the same length, so only equal values are found. a search term that is not a
+// whole value is rejected rather than finding nothing.
+func (k searchKeyer) searchQuery(field SearchField, searchTerm string) (searchIndex, string, error) {
+	index, sortKeyPrefix, err := searchQuery(field, searchTerm)
+	if err != nil || !k.hashes(field) {
+		return index, sortKeyPrefix, err
+	}
+
+	normalised := strings.TrimPrefix(sortKeyPrefix, index.prefix)
+	if !wholeSearchValues[field].MatchString(normalised) {
+		return searchIndex{}, "", fmt.Errorf("%v is encrypted, so %w", field, ErrPartialSearchTerm)
+	}
+
+	sortKey, ok := k.sortKey(field, normalised)
+	if !ok {
+		return searchIndex{}, "", fmt.Errorf("patients can not be searched by %q without a blind index key", field)
+	}
+
+	return index, sortKey, nil
+}
+
+// returns the index to query and the sort key prefix to look for when
+// searching a field for the search term.
+func searchQuery(field SearchField, searchTerm string) (searchIndex, string, error) {
+	if field == "" {
+		field = SearchFieldName
+	}
+
+	index, ok := searchIndexes[field]
+	if !ok {
+		return searchIndex{}, "", fmt.Errorf("patients can not be searched by %q", field)
+	}
+
+	return index, index.prefix + normaliseSearchValue(field, searchTerm), nil
+}
+
+var ukDateRegex = regexp.MustCompile(`^(\d{2})/(\d{2})/(\d{4})$`)
+
+// puts a value into the form it is stored in the search index, so that the
+// way it was typed in does not change whether it is found.
+func normaliseSearchValue(field SearchField, value string) string {
+	value = strings.TrimSpace(value)
+
+	switch field {
+	case SearchFieldMobilePhone:
+		// the uk dialling code is stored as the leading 0 of a national number
+		for _, countryCode := range []string{"+44", "0044"} {
+			if strings.HasPrefix(value, countryCode) {
+				value = "0" + strings.TrimPrefix(value, countryCode)
+				break
+			}
+		}
+
+		return strings.Map(func(r rune) rune {
+			if unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, value)
+	case SearchFieldPostCode:
+		return strings.ToLower(strings.Join(strings.Fields(value), ""))
+	case SearchFieldDateOfBirth:
+		// dates of birth are stored as yyyy-mm-dd, but are usually typed in
+		// as dd/mm/yyyy at the front desk
+		if match := ukDateRegex.FindStringSubmatch(value); match != nil {
+			return fmt.Sprintf("%v-%v-%v", match[3], match[2], match[1])
+		}
+
+		return value
+	default:
+		return strings.ToLower(value)
+	}
+}
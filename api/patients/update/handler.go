@@ -0,0 +1,219 @@
+package update
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/apierror"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/conditional"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/redaction"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/validation"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
+	"go.uber.org/zap"
+)
+
+const contentTypeHeader string = "content-type"
+const jsonContentType string = "application/json"
+const mergePatchContentType string = "application/merge-patch+json"
+const ifMatchHeader string = "if-match"
+const etagHeader string = "etag"
+
+func UpdatePatientHandler(logger *zap.Logger, repository patients.PatientRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context(), logger)
+
+		logger.Info("running the update patient handler...")
+
+		if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+			w.Header().Set("allow", "PUT, PATCH")
+			problem.Error(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		identity, ok := auth.FromContext(r.Context())
+		if !ok {
+			logger.Error("no dental practice was found for the request")
+			problem.Error(w, r, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// the fields returned depend on the role, so a role that is not known
+		// gets nothing
+		if !redaction.Allowed(identity.Role) {
+			logger.Error("the role is not allowed to read patients", zap.String("role", string(identity.Role)))
+			problem.Error(w, r, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		// enforce a json content-type, patches may also use the merge patch type
+		mediatype, _, err := mime.ParseMediaType(r.Header.Get(contentTypeHeader))
+		if err != nil {
+			logger.Error("error when parsing the mime type", zap.Error(err))
+			problem.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if mediatype != jsonContentType && !(r.Method == http.MethodPatch && mediatype == mergePatchContentType) {
+			logger.Error("unsupported content-type", zap.String("contentType", mediatype))
+			problem.Error(w, r, "api expects application/json content-type", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		// updates have to say which version of the patient they are based on, so
+		// that one update can not silently overwrite another
+		ifMatch := r.Header.Get(ifMatchHeader)
+		if ifMatch == "" {
+			logger.Error("the if-match header is missing")
+			problem.Error(w, r, "updates must send the etag of the patient in an if-match header", http.StatusPreconditionRequired)
+			return
+		}
+
+		patientID := strings.TrimPrefix(r.URL.Path, "/patients/")
+
+		// a path that does not hold a patient id can not name a patient
+		if !validation.IsPatientID(patientID) {
+			logger.Error("the patient id is invalid", zap.String("patientID", patientID))
+			problem.Error(w, r, "requested patient could not be found", http.StatusNotFound)
+			return
+		}
+
+		logger.Info("requested patient", zap.String("patientID", patientID))
+
+		logger = logger.With(zap.String("patientID", patientID))
+
+		existingPatient, err := repository.GetPatient(logging.NewContext(r.Context(), logger), identity.DentalPracticeID, patientID)
+		if err != nil {
+			logger.Error("failed to get patient", zap.Error(err))
+			apierror.Write(w, r, err, "failed to get the patient")
+			return
+		}
+
+		if !conditional.IfMatch(ifMatch, conditional.ETag(existingPatient)) {
+			logger.Error("the patient has changed since the etag was read", zap.String("ifMatch", ifMatch), zap.Int("version", existingPatient.Version))
+			w.Header().Set(etagHeader, conditional.ETag(existingPatient))
+			problem.Error(w, r, "the patient has changed since it was read, get it again and retry", http.StatusPreconditionFailed)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			logger.Error("could not read the request body", zap.Error(err))
+			problem.Error(w, r, "request body is invalid", http.StatusBadRequest)
+			return
+		}
+
+		var updatePatientRequest patients.UpdatePatientRequest
+		if r.Method == http.MethodPut {
+			err = decodeUpdatePatientRequest(body, &updatePatientRequest)
+		} else {
+			updatePatientRequest, err = applyMergePatch(existingPatient, body)
+		}
+		if err != nil {
+			logger.Error("the request body is invalid", zap.Error(err))
+			problem.Error(w, r, "request body is invalid", http.StatusBadRequest)
+			return
+		}
+
+		// the patient id in the path always wins over anything in the body
+		updatePatientRequest.PatientID = patientID
+
+		// the caller was not shown every field, so what it could not see, or
+		// sent back masked, stays as it is
+		updatePatientRequest = redaction.KeepHidden(identity.Role, existingPatient, updatePatientRequest)
+
+		// validation
+		if fieldErrors := validation.ValidateUpdatePatientRequest(updatePatientRequest); len(fieldErrors) > 0 {
+			logger.Error("the request body failed validation", zap.Any("fieldErrors", fieldErrors))
+			problem.Write(w, r, problem.Validation("request body is invalid", fieldErrors))
+			return
+		}
+
+		// the store checks the version again as it writes, in case the patient
+		// has been updated since it was read
+		patient, err := repository.UpdatePatient(logging.NewContext(r.Context(), logger), identity.DentalPracticeID, updatePatientRequest, existingPatient.Version)
+		if err != nil {
+			logger.Error("failed to update the patient", zap.Error(err))
+			apierror.Write(w, r, err, "failed to update the patient")
+			return
+		}
+
+		w.Header().Set(contentTypeHeader, jsonContentType)
+		w.Header().Set(etagHeader, conditional.ETag(patient))
+
+		// callers with different roles see different fields of the same patient
+		w.Header().Set("vary", "authorization")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(redaction.Patient(identity.Role, patient))
+		if err != nil {
+			logger.Error("error in json marshal", zap.Error(err))
+		}
+	})
+}
+
+// decodes a json body into an update patient request, rejecting any fields
+// that are not part of the request.
+func decodeUpdatePatientRequest(body []byte, updatePatientRequest *patients.UpdatePatientRequest) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+
+	return dec.Decode(updatePatientRequest)
+}
+
+// applies a json merge patch (rfc 7386) to the existing patient and returns the
+// resulting update request. patient attributes are all flat, so members of the
+// patch either replace the existing value or, when null, clear it.
+func applyMergePatch(existingPatient patients.Patient, patch []byte) (patients.UpdatePatientRequest, error) {
+	var updatePatientRequest patients.UpdatePatientRequest
+
+	// make sure the patch only touches fields that can be updated
+	err := decodeUpdatePatientRequest(patch, &updatePatientRequest)
+	if err != nil {
+		return updatePatientRequest, err
+	}
+
+	var patchMembers map[string]json.RawMessage
+	err = json.Unmarshal(patch, &patchMembers)
+	if err != nil {
+		return updatePatientRequest, err
+	}
+
+	existing, err := json.Marshal(existingPatient)
+	if err != nil {
+		return updatePatientRequest, err
+	}
+
+	var merged map[string]json.RawMessage
+	err = json.Unmarshal(existing, &merged)
+	if err != nil {
+		return updatePatientRequest, err
+	}
+
+	for name, value := range patchMembers {
+		if string(value) == "null" {
+			delete(merged, name)
+			continue
+		}
+
+		merged[name] = value
+	}
+
+	result, err := json.Marshal(merged)
+	if err != nil {
+		return updatePatientRequest, err
+	}
+
+	// the merged document still carries the read only patient attributes, so
+	// unknown fields are ignored at this point
+	updatePatientRequest = patients.UpdatePatientRequest{}
+	err = json.Unmarshal(result, &updatePatientRequest)
+
+	return updatePatientRequest, err
+}
@@ -0,0 +1,872 @@
+package update
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"go.uber.org/zap"
+)
+
+type StubPatientStore struct {
+	createPatient             func(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error)
+	getPatient                func(ctx context.Context, dentalPracticeID string, patientID string) (patients.Patient, error)
+	searchPatients            func(ctx context.Context, dentalPracticeID string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error)
+	updatePatient             func(ctx context.Context, dentalPracticeID string, patient patients.UpdatePatientRequest, expectedVersion int) (patients.Patient, error)
+	listPatients              func(ctx context.Context, dentalPracticeID string, request patients.ListPatientsRequest) (patients.PatientSearchResponse, error)
+	findDuplicatePatients     func(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) ([]string, error)
+	mergePatients             func(ctx context.Context, dentalPracticeID string, request patients.MergePatientsRequest, expectedVersion int) (patients.Patient, error)
+	getPatientHistory         func(ctx context.Context, dentalPracticeID string, request patients.PatientHistoryRequest) (patients.PatientHistoryResponse, error)
+	getIdempotentResponse     func(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey) (patients.CreatePatientResponse, error)
+	createPatientIdempotently func(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error)
+}
+
+func (s *StubPatientStore) CreatePatient(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+	return s.createPatient(ctx, dentalPracticeID, patient)
+}
+
+func (s *StubPatientStore) GetPatient(ctx context.Context, dentalPracticeID string, patientID string) (patients.Patient, error) {
+	return s.getPatient(ctx, dentalPracticeID, patientID)
+}
+
+func (s *StubPatientStore) SearchPatients(ctx context.Context, dentalPracticeID string, request patients.SearchPatientsRequest) (patients.PatientSearchResponse, error) {
+	return s.searchPatients(ctx, dentalPracticeID, request)
+}
+
+func (s *StubPatientStore) UpdatePatient(ctx context.Context, dentalPracticeID string, patient patients.UpdatePatientRequest, expectedVersion int) (patients.Patient, error) {
+	return s.updatePatient(ctx, dentalPracticeID, patient, expectedVersion)
+}
+
+func (s *StubPatientStore) ListPatients(ctx context.Context, dentalPracticeID string, request patients.ListPatientsRequest) (patients.PatientSearchResponse, error) {
+	return s.listPatients(ctx, dentalPracticeID, request)
+}
+
+func (s *StubPatientStore) FindDuplicatePatients(ctx context.Context, dentalPracticeID string, patient patients.CreatePatientRequest) ([]string, error) {
+	return s.findDuplicatePatients(ctx, dentalPracticeID, patient)
+}
+
+func (s *StubPatientStore) MergePatients(ctx context.Context, dentalPracticeID string, request patients.MergePatientsRequest, expectedVersion int) (patients.Patient, error) {
+	return s.mergePatients(ctx, dentalPracticeID, request, expectedVersion)
+}
+
+func (s *StubPatientStore) GetPatientHistory(ctx context.Context, dentalPracticeID string, request patients.PatientHistoryRequest) (patients.PatientHistoryResponse, error) {
+	return s.getPatientHistory(ctx, dentalPracticeID, request)
+}
+
+func (s *StubPatientStore) GetIdempotentResponse(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey) (patients.CreatePatientResponse, error) {
+	return s.getIdempotentResponse(ctx, dentalPracticeID, key)
+}
+
+func (s *StubPatientStore) CreatePatientIdempotently(ctx context.Context, dentalPracticeID string, key patients.IdempotencyKey, patient patients.CreatePatientRequest) (patients.CreatePatientResponse, error) {
+	return s.createPatientIdempotently(ctx, dentalPracticeID, key, patient)
+}
+
+func TestUpdatePatient(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	contentType := "content-type"
+	applicationJson := "application/json"
+	requestedPatientID := "3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34"
+	ifMatch := "if-match"
+
+	existingPatient := patients.Patient{
+		PatientID:   requestedPatientID,
+		FirstName:   "Jane",
+		LastName:    "Doe",
+		Email:       "jane.doe@gmail.com",
+		PostCode:    "LS18 9BQ",
+		Active:      true,
+		CreatedAt:   "2022-10-01T09:00:00Z",
+		MobilePhone: "07865154788",
+		Version:     4,
+	}
+
+	// create the logger
+	logger, _ := zap.NewProduction()
+
+	t.Run("returns 405 (method not allowed) when the request is not a put or a patch", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/patients/%v", requestedPatientID), nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusMethodNotAllowed)
+	})
+
+	t.Run("returns 404 (not found) when the path does not hold a patient id", func(t *testing.T) {
+		// create the stub patient store, it is never called
+		patientStore := StubPatientStore{}
+
+		// create a request to pass to our handler
+		req := httptest.NewRequest("PUT", "/patients/not_a_patient_id", bytes.NewBufferString(`{"first_name": "Jane"}`))
+		req.Header.Set(contentType, applicationJson)
+		req.Header.Set(ifMatch, `"4"`)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusNotFound)
+	})
+
+	t.Run("returns 415 (unsupported media type) when the request does not have content-type set as application/json", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/patients/%v", requestedPatientID), nil)
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, "text/csv")
+
+		// set the version of the patient the update is based on
+		req.Header.Set(ifMatch, `"4"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusUnsupportedMediaType)
+	})
+
+	t.Run("returns 404 when the patient to update does not exist", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return patients.Patient{}, fmt.Errorf("could not find patient with id %q: %w", patientID, patients.ErrNotFound)
+			},
+			updatePatient: func(_ context.Context, _ string, patient patients.UpdatePatientRequest, _ int) (patients.Patient, error) {
+				t.Error("UpdatePatient() should not be called for a patient that does not exist")
+				return patients.Patient{}, nil
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patients.UpdatePatientRequest{FirstName: "Janet"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the version of the patient the update is based on
+		req.Header.Set(ifMatch, `"4"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusNotFound)
+	})
+
+	t.Run("returns 400 (bad request) when first name is not set in the body", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return existingPatient, nil
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patients.UpdatePatientRequest{LastName: "Doe"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the version of the patient the update is based on
+		req.Header.Set(ifMatch, `"4"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusBadRequest)
+	})
+
+	t.Run("returns 400 (bad request) when a patch contains a field that cannot be updated", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return existingPatient, nil
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PATCH", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBufferString(`{"created_at": "2020-01-01T00:00:00Z"}`))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, "application/merge-patch+json")
+
+		// set the version of the patient the update is based on
+		req.Header.Set(ifMatch, `"4"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusBadRequest)
+	})
+
+	t.Run("put replaces the whole patient with the request body", func(t *testing.T) {
+		requestBody := patients.UpdatePatientRequest{FirstName: "Janet", LastName: "Smith", PostCode: "LS1 3LP"}
+		expectedRequest := requestBody
+		expectedRequest.PatientID = requestedPatientID
+		expectedPatient := patients.Patient{PatientID: requestedPatientID, FirstName: "Janet", LastName: "Smith", PostCode: "LS1 3LP", Active: true}
+
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return existingPatient, nil
+			},
+			updatePatient: func(_ context.Context, _ string, patient patients.UpdatePatientRequest, _ int) (patients.Patient, error) {
+				assertUpdatePatientRequest(t, patient, expectedRequest)
+				return expectedPatient, nil
+			},
+		}
+
+		jsonValue, _ := json.Marshal(requestBody)
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the version of the patient the update is based on
+		req.Header.Set(ifMatch, `"4"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// decode the json response into patients.Patient
+		got := getPatientFromResponse(t, res.Body)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		// check the response body is what we expect
+		assertPatient(t, got, expectedPatient)
+	})
+
+	t.Run("patch merges the request body into the existing patient", func(t *testing.T) {
+		expectedRequest := patients.UpdatePatientRequest{
+			PatientID:   requestedPatientID,
+			FirstName:   "Janet",
+			LastName:    "Doe",
+			PostCode:    "LS18 9BQ",
+			MobilePhone: "07865154788",
+		}
+
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return existingPatient, nil
+			},
+			updatePatient: func(_ context.Context, _ string, patient patients.UpdatePatientRequest, _ int) (patients.Patient, error) {
+				assertUpdatePatientRequest(t, patient, expectedRequest)
+				return patients.Patient{PatientID: patient.PatientID, FirstName: patient.FirstName}, nil
+			},
+		}
+
+		// create a request to pass to our handler, null removes the email
+		req, _ := http.NewRequest("PATCH", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBufferString(`{"first_name": "Janet", "email": null}`))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, "application/merge-patch+json")
+
+		// set the version of the patient the update is based on
+		req.Header.Set(ifMatch, `"4"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+	})
+
+	t.Run("returns 404 when the patient is removed before it is updated", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return existingPatient, nil
+			},
+			updatePatient: func(_ context.Context, _ string, patient patients.UpdatePatientRequest, _ int) (patients.Patient, error) {
+				return patients.Patient{}, fmt.Errorf("could not find patient with id %q: %w", patient.PatientID, patients.ErrNotFound)
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patients.UpdatePatientRequest{FirstName: "Janet"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the version of the patient the update is based on
+		req.Header.Set(ifMatch, `"4"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusNotFound)
+	})
+
+	t.Run("returns 500 (internal server error) when call to dynamodb fails", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return existingPatient, nil
+			},
+			updatePatient: func(_ context.Context, _ string, patient patients.UpdatePatientRequest, _ int) (patients.Patient, error) {
+				return patients.Patient{}, errors.New("call to dynamodb failed")
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patients.UpdatePatientRequest{FirstName: "Janet"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the version of the patient the update is based on
+		req.Header.Set(ifMatch, `"4"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusInternalServerError)
+	})
+
+	t.Run("returns 428 (precondition required) when the request does not have an if-match header", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			updatePatient: func(_ context.Context, _ string, _ patients.UpdatePatientRequest, _ int) (patients.Patient, error) {
+				t.Error("UpdatePatient() should not be called without an if-match header")
+				return patients.Patient{}, nil
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patients.UpdatePatientRequest{FirstName: "Janet"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusPreconditionRequired)
+	})
+
+	t.Run("returns 412 (precondition failed) when the if-match header is for an older version", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return existingPatient, nil
+			},
+			updatePatient: func(_ context.Context, _ string, _ patients.UpdatePatientRequest, _ int) (patients.Patient, error) {
+				t.Error("UpdatePatient() should not be called for an older version")
+				return patients.Patient{}, nil
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patients.UpdatePatientRequest{FirstName: "Janet"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set an older version of the patient
+		req.Header.Set(ifMatch, `"3"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusPreconditionFailed)
+
+		// assert the current etag is returned
+		if got := res.Header().Get("etag"); got != `"4"` {
+			t.Errorf("handler returned wrong etag: got %v want %v", got, `"4"`)
+		}
+	})
+
+	t.Run("returns 412 (precondition failed) when the patient is updated by another request first", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return existingPatient, nil
+			},
+			updatePatient: func(_ context.Context, _ string, patient patients.UpdatePatientRequest, _ int) (patients.Patient, error) {
+				return patients.Patient{}, fmt.Errorf("patient %q was changed by another request: %w", patient.PatientID, patients.ErrPreconditionFailed)
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patients.UpdatePatientRequest{FirstName: "Janet"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the version of the patient the update is based on
+		req.Header.Set(ifMatch, `"4"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusPreconditionFailed)
+	})
+
+	t.Run("check that the version from the if-match header is passed to the patients store and the new etag returned", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return existingPatient, nil
+			},
+			updatePatient: func(_ context.Context, _ string, patient patients.UpdatePatientRequest, expectedVersion int) (patients.Patient, error) {
+				if expectedVersion != 4 {
+					t.Errorf("%v was passed to UpdatePatient() but the expected version was 4", expectedVersion)
+				}
+				return patients.Patient{PatientID: patient.PatientID, FirstName: patient.FirstName, Version: 5}, nil
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patients.UpdatePatientRequest{FirstName: "Janet"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the version of the patient the update is based on
+		req.Header.Set(ifMatch, `"4"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		// assert the etag of the new version is returned
+		if got := res.Header().Get("etag"); got != `"5"` {
+			t.Errorf("handler returned wrong etag: got %v want %v", got, `"5"`)
+		}
+	})
+
+	t.Run("returns 401 (unauthorized) when the request is not made on behalf of a dental practice", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{}
+
+		jsonValue, _ := json.Marshal(patients.UpdatePatientRequest{FirstName: "Janet"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the version of the patient the update is based on
+		req.Header.Set(ifMatch, `"4"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("check that the dental practice of the request is getting passed to the patients store", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, requestedDentalPracticeID string, patientID string) (patients.Patient, error) {
+				if requestedDentalPracticeID != dentalPracticeID {
+					t.Errorf("%q was passed to GetPatient() but the expected value was %q", requestedDentalPracticeID, dentalPracticeID)
+				}
+				return existingPatient, nil
+			},
+			updatePatient: func(_ context.Context, requestedDentalPracticeID string, patient patients.UpdatePatientRequest, _ int) (patients.Patient, error) {
+				if requestedDentalPracticeID != dentalPracticeID {
+					t.Errorf("%q was passed to UpdatePatient() but the expected value was %q", requestedDentalPracticeID, dentalPracticeID)
+				}
+				return existingPatient, nil
+			},
+		}
+
+		jsonValue, _ := json.Marshal(patients.UpdatePatientRequest{FirstName: "Janet"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice the request is made on behalf of
+		req = withDentalPractice(req, dentalPracticeID)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the version of the patient the update is based on
+		req.Header.Set(ifMatch, `"4"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+	})
+
+	t.Run("put keeps the fields the role can not see", func(t *testing.T) {
+		sensitivePatient := existingPatient
+		sensitivePatient.NationalInsuranceNumber = "AB123456C"
+		sensitivePatient.Ethnicity = "test_ethnicity"
+		sensitivePatient.Occupation = "test_occupation"
+
+		expectedRequest := patients.UpdatePatientRequest{
+			PatientID:               requestedPatientID,
+			FirstName:               "Janet",
+			LastName:                "Doe",
+			NationalInsuranceNumber: "AB123456C",
+			Ethnicity:               "test_ethnicity",
+			Occupation:              "test_occupation",
+		}
+
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return sensitivePatient, nil
+			},
+			updatePatient: func(_ context.Context, _ string, patient patients.UpdatePatientRequest, _ int) (patients.Patient, error) {
+				assertUpdatePatientRequest(t, patient, expectedRequest)
+				return patients.Patient{PatientID: patient.PatientID, FirstName: patient.FirstName, Version: 5}, nil
+			},
+		}
+
+		// the receptionist sends back the masked national insurance number and
+		// none of the fields that are hidden from them
+		jsonValue, _ := json.Marshal(patients.UpdatePatientRequest{FirstName: "Janet", LastName: "Doe", NationalInsuranceNumber: "AB******C"})
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PUT", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBuffer(jsonValue))
+
+		// set the dental practice and the role the request is made with
+		req = withRole(req, dentalPracticeID, auth.RoleReceptionist)
+
+		// set the content type
+		req.Header.Set(contentType, applicationJson)
+
+		// set the version of the patient the update is based on
+		req.Header.Set(ifMatch, `"4"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+	})
+
+	t.Run("returns 403 (forbidden) when the role is not known", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PATCH", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBufferString(`{"first_name": "Janet"}`))
+
+		// set the dental practice and the role the request is made with
+		req = withRole(req, dentalPracticeID, "patient")
+
+		// set the content type
+		req.Header.Set(contentType, "application/merge-patch+json")
+
+		// set the version of the patient the update is based on
+		req.Header.Set(ifMatch, `"4"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusForbidden)
+	})
+
+	t.Run("the updated patient is returned with the fields the role can not see taken out", func(t *testing.T) {
+		// create the stub patient store
+		patientStore := StubPatientStore{
+			getPatient: func(_ context.Context, _ string, patientID string) (patients.Patient, error) {
+				return existingPatient, nil
+			},
+			updatePatient: func(_ context.Context, _ string, patient patients.UpdatePatientRequest, _ int) (patients.Patient, error) {
+				return patients.Patient{PatientID: patient.PatientID, FirstName: patient.FirstName, NationalInsuranceNumber: "QQ123456C", Ethnicity: "test_ethnicity", Version: 5}, nil
+			},
+		}
+
+		// create a request to pass to our handler
+		req, _ := http.NewRequest("PATCH", fmt.Sprintf("/patients/%v", requestedPatientID), bytes.NewBufferString(`{"first_name": "Janet"}`))
+
+		// set the dental practice and the role the request is made with
+		req = withRole(req, dentalPracticeID, auth.RoleReceptionist)
+
+		// set the content type
+		req.Header.Set(contentType, "application/merge-patch+json")
+
+		// set the version of the patient the update is based on
+		req.Header.Set(ifMatch, `"4"`)
+
+		// create a response recorder
+		res := httptest.NewRecorder()
+
+		// get the handler
+		handler := UpdatePatientHandler(logger, &patientStore)
+
+		// our handler satisfies http.handler, so we can call its serve http method
+		// directly and pass in our request and response recorder
+		handler.ServeHTTP(res, req)
+
+		// assert status code is what we expect
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		got := getPatientFromResponse(t, res.Body)
+		if got.FirstName != "Janet" || got.NationalInsuranceNumber != "QQ******C" || got.Ethnicity != "" {
+			t.Errorf("got %+v want the national insurance number masked and the ethnicity left out", got)
+		}
+	})
+}
+
+// the request is made by an admin, who sees every field.
+func withDentalPractice(req *http.Request, dentalPracticeID string) *http.Request {
+	return withRole(req, dentalPracticeID, auth.RoleAdmin)
+}
+
+func withRole(req *http.Request, dentalPracticeID string, role auth.Role) *http.Request {
+	return req.WithContext(auth.NewContext(req.Context(), auth.Identity{DentalPracticeID: dentalPracticeID, Role: role}))
+}
+
+func assertStatusCode(t testing.TB, got, want int) {
+	t.Helper()
+
+	if got != want {
+		t.Errorf("handler returned wrong status code: got %v want %v", got, want)
+	}
+}
+
+func getPatientFromResponse(t testing.TB, body io.Reader) (patient patients.Patient) {
+	t.Helper()
+
+	err := json.NewDecoder(body).Decode(&patient)
+
+	if err != nil {
+		t.Fatalf("unable to process response from server %q into a Patient, '%v'", body, err)
+	}
+
+	return
+}
+
+func assertPatient(t testing.TB, got, want patients.Patient) {
+	t.Helper()
+
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Error("handler returned unexpected body", diff)
+	}
+}
+
+func assertUpdatePatientRequest(t testing.TB, got, want patients.UpdatePatientRequest) {
+	t.Helper()
+
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Error("handler passed an unexpected update request to the store", diff)
+	}
+}
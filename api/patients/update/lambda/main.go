@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/akrylysov/algnhsa"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/update"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/ratelimit"
+	"go.uber.org/zap"
+)
+
+func main() {
+	// initialise a new zap logger
+	logger, _ := zap.NewProduction()
+
+	logger.Info("running the update patient lamdba...")
+
+	mux := http.NewServeMux()
+
+	// every lambda instance shares the rate limit buckets through the table
+	limiter := ratelimit.NewLimiter(ratelimit.NewDynamoDBStore(logger), ratelimit.LimitsFromEnv(logger))
+
+	mux.Handle("/", logging.RequestIDs(logger, auth.APIGatewayClaims(logger, limiter.Enforce(logger, ratelimit.RouteUpdatePatient, update.UpdatePatientHandler(logger, patients.NewPatientStore(logger))))))
+	algnhsa.ListenAndServe(mux, nil)
+}
@@ -0,0 +1,235 @@
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
+)
+
+// the oldest date of birth that is accepted.
+const maxAgeInYears int = 130
+
+var (
+	// https://www.gov.uk/government/publications/open-standards-for-government-data-and-technology/uk-postcode-format
+	postCodePattern = regexp.MustCompile(`^(GIR ?0AA|[A-PR-UWYZ]([0-9]{1,2}|[A-HK-Y][0-9]([0-9ABEHMNPRV-Y])?|[0-9][A-HJKPS-UW]) ?[0-9][ABD-HJLNP-UW-Z]{2})$`)
+
+	// https://www.gov.uk/hmrc-internal-manuals/national-insurance-manual/nim39110
+	nationalInsuranceNumberPattern = regexp.MustCompile(`^[A-CEGHJ-PR-TW-Z][A-CEGHJ-NPR-TW-Z][0-9]{6}[A-D]$`)
+
+	// uk mobile numbers, either in the national or the international format
+	mobilePhonePattern = regexp.MustCompile(`^(07[0-9]{9}|\+447[0-9]{9})$`)
+
+	// any other phone number, optionally in the international format
+	phonePattern = regexp.MustCompile(`^\+?[0-9]{10,15}$`)
+
+	// the patient ids the stores generate, lower case uuids
+	patientIDPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+	// the characters that are allowed to separate the digits of a phone number
+	phoneSeparators = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "")
+
+	// national insurance number prefixes that are never issued
+	invalidNationalInsurancePrefixes = []string{"BG", "GB", "KN", "NK", "NT", "TN", "ZZ"}
+
+	// the countries that post codes are checked against the uk format for, an
+	// empty country is assumed to be the uk
+	ukCountries = []string{"", "uk", "gb", "united kingdom", "great britain", "england", "scotland", "wales", "northern ireland"}
+)
+
+// FieldError describes why a single field of a request is invalid, the errors
+// are returned to api clients in the problem for the request.
+type FieldError = problem.FieldError
+
+type validator struct {
+	errors []FieldError
+}
+
+func (v *validator) fail(field string, reason string) {
+	v.errors = append(v.errors, FieldError{Field: field, Reason: reason})
+}
+
+func (v *validator) required(field string, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.fail(field, "is required")
+		return false
+	}
+
+	return true
+}
+
+func (v *validator) maxLength(field string, value string, max int) bool {
+	if utf8.RuneCountInString(value) > max {
+		v.fail(field, fmt.Sprintf("must be at most %d characters long", max))
+		return false
+	}
+
+	return true
+}
+
+// ValidateCreatePatientRequest checks every field of the request and returns
+// the ones that are invalid, or nil when the request is valid.
+func ValidateCreatePatientRequest(request patients.CreatePatientRequest) []FieldError {
+	v := &validator{}
+
+	if v.required("first_name", request.FirstName) {
+		v.maxLength("first_name", request.FirstName, 100)
+	}
+
+	v.maxLength("title", request.Title, 20)
+	v.maxLength("middle_name", request.MiddleName, 100)
+	v.maxLength("last_name", request.LastName, 100)
+	v.maxLength("gender", request.Gender, 20)
+	v.maxLength("address_line_1", request.AddressLine1, 100)
+	v.maxLength("address_line_2", request.AddressLine2, 100)
+	v.maxLength("city", request.City, 100)
+	v.maxLength("county", request.County, 100)
+	v.maxLength("country", request.Country, 100)
+	v.maxLength("emergency_contact_full_name", request.EmergencyContactFullName, 200)
+	v.maxLength("emergency_contact_relation_to_patient", request.EmergencyContactRelationToPatient, 50)
+	v.maxLength("ethnicity", request.Ethnicity, 100)
+	v.maxLength("occupation", request.Occupation, 100)
+	v.maxLength("acquisition_source", request.AcquisitionSource, 100)
+	v.maxLength("assigned_dentist", request.AssignedDentist, 100)
+	v.maxLength("assigned_hygienist", request.AssignedHygienist, 100)
+
+	if request.Email != "" && v.maxLength("email", request.Email, 254) && !isEmail(request.Email) {
+		v.fail("email", "must be a valid email address")
+	}
+
+	if request.DateOfBirth != "" {
+		validateDateOfBirth(v, request.DateOfBirth, time.Now())
+	}
+
+	if request.PostCode != "" && isUKCountry(request.Country) {
+		if !postCodePattern.MatchString(strings.ToUpper(strings.TrimSpace(request.PostCode))) {
+			v.fail("post_code", "must be a valid uk post code")
+		}
+	}
+
+	if request.NationalInsuranceNumber != "" && !IsNationalInsuranceNumber(request.NationalInsuranceNumber) {
+		v.fail("national_insurance_number", "must be a valid national insurance number, for example AB123456C")
+	}
+
+	if request.MobilePhone != "" && !mobilePhonePattern.MatchString(phoneSeparators.Replace(request.MobilePhone)) {
+		v.fail("mobile_phone", "must be a valid uk mobile number, for example 07700 900123")
+	}
+
+	phones := []struct {
+		field string
+		value string
+	}{
+		{field: "home_phone", value: request.HomePhone},
+		{field: "work_phone", value: request.WorkPhone},
+		{field: "emergency_contact_phone", value: request.EmergencyContactPhone},
+	}
+
+	for _, phone := range phones {
+		if phone.value != "" && !phonePattern.MatchString(phoneSeparators.Replace(phone.value)) {
+			v.fail(phone.field, "must be a valid phone number")
+		}
+	}
+
+	return v.errors
+}
+
+// ValidateUpdatePatientRequest applies the same rules as when the patient is
+// created.
+func ValidateUpdatePatientRequest(request patients.UpdatePatientRequest) []FieldError {
+	return ValidateCreatePatientRequest(patients.CreatePatientRequest(request))
+}
+
+// ValidateMergePatientsRequest checks that the request names a different
+// patient to merge from, and that each of the fields to copy from them is one
+// that can be updated.
+func ValidateMergePatientsRequest(request patients.MergePatientsRequest) []FieldError {
+	v := &validator{}
+
+	if v.required("source_patient_id", request.SourcePatientID) {
+		switch {
+		case !IsPatientID(request.SourcePatientID):
+			v.fail("source_patient_id", "is not a patient id")
+		case request.SourcePatientID == request.PatientID:
+			v.fail("source_patient_id", "a patient can not be merged into itself")
+		}
+	}
+
+	for _, field := range request.Fields {
+		if !patients.IsMergeableField(field) {
+			v.fail("fields", fmt.Sprintf("%q can not be copied from the merged patient", field))
+		}
+	}
+
+	return v.errors
+}
+
+// IsPatientID reports whether value could be the id of a patient. the ids are
+// part of the keys of the patient items, so anything else is never looked up.
+func IsPatientID(value string) bool {
+	return patientIDPattern.MatchString(value)
+}
+
+// IsNationalInsuranceNumber reports whether value is a correctly formatted
+// national insurance number, ignoring case and spaces.
+func IsNationalInsuranceNumber(value string) bool {
+	normalised := strings.ToUpper(strings.ReplaceAll(value, " ", ""))
+
+	if !nationalInsuranceNumberPattern.MatchString(normalised) {
+		return false
+	}
+
+	for _, prefix := range invalidNationalInsurancePrefixes {
+		if strings.HasPrefix(normalised, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// reports whether value is a bare email address, without a display name, whose
+// domain has at least two labels.
+func isEmail(value string) bool {
+	address, err := mail.ParseAddress(value)
+	if err != nil || address.Address != value {
+		return false
+	}
+
+	domain := value[strings.LastIndex(value, "@")+1:]
+
+	return strings.Contains(domain, ".") && !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+func validateDateOfBirth(v *validator, value string, now time.Time) {
+	dateOfBirth, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		v.fail("date_of_birth", "must be a date in the format YYYY-MM-DD")
+		return
+	}
+
+	if !dateOfBirth.Before(now) {
+		v.fail("date_of_birth", "must be in the past")
+		return
+	}
+
+	if dateOfBirth.Before(now.AddDate(-maxAgeInYears, 0, 0)) {
+		v.fail("date_of_birth", fmt.Sprintf("must be within the last %d years", maxAgeInYears))
+	}
+}
+
+func isUKCountry(country string) bool {
+	normalised := strings.ToLower(strings.TrimSpace(country))
+
+	for _, ukCountry := range ukCountries {
+		if normalised == ukCountry {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,151 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+)
+
+func TestValidateCreatePatientRequest(t *testing.T) {
+	t.Run("a request with only a first name is valid", func(t *testing.T) {
+		got := ValidateCreatePatientRequest(patients.CreatePatientRequest{FirstName: "Jane"})
+
+		assertFieldErrors(t, got, nil)
+	})
+
+	t.Run("a fully populated request is valid", func(t *testing.T) {
+		got := ValidateCreatePatientRequest(patients.CreatePatientRequest{
+			Title:                   "Mrs",
+			FirstName:               "Jane",
+			LastName:                "Doe",
+			NationalInsuranceNumber: "AB 12 34 56 C",
+			Email:                   "jane.doe@gmail.com",
+			DateOfBirth:             "1985-04-12",
+			PostCode:                "ls18 9bq",
+			Country:                 "United Kingdom",
+			MobilePhone:             "07700 900123",
+			HomePhone:               "0113 496 0000",
+			WorkPhone:               "+44 (0)113 496 0001",
+			EmergencyContactPhone:   "+353 1 555 0100",
+		})
+
+		assertFieldErrors(t, got, nil)
+	})
+
+	t.Run("first name is required", func(t *testing.T) {
+		got := ValidateCreatePatientRequest(patients.CreatePatientRequest{FirstName: "  "})
+
+		assertFieldErrors(t, got, []FieldError{{Field: "first_name", Reason: "is required"}})
+	})
+
+	cases := []struct {
+		name    string
+		request patients.CreatePatientRequest
+		field   string
+	}{
+		{name: "email without a domain", request: patients.CreatePatientRequest{Email: "jane.doe"}, field: "email"},
+		{name: "email without a top level domain", request: patients.CreatePatientRequest{Email: "jane@localhost"}, field: "email"},
+		{name: "email with a display name", request: patients.CreatePatientRequest{Email: "Jane <jane@gmail.com>"}, field: "email"},
+		{name: "date of birth in the wrong format", request: patients.CreatePatientRequest{DateOfBirth: "12/04/1985"}, field: "date_of_birth"},
+		{name: "date of birth that does not exist", request: patients.CreatePatientRequest{DateOfBirth: "1985-02-30"}, field: "date_of_birth"},
+		{name: "date of birth in the future", request: patients.CreatePatientRequest{DateOfBirth: time.Now().AddDate(0, 0, 1).Format("2006-01-02")}, field: "date_of_birth"},
+		{name: "date of birth too long ago", request: patients.CreatePatientRequest{DateOfBirth: "1850-01-01"}, field: "date_of_birth"},
+		{name: "uk post code in the wrong format", request: patients.CreatePatientRequest{PostCode: "LS18 BQ9"}, field: "post_code"},
+		{name: "national insurance number in the wrong format", request: patients.CreatePatientRequest{NationalInsuranceNumber: "QQ123456"}, field: "national_insurance_number"},
+		{name: "national insurance number with a prefix that is never issued", request: patients.CreatePatientRequest{NationalInsuranceNumber: "GB123456C"}, field: "national_insurance_number"},
+		{name: "mobile phone that is not a mobile number", request: patients.CreatePatientRequest{MobilePhone: "0113 496 0000"}, field: "mobile_phone"},
+		{name: "home phone with letters", request: patients.CreatePatientRequest{HomePhone: "0113 CALL ME"}, field: "home_phone"},
+		{name: "work phone that is too short", request: patients.CreatePatientRequest{WorkPhone: "12345"}, field: "work_phone"},
+		{name: "last name that is too long", request: patients.CreatePatientRequest{LastName: strings.Repeat("a", 101)}, field: "last_name"},
+		{name: "email that is too long", request: patients.CreatePatientRequest{Email: strings.Repeat("a", 250) + "@gmail.com"}, field: "email"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name+" is invalid", func(t *testing.T) {
+			c.request.FirstName = "Jane"
+
+			got := ValidateCreatePatientRequest(c.request)
+
+			if len(got) != 1 || got[0].Field != c.field {
+				t.Errorf("got %+v want a single error for %q", got, c.field)
+			}
+		})
+	}
+
+	t.Run("post codes outside of the uk are not checked against the uk format", func(t *testing.T) {
+		got := ValidateCreatePatientRequest(patients.CreatePatientRequest{FirstName: "Jane", PostCode: "D02 X285", Country: "Ireland"})
+
+		assertFieldErrors(t, got, nil)
+	})
+
+	t.Run("every invalid field is reported", func(t *testing.T) {
+		got := ValidateCreatePatientRequest(patients.CreatePatientRequest{Email: "jane", PostCode: "nope"})
+
+		assertFieldErrors(t, got, []FieldError{
+			{Field: "first_name", Reason: "is required"},
+			{Field: "email", Reason: "must be a valid email address"},
+			{Field: "post_code", Reason: "must be a valid uk post code"},
+		})
+	})
+}
+
+func TestValidateMergePatientsRequest(t *testing.T) {
+	t.Run("a request copying updatable fields is valid", func(t *testing.T) {
+		got := ValidateMergePatientsRequest(patients.MergePatientsRequest{PatientID: "3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", SourcePatientID: "9c1d2e3f-4a5b-4c6d-8e7f-0a1b2c3d4e5f", Fields: []string{"email", "mobile_phone"}})
+
+		assertFieldErrors(t, got, nil)
+	})
+
+	t.Run("the source patient is required", func(t *testing.T) {
+		got := ValidateMergePatientsRequest(patients.MergePatientsRequest{PatientID: "3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34"})
+
+		assertFieldErrors(t, got, []FieldError{{Field: "source_patient_id", Reason: "is required"}})
+	})
+
+	t.Run("a patient can not be merged into itself", func(t *testing.T) {
+		got := ValidateMergePatientsRequest(patients.MergePatientsRequest{PatientID: "3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", SourcePatientID: "3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34"})
+
+		assertFieldErrors(t, got, []FieldError{{Field: "source_patient_id", Reason: "a patient can not be merged into itself"}})
+	})
+
+	t.Run("the source patient has to be a patient id", func(t *testing.T) {
+		got := ValidateMergePatientsRequest(patients.MergePatientsRequest{PatientID: "3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", SourcePatientID: "9c1d2e3f#h#2022"})
+
+		assertFieldErrors(t, got, []FieldError{{Field: "source_patient_id", Reason: "is not a patient id"}})
+	})
+
+	t.Run("every field that can not be copied is reported", func(t *testing.T) {
+		got := ValidateMergePatientsRequest(patients.MergePatientsRequest{PatientID: "3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", SourcePatientID: "9c1d2e3f-4a5b-4c6d-8e7f-0a1b2c3d4e5f", Fields: []string{"patient_id", "email", "created_at"}})
+
+		assertFieldErrors(t, got, []FieldError{
+			{Field: "fields", Reason: `"patient_id" can not be copied from the merged patient`},
+			{Field: "fields", Reason: `"created_at" can not be copied from the merged patient`},
+		})
+	})
+}
+
+func TestIsPatientID(t *testing.T) {
+	for value, want := range map[string]bool{
+		"3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34":         true,
+		"3F2B6C1E-8D4A-4C7B-9E21-5A6F0D8B7C34":         false,
+		"3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34#h#2022":  false,
+		"3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34/history": false,
+		"test_patient_id":                              false,
+		"":                                             false,
+	} {
+		if got := IsPatientID(value); got != want {
+			t.Errorf("IsPatientID(%q) got %v want %v", value, got, want)
+		}
+	}
+}
+
+func assertFieldErrors(t testing.TB, got, want []FieldError) {
+	t.Helper()
+
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Error("unexpected field errors", diff)
+	}
+}
@@ -0,0 +1,122 @@
+package patients
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+func TestPatientVersions(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	// both stores have to version patients the same way
+	stores := map[string]PatientRepository{
+		"dynamodb": &PatientStore{client: newFakeDynamoDBClient(), tableName: "test_table"},
+		"memory":   NewMemoryPatientStore(),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			created, err := store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: "Jane", LastName: "Doe"})
+			if err != nil {
+				t.Fatalf("could not create the patient: %v", err)
+			}
+
+			t.Run("a new patient is at version 1", func(t *testing.T) {
+				got, _ := store.GetPatient(context.Background(), dentalPracticeID, created.PatientID)
+				if got.Version != 1 {
+					t.Errorf("got version %v want 1", got.Version)
+				}
+			})
+
+			t.Run("an update moves the patient on to the next version", func(t *testing.T) {
+				updated, err := store.UpdatePatient(context.Background(), dentalPracticeID, UpdatePatientRequest{PatientID: created.PatientID, FirstName: "Janet"}, 1)
+				if err != nil {
+					t.Fatalf("could not update the patient: %v", err)
+				}
+
+				got, _ := store.GetPatient(context.Background(), dentalPracticeID, created.PatientID)
+				if updated.Version != 2 || got.Version != 2 {
+					t.Errorf("got versions %v and %v want 2", updated.Version, got.Version)
+				}
+			})
+
+			t.Run("an update based on an old version is rejected", func(t *testing.T) {
+				_, err := store.UpdatePatient(context.Background(), dentalPracticeID, UpdatePatientRequest{PatientID: created.PatientID, FirstName: "Jan"}, 1)
+				if !errors.Is(err, ErrPreconditionFailed) {
+					t.Errorf("got error %v want %v", err, ErrPreconditionFailed)
+				}
+
+				got, _ := store.GetPatient(context.Background(), dentalPracticeID, created.PatientID)
+				if got.FirstName != "Janet" {
+					t.Errorf("got first name %q want the stale update to be ignored", got.FirstName)
+				}
+			})
+
+			t.Run("merging moves both patients on to their next version", func(t *testing.T) {
+				source, _ := store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: "Janet"})
+
+				merged, err := store.MergePatients(context.Background(), dentalPracticeID, MergePatientsRequest{PatientID: created.PatientID, SourcePatientID: source.PatientID}, 2)
+				if err != nil {
+					t.Fatalf("could not merge the patients: %v", err)
+				}
+
+				got, _ := store.GetPatient(context.Background(), dentalPracticeID, source.PatientID)
+				if merged.Version != 3 || got.Version != 2 {
+					t.Errorf("got versions %v and %v want 3 and 2", merged.Version, got.Version)
+				}
+			})
+		})
+	}
+
+	t.Run("a patient stored before versions were added is at version 0", func(t *testing.T) {
+		client := newFakeDynamoDBClient()
+		store := &PatientStore{client: client, tableName: "test_table"}
+
+		created, err := store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: "Jane"})
+		if err != nil {
+			t.Fatalf("could not create the patient: %v", err)
+		}
+
+		// patients stored back then have neither a version nor any history
+		delete(client.items["dp#"+dentalPracticeID+"|p#"+created.PatientID], "v")
+		delete(client.items, "dp#"+dentalPracticeID+"|"+historySortKey(created.PatientID, 1))
+
+		updated, err := store.UpdatePatient(context.Background(), dentalPracticeID, UpdatePatientRequest{PatientID: created.PatientID, FirstName: "Janet"}, 0)
+		if err != nil {
+			t.Fatalf("could not update the patient: %v", err)
+		}
+
+		if updated.Version != 1 {
+			t.Errorf("got version %v want 1", updated.Version)
+		}
+	})
+
+	t.Run("a patient written between being read and updated is reported as changed", func(t *testing.T) {
+		client := newFakeDynamoDBClient()
+		store := &PatientStore{client: client, tableName: "test_table"}
+
+		created, err := store.CreatePatient(context.Background(), dentalPracticeID, CreatePatientRequest{FirstName: "Jane"})
+		if err != nil {
+			t.Fatalf("could not create the patient: %v", err)
+		}
+
+		existing, _ := store.GetPatient(context.Background(), dentalPracticeID, created.PatientID)
+		_, transactItems, err := store.updatePatientWrites(context.Background(), dentalPracticeID, UpdatePatientRequest{PatientID: created.PatientID, FirstName: "Janet"}, existing)
+		if err != nil {
+			t.Fatalf("could not build the update: %v", err)
+		}
+
+		// another request updates the patient first
+		if _, err := store.UpdatePatient(context.Background(), dentalPracticeID, UpdatePatientRequest{PatientID: created.PatientID, FirstName: "Jan"}, 1); err != nil {
+			t.Fatalf("could not update the patient: %v", err)
+		}
+
+		_, err = client.TransactWriteItems(context.Background(), &dynamodb.TransactWriteItemsInput{TransactItems: transactItems})
+		if !isConditionalCheckFailure(err, 0) {
+			t.Errorf("got error %v want the condition on the patient to fail", err)
+		}
+	})
+}
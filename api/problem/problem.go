@@ -0,0 +1,98 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
+	"go.uber.org/zap"
+)
+
+// ContentType is the media type every error response is written with.
+const ContentType string = "application/problem+json"
+
+// the types of problem that clients can tell apart without parsing the detail.
+// about:blank means the problem is described by its status code alone.
+const (
+	TypeBlank                = "about:blank"
+	TypeValidation           = "/problems/validation"
+	TypeDuplicatePatient     = "/problems/duplicate-patient"
+	TypeIdempotencyKeyReused = "/problems/idempotency-key-reused"
+)
+
+// Problem is an rfc 7807 problem details object, the body of every error
+// response of the api.
+type Problem struct {
+	// a uri reference that identifies the type of problem
+	Type string `json:"type"`
+	// a short summary of the type of problem, which is the same every time
+	Title string `json:"title"`
+	// the http status code of the response
+	Status int `json:"status"`
+	// what went wrong with this request in particular
+	Detail string `json:"detail,omitempty"`
+	// the id of the request, so that a problem can be found in the logs
+	RequestID string `json:"request_id,omitempty"`
+	// the fields of the request that are invalid
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes why a single field of a request is invalid.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Details is a problem, or a struct that embeds one to add extension members
+// to it.
+type Details interface {
+	problem() *Problem
+}
+
+func (p *Problem) problem() *Problem {
+	return p
+}
+
+// Validation returns the problem for a request with fields that are invalid.
+func Validation(detail string, errors []FieldError) *Problem {
+	return &Problem{
+		Type:   TypeValidation,
+		Title:  "Invalid Request",
+		Status: http.StatusBadRequest,
+		Detail: detail,
+		Errors: errors,
+	}
+}
+
+// Write replies to the request with the problem. the title of problems without
+// one is the text of their status code, and the id of the request is added
+// from its context.
+func Write(w http.ResponseWriter, r *http.Request, details Details) {
+	p := details.problem()
+
+	if p.Type == "" {
+		p.Type = TypeBlank
+	}
+
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+
+	if p.RequestID == "" {
+		p.RequestID = logging.RequestIDFromContext(r.Context())
+	}
+
+	w.Header().Set("content-type", ContentType)
+	w.Header().Set("x-content-type-options", "nosniff")
+	w.WriteHeader(p.Status)
+
+	if err := json.NewEncoder(w).Encode(details); err != nil {
+		logging.FromContext(r.Context(), nil).Error("failed to encode the json for the problem", zap.Error(err))
+	}
+}
+
+// Error replies to the request with a problem that is described by its status
+// code and the detail. it is used in place of http.Error.
+func Error(w http.ResponseWriter, r *http.Request, detail string, status int) {
+	Write(w, r, &Problem{Status: status, Detail: detail})
+}
@@ -0,0 +1,98 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
+	"go.uber.org/zap"
+)
+
+func TestError(t *testing.T) {
+	// create a handler that replies to requests that have been given an id
+	handler := logging.RequestIDs(zap.NewNop(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Error(w, r, "requested patient could not be found", http.StatusNotFound)
+	}))
+
+	req, _ := http.NewRequest("GET", "/patients/test_patient_id", nil)
+	req.Header.Set(logging.RequestIDHeader, "test_request_id")
+	res := httptest.NewRecorder()
+
+	handler.ServeHTTP(res, req)
+
+	if res.Code != http.StatusNotFound {
+		t.Errorf("got status code %v want %v", res.Code, http.StatusNotFound)
+	}
+
+	if got := res.Header().Get("content-type"); got != ContentType {
+		t.Errorf("got content type %q want %q", got, ContentType)
+	}
+
+	var got Problem
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatalf("unable to decode the problem, '%v'", err)
+	}
+
+	want := Problem{
+		Type:      TypeBlank,
+		Title:     "Not Found",
+		Status:    http.StatusNotFound,
+		Detail:    "requested patient could not be found",
+		RequestID: "test_request_id",
+	}
+
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Error("unexpected problem", diff)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	t.Run("writes the field errors of a validation problem", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/patients", nil)
+		res := httptest.NewRecorder()
+
+		Write(res, req, Validation("request body is invalid", []FieldError{{Field: "first_name", Reason: "is required"}}))
+
+		var got map[string]interface{}
+		json.NewDecoder(res.Body).Decode(&got)
+
+		want := map[string]interface{}{
+			"type":   TypeValidation,
+			"title":  "Invalid Request",
+			"status": float64(http.StatusBadRequest),
+			"detail": "request body is invalid",
+			"errors": []interface{}{map[string]interface{}{"field": "first_name", "reason": "is required"}},
+		}
+
+		// there is no request id to add, so it is left out
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Error("unexpected problem", diff)
+		}
+	})
+
+	t.Run("writes the extension members of a struct that embeds a problem", func(t *testing.T) {
+		type outOfCredit struct {
+			Problem
+			Balance int `json:"balance"`
+		}
+
+		req, _ := http.NewRequest("POST", "/orders", nil)
+		res := httptest.NewRecorder()
+
+		Write(res, req, &outOfCredit{Problem: Problem{Type: "/problems/out-of-credit", Title: "Out Of Credit", Status: http.StatusForbidden}, Balance: 30})
+
+		if res.Code != http.StatusForbidden {
+			t.Errorf("got status code %v want %v", res.Code, http.StatusForbidden)
+		}
+
+		var got map[string]interface{}
+		json.NewDecoder(res.Body).Decode(&got)
+
+		if got["balance"] != float64(30) || got["title"] != "Out Of Credit" {
+			t.Errorf("got %v want the problem with its balance", got)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.uber.org/zap"
+)
+
+// how many times taking a request is tried when other requests keep changing
+// the bucket at the same time.
+const maxAttempts int = 3
+
+// buckets are kept for a while after they are full again, so that dynamodb
+// deleting them late does not matter.
+const bucketTTLMargin time.Duration = time.Minute
+
+type dynamoDBClient interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// DynamoDBStore keeps the token buckets in the patients table, next to the
+// patients of the dental practice, so that every lambda instance shares them.
+type DynamoDBStore struct {
+	client    dynamoDBClient
+	tableName string
+	now       func() time.Time
+}
+
+func NewDynamoDBStore(logger *zap.Logger) *DynamoDBStore {
+	dynamodbTableName, ok := os.LookupEnv("DYNAMODB_TABLENAME")
+	if !ok {
+		logger.Fatal("the DYNAMODB_TABLENAME variable was not set!")
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		logger.Fatal("unable to load sdk config", zap.Error(err))
+	}
+
+	return &DynamoDBStore{client: dynamodb.NewFromConfig(cfg), tableName: dynamodbTableName, now: time.Now}
+}
+
+// Take takes a request from the bucket. a bucket that other requests keep
+// changing is being hammered, so once every attempt has lost the race the
+// request is rejected until the bucket has had time to refill, rather than
+// being let through.
+func (d *DynamoDBStore) Take(ctx context.Context, key Key, limit Limit) (Decision, error) {
+	for attempt := 1; ; attempt++ {
+		decision, err := d.take(ctx, key, limit)
+
+		var conditionalCheckFailed *types.ConditionalCheckFailedException
+		if !errors.As(err, &conditionalCheckFailed) {
+			return decision, err
+		}
+
+		if attempt == maxAttempts {
+			return Decision{RetryAfter: time.Duration(float64(time.Second) / limit.Rate)}, nil
+		}
+	}
+}
+
+// reads the bucket, takes a request from it and writes it back as long as no
+// other request changed it in the meantime.
+func (d *DynamoDBStore) take(ctx context.Context, key Key, limit Limit) (Decision, error) {
+	itemKey := bucketItemKey(key)
+
+	response, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.tableName),
+		Key:            itemKey,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return Decision{}, fmt.Errorf("could not get the rate limit bucket: %w", err)
+	}
+
+	stored, err := unmarshalBucket(response.Item)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	now := d.now()
+
+	taken, decision, err := stored.take(limit, now)
+	if err != nil || !decision.Allowed {
+		// nothing was taken, so there is nothing to write
+		return decision, err
+	}
+
+	item := map[string]types.AttributeValue{
+		"_pk": itemKey["_pk"],
+		"_sk": itemKey["_sk"],
+		"tk":  &types.AttributeValueMemberN{Value: strconv.FormatFloat(taken.Tokens, 'f', -1, 64)},
+		"u":   &types.AttributeValueMemberN{Value: strconv.FormatInt(taken.UpdatedAt.UnixNano(), 10)},
+		"ttl": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(taken.fullAfter(limit)+bucketTTLMargin).Unix(), 10)},
+	}
+
+	put := &dynamodb.PutItemInput{
+		TableName:                aws.String(d.tableName),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(#_pk)"),
+		ExpressionAttributeNames: map[string]string{"#_pk": "_pk"},
+	}
+
+	if response.Item != nil {
+		put.ConditionExpression = aws.String("#u = :u")
+		put.ExpressionAttributeNames = map[string]string{"#u": "u"}
+		put.ExpressionAttributeValues = map[string]types.AttributeValue{":u": response.Item["u"]}
+	}
+
+	_, err = d.client.PutItem(ctx, put)
+	if err != nil {
+		return Decision{}, fmt.Errorf("could not update the rate limit bucket: %w", err)
+	}
+
+	return decision, nil
+}
+
+// the key of the item a bucket is stored in, in the partition of the dental
+// practice.
+func bucketItemKey(key Key) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"_pk": &types.AttributeValueMemberS{Value: "dp#" + key.DentalPracticeID},
+		"_sk": &types.AttributeValueMemberS{Value: "rl#" + key.Route + "#" + key.UserID},
+	}
+}
+
+// reads a bucket from its item, a missing item is a bucket that has never
+// been used.
+func unmarshalBucket(item map[string]types.AttributeValue) (bucket, error) {
+	if item == nil {
+		return bucket{}, nil
+	}
+
+	tokens, tokensOK := item["tk"].(*types.AttributeValueMemberN)
+	updatedAt, updatedAtOK := item["u"].(*types.AttributeValueMemberN)
+	if !tokensOK || !updatedAtOK {
+		return bucket{}, errors.New("the rate limit bucket is missing its tokens or when it was updated")
+	}
+
+	b := bucket{}
+
+	var err error
+	b.Tokens, err = strconv.ParseFloat(tokens.Value, 64)
+	if err != nil {
+		return bucket{}, fmt.Errorf("could not parse the tokens of the rate limit bucket: %w", err)
+	}
+
+	nanos, err := strconv.ParseInt(updatedAt.Value, 10, 64)
+	if err != nil {
+		return bucket{}, fmt.Errorf("could not parse when the rate limit bucket was updated: %w", err)
+	}
+	b.UpdatedAt = time.Unix(0, nanos)
+
+	return b, nil
+}
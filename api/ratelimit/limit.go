@@ -0,0 +1,172 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// the names of the routes that limits are set for.
+const (
+	RouteCreatePatient  string = "create-patient"
+	RouteGetPatient     string = "get-patient"
+	RouteSearchPatients string = "search-patients"
+	RouteUpdatePatient  string = "update-patient"
+	RouteMergePatients  string = "merge-patients"
+	RoutePatientHistory string = "patient-history"
+)
+
+// Limit is a token bucket, it holds up to Burst requests and refills at Rate
+// requests per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// DefaultLimits are the limits of each route when none are configured. they
+// are generous enough for a busy reception desk, searches are typed into a
+// search box so they are allowed the most.
+var DefaultLimits = map[string]Limit{
+	RouteCreatePatient:  {Rate: 1, Burst: 10},
+	RouteGetPatient:     {Rate: 10, Burst: 50},
+	RouteSearchPatients: {Rate: 5, Burst: 30},
+	RouteUpdatePatient:  {Rate: 2, Burst: 20},
+	RouteMergePatients:  {Rate: 0.5, Burst: 5},
+	RoutePatientHistory: {Rate: 2, Burst: 20},
+}
+
+// the periods a rate can be given per.
+var periods = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// ParseLimit parses a limit written as <requests>/<s|m|h>, optionally followed
+// by :<burst>, for example 300/m:50. the burst is the number of requests when
+// it is not given.
+func ParseLimit(s string) (Limit, error) {
+	rate, burst, hasBurst := strings.Cut(strings.TrimSpace(s), ":")
+
+	requests, per, ok := strings.Cut(rate, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("the limit %q is not of the form <requests>/<s|m|h>", s)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("the number of requests of the limit %q must be a positive number", s)
+	}
+
+	period, ok := periods[per]
+	if !ok {
+		return Limit{}, fmt.Errorf("the period of the limit %q must be one of s, m or h", s)
+	}
+
+	limit := Limit{Rate: float64(n) / period.Seconds(), Burst: n}
+
+	if hasBurst {
+		limit.Burst, err = strconv.Atoi(burst)
+		if err != nil || limit.Burst <= 0 {
+			return Limit{}, fmt.Errorf("the burst of the limit %q must be a positive number", s)
+		}
+	}
+
+	return limit, nil
+}
+
+// ParseLimits parses a comma separated list of <route>=<limit>, for example
+// search-patients=10/s:30,create-patient=60/m. the limits override the
+// defaults, a limit of off turns limiting off for the route.
+func ParseLimits(s string, defaults map[string]Limit) (map[string]Limit, error) {
+	limits := make(map[string]Limit, len(defaults))
+	for route, limit := range defaults {
+		limits[route] = limit
+	}
+
+	if strings.TrimSpace(s) == "" {
+		return limits, nil
+	}
+
+	for _, routeLimit := range strings.Split(s, ",") {
+		route, value, ok := strings.Cut(routeLimit, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not of the form <route>=<limit>", routeLimit)
+		}
+
+		route = strings.TrimSpace(route)
+		if strings.TrimSpace(value) == "off" {
+			delete(limits, route)
+			continue
+		}
+
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return nil, err
+		}
+
+		limits[route] = limit
+	}
+
+	return limits, nil
+}
+
+// LimitsFromEnv returns the default limits overridden by the RATE_LIMITS
+// variable, which is parsed by ParseLimits.
+func LimitsFromEnv(logger *zap.Logger) map[string]Limit {
+	limits, err := ParseLimits(os.Getenv("RATE_LIMITS"), DefaultLimits)
+	if err != nil {
+		logger.Fatal("the RATE_LIMITS variable is invalid", zap.Error(err))
+	}
+
+	return limits
+}
+
+// Decision is the outcome of taking a request from a bucket.
+type Decision struct {
+	Allowed bool
+	// how long until the bucket holds a request again, when the request was
+	// not allowed.
+	RetryAfter time.Duration
+}
+
+// the state of a token bucket, a bucket that has never been used is full.
+type bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+var errInvalidLimit = errors.New("the rate and burst of a limit must be positive")
+
+// refills the bucket for the time that has passed since it was last used, and
+// takes a request from it if it holds one.
+func (b bucket) take(limit Limit, now time.Time) (bucket, Decision, error) {
+	if limit.Rate <= 0 || limit.Burst <= 0 {
+		return b, Decision{}, errInvalidLimit
+	}
+
+	tokens := float64(limit.Burst)
+	if !b.UpdatedAt.IsZero() {
+		elapsed := now.Sub(b.UpdatedAt).Seconds()
+		tokens = math.Min(float64(limit.Burst), b.Tokens+math.Max(elapsed, 0)*limit.Rate)
+	}
+
+	if tokens < 1 {
+		retryAfter := time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+		return bucket{Tokens: tokens, UpdatedAt: now}, Decision{RetryAfter: retryAfter}, nil
+	}
+
+	return bucket{Tokens: tokens - 1, UpdatedAt: now}, Decision{Allowed: true}, nil
+}
+
+// how long until the bucket is full again, after which it is the same as a
+// bucket that has never been used and can be forgotten.
+func (b bucket) fullAfter(limit Limit) time.Duration {
+	return time.Duration((float64(limit.Burst) - b.Tokens) / limit.Rate * float64(time.Second))
+}
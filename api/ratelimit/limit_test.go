@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseLimit(t *testing.T) {
+	cases := []struct {
+		limit string
+		want  Limit
+	}{
+		{limit: "10/s", want: Limit{Rate: 10, Burst: 10}},
+		{limit: "120/m", want: Limit{Rate: 2, Burst: 120}},
+		{limit: "3600/h:10", want: Limit{Rate: 1, Burst: 10}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.limit, func(t *testing.T) {
+			got, err := ParseLimit(c.limit)
+			if err != nil || got != c.want {
+				t.Errorf("got %+v, '%v' want %+v", got, err, c.want)
+			}
+		})
+	}
+
+	t.Run("rejects invalid limits", func(t *testing.T) {
+		for _, limit := range []string{"", "10", "ten/s", "0/s", "10/d", "10/s:", "10/s:0"} {
+			if _, err := ParseLimit(limit); err == nil {
+				t.Errorf("%q was accepted", limit)
+			}
+		}
+	})
+}
+
+func TestParseLimits(t *testing.T) {
+	defaults := map[string]Limit{
+		RouteCreatePatient:  {Rate: 1, Burst: 10},
+		RouteSearchPatients: {Rate: 5, Burst: 30},
+	}
+
+	t.Run("returns the defaults when nothing is overridden", func(t *testing.T) {
+		got, err := ParseLimits("", defaults)
+		if diff := cmp.Diff(got, defaults); err != nil || diff != "" {
+			t.Errorf("unexpected limits '%v' %v", err, diff)
+		}
+	})
+
+	t.Run("overrides and turns off the limits of routes", func(t *testing.T) {
+		got, err := ParseLimits("search-patients=10/s:20, create-patient=off", defaults)
+
+		want := map[string]Limit{RouteSearchPatients: {Rate: 10, Burst: 20}}
+		if diff := cmp.Diff(got, want); err != nil || diff != "" {
+			t.Errorf("unexpected limits '%v' %v", err, diff)
+		}
+
+		// the defaults are left as they were
+		if _, ok := defaults[RouteCreatePatient]; !ok {
+			t.Error("the defaults were changed")
+		}
+	})
+
+	t.Run("rejects invalid limits", func(t *testing.T) {
+		for _, limits := range []string{"search-patients", "search-patients=fast"} {
+			if _, err := ParseLimits(limits, defaults); err == nil {
+				t.Errorf("%q was accepted", limits)
+			}
+		}
+	})
+}
+
+func TestBucket(t *testing.T) {
+	limit := Limit{Rate: 1, Burst: 2}
+	now := time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC)
+
+	t.Run("a new bucket is full", func(t *testing.T) {
+		b, first, _ := bucket{}.take(limit, now)
+		_, second, _ := b.take(limit, now)
+
+		if !first.Allowed || !second.Allowed {
+			t.Errorf("got %+v and %+v want both requests allowed", first, second)
+		}
+	})
+
+	t.Run("an empty bucket says when to retry", func(t *testing.T) {
+		_, decision, _ := bucket{Tokens: 0.25, UpdatedAt: now}.take(limit, now)
+
+		want := Decision{RetryAfter: 750 * time.Millisecond}
+		if decision != want {
+			t.Errorf("got %+v want %+v", decision, want)
+		}
+	})
+
+	t.Run("refills at the rate up to the burst", func(t *testing.T) {
+		b, decision, _ := bucket{Tokens: 0, UpdatedAt: now}.take(limit, now.Add(time.Hour))
+
+		if !decision.Allowed || b.Tokens != 1 {
+			t.Errorf("got %+v with %v tokens left want allowed with 1 token left", decision, b.Tokens)
+		}
+	})
+
+	t.Run("rejects limits that never refill", func(t *testing.T) {
+		if _, _, err := (bucket{}).take(Limit{Burst: 1}, now); err == nil {
+			t.Error("a limit without a rate was accepted")
+		}
+	})
+}
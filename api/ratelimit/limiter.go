@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
+	"go.uber.org/zap"
+)
+
+// Limiter limits how often each user of a dental practice can call each
+// route.
+type Limiter struct {
+	store  Store
+	limits map[string]Limit
+}
+
+// NewLimiter returns a limiter that keeps its buckets in store. routes
+// without a limit are not limited.
+func NewLimiter(store Store, limits map[string]Limit) *Limiter {
+	return &Limiter{store: store, limits: limits}
+}
+
+// Enforce rejects requests to the route with a 429 once the user making them
+// has used up the limit of the route. it has to run after the identity of the
+// request is known, requests without one are passed through for the handler
+// to reject.
+func (l *Limiter) Enforce(logger *zap.Logger, route string, next http.Handler) http.Handler {
+	limit, ok := l.limits[route]
+	if !ok {
+		logger.Info("the route is not rate limited", zap.String("route", route))
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context(), logger)
+
+		identity, ok := auth.FromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		decision, err := l.store.Take(r.Context(), Key{DentalPracticeID: identity.DentalPracticeID, UserID: identity.UserID, Route: route}, limit)
+
+		// the limits protect the api, they should not take it down with them
+		if err != nil {
+			logger.Error("could not check the rate limit, letting the request through", zap.Error(err))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !decision.Allowed {
+			logger.Warn("the request was rate limited", zap.String("route", route), zap.String("userID", identity.UserID), zap.Duration("retryAfter", decision.RetryAfter))
+			w.Header().Set("retry-after", strconv.Itoa(int(math.Max(1, math.Ceil(decision.RetryAfter.Seconds())))))
+			problem.Error(w, r, "too many requests, please try again later", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"go.uber.org/zap"
+)
+
+type StubStore struct {
+	take func(ctx context.Context, key Key, limit Limit) (Decision, error)
+}
+
+func (s *StubStore) Take(ctx context.Context, key Key, limit Limit) (Decision, error) {
+	return s.take(ctx, key, limit)
+}
+
+func TestEnforce(t *testing.T) {
+	// create the logger
+	logger, _ := zap.NewProduction()
+
+	identity := auth.Identity{DentalPracticeID: "test_dental_practice_id", UserID: "test_user_id", Role: auth.RoleAdmin}
+	limits := map[string]Limit{RouteSearchPatients: {Rate: 1, Burst: 2}}
+
+	// the handler being limited
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("returns 429 (too many requests) with retry-after once the limit is used up", func(t *testing.T) {
+		store := &StubStore{
+			take: func(_ context.Context, key Key, limit Limit) (Decision, error) {
+				want := Key{DentalPracticeID: identity.DentalPracticeID, UserID: identity.UserID, Route: RouteSearchPatients}
+				if key != want || limit != limits[RouteSearchPatients] {
+					t.Errorf("got Take(%+v, %+v) want Take(%+v, %+v)", key, limit, want, limits[RouteSearchPatients])
+				}
+				return Decision{RetryAfter: 1500 * time.Millisecond}, nil
+			},
+		}
+
+		req, _ := http.NewRequest("GET", "/patients", nil)
+		req = req.WithContext(auth.NewContext(req.Context(), identity))
+		res := httptest.NewRecorder()
+
+		NewLimiter(store, limits).Enforce(logger, RouteSearchPatients, next).ServeHTTP(res, req)
+
+		if res.Code != http.StatusTooManyRequests || res.Header().Get("retry-after") != "2" {
+			t.Errorf("got %v with retry-after %q want 429 with retry-after 2", res.Code, res.Header().Get("retry-after"))
+		}
+	})
+
+	t.Run("passes the request on while there is limit left", func(t *testing.T) {
+		store := &StubStore{
+			take: func(_ context.Context, _ Key, _ Limit) (Decision, error) {
+				return Decision{Allowed: true}, nil
+			},
+		}
+
+		req, _ := http.NewRequest("GET", "/patients", nil)
+		req = req.WithContext(auth.NewContext(req.Context(), identity))
+		res := httptest.NewRecorder()
+
+		NewLimiter(store, limits).Enforce(logger, RouteSearchPatients, next).ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK {
+			t.Errorf("got %v want 200", res.Code)
+		}
+	})
+
+	t.Run("passes the request on when the limit cannot be checked", func(t *testing.T) {
+		store := &StubStore{
+			take: func(_ context.Context, _ Key, _ Limit) (Decision, error) {
+				return Decision{}, errors.New("call to dynamodb failed")
+			},
+		}
+
+		req, _ := http.NewRequest("GET", "/patients", nil)
+		req = req.WithContext(auth.NewContext(req.Context(), identity))
+		res := httptest.NewRecorder()
+
+		NewLimiter(store, limits).Enforce(logger, RouteSearchPatients, next).ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK {
+			t.Errorf("got %v want 200", res.Code)
+		}
+	})
+
+	t.Run("passes requests without an identity on for the handler to reject", func(t *testing.T) {
+		store := &StubStore{}
+
+		req, _ := http.NewRequest("GET", "/patients", nil)
+		res := httptest.NewRecorder()
+
+		NewLimiter(store, limits).Enforce(logger, RouteSearchPatients, next).ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK {
+			t.Errorf("got %v want 200", res.Code)
+		}
+	})
+}
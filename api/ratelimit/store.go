@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Key identifies the bucket a request is taken from, every user of a dental
+// practice has their own bucket for each route.
+type Key struct {
+	DentalPracticeID string
+	UserID           string
+	Route            string
+}
+
+// Store keeps the token buckets.
+type Store interface {
+	// Take takes a request from the bucket of the key, as long as it holds one.
+	Take(ctx context.Context, key Key, limit Limit) (Decision, error)
+}
+
+// how often the memory store forgets the buckets that have filled up again.
+const sweepInterval time.Duration = time.Minute
+
+// MemoryStore keeps the token buckets in memory. it is only suitable for a
+// single server, lambda instances each have their own memory and would each
+// allow the full limit. it is safe for concurrent use.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[Key]memoryBucket
+	// when the buckets that have filled up again are next forgotten
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+// a bucket along with when it is full again.
+type memoryBucket struct {
+	bucket
+	fullAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[Key]memoryBucket), now: time.Now}
+}
+
+func (m *MemoryStore) Take(_ context.Context, key Key, limit Limit) (Decision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+
+	taken, decision, err := m.buckets[key].take(limit, now)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	m.buckets[key] = memoryBucket{bucket: taken, fullAt: now.Add(taken.fullAfter(limit))}
+
+	// a full bucket is the same as one that has never been used, so they are
+	// forgotten to keep the map to the users that have been busy recently
+	if now.After(m.nextSweep) {
+		for k, b := range m.buckets {
+			if now.After(b.fullAt) {
+				delete(m.buckets, k)
+			}
+		}
+
+		m.nextSweep = now.Add(sweepInterval)
+	}
+
+	return decision, nil
+}
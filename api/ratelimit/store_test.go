@@ -0,0 +1,180 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// a very small stand in for a dynamodb table, it only understands the
+// conditions used by the dynamodb store.
+type fakeDynamoDBClient struct {
+	mu    sync.Mutex
+	items map[string]map[string]types.AttributeValue
+
+	// called before every put, so a test can change the item in between
+	beforePut func()
+}
+
+func newFakeDynamoDBClient() *fakeDynamoDBClient {
+	return &fakeDynamoDBClient{items: map[string]map[string]types.AttributeValue{}}
+}
+
+func (f *fakeDynamoDBClient) GetItem(_ context.Context, params *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &dynamodb.GetItemOutput{Item: f.items[fakeItemKey(params.Key)]}, nil
+}
+
+func (f *fakeDynamoDBClient) PutItem(_ context.Context, params *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if f.beforePut != nil {
+		f.beforePut()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	existing, exists := f.items[fakeItemKey(params.Item)]
+
+	switch aws.ToString(params.ConditionExpression) {
+	case "attribute_not_exists(#_pk)":
+		if exists {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+	case "#u = :u":
+		if !exists || existing["u"].(*types.AttributeValueMemberN).Value != params.ExpressionAttributeValues[":u"].(*types.AttributeValueMemberN).Value {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+	}
+
+	f.items[fakeItemKey(params.Item)] = params.Item
+
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func fakeItemKey(item map[string]types.AttributeValue) string {
+	return item["_pk"].(*types.AttributeValueMemberS).Value + "|" + item["_sk"].(*types.AttributeValueMemberS).Value
+}
+
+func TestStores(t *testing.T) {
+	now := time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	limit := Limit{Rate: 1, Burst: 2}
+	key := Key{DentalPracticeID: "test_dental_practice_id", UserID: "test_user_id", Route: RouteSearchPatients}
+
+	// both stores have to limit requests the same way
+	stores := map[string]Store{
+		"dynamodb": &DynamoDBStore{client: newFakeDynamoDBClient(), tableName: "test_table", now: clock},
+		"memory":   &MemoryStore{buckets: make(map[Key]memoryBucket), now: clock},
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			t.Run("allows the burst and then rejects requests", func(t *testing.T) {
+				for i := 0; i < 2; i++ {
+					if decision, err := store.Take(ctx, key, limit); err != nil || !decision.Allowed {
+						t.Fatalf("request %v got %+v, '%v' want it allowed", i+1, decision, err)
+					}
+				}
+
+				decision, err := store.Take(ctx, key, limit)
+
+				want := Decision{RetryAfter: time.Second}
+				if err != nil || decision != want {
+					t.Errorf("got %+v, '%v' want %+v", decision, err, want)
+				}
+			})
+
+			t.Run("other users and dental practices have their own buckets", func(t *testing.T) {
+				for _, other := range []Key{
+					{DentalPracticeID: key.DentalPracticeID, UserID: "another_user_id", Route: key.Route},
+					{DentalPracticeID: "another_dental_practice_id", UserID: key.UserID, Route: key.Route},
+					{DentalPracticeID: key.DentalPracticeID, UserID: key.UserID, Route: RouteGetPatient},
+				} {
+					if decision, err := store.Take(ctx, other, limit); err != nil || !decision.Allowed {
+						t.Errorf("%+v got %+v, '%v' want it allowed", other, decision, err)
+					}
+				}
+			})
+
+			t.Run("allows requests again once the bucket refills", func(t *testing.T) {
+				now = now.Add(time.Second)
+
+				if decision, err := store.Take(ctx, key, limit); err != nil || !decision.Allowed {
+					t.Errorf("got %+v, '%v' want it allowed", decision, err)
+				}
+			})
+		})
+	}
+}
+
+func TestDynamoDBStoreRetriesConcurrentChanges(t *testing.T) {
+	now := time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC)
+
+	client := newFakeDynamoDBClient()
+	store := &DynamoDBStore{client: client, tableName: "test_table", now: func() time.Time { return now }}
+
+	limit := Limit{Rate: 1, Burst: 2}
+	key := Key{DentalPracticeID: "test_dental_practice_id", UserID: "test_user_id", Route: RouteSearchPatients}
+
+	// another lambda instance takes a request between the read and the write
+	// of the first attempt
+	client.beforePut = func() {
+		client.beforePut = nil
+		other := &DynamoDBStore{client: client, tableName: "test_table", now: store.now}
+		if _, err := other.Take(context.Background(), key, limit); err != nil {
+			t.Fatalf("the other request failed, '%v'", err)
+		}
+	}
+
+	if decision, err := store.Take(context.Background(), key, limit); err != nil || !decision.Allowed {
+		t.Fatalf("got %+v, '%v' want it allowed", decision, err)
+	}
+
+	// both requests were taken from the bucket
+	if decision, _ := store.Take(context.Background(), key, limit); decision.Allowed {
+		t.Error("a third request was allowed")
+	}
+}
+
+func TestDynamoDBStoreRejectsContendedBuckets(t *testing.T) {
+	now := time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC)
+
+	client := newFakeDynamoDBClient()
+	store := &DynamoDBStore{client: client, tableName: "test_table", now: func() time.Time { return now }}
+
+	limit := Limit{Rate: 2, Burst: 10}
+	key := Key{DentalPracticeID: "test_dental_practice_id", UserID: "test_user_id", Route: RouteSearchPatients}
+
+	// another lambda instance takes a request between the read and the write
+	// of every attempt
+	otherNow := now
+	other := &DynamoDBStore{client: client, tableName: "test_table", now: func() time.Time {
+		otherNow = otherNow.Add(time.Nanosecond)
+		return otherNow
+	}}
+	client.beforePut = func() {
+		beforePut := client.beforePut
+		client.beforePut = nil
+		other.Take(context.Background(), key, limit)
+		client.beforePut = beforePut
+	}
+
+	decision, err := store.Take(context.Background(), key, limit)
+	if err != nil {
+		t.Fatalf("got '%v' want the request to be rejected without an error", err)
+	}
+
+	if decision.Allowed || decision.RetryAfter != 500*time.Millisecond {
+		t.Errorf("got %+v want it rejected until a request has been refilled", decision)
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awscognito"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsdynamodb"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
 	"github.com/aws/aws-cdk-go/awscdkapigatewayv2alpha/v2"
 	"github.com/aws/aws-cdk-go/awscdkapigatewayv2integrationsalpha/v2"
@@ -37,17 +40,78 @@ func NewPatientsServiceAppStack(scope constructs.Construct, id string, props *Pa
 			Name: jsii.String("_sk"),
 			Type: awsdynamodb.AttributeType_STRING},
 		BillingMode: awsdynamodb.BillingMode_PAY_PER_REQUEST,
+		// the idempotency keys of create patient requests, and the rate limit
+		// buckets (the rl# items), are deleted once they expire
+		TimeToLiveAttribute: jsii.String("ttl"),
 	})
 
+	// the attributes copied into the search indexes, which are what a search
+	// returns for each patient. the search items leave out the encrypted ones,
+	// which searches read from the patient items
+	searchItemAttributes := []string{"pid", "fn", "mn", "ln", "e", "mp", "dob", "pc"}
+
 	// add a global secondary index based on name
 	table.AddGlobalSecondaryIndex(&awsdynamodb.GlobalSecondaryIndexProps{
 		IndexName:        jsii.String("name-index"),
 		PartitionKey:     &awsdynamodb.Attribute{Name: jsii.String("_pk"), Type: awsdynamodb.AttributeType_STRING},
 		SortKey:          &awsdynamodb.Attribute{Name: jsii.String("st"), Type: awsdynamodb.AttributeType_STRING},
-		NonKeyAttributes: jsii.Strings("pid", "fn", "mn", "ln", "e", "mp", "dob", "pc"),
+		NonKeyAttributes: jsii.Strings(searchItemAttributes...),
+		ProjectionType:   awsdynamodb.ProjectionType_INCLUDE,
+	})
+
+	// add a global secondary index based on the email, mobile phone, post code,
+	// date of birth, phonetic name and hashed national insurance number search
+	// items, the sort key is prefixed with the field. encrypted fields are
+	// indexed by their keyed hashes
+	table.AddGlobalSecondaryIndex(&awsdynamodb.GlobalSecondaryIndexProps{
+		IndexName:        jsii.String("field-index"),
+		PartitionKey:     &awsdynamodb.Attribute{Name: jsii.String("_pk"), Type: awsdynamodb.AttributeType_STRING},
+		SortKey:          &awsdynamodb.Attribute{Name: jsii.String("fst"), Type: awsdynamodb.AttributeType_STRING},
+		NonKeyAttributes: jsii.Strings(searchItemAttributes...),
+		ProjectionType:   awsdynamodb.ProjectionType_INCLUDE,
+	})
+
+	// add a global secondary index based on when the patient was created, only
+	// patient items have a created at attribute so search items are left out.
+	// merged into is projected so that merged patients can be filtered out, and
+	// the data key so that the encrypted fields can be decrypted
+	table.AddGlobalSecondaryIndex(&awsdynamodb.GlobalSecondaryIndexProps{
+		IndexName:        jsii.String("created-index"),
+		PartitionKey:     &awsdynamodb.Attribute{Name: jsii.String("_pk"), Type: awsdynamodb.AttributeType_STRING},
+		SortKey:          &awsdynamodb.Attribute{Name: jsii.String("ca"), Type: awsdynamodb.AttributeType_STRING},
+		NonKeyAttributes: jsii.Strings(append(searchItemAttributes, "a", "ad", "ah", "mi", "dk")...),
 		ProjectionType:   awsdynamodb.ProjectionType_INCLUDE,
 	})
 
+	// create the kms key the sensitive patient fields are encrypted under
+	encryptionKey := awskms.NewKey(stack, jsii.String("PatientsEncryptionKey"), &awskms.KeyProps{
+		EnableKeyRotation: jsii.Bool(true),
+	})
+
+	// create the kms hmac key the key of the blind index is derived from, the
+	// blind index hashes national insurance numbers in the field-index. the
+	// higher level key construct can not create hmac keys yet
+	blindIndexKey := awskms.NewCfnKey(stack, jsii.String("PatientsBlindIndexKey"), &awskms.CfnKeyProps{
+		KeySpec:  jsii.String("HMAC_256"),
+		KeyUsage: jsii.String("GENERATE_VERIFY_MAC"),
+		KeyPolicy: awsiam.NewPolicyDocument(&awsiam.PolicyDocumentProps{
+			Statements: &[]awsiam.PolicyStatement{
+				awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+					Actions:    jsii.Strings("kms:*"),
+					Resources:  jsii.Strings("*"),
+					Principals: &[]awsiam.IPrincipal{awsiam.NewAccountRootPrincipal()},
+				}),
+			},
+		}),
+	})
+
+	// the environment shared by every lambda
+	lambdaEnvironment := &map[string]*string{
+		"DYNAMODB_TABLENAME":     table.TableName(),
+		"ENCRYPTION_KMS_KEY_ID":  encryptionKey.KeyArn(),
+		"BLIND_INDEX_KMS_KEY_ID": blindIndexKey.AttrArn(),
+	}
+
 	// bundling options to make go fast
 	bundlingOptions := &awscdklambdagoalpha.BundlingOptions{
 		GoBuildFlags: &[]*string{jsii.String(`-ldflags "-s -w" -tags lambda.norpc`)},
@@ -57,7 +121,7 @@ func NewPatientsServiceAppStack(scope constructs.Construct, id string, props *Pa
 	createPatientHandler := awscdklambdagoalpha.NewGoFunction(stack, jsii.String("CreatePatientFunction"), &awscdklambdagoalpha.GoFunctionProps{
 		Architecture: awslambda.Architecture_ARM_64(),
 		Entry:        jsii.String("../api/patients/create/lambda"),
-		Environment:  &map[string]*string{"DYNAMODB_TABLENAME": table.TableName()},
+		Environment:  lambdaEnvironment,
 		Bundling:     bundlingOptions,
 		MemorySize:   jsii.Number(1024),
 		Timeout:      awscdk.Duration_Millis(jsii.Number(15000)),
@@ -66,11 +130,14 @@ func NewPatientsServiceAppStack(scope constructs.Construct, id string, props *Pa
 	// grant dynamodb read write permissions to the create patient lambda
 	table.GrantReadWriteData(createPatientHandler)
 
+	// grant kms encrypt and decrypt permissions to the create patient lambda
+	encryptionKey.GrantEncryptDecrypt(createPatientHandler)
+
 	// creating the aws lambda for getting a patient
 	getPatientHandler := awscdklambdagoalpha.NewGoFunction(stack, jsii.String("GetPatientFunction"), &awscdklambdagoalpha.GoFunctionProps{
 		Architecture: awslambda.Architecture_ARM_64(),
 		Entry:        jsii.String("../api/patients/get/lambda"),
-		Environment:  &map[string]*string{"DYNAMODB_TABLENAME": table.TableName()},
+		Environment:  lambdaEnvironment,
 		Bundling:     bundlingOptions,
 		MemorySize:   jsii.Number(1024),
 		Timeout:      awscdk.Duration_Millis(jsii.Number(15000)),
@@ -79,11 +146,14 @@ func NewPatientsServiceAppStack(scope constructs.Construct, id string, props *Pa
 	// grant dynamodb read write permissions to the get patient lambda
 	table.GrantReadWriteData(getPatientHandler)
 
+	// grant kms decrypt permissions to the get patient lambda
+	encryptionKey.GrantDecrypt(getPatientHandler)
+
 	// creating the aws lambda for finding a patient
 	searchPatientsHandler := awscdklambdagoalpha.NewGoFunction(stack, jsii.String("SearchPatientsFunction"), &awscdklambdagoalpha.GoFunctionProps{
 		Architecture: awslambda.Architecture_ARM_64(),
 		Entry:        jsii.String("../api/patients/search/lambda"),
-		Environment:  &map[string]*string{"DYNAMODB_TABLENAME": table.TableName()},
+		Environment:  lambdaEnvironment,
 		Bundling:     bundlingOptions,
 		MemorySize:   jsii.Number(1024),
 		Timeout:      awscdk.Duration_Millis(jsii.Number(15000)),
@@ -92,13 +162,122 @@ func NewPatientsServiceAppStack(scope constructs.Construct, id string, props *Pa
 	// grant dynamodb read write permissions to the search patients lambda
 	table.GrantReadWriteData(searchPatientsHandler)
 
+	// grant kms decrypt permissions to the search patients lambda
+	encryptionKey.GrantDecrypt(searchPatientsHandler)
+
+	// creating the aws lambda for updating a patient
+	updatePatientHandler := awscdklambdagoalpha.NewGoFunction(stack, jsii.String("UpdatePatientFunction"), &awscdklambdagoalpha.GoFunctionProps{
+		Architecture: awslambda.Architecture_ARM_64(),
+		Entry:        jsii.String("../api/patients/update/lambda"),
+		Environment:  lambdaEnvironment,
+		Bundling:     bundlingOptions,
+		MemorySize:   jsii.Number(1024),
+		Timeout:      awscdk.Duration_Millis(jsii.Number(15000)),
+	})
+
+	// grant dynamodb read write permissions to the update patient lambda
+	table.GrantReadWriteData(updatePatientHandler)
+
+	// grant kms encrypt and decrypt permissions to the update patient lambda
+	encryptionKey.GrantEncryptDecrypt(updatePatientHandler)
+
+	// creating the aws lambda for merging patients
+	mergePatientsHandler := awscdklambdagoalpha.NewGoFunction(stack, jsii.String("MergePatientsFunction"), &awscdklambdagoalpha.GoFunctionProps{
+		Architecture: awslambda.Architecture_ARM_64(),
+		Entry:        jsii.String("../api/patients/merge/lambda"),
+		Environment:  lambdaEnvironment,
+		Bundling:     bundlingOptions,
+		MemorySize:   jsii.Number(1024),
+		Timeout:      awscdk.Duration_Millis(jsii.Number(15000)),
+	})
+
+	// grant dynamodb read write permissions to the merge patients lambda
+	table.GrantReadWriteData(mergePatientsHandler)
+
+	// grant kms encrypt and decrypt permissions to the merge patients lambda
+	encryptionKey.GrantEncryptDecrypt(mergePatientsHandler)
+
+	// creating the aws lambda for getting the history of a patient
+	patientHistoryHandler := awscdklambdagoalpha.NewGoFunction(stack, jsii.String("PatientHistoryFunction"), &awscdklambdagoalpha.GoFunctionProps{
+		Architecture: awslambda.Architecture_ARM_64(),
+		Entry:        jsii.String("../api/patients/history/lambda"),
+		Environment:  lambdaEnvironment,
+		Bundling:     bundlingOptions,
+		MemorySize:   jsii.Number(1024),
+		Timeout:      awscdk.Duration_Millis(jsii.Number(15000)),
+	})
+
+	// grant dynamodb read write permissions to the patient history lambda, it
+	// only reads patients but it writes its rate limit buckets
+	table.GrantReadWriteData(patientHistoryHandler)
+
+	// grant kms decrypt permissions to the patient history lambda
+	encryptionKey.GrantDecrypt(patientHistoryHandler)
+
+	// grant kms generate mac permissions to every lambda with a patient store,
+	// which derives the blind index key as it starts
+	for _, handler := range []awscdklambdagoalpha.GoFunction{createPatientHandler, getPatientHandler, searchPatientsHandler, updatePatientHandler, mergePatientsHandler, patientHistoryHandler} {
+		handler.AddToRolePolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Actions:   jsii.Strings("kms:GenerateMac"),
+			Resources: jsii.Strings(*blindIndexKey.AttrArn()),
+		}))
+	}
+
+	// creating the aws lambda for serving the openapi document, it does not
+	// need access to the table or the key
+	openAPIHandler := awscdklambdagoalpha.NewGoFunction(stack, jsii.String("OpenAPIFunction"), &awscdklambdagoalpha.GoFunctionProps{
+		Architecture: awslambda.Architecture_ARM_64(),
+		Entry:        jsii.String("../api/openapi/lambda"),
+		Bundling:     bundlingOptions,
+		MemorySize:   jsii.Number(128),
+		Timeout:      awscdk.Duration_Millis(jsii.Number(5000)),
+	})
+
+	// create the user pool the users of the dental practices sign in to, the
+	// dental practice and role of each user are carried in their tokens
+	userPool := awscognito.NewUserPool(stack, jsii.String("PatientsUserPool"), &awscognito.UserPoolProps{
+		CustomAttributes: &map[string]awscognito.ICustomAttribute{
+			"dental_practice_id": awscognito.NewStringAttribute(&awscognito.StringAttributeProps{Mutable: jsii.Bool(false)}),
+			"role":               awscognito.NewStringAttribute(&awscognito.StringAttributeProps{Mutable: jsii.Bool(true)}),
+		},
+	})
+
+	// create the client the front-end signs users in with. users can only
+	// change their own name and email through it, the dental practice and
+	// role are set by administrators with the admin apis, or any user could
+	// make themselves an admin
+	userPoolClient := userPool.AddClient(jsii.String("PatientsApiClient"), &awscognito.UserPoolClientOptions{
+		WriteAttributes: awscognito.NewClientAttributes().WithStandardAttributes(&awscognito.StandardAttributesMask{
+			Email:      jsii.Bool(true),
+			GivenName:  jsii.Bool(true),
+			FamilyName: jsii.Bool(true),
+		}),
+	})
+
 	// create a new http patientsApi gateway
 	patientsApi := awscdkapigatewayv2alpha.NewHttpApi(stack, jsii.String("PatientsApi"), &awscdkapigatewayv2alpha.HttpApiProps{})
 
+	// create the jwt authorizer every route is protected by, api gateway
+	// rejects requests without a valid token from the user pool before they
+	// reach the lambdas, which read the identity from the verified claims
+	jwtAuthorizer := awscdkapigatewayv2alpha.NewHttpAuthorizer(stack, jsii.String("PatientsJwtAuthorizer"), &awscdkapigatewayv2alpha.HttpAuthorizerProps{
+		HttpApi:        patientsApi,
+		Type:           awscdkapigatewayv2alpha.HttpAuthorizerType_JWT,
+		IdentitySource: jsii.Strings("$request.header.Authorization"),
+		JwtIssuer:      jsii.String("https://" + *userPool.UserPoolProviderUrl()),
+		JwtAudience:    jsii.Strings(*userPoolClient.UserPoolClientId()),
+	})
+
+	routeAuthorizer := awscdkapigatewayv2alpha.HttpAuthorizer_FromHttpAuthorizerAttributes(stack, jsii.String("PatientsRouteAuthorizer"), &awscdkapigatewayv2alpha.HttpAuthorizerAttributes{
+		AuthorizerId:   jwtAuthorizer.AuthorizerId(),
+		AuthorizerType: jsii.String(string(awscdkapigatewayv2alpha.HttpAuthorizerType_JWT)),
+	})
+
 	// add route for creating a patient
 	patientsApi.AddRoutes(&awscdkapigatewayv2alpha.AddRoutesOptions{
-		Path:    jsii.String("/patients"),
-		Methods: &[]awscdkapigatewayv2alpha.HttpMethod{awscdkapigatewayv2alpha.HttpMethod_POST},
+		Path:       jsii.String("/patients"),
+		Methods:    &[]awscdkapigatewayv2alpha.HttpMethod{awscdkapigatewayv2alpha.HttpMethod_POST},
+		Authorizer: routeAuthorizer,
 		Integration: awscdkapigatewayv2integrationsalpha.NewHttpLambdaIntegration(jsii.String("createPatientLambdaIntegration"), createPatientHandler, &awscdkapigatewayv2integrationsalpha.HttpLambdaIntegrationProps{
 			PayloadFormatVersion: awscdkapigatewayv2alpha.PayloadFormatVersion_VERSION_2_0(),
 		}),
@@ -106,25 +285,71 @@ func NewPatientsServiceAppStack(scope constructs.Construct, id string, props *Pa
 
 	// add route for getting a patient
 	patientsApi.AddRoutes(&awscdkapigatewayv2alpha.AddRoutesOptions{
-		Path:    jsii.String("/patients/{patient-id}"),
-		Methods: &[]awscdkapigatewayv2alpha.HttpMethod{awscdkapigatewayv2alpha.HttpMethod_GET},
+		Path:       jsii.String("/patients/{patient-id}"),
+		Methods:    &[]awscdkapigatewayv2alpha.HttpMethod{awscdkapigatewayv2alpha.HttpMethod_GET},
+		Authorizer: routeAuthorizer,
 		Integration: awscdkapigatewayv2integrationsalpha.NewHttpLambdaIntegration(jsii.String("getPatientLambdaIntegration"), getPatientHandler, &awscdkapigatewayv2integrationsalpha.HttpLambdaIntegrationProps{
 			PayloadFormatVersion: awscdkapigatewayv2alpha.PayloadFormatVersion_VERSION_2_0(),
 		}),
 	})
 
-	// add route for searching patients
+	// add route for searching and listing patients
 	patientsApi.AddRoutes(&awscdkapigatewayv2alpha.AddRoutesOptions{
-		Path:    jsii.String("/patients"),
-		Methods: &[]awscdkapigatewayv2alpha.HttpMethod{awscdkapigatewayv2alpha.HttpMethod_GET},
+		Path:       jsii.String("/patients"),
+		Methods:    &[]awscdkapigatewayv2alpha.HttpMethod{awscdkapigatewayv2alpha.HttpMethod_GET},
+		Authorizer: routeAuthorizer,
 		Integration: awscdkapigatewayv2integrationsalpha.NewHttpLambdaIntegration(jsii.String("searchPatientsLambdaIntegration"), searchPatientsHandler, &awscdkapigatewayv2integrationsalpha.HttpLambdaIntegrationProps{
 			PayloadFormatVersion: awscdkapigatewayv2alpha.PayloadFormatVersion_VERSION_2_0(),
 		}),
 	})
 
+	// add route for updating a patient, both full replacement and merge patches
+	patientsApi.AddRoutes(&awscdkapigatewayv2alpha.AddRoutesOptions{
+		Path:       jsii.String("/patients/{patient-id}"),
+		Methods:    &[]awscdkapigatewayv2alpha.HttpMethod{awscdkapigatewayv2alpha.HttpMethod_PUT, awscdkapigatewayv2alpha.HttpMethod_PATCH},
+		Authorizer: routeAuthorizer,
+		Integration: awscdkapigatewayv2integrationsalpha.NewHttpLambdaIntegration(jsii.String("updatePatientLambdaIntegration"), updatePatientHandler, &awscdkapigatewayv2integrationsalpha.HttpLambdaIntegrationProps{
+			PayloadFormatVersion: awscdkapigatewayv2alpha.PayloadFormatVersion_VERSION_2_0(),
+		}),
+	})
+
+	// add route for merging another patient into a patient
+	patientsApi.AddRoutes(&awscdkapigatewayv2alpha.AddRoutesOptions{
+		Path:       jsii.String("/patients/{patient-id}/merge"),
+		Methods:    &[]awscdkapigatewayv2alpha.HttpMethod{awscdkapigatewayv2alpha.HttpMethod_POST},
+		Authorizer: routeAuthorizer,
+		Integration: awscdkapigatewayv2integrationsalpha.NewHttpLambdaIntegration(jsii.String("mergePatientsLambdaIntegration"), mergePatientsHandler, &awscdkapigatewayv2integrationsalpha.HttpLambdaIntegrationProps{
+			PayloadFormatVersion: awscdkapigatewayv2alpha.PayloadFormatVersion_VERSION_2_0(),
+		}),
+	})
+
+	// add route for getting the history of a patient
+	patientsApi.AddRoutes(&awscdkapigatewayv2alpha.AddRoutesOptions{
+		Path:       jsii.String("/patients/{patient-id}/history"),
+		Methods:    &[]awscdkapigatewayv2alpha.HttpMethod{awscdkapigatewayv2alpha.HttpMethod_GET},
+		Authorizer: routeAuthorizer,
+		Integration: awscdkapigatewayv2integrationsalpha.NewHttpLambdaIntegration(jsii.String("patientHistoryLambdaIntegration"), patientHistoryHandler, &awscdkapigatewayv2integrationsalpha.HttpLambdaIntegrationProps{
+			PayloadFormatVersion: awscdkapigatewayv2alpha.PayloadFormatVersion_VERSION_2_0(),
+		}),
+	})
+
+	// add route for getting the openapi document, which is public so that api
+	// clients can be generated from it
+	patientsApi.AddRoutes(&awscdkapigatewayv2alpha.AddRoutesOptions{
+		Path:    jsii.String("/openapi.json"),
+		Methods: &[]awscdkapigatewayv2alpha.HttpMethod{awscdkapigatewayv2alpha.HttpMethod_GET},
+		Integration: awscdkapigatewayv2integrationsalpha.NewHttpLambdaIntegration(jsii.String("openAPILambdaIntegration"), openAPIHandler, &awscdkapigatewayv2integrationsalpha.HttpLambdaIntegrationProps{
+			PayloadFormatVersion: awscdkapigatewayv2alpha.PayloadFormatVersion_VERSION_2_0(),
+		}),
+	})
+
 	// output the lambda url to the console
 	awscdk.NewCfnOutput(stack, jsii.String("PatientsApiUrl"), &awscdk.CfnOutputProps{Value: patientsApi.Url()})
 
+	// output the user pool and client the front-end signs users in with
+	awscdk.NewCfnOutput(stack, jsii.String("PatientsUserPoolId"), &awscdk.CfnOutputProps{Value: userPool.UserPoolId()})
+	awscdk.NewCfnOutput(stack, jsii.String("PatientsUserPoolClientId"), &awscdk.CfnOutputProps{Value: userPoolClient.UserPoolClientId()})
+
 	return stack
 }
 
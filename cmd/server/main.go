@@ -0,0 +1,175 @@
+// the server command runs all of the patient routes on one http server so the
+// api can be used locally without deploying the lambdas.
+//
+//	go run ./cmd/server -port 8080 -repository memory
+//	DYNAMODB_TABLENAME=patients go run ./cmd/server -repository dynamodb
+//	go run ./cmd/server -jwks-url https://cognito-idp.eu-west-2.amazonaws.com/<user-pool-id>/.well-known/jwks.json
+//	go run ./cmd/server -validate-requests
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/openapi"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/create"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/get"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/history"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/merge"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/search"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients/update"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/ratelimit"
+	"go.uber.org/zap"
+)
+
+func main() {
+	port := flag.Int("port", 8080, "the port to listen on")
+	repositoryType := flag.String("repository", "memory", "where patients are stored, either memory or dynamodb (uses DYNAMODB_TABLENAME)")
+	dentalPracticeID := flag.String("dental-practice-id", "c9ec3cfe-9f2c-4d68-aec6-9c6a43bf9aec", "the dental practice every request is made on behalf of")
+	userID := flag.String("user-id", "local", "the user every request is made by, which changes to patients are attributed to")
+	role := flag.String("role", string(auth.RoleAdmin), "the role every request is made with, which decides the patient fields that are returned")
+	jwksURL := flag.String("jwks-url", "", "verify bearer tokens with the key set at this url, rather than making every request as the configured user")
+	jwksFile := flag.String("jwks-file", "", "verify bearer tokens with the key set in this file")
+	jwtSecret := flag.String("jwt-secret", "", "verify bearer tokens signed with this HS256 secret, for testing only")
+	jwtIssuer := flag.String("jwt-issuer", "", "the issuer bearer tokens have to be issued by")
+	jwtAudience := flag.String("jwt-audience", "", "the audience bearer tokens have to be issued for")
+	rateLimits := flag.String("rate-limits", "", "override the rate limit of routes, for example search-patients=10/s:30,create-patient=off")
+	validateRequests := flag.Bool("validate-requests", false, "reject requests that do not match the openapi document before they reach the handlers")
+	flag.Parse()
+
+	// initialise a new zap logger
+	logger, _ := zap.NewDevelopment()
+	defer logger.Sync()
+
+	repository, err := newRepository(logger, *repositoryType)
+	if err != nil {
+		logger.Fatal("unable to create the patient repository", zap.Error(err))
+	}
+
+	limits, err := ratelimit.ParseLimits(*rateLimits, ratelimit.DefaultLimits)
+	if err != nil {
+		logger.Fatal("unable to parse the rate limits", zap.Error(err))
+	}
+
+	// there is only the one server, so the buckets are kept in memory
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), limits)
+
+	routes := newHandler(logger, repository, limiter)
+
+	if *validateRequests {
+		doc, err := openapi.Load()
+		if err != nil {
+			logger.Fatal("unable to load the openapi document", zap.Error(err))
+		}
+
+		routes = openapi.ValidateRequests(logger, doc, routes)
+	}
+
+	keys, err := newKeySource(*jwksURL, *jwksFile, *jwtSecret)
+	if err != nil {
+		logger.Fatal("unable to load the keys bearer tokens are verified with", zap.Error(err))
+	}
+
+	// there is no api gateway authorizer locally, so unless bearer tokens are
+	// verified every request is made on behalf of the configured dental
+	// practice, by the configured user and role
+	var handler http.Handler
+	if keys != nil {
+		handler = auth.Authenticate(logger, auth.NewVerifier(keys, *jwtIssuer, *jwtAudience), routes)
+	} else {
+		handler = auth.StaticIdentity(auth.Identity{DentalPracticeID: *dentalPracticeID, UserID: *userID, Role: auth.Role(*role)}, routes)
+	}
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", *port),
+		Handler:           logging.RequestIDs(logger, withOpenAPIDocument(handler)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		logger.Info("running the patients server...", zap.String("addr", server.Addr), zap.String("repository", *repositoryType))
+
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal("the server stopped unexpectedly", zap.Error(err))
+		}
+	}()
+
+	// wait for ctrl+c and give in flight requests a chance to finish
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("unable to shut the server down cleanly", zap.Error(err))
+	}
+}
+
+func newRepository(logger *zap.Logger, repositoryType string) (patients.PatientRepository, error) {
+	switch repositoryType {
+	case "memory":
+		return patients.NewMemoryPatientStore(), nil
+	case "dynamodb":
+		return patients.NewPatientStore(logger), nil
+	default:
+		return nil, fmt.Errorf("unknown repository %q, expected memory or dynamodb", repositoryType)
+	}
+}
+
+// returns the keys bearer tokens are verified with, or nil when none of the
+// ways of verifying them was configured.
+func newKeySource(jwksURL string, jwksFile string, jwtSecret string) (auth.KeySource, error) {
+	switch {
+	case jwksURL != "":
+		return auth.NewRemoteJWKS(jwksURL), nil
+	case jwksFile != "":
+		return auth.LoadJWKS(jwksFile)
+	case jwtSecret != "":
+		return auth.HMACKey(jwtSecret), nil
+	default:
+		return nil, nil
+	}
+}
+
+// mounts the patient handlers on the same paths as the routes of the http api
+// in the cdk stack, each limited the same as its lambda.
+func newHandler(logger *zap.Logger, repository patients.PatientRepository, limiter *ratelimit.Limiter) http.Handler {
+	rt := &router{}
+
+	rt.handle(http.MethodPost, "/patients", limiter.Enforce(logger, ratelimit.RouteCreatePatient, create.CreatePatientHandler(logger, repository)))
+	rt.handle(http.MethodGet, "/patients", limiter.Enforce(logger, ratelimit.RouteSearchPatients, search.SearchPatientsHandler(logger, repository)))
+	rt.handle(http.MethodGet, "/patients/[^/]+", limiter.Enforce(logger, ratelimit.RouteGetPatient, get.GetPatientHandler(logger, repository)))
+	rt.handle(http.MethodPut, "/patients/[^/]+", limiter.Enforce(logger, ratelimit.RouteUpdatePatient, update.UpdatePatientHandler(logger, repository)))
+	rt.handle(http.MethodPatch, "/patients/[^/]+", limiter.Enforce(logger, ratelimit.RouteUpdatePatient, update.UpdatePatientHandler(logger, repository)))
+	rt.handle(http.MethodPost, "/patients/[^/]+/merge", limiter.Enforce(logger, ratelimit.RouteMergePatients, merge.MergePatientsHandler(logger, repository)))
+	rt.handle(http.MethodGet, "/patients/[^/]+/history", limiter.Enforce(logger, ratelimit.RoutePatientHistory, history.PatientHistoryHandler(logger, repository)))
+
+	return rt
+}
+
+// serves the openapi document alongside the routes, like the api gateway it
+// can be read without a bearer token.
+func withOpenAPIDocument(handler http.Handler) http.Handler {
+	document := openapi.Handler()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == openapi.Path {
+			document.ServeHTTP(w, r)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/auth"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/logging"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/openapi"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/patients"
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/ratelimit"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestServer(t *testing.T) {
+	dentalPracticeID := "test_dental_practice_id"
+
+	// create the logger
+	logger, _ := zap.NewProduction()
+
+	// create the handler serving every route, backed by an in memory store
+	handler := validateResponses(t, auth.StaticIdentity(auth.Identity{DentalPracticeID: dentalPracticeID, Role: auth.RoleAdmin}, newHandler(logger, patients.NewMemoryPatientStore(), ratelimit.NewLimiter(ratelimit.NewMemoryStore(), nil))))
+
+	var created patients.CreatePatientResponse
+	var etag string
+
+	t.Run("create a patient with POST /patients", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/patients", strings.NewReader(`{"first_name": "Jane", "last_name": "Doe"}`))
+		req.Header.Set("content-type", "application/json")
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusCreated)
+
+		if err := json.NewDecoder(res.Body).Decode(&created); err != nil || created.PatientID == "" {
+			t.Fatalf("unable to read the created patient id from %q, '%v'", res.Body, err)
+		}
+	})
+
+	t.Run("get the patient with GET /patients/{patient-id}", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/patients/"+created.PatientID, nil)
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		var got patients.Patient
+		json.NewDecoder(res.Body).Decode(&got)
+
+		if got.FirstName != "Jane" || !got.Active || got.CreatedAt == "" {
+			t.Errorf("unexpected patient returned %+v", got)
+		}
+
+		etag = res.Header().Get("etag")
+	})
+
+	t.Run("find the patient with GET /patients?search=", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/patients?search=do", nil)
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		var got patients.PatientSearchResponse
+		json.NewDecoder(res.Body).Decode(&got)
+
+		if len(got.Items) != 1 || got.Items[0].PatientID != created.PatientID {
+			t.Errorf("unexpected search results %+v", got)
+		}
+	})
+
+	t.Run("update the patient with PATCH /patients/{patient-id}", func(t *testing.T) {
+		req, _ := http.NewRequest("PATCH", "/patients/"+created.PatientID, strings.NewReader(`{"last_name": "Smith"}`))
+		req.Header.Set("content-type", "application/merge-patch+json")
+		req.Header.Set("if-match", etag)
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		etag = res.Header().Get("etag")
+
+		var got patients.Patient
+		json.NewDecoder(res.Body).Decode(&got)
+
+		if got.LastName != "Smith" || got.ModifiedAt == "" {
+			t.Errorf("unexpected patient returned %+v", got)
+		}
+	})
+
+	t.Run("merge another patient with POST /patients/{patient-id}/merge", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/patients", strings.NewReader(`{"first_name": "Janet", "last_name": "Smith", "mobile_phone": "07700900123"}`))
+		req.Header.Set("content-type", "application/json")
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		var duplicate patients.CreatePatientResponse
+		json.NewDecoder(res.Body).Decode(&duplicate)
+
+		req, _ = http.NewRequest("POST", "/patients/"+created.PatientID+"/merge", strings.NewReader(`{"source_patient_id": "`+duplicate.PatientID+`", "fields": ["mobile_phone"]}`))
+		req.Header.Set("content-type", "application/json")
+		req.Header.Set("if-match", etag)
+
+		res = httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		var got patients.Patient
+		json.NewDecoder(res.Body).Decode(&got)
+
+		if got.PatientID != created.PatientID || got.MobilePhone != "07700900123" {
+			t.Errorf("unexpected patient returned %+v", got)
+		}
+
+		// the merged patient now redirects to the surviving patient
+		req, _ = http.NewRequest("GET", "/patients/"+duplicate.PatientID, nil)
+
+		res = httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusPermanentRedirect)
+
+		if location := res.Header().Get("location"); location != "/patients/"+created.PatientID {
+			t.Errorf("unexpected location header %q", location)
+		}
+	})
+
+	t.Run("get the changes made to a patient with GET /patients/{patient-id}/history", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/patients/"+created.PatientID+"/history", nil)
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		var got patients.PatientHistoryResponse
+		json.NewDecoder(res.Body).Decode(&got)
+
+		// the merge, the update and the create, newest first
+		var operations []string
+		for _, item := range got.Items {
+			operations = append(operations, item.Operation)
+		}
+
+		if strings.Join(operations, ",") != "merge,update,create" {
+			t.Errorf("unexpected history %+v", got.Items)
+		}
+	})
+
+	t.Run("return 405 when the path exists but not for the method", func(t *testing.T) {
+		req, _ := http.NewRequest("DELETE", "/patients/"+created.PatientID, nil)
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusMethodNotAllowed)
+
+		if got := res.Header().Get("allow"); got != "GET, PUT, PATCH" {
+			t.Errorf("unexpected allow header %q", got)
+		}
+	})
+
+	t.Run("return 404 for paths that are not routed", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/dentists", nil)
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusNotFound)
+	})
+}
+
+func TestServerWithBearerTokens(t *testing.T) {
+	// create the logger
+	logger, _ := zap.NewProduction()
+
+	keys, err := newKeySource("", "", "test_secret")
+	if err != nil {
+		t.Fatalf("unable to create the key source, '%v'", err)
+	}
+
+	// create the handler serving every route, only to requests with a valid token
+	handler := validateResponses(t, auth.Authenticate(logger, auth.NewVerifier(keys, "", ""), newHandler(logger, patients.NewMemoryPatientStore(), ratelimit.NewLimiter(ratelimit.NewMemoryStore(), nil))))
+
+	t.Run("return 401 when there is no bearer token", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/patients", nil)
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("list the patients of the dental practice in the bearer token", func(t *testing.T) {
+		claims, _ := json.Marshal(map[string]interface{}{
+			"exp":                      time.Now().Add(time.Hour).Unix(),
+			"sub":                      "test_user_id",
+			auth.DentalPracticeIDClaim: "test_dental_practice_id",
+			auth.RoleClaim:             "receptionist",
+		})
+
+		signingInput := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + base64.RawURLEncoding.EncodeToString(claims)
+		mac := hmac.New(sha256.New, []byte("test_secret"))
+		mac.Write([]byte(signingInput))
+
+		req, _ := http.NewRequest("GET", "/patients", nil)
+		req.Header.Set("authorization", "Bearer "+signingInput+"."+base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusOK)
+	})
+}
+
+func TestServerWithRequestIDs(t *testing.T) {
+	// create a logger that records what is logged
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core)
+
+	// create the handler serving every route, with every request given an id
+	handler := validateResponses(t, logging.RequestIDs(logger, auth.StaticIdentity(auth.Identity{DentalPracticeID: "test_dental_practice_id", Role: auth.RoleAdmin}, newHandler(logger, patients.NewMemoryPatientStore(), ratelimit.NewLimiter(ratelimit.NewMemoryStore(), nil)))))
+
+	t.Run("the request id is echoed and logged by the handler and the repository", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/patients/3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", nil)
+		req.Header.Set(logging.RequestIDHeader, "test_request_id")
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusNotFound)
+
+		if got := res.Header().Get(logging.RequestIDHeader); got != "test_request_id" {
+			t.Errorf("got request id header %q want %q", got, "test_request_id")
+		}
+
+		messages := map[string]bool{}
+		for _, entry := range logs.FilterField(zap.String("requestID", "test_request_id")).All() {
+			messages[entry.Message] = true
+		}
+
+		for _, want := range []string{"running the get patient handler...", "getting patient"} {
+			if !messages[want] {
+				t.Errorf("%q was not logged with the request id, got %v", want, messages)
+			}
+		}
+	})
+}
+
+func TestServerWithRateLimits(t *testing.T) {
+	// create the logger
+	logger, _ := zap.NewProduction()
+
+	limits, err := ratelimit.ParseLimits("search-patients=1/m:2", nil)
+	if err != nil {
+		t.Fatalf("unable to parse the rate limits, '%v'", err)
+	}
+
+	// create the handler serving every route, with searches limited to two in a row
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), limits)
+	handler := validateResponses(t, auth.StaticIdentity(auth.Identity{DentalPracticeID: "test_dental_practice_id", UserID: "test_user_id", Role: auth.RoleAdmin}, newHandler(logger, patients.NewMemoryPatientStore(), limiter)))
+
+	t.Run("return 429 with retry-after once the limit is used up", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequest("GET", "/patients", nil)
+
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, req)
+
+			assertStatusCode(t, res.Code, http.StatusOK)
+		}
+
+		req, _ := http.NewRequest("GET", "/patients", nil)
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusTooManyRequests)
+
+		if got := res.Header().Get("retry-after"); got != "60" {
+			t.Errorf("got retry-after %q want %q", got, "60")
+		}
+	})
+
+	t.Run("routes without a limit are not limited", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/patients/3f2b6c1e-8d4a-4c7b-9e21-5a6f0d8b7c34", nil)
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusNotFound)
+	})
+}
+
+func TestServerWithOpenAPI(t *testing.T) {
+	// create the logger
+	logger, _ := zap.NewProduction()
+
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatalf("unable to load the openapi document: %v", err)
+	}
+
+	// create the handler serving the document, and every route to requests
+	// that match it
+	routes := openapi.ValidateRequests(logger, doc, newHandler(logger, patients.NewMemoryPatientStore(), ratelimit.NewLimiter(ratelimit.NewMemoryStore(), nil)))
+	handler := validateResponses(t, withOpenAPIDocument(auth.StaticIdentity(auth.Identity{DentalPracticeID: "test_dental_practice_id", Role: auth.RoleAdmin}, routes)))
+
+	t.Run("serve the document with GET /openapi.json", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/openapi.json", nil)
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusOK)
+
+		if _, err := openapi.Parse(res.Body.Bytes()); err != nil {
+			t.Errorf("unable to parse the served document, '%v'", err)
+		}
+	})
+
+	t.Run("reject requests that do not match the document", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/patients?limit=1000", nil)
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		assertStatusCode(t, res.Code, http.StatusBadRequest)
+	})
+}
+
+// fails the test when a response does not match the openapi document.
+func validateResponses(t *testing.T, handler http.Handler) http.Handler {
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatalf("unable to load the openapi document: %v", err)
+	}
+
+	return openapi.ValidateResponses(doc, func(err error) { t.Error(err) }, handler)
+}
+
+func assertStatusCode(t testing.TB, got, want int) {
+	t.Helper()
+
+	if got != want {
+		t.Errorf("handler returned wrong status code: got %v want %v", got, want)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/jwankhalaf/dentalcloud__patients-service/api/problem"
+)
+
+// a route matches requests by method and by a pattern on the whole path.
+type route struct {
+	method  string
+	pattern *regexp.Regexp
+	handler http.Handler
+}
+
+// router sends requests to the handler of the first route that matches, the
+// same way the api gateway routes requests to the lambdas.
+type router struct {
+	routes []route
+}
+
+func (rt *router) handle(method string, pattern string, handler http.Handler) {
+	rt.routes = append(rt.routes, route{
+		method:  method,
+		pattern: regexp.MustCompile("^" + pattern + "$"),
+		handler: handler,
+	})
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var allowed []string
+
+	for _, route := range rt.routes {
+		if !route.pattern.MatchString(r.URL.Path) {
+			continue
+		}
+
+		if route.method == r.Method {
+			route.handler.ServeHTTP(w, r)
+			return
+		}
+
+		allowed = append(allowed, route.method)
+	}
+
+	// the path exists but not for the method that was used
+	if len(allowed) > 0 {
+		w.Header().Set("allow", strings.Join(allowed, ", "))
+		problem.Error(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	problem.Error(w, r, "the route could not be found", http.StatusNotFound)
+}
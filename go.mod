@@ -12,8 +12,10 @@ require (
 	github.com/aws/aws-sdk-go-v2/config v1.17.9
 	github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue v1.10.2
 	github.com/aws/aws-sdk-go-v2/service/dynamodb v1.17.3
+	github.com/aws/aws-sdk-go-v2/service/kms v1.18.15
 	github.com/aws/constructs-go/constructs/v10 v10.1.137
 	github.com/aws/jsii-runtime-go v1.70.0
+	github.com/aws/smithy-go v1.13.4
 	github.com/google/go-cmp v0.5.9
 	github.com/google/uuid v1.3.0
 	go.uber.org/zap v1.23.0
@@ -34,7 +36,6 @@ require (
 	github.com/aws/aws-sdk-go-v2/service/sso v1.11.24 // indirect
 	github.com/aws/aws-sdk-go-v2/service/ssooidc v1.13.7 // indirect
 	github.com/aws/aws-sdk-go-v2/service/sts v1.17.0 // indirect
-	github.com/aws/smithy-go v1.13.4 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/mattn/go-isatty v0.0.16 // indirect
 	github.com/yuin/goldmark v1.4.13 // indirect